@@ -0,0 +1,34 @@
+package main
+
+import (
+	"flag"
+	"log"
+	"os"
+
+	"github.com/SsSJKK/wallet/pkg/cli"
+	"github.com/SsSJKK/wallet/pkg/repl"
+	"github.com/SsSJKK/wallet/pkg/wallet"
+)
+
+func main() {
+	dir := flag.String("data", "./data", "data directory")
+	user := flag.String("user", "", "staff name, the password is read from "+cli.EnvPassword)
+	flag.Parse()
+
+	svc := &wallet.Service{}
+	app := &cli.App{Svc: svc, Dir: *dir}
+	err := app.Load()
+	if err != nil {
+		log.Fatal(err)
+	}
+	session, err := svc.LoginStaff(*user, os.Getenv(cli.EnvPassword))
+	if err != nil {
+		// неудачные попытки считаются до блокировки
+		app.Save()
+		log.Fatal(err)
+	}
+	err = repl.New(svc, &session.Principal, *dir, os.Stdin, os.Stdout).Run()
+	if err != nil {
+		log.Fatal(err)
+	}
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/SsSJKK/wallet/pkg/cli"
+	"github.com/SsSJKK/wallet/pkg/server"
+	"github.com/SsSJKK/wallet/pkg/wallet"
+	"github.com/SsSJKK/wallet/pkg/webhook"
+)
+
+func main() {
+	addr := flag.String("addr", ":9999", "listen address")
+	dir := flag.String("data", "./data", "data directory")
+	interval := flag.Duration("webhooks", 5*time.Second, "webhook delivery interval")
+	every := flag.Duration("schedules", time.Minute, "scheduled payments interval")
+	flag.Parse()
+
+	svc := &wallet.Service{}
+	// журнал аудита с разорванной цепочкой не загружается, сервер не стартует
+	err := (&cli.App{Svc: svc, Dir: *dir}).Load()
+	if err != nil {
+		log.Fatal(err)
+	}
+	// правила риска загружаются из каталога данных, настраиваются через wallet risk set
+	rules := svc.RiskRules()
+	if len(rules) == 0 {
+		log.Print("no risk rules, payments are not checked")
+	}
+	for _, rule := range rules {
+		fields, ok := wallet.RiskRuleFields(rule)
+		if !ok {
+			fields = []string{rule.Name()}
+		}
+		log.Print("risk rule ", strings.Join(fields, " "))
+	}
+
+	// вебхуки доставляются в фоне, запросы только дописывают outbox
+	hooks, err := webhook.NewDispatcher(*dir)
+	if err != nil {
+		log.Fatal(err)
+	}
+	bus := &wallet.EventBus{}
+	hooks.Attach(bus)
+	svc.SetEventBus(bus)
+	delivery, stopDelivery := context.WithCancel(context.Background())
+	delivered := make(chan struct{})
+	go func() {
+		hooks.Run(delivery, *interval)
+		close(delivered)
+	}()
+
+	api := server.New(svc)
+	srv := &http.Server{Addr: *addr, Handler: api}
+
+	// платежи по расписанию идут под тем же замком, что и запросы
+	scheduler := &wallet.Scheduler{
+		Service:  svc,
+		Interval: *every,
+		Do:       api.Do,
+		OnRun: func(run wallet.ScheduleRun) {
+			if run.Err != nil {
+				log.Print("schedule ", run.ScheduleID, ": ", run.Err)
+			}
+		},
+	}
+	scheduling, stopScheduling := context.WithCancel(context.Background())
+	scheduled := make(chan struct{})
+	go func() {
+		scheduler.Run(scheduling)
+		close(scheduled)
+	}()
+
+	done := make(chan os.Signal, 1)
+	signal.Notify(done, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		log.Print("listening on ", *addr)
+		err := srv.ListenAndServe()
+		if err != nil && err != http.ErrServerClosed {
+			log.Fatal(err)
+		}
+	}()
+	<-done
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	err = srv.Shutdown(ctx)
+	if err != nil {
+		log.Print(err)
+	}
+	stopScheduling()
+	<-scheduled
+	stopDelivery()
+	<-delivered
+	err = api.Do(func(svc *wallet.Service) error {
+		err := os.MkdirAll(*dir, 0755)
+		if err != nil {
+			return err
+		}
+		return svc.Export(*dir)
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+	log.Print("exported to ", *dir)
+}
@@ -0,0 +1,352 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/SsSJKK/wallet/pkg/types"
+	"github.com/SsSJKK/wallet/pkg/wallet"
+)
+
+//Account JSON representation of types.Account
+type Account struct {
+	ID           int64               `json:"id"`
+	Phone        types.Phone         `json:"phone"`
+	Balance      types.Money         `json:"balance"`
+	Status       types.AccountStatus `json:"status,omitempty"`
+	StatusReason string              `json:"statusReason,omitempty"`
+}
+
+//Payment JSON representation of types.Payment
+type Payment struct {
+	ID         string                `json:"id"`
+	AccountID  int64                 `json:"accountId"`
+	Amount     types.Money           `json:"amount"`
+	Category   types.PaymentCategory `json:"category"`
+	Status     types.PaymentStatus   `json:"status"`
+	Time       int64                 `json:"time"`
+	ParentID   string                `json:"parentId,omitempty"`
+	MerchantID string                `json:"merchantId,omitempty"`
+	RejectedAt int64                 `json:"rejectedAt,omitempty"`
+}
+
+//Favorite JSON representation of types.Favorite
+type Favorite struct {
+	ID        string                `json:"id"`
+	AccountID int64                 `json:"accountId"`
+	Amount    types.Money           `json:"amount"`
+	Name      string                `json:"name"`
+	Category  types.PaymentCategory `json:"category"`
+}
+
+//Merchant JSON representation of types.Merchant
+type Merchant struct {
+	ID                string                `json:"id"`
+	Name              string                `json:"name"`
+	Category          types.PaymentCategory `json:"category"`
+	MCC               string                `json:"mcc,omitempty"`
+	SettlementAccount int64                 `json:"settlementAccount"`
+}
+
+//RegisterRequest body of POST /accounts, Pin is required when a customer
+//signs up without a token
+type RegisterRequest struct {
+	Phone types.Phone `json:"phone"`
+	Pin   string      `json:"pin,omitempty"`
+}
+
+//LoginRequest body of POST /sessions: Phone and Pin of a customer or Name
+//and Pin (the password) of a staff member
+type LoginRequest struct {
+	Phone types.Phone `json:"phone,omitempty"`
+	Name  string      `json:"name,omitempty"`
+	Pin   string      `json:"pin"`
+}
+
+//Session body of the POST /sessions response, Token goes to the
+//Authorization header as "Bearer <token>"
+type Session struct {
+	Token     string      `json:"token"`
+	Expires   int64       `json:"expires"`
+	AccountID int64       `json:"accountId,omitempty"`
+	Name      string      `json:"name,omitempty"`
+	Role      wallet.Role `json:"role"`
+}
+
+//DepositRequest body of POST /accounts/{id}/deposit
+type DepositRequest struct {
+	Amount types.Money `json:"amount"`
+}
+
+//PayRequest body of POST /payments
+type PayRequest struct {
+	AccountID int64                 `json:"accountId"`
+	Amount    types.Money           `json:"amount"`
+	Category  types.PaymentCategory `json:"category"`
+}
+
+//FavoriteRequest body of POST /favorites, either PaymentID or
+//AccountID, Amount and Category are set
+type FavoriteRequest struct {
+	PaymentID string                `json:"paymentId,omitempty"`
+	AccountID int64                 `json:"accountId,omitempty"`
+	Name      string                `json:"name"`
+	Amount    types.Money           `json:"amount,omitempty"`
+	Category  types.PaymentCategory `json:"category,omitempty"`
+}
+
+//FavoriteUpdateRequest body of PUT /favorites/{id}
+type FavoriteUpdateRequest struct {
+	Name     string                `json:"name"`
+	Amount   types.Money           `json:"amount"`
+	Category types.PaymentCategory `json:"category"`
+}
+
+//FavoriteOrderRequest body of POST /accounts/{id}/favorites/order, every
+//favorite of the account in the new order
+type FavoriteOrderRequest struct {
+	FavoriteIDs []string `json:"favoriteIds"`
+}
+
+//Error body of an error response
+type Error struct {
+	Code     string          `json:"code"`
+	Message  string          `json:"message"`
+	Limit    *LimitViolation `json:"limit,omitempty"`
+	HTTPCode int             `json:"-"`
+}
+
+//LimitViolation details of wallet.LimitError
+type LimitViolation struct {
+	AccountID int64                 `json:"accountId"`
+	Category  types.PaymentCategory `json:"category"`
+	Kind      wallet.LimitKind      `json:"kind"`
+	Limit     types.Money           `json:"limit"`
+	Remaining types.Money           `json:"remaining"`
+}
+
+//Error codes
+const (
+	CodeBadRequest         = "BAD_REQUEST"
+	CodeNotFound           = "NOT_FOUND"
+	CodeMethodNotAllowed   = "METHOD_NOT_ALLOWED"
+	CodeInternal           = "INTERNAL"
+	CodeInvalidAmount      = "AMOUNT_MUST_BE_POSITIVE"
+	CodePhoneRegistered    = "PHONE_REGISTERED"
+	CodeAccountNotFound    = "ACCOUNT_NOT_FOUND"
+	CodeNotEnoughBalance   = "NOT_ENOUGH_BALANCE"
+	CodePaymentNotFound    = "PAYMENT_NOT_FOUND"
+	CodeNotRejectable      = "PAYMENT_NOT_REJECTABLE"
+	CodeFavoriteNotFound   = "FAVORITE_NOT_FOUND"
+	CodeFavoriteNameExists = "FAVORITE_NAME_EXISTS"
+	CodeInvalidFavorite    = "INVALID_FAVORITE_NAME"
+	CodeInvalidOrder       = "INVALID_FAVORITE_ORDER"
+	CodeLimitExceeded      = "LIMIT_EXCEEDED"
+	CodeUnauthorized       = "UNAUTHORIZED"
+	CodeInvalidCredentials = "INVALID_CREDENTIALS"
+	CodeAccountLocked      = "ACCOUNT_LOCKED"
+	CodeWeakPIN            = "WEAK_PIN"
+	CodeForbidden          = "FORBIDDEN"
+	CodeAccountFrozen      = "ACCOUNT_FROZEN"
+	CodeAccountClosed      = "ACCOUNT_CLOSED"
+	CodeBalanceNotZero     = "BALANCE_NOT_ZERO"
+	CodeInvalidPhone       = "INVALID_PHONE"
+	CodeInvalidCode        = "INVALID_CODE"
+	CodeCodeExpired        = "CODE_EXPIRED"
+	CodeTooManyAttempts    = "TOO_MANY_ATTEMPTS"
+	CodeNotEnoughPoints    = "NOT_ENOUGH_POINTS"
+	CodeInvalidCreditLine  = "INVALID_CREDIT_LINE"
+	CodeCreditLineInUse    = "CREDIT_LINE_IN_USE"
+	CodeInvalidSavingsTier = "INVALID_SAVINGS_TIER"
+	CodeMerchantNotFound   = "MERCHANT_NOT_FOUND"
+	CodeMerchantExists     = "MERCHANT_EXISTS"
+	CodeInvalidMerchant    = "INVALID_MERCHANT"
+	CodeRiskDenied         = "RISK_DENIED"
+	CodeReviewNotFound     = "REVIEW_NOT_FOUND"
+	CodeReviewResolved     = "REVIEW_RESOLVED"
+)
+
+type errorMapping struct {
+	err    error
+	code   string
+	status int
+}
+
+var errorMappings = []errorMapping{
+	{wallet.ErrAmountMustBePositive, CodeInvalidAmount, http.StatusBadRequest},
+	{wallet.ErrPhoneRegistered, CodePhoneRegistered, http.StatusConflict},
+	{wallet.ErrAccountNotFound, CodeAccountNotFound, http.StatusNotFound},
+	{wallet.ErrNotEnoughBalance, CodeNotEnoughBalance, http.StatusUnprocessableEntity},
+	{wallet.ErrPaymentNotFound, CodePaymentNotFound, http.StatusNotFound},
+	{wallet.ErrPaymentNotRejectable, CodeNotRejectable, http.StatusConflict},
+	{wallet.ErrFavoriteNotFound, CodeFavoriteNotFound, http.StatusNotFound},
+	{wallet.ErrFavoriteNameExists, CodeFavoriteNameExists, http.StatusConflict},
+	{wallet.ErrInvalidFavoriteName, CodeInvalidFavorite, http.StatusBadRequest},
+	{wallet.ErrInvalidFavoriteOrder, CodeInvalidOrder, http.StatusBadRequest},
+	{wallet.ErrLimitExceeded, CodeLimitExceeded, http.StatusUnprocessableEntity},
+	{wallet.ErrUnauthorized, CodeUnauthorized, http.StatusUnauthorized},
+	{wallet.ErrInvalidCredentials, CodeInvalidCredentials, http.StatusUnauthorized},
+	{wallet.ErrAccountLocked, CodeAccountLocked, http.StatusLocked},
+	{wallet.ErrWeakPIN, CodeWeakPIN, http.StatusBadRequest},
+	{wallet.ErrForbidden, CodeForbidden, http.StatusForbidden},
+	{wallet.ErrAccountFrozen, CodeAccountFrozen, http.StatusConflict},
+	{wallet.ErrAccountClosed, CodeAccountClosed, http.StatusConflict},
+	{wallet.ErrBalanceNotZero, CodeBalanceNotZero, http.StatusConflict},
+	{wallet.ErrInvalidPhone, CodeInvalidPhone, http.StatusBadRequest},
+	{wallet.ErrInvalidCode, CodeInvalidCode, http.StatusUnprocessableEntity},
+	{wallet.ErrCodeExpired, CodeCodeExpired, http.StatusUnprocessableEntity},
+	{wallet.ErrTooManyAttempts, CodeTooManyAttempts, http.StatusUnprocessableEntity},
+	{wallet.ErrNotEnoughPoints, CodeNotEnoughPoints, http.StatusUnprocessableEntity},
+	{wallet.ErrInvalidCreditLine, CodeInvalidCreditLine, http.StatusBadRequest},
+	{wallet.ErrCreditLineInUse, CodeCreditLineInUse, http.StatusConflict},
+	{wallet.ErrInvalidSavingsTier, CodeInvalidSavingsTier, http.StatusBadRequest},
+	{wallet.ErrMerchantNotFound, CodeMerchantNotFound, http.StatusNotFound},
+	{wallet.ErrMerchantExists, CodeMerchantExists, http.StatusConflict},
+	{wallet.ErrInvalidMerchant, CodeInvalidMerchant, http.StatusBadRequest},
+	{wallet.ErrRiskDenied, CodeRiskDenied, http.StatusUnprocessableEntity},
+	{wallet.ErrReviewNotFound, CodeReviewNotFound, http.StatusNotFound},
+	{wallet.ErrReviewResolved, CodeReviewResolved, http.StatusConflict},
+}
+
+//FromError makes the response body and status for an error of the service
+func FromError(err error) *Error {
+	e := &Error{Code: CodeInternal, Message: err.Error(), HTTPCode: http.StatusInternalServerError}
+	for _, mapping := range errorMappings {
+		if errors.Is(err, mapping.err) {
+			e.Code = mapping.code
+			e.HTTPCode = mapping.status
+			break
+		}
+	}
+	var limitErr *wallet.LimitError
+	if errors.As(err, &limitErr) {
+		e.Limit = &LimitViolation{
+			AccountID: limitErr.AccountID,
+			Category:  limitErr.Category,
+			Kind:      limitErr.Kind,
+			Limit:     limitErr.Limit,
+			Remaining: limitErr.Remaining,
+		}
+	}
+	return e
+}
+
+//Err returns the service error for the response body: the sentinel error of
+//the wallet package or *wallet.LimitError when the code is known, otherwise e
+func (e *Error) Err() error {
+	if e.Limit != nil {
+		return &wallet.LimitError{
+			AccountID: e.Limit.AccountID,
+			Category:  e.Limit.Category,
+			Kind:      e.Limit.Kind,
+			Limit:     e.Limit.Limit,
+			Remaining: e.Limit.Remaining,
+		}
+	}
+	for _, mapping := range errorMappings {
+		if mapping.code == e.Code {
+			return mapping.err
+		}
+	}
+	return e
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+//FromAccount meth
+func FromAccount(account types.Account) Account {
+	return Account{
+		ID:           account.ID,
+		Phone:        account.Phone,
+		Balance:      account.Balance,
+		Status:       account.Status,
+		StatusReason: account.StatusReason,
+	}
+}
+
+//ToAccount meth
+func (a Account) ToAccount() *types.Account {
+	return &types.Account{
+		ID:           a.ID,
+		Phone:        a.Phone,
+		Balance:      a.Balance,
+		Status:       a.Status,
+		StatusReason: a.StatusReason,
+	}
+}
+
+//FromSession meth
+func FromSession(session wallet.Session) Session {
+	return Session{
+		Token:     session.Token,
+		Expires:   session.Expires,
+		AccountID: session.Principal.AccountID,
+		Name:      session.Principal.Name,
+		Role:      session.Principal.Role,
+	}
+}
+
+//FromPayment meth
+func FromPayment(payment types.Payment) Payment {
+	return Payment{
+		ID:         payment.ID,
+		AccountID:  payment.AccountID,
+		Amount:     payment.Amount,
+		Category:   payment.Category,
+		Status:     payment.Status,
+		Time:       payment.Time,
+		ParentID:   payment.ParentID,
+		MerchantID: payment.MerchantID,
+		RejectedAt: payment.RejectedAt,
+	}
+}
+
+//ToPayment meth
+func (p Payment) ToPayment() *types.Payment {
+	return &types.Payment{
+		ID:         p.ID,
+		AccountID:  p.AccountID,
+		Amount:     p.Amount,
+		Category:   p.Category,
+		Status:     p.Status,
+		Time:       p.Time,
+		ParentID:   p.ParentID,
+		MerchantID: p.MerchantID,
+		RejectedAt: p.RejectedAt,
+	}
+}
+
+//FromFavorite meth
+func FromFavorite(favorite types.Favorite) Favorite {
+	return Favorite{
+		ID:        favorite.ID,
+		AccountID: favorite.AccountID,
+		Amount:    favorite.Amount,
+		Name:      favorite.Name,
+		Category:  favorite.Category,
+	}
+}
+
+//ToFavorite meth
+func (f Favorite) ToFavorite() *types.Favorite {
+	return &types.Favorite{
+		ID:        f.ID,
+		AccountID: f.AccountID,
+		Amount:    f.Amount,
+		Name:      f.Name,
+		Category:  f.Category,
+	}
+}
+
+//FromMerchant meth
+func FromMerchant(merchant types.Merchant) Merchant {
+	return Merchant{
+		ID:                merchant.ID,
+		Name:              merchant.Name,
+		Category:          merchant.Category,
+		MCC:               merchant.MCC,
+		SettlementAccount: merchant.SettlementAccount,
+	}
+}
@@ -0,0 +1,1131 @@
+package cli
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/SsSJKK/wallet/pkg/api"
+	"github.com/SsSJKK/wallet/pkg/reconcile"
+	"github.com/SsSJKK/wallet/pkg/report"
+	"github.com/SsSJKK/wallet/pkg/types"
+	"github.com/SsSJKK/wallet/pkg/wallet"
+	"github.com/SsSJKK/wallet/pkg/webhook"
+)
+
+const usage = `usage: wallet [-data dir] [-format table|json] -user <name>|-phone <phone> <command> [args]
+
+The password of the staff member or the PIN of the customer is read from
+WALLET_PASSWORD, commands run with the rights of that role.
+
+commands:
+  staff init <name>               first admin, password from WALLET_PASSWORD
+  staff add <name> support|auditor|admin   password from WALLET_NEW_PASSWORD
+  account register <phone>
+  account show <accountID>
+  account list
+  account freeze|unfreeze|reopen <accountID> <reason>
+  account close <accountID> <reason> [payout]
+  deposit <accountID> <amount>
+  pay <accountID> <amount> <category>
+  reject <paymentID>
+  repeat <paymentID>
+  favorite add <paymentID> <name>
+  favorite add <accountID> <name> <amount> <category>
+  favorite list <accountID>
+  favorite pay <favoriteID>
+  favorite update <favoriteID> <name> <amount> <category>
+  favorite delete <favoriteID>
+  favorite reorder <accountID> <favoriteID>...
+  schedule add <favoriteID> once|daily|weekly|monthly [YYYY-MM-DD] [count]
+  schedule list <favoriteID>
+  schedule cancel <scheduleID>
+  schedule run
+  history <accountID>
+  export <dir>
+  import <dir>
+  report <accountID> [YYYY-MM] [text|json|html]
+  sum
+  points <accountID>
+  points redeem <accountID> <points>
+  credit set <accountID> <limit> <dailyRate>
+  credit remove <accountID>
+  credit accrue
+  credit report
+  savings tiers <from>:<annualRate>...
+  savings accrue [YYYY-MM-DD]
+  merchant register <name> <category> <mcc> <settlementAccountID>
+  merchant list
+  merchant pay <accountID> <merchantID> <amount>
+  merchant payments <merchantID>
+  merchant totals <merchantID>
+  settle [YYYY-MM-DD]
+  reconcile <statementFile> [toleranceHours]
+  risk decisions [accountID]
+  risk rules
+  risk set velocity <max> <window> [ALLOW|HOLD|DENY]
+  risk set spike <factor> [minHistory] [last] [ALLOW|HOLD|DENY]
+  risk set first_large <amount> [ALLOW|HOLD|DENY]
+  risk set blacklist <category,...> [ALLOW|HOLD|DENY]
+  risk remove <rule>
+  review list
+  review assign <paymentID> <assignee>
+  review comment <paymentID> <text>
+  review approve <paymentID>
+  review decline <paymentID>
+  review sla [hours]
+  webhook add <url> <secret>
+  webhook list
+  webhook remove <endpointID>
+  webhook pending|dead
+  webhook redeliver <messageID>
+  webhook deliver
+  migrate phones
+  audit list
+  audit verify [file]
+`
+
+var errUsage = errors.New("invalid arguments")
+
+var errLogin = errors.New("login with -user or -phone and WALLET_PASSWORD")
+
+//Environment variables with secrets, they are not passed as arguments
+const (
+	EnvPassword    = "WALLET_PASSWORD"
+	EnvNewPassword = "WALLET_NEW_PASSWORD"
+)
+
+//App state of one CLI run
+type App struct {
+	Dir    string
+	Format string
+	Out    io.Writer
+	Svc    *wallet.Service
+	Actor  *wallet.Actor
+	Hooks  *webhook.Dispatcher
+}
+
+//Run executes the command line and returns the exit code
+func Run(args []string, stdout io.Writer, stderr io.Writer) int {
+	flags := flag.NewFlagSet("wallet", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	flags.Usage = func() { fmt.Fprint(stderr, usage) }
+	dir := flags.String("data", "./data", "data directory")
+	format := flags.String("format", "table", "output format: table or json")
+	user := flags.String("user", "", "staff name")
+	phone := flags.String("phone", "", "phone of the customer")
+	err := flags.Parse(args)
+	if err != nil {
+		return 2
+	}
+	if *format != "table" && *format != "json" {
+		fmt.Fprintln(stderr, "format must be table or json")
+		return 2
+	}
+
+	app := &App{Dir: *dir, Format: *format, Out: stdout, Svc: &wallet.Service{}}
+	err = app.Load()
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 1
+	}
+	app.Hooks, err = webhook.NewDispatcher(*dir)
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 1
+	}
+	// события команды попадают в outbox, доставляет их сервер или webhook deliver
+	bus := &wallet.EventBus{}
+	app.Hooks.Attach(bus)
+	app.Svc.SetEventBus(bus)
+	args = flags.Args()
+	if len(args) == 0 {
+		fmt.Fprint(stderr, usage)
+		return 2
+	}
+	// первого админа заводят без входа, дальше всё от имени вошедшего
+	if len(args) < 2 || args[0] != "staff" || args[1] != "init" {
+		err = app.Login(*user, types.Phone(*phone), os.Getenv(EnvPassword))
+		if err != nil {
+			// неудачные попытки считаются до блокировки, их надо сохранить
+			if err != errLogin {
+				app.Save()
+			}
+			fmt.Fprintln(stderr, "error:", err)
+			return 1
+		}
+	}
+	changed, err := app.Exec(args)
+	if err == errUsage {
+		fmt.Fprint(stderr, usage)
+		return 2
+	}
+	// отказ попадает в журнал аудита, его сохраняем как изменение
+	if changed || errors.Is(err, wallet.ErrForbidden) {
+		saveErr := app.Save()
+		if saveErr != nil {
+			fmt.Fprintln(stderr, saveErr)
+			return 1
+		}
+	}
+	if err != nil {
+		fmt.Fprintln(stderr, "error:", err)
+		return 1
+	}
+	return 0
+}
+
+//Login checks the password of the staff member with the name or the PIN of
+//the customer with the phone, commands run as that principal
+func (a *App) Login(name string, phone types.Phone, password string) error {
+	var session *wallet.Session
+	var err error
+	switch {
+	case name != "":
+		session, err = a.Svc.LoginStaff(name, password)
+	case phone != "":
+		session, err = a.Svc.Login(phone, password)
+	default:
+		return errLogin
+	}
+	if err != nil {
+		return err
+	}
+	// токен CLI не нужен, хватает принципала
+	a.Svc.Logout(session.Token)
+	a.Actor = a.Svc.As(&session.Principal)
+	return nil
+}
+
+//Load imports the data directory if it has data
+func (a *App) Load() error {
+	return load(a.Svc, a.Dir)
+}
+
+//Save exports the service to the data directory
+func (a *App) Save() error {
+	err := os.MkdirAll(a.Dir, 0755)
+	if err != nil {
+		return err
+	}
+	err = a.Svc.Export(a.Dir)
+	if err != nil {
+		return err
+	}
+	// staff init идёт до первого счёта, а без accounts.dump каталог не загрузится
+	file, err := os.OpenFile(a.Dir+"/accounts.dump", os.O_CREATE|os.O_RDONLY, 0644)
+	if err != nil {
+		return err
+	}
+	return file.Close()
+}
+
+func load(svc *wallet.Service, dir string) error {
+	_, err := os.Stat(dir + "/accounts.dump")
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return svc.Import(dir)
+}
+
+//Exec runs one command, changed tells whether data must be saved
+func (a *App) Exec(args []string) (changed bool, err error) {
+	if len(args) == 0 {
+		return false, errUsage
+	}
+	command, args := args[0], args[1:]
+	switch command {
+	case "staff":
+		return a.staff(args)
+	case "account":
+		return a.account(args)
+	case "deposit":
+		if len(args) != 2 {
+			return false, errUsage
+		}
+		accountID, amount, err := parseIDAmount(args[0], args[1])
+		if err != nil {
+			return false, err
+		}
+		err = a.Actor.Deposit(accountID, amount)
+		if err != nil {
+			return false, err
+		}
+		account, _ := a.Svc.FindAccountByID(accountID)
+		return true, a.printAccounts([]types.Account{*account})
+	case "pay":
+		if len(args) != 3 {
+			return false, errUsage
+		}
+		accountID, amount, err := parseIDAmount(args[0], args[1])
+		if err != nil {
+			return false, err
+		}
+		payment, err := a.Actor.Pay(accountID, amount, types.PaymentCategory(args[2]))
+		if err != nil {
+			return false, err
+		}
+		return true, a.printPayments([]types.Payment{*payment})
+	case "reject":
+		if len(args) != 1 {
+			return false, errUsage
+		}
+		err := a.Actor.Reject(args[0])
+		if err != nil {
+			return false, err
+		}
+		payment, _ := a.Svc.FindPaymentByID(args[0])
+		return true, a.printPayments([]types.Payment{*payment})
+	case "repeat":
+		if len(args) != 1 {
+			return false, errUsage
+		}
+		payment, err := a.Actor.Repeat(args[0])
+		if err != nil {
+			return false, err
+		}
+		return true, a.printPayments([]types.Payment{*payment})
+	case "favorite":
+		return a.favorite(args)
+	case "schedule":
+		return a.schedule(args)
+	case "history":
+		if len(args) != 1 {
+			return false, errUsage
+		}
+		accountID, err := parseID(args[0])
+		if err != nil {
+			return false, err
+		}
+		payments, err := a.Actor.ExportAccountHistory(accountID)
+		if err != nil {
+			return false, err
+		}
+		return false, a.printPayments(payments)
+	case "export":
+		if len(args) != 1 {
+			return false, errUsage
+		}
+		err := os.MkdirAll(args[0], 0755)
+		if err != nil {
+			return false, err
+		}
+		return false, a.Actor.Export(args[0])
+	case "import":
+		if len(args) != 1 {
+			return false, errUsage
+		}
+		_, err := os.Stat(args[0] + "/accounts.dump")
+		if err != nil {
+			return false, err
+		}
+		return true, a.Actor.Import(args[0])
+	case "report":
+		return false, a.report(args)
+	case "audit":
+		return false, a.audit(args)
+	case "points":
+		return a.points(args)
+	case "credit":
+		return a.credit(args)
+	case "savings":
+		return a.savings(args)
+	case "merchant":
+		return a.merchant(args)
+	case "reconcile":
+		return false, a.reconcile(args)
+	case "risk":
+		return a.risk(args)
+	case "review":
+		return a.review(args)
+	case "webhook":
+		return false, a.webhook(args)
+	case "settle":
+		if len(args) > 1 {
+			return false, errUsage
+		}
+		day := time.Now()
+		if len(args) == 1 {
+			var err error
+			day, err = time.ParseInLocation("2006-01-02", args[0], time.Local)
+			if err != nil {
+				return false, fmt.Errorf("day must be YYYY-MM-DD: %v", err)
+			}
+		}
+		err := os.MkdirAll(a.Dir+"/settlements", 0755)
+		if err != nil {
+			return false, err
+		}
+		batches, err := a.Actor.Settle(day, a.Dir+"/settlements")
+		if err != nil {
+			return false, err
+		}
+		return len(batches) > 0, a.printValue(batches, func(w io.Writer) {
+			fmt.Fprintln(w, "BATCH\tMERCHANT\tCATEGORY\tACCOUNT\tCOUNT\tGROSS\tFEE\tNET")
+			for _, batch := range batches {
+				fmt.Fprintf(w, "%s\t%s\t%s\t%d\t%d\t%d\t%d\t%d\n", batch.ID, batch.MerchantID, batch.Category, batch.AccountID, batch.Count, batch.Gross, batch.Fee, batch.Net)
+			}
+		})
+	case "migrate":
+		if len(args) != 1 || args[0] != "phones" {
+			return false, errUsage
+		}
+		migration, err := a.Actor.MigratePhones()
+		if err != nil {
+			return false, err
+		}
+		return len(migration.Changed) > 0, a.printValue(migration, func(w io.Writer) {
+			fmt.Fprintln(w, "RESULT\tACCOUNT\tFROM\tTO")
+			for _, change := range migration.Changed {
+				fmt.Fprintf(w, "changed\t%d\t%s\t%s\n", change.AccountID, change.From, change.To)
+			}
+			for _, change := range migration.Invalid {
+				fmt.Fprintf(w, "invalid\t%d\t%s\t-\n", change.AccountID, change.From)
+			}
+			for _, collision := range migration.Collisions {
+				for _, accountID := range collision.AccountIDs {
+					account, _ := a.Svc.FindAccountByID(accountID)
+					fmt.Fprintf(w, "collision\t%d\t%s\t%s\n", accountID, account.Phone, collision.Phone)
+				}
+			}
+		})
+	case "sum":
+		if len(args) != 0 {
+			return false, errUsage
+		}
+		sum, err := a.Actor.SumPayments(1)
+		if err != nil {
+			return false, err
+		}
+		return false, a.printValue(map[string]types.Money{"sum": sum}, func(w io.Writer) {
+			fmt.Fprintln(w, sum)
+		})
+	}
+	return false, errUsage
+}
+
+func (a *App) staff(args []string) (bool, error) {
+	switch {
+	case len(args) == 2 && args[0] == "init":
+		return true, a.Svc.InitStaff(args[1], os.Getenv(EnvPassword))
+	case len(args) == 3 && args[0] == "add":
+		return true, a.Actor.RegisterStaff(args[1], wallet.Role(args[2]), os.Getenv(EnvNewPassword))
+	}
+	return false, errUsage
+}
+
+func (a *App) account(args []string) (bool, error) {
+	if len(args) == 0 {
+		return false, errUsage
+	}
+	switch {
+	case args[0] == "register" && len(args) == 2:
+		account, err := a.Actor.RegisterAccount(types.Phone(args[1]))
+		if err != nil {
+			return false, err
+		}
+		return true, a.printAccounts([]types.Account{*account})
+	case args[0] == "show" && len(args) == 2:
+		accountID, err := parseID(args[1])
+		if err != nil {
+			return false, err
+		}
+		account, err := a.Actor.FindAccountByID(accountID)
+		if err != nil {
+			return false, err
+		}
+		return false, a.printAccounts([]types.Account{*account})
+	case args[0] == "list" && len(args) == 1:
+		accounts, err := a.Actor.Accounts()
+		if err != nil {
+			return false, err
+		}
+		return false, a.printAccounts(accounts)
+	case len(args) == 3 || (len(args) == 4 && args[0] == "close" && args[3] == "payout"):
+		accountID, err := parseID(args[1])
+		if err != nil {
+			return false, err
+		}
+		switch args[0] {
+		case "freeze":
+			err = a.Actor.FreezeAccount(accountID, args[2])
+		case "unfreeze":
+			err = a.Actor.UnfreezeAccount(accountID, args[2])
+		case "reopen":
+			err = a.Actor.ReopenAccount(accountID, args[2])
+		case "close":
+			_, err = a.Actor.CloseAccount(accountID, args[2], len(args) == 4)
+		default:
+			return false, errUsage
+		}
+		if err != nil {
+			return false, err
+		}
+		account, _ := a.Svc.FindAccountByID(accountID)
+		return true, a.printAccounts([]types.Account{*account})
+	}
+	return false, errUsage
+}
+
+func (a *App) favorite(args []string) (bool, error) {
+	if len(args) == 0 {
+		return false, errUsage
+	}
+	switch {
+	case args[0] == "add" && len(args) == 3:
+		favorite, err := a.Actor.FavoritePayment(args[1], args[2])
+		if err != nil {
+			return false, err
+		}
+		return true, a.printFavorites([]types.Favorite{*favorite})
+	case args[0] == "add" && len(args) == 5:
+		accountID, amount, err := parseIDAmount(args[1], args[3])
+		if err != nil {
+			return false, err
+		}
+		favorite, err := a.Actor.AddFavorite(accountID, args[2], amount, types.PaymentCategory(args[4]))
+		if err != nil {
+			return false, err
+		}
+		return true, a.printFavorites([]types.Favorite{*favorite})
+	case args[0] == "list" && len(args) == 2:
+		accountID, err := parseID(args[1])
+		if err != nil {
+			return false, err
+		}
+		favorites, err := a.Actor.AccountFavorites(accountID)
+		if err != nil {
+			return false, err
+		}
+		return false, a.printFavorites(favorites)
+	case args[0] == "pay" && len(args) == 2:
+		payment, err := a.Actor.PayFromFavorite(args[1])
+		if err != nil {
+			return false, err
+		}
+		return true, a.printPayments([]types.Payment{*payment})
+	case args[0] == "update" && len(args) == 5:
+		amount, err := strconv.ParseInt(args[3], 10, 64)
+		if err != nil {
+			return false, fmt.Errorf("invalid amount %q", args[3])
+		}
+		favorite, err := a.Actor.UpdateFavorite(args[1], args[2], types.Money(amount), types.PaymentCategory(args[4]))
+		if err != nil {
+			return false, err
+		}
+		return true, a.printFavorites([]types.Favorite{*favorite})
+	case args[0] == "delete" && len(args) == 2:
+		return true, a.Actor.DeleteFavorite(args[1])
+	case args[0] == "reorder" && len(args) >= 2:
+		accountID, err := parseID(args[1])
+		if err != nil {
+			return false, err
+		}
+		err = a.Actor.ReorderFavorites(accountID, args[2:])
+		if err != nil {
+			return false, err
+		}
+		favorites, _ := a.Actor.AccountFavorites(accountID)
+		return true, a.printFavorites(favorites)
+	}
+	return false, errUsage
+}
+
+func (a *App) schedule(args []string) (bool, error) {
+	switch {
+	case len(args) >= 3 && len(args) <= 5 && args[0] == "add":
+		template := types.Schedule{Kind: types.ScheduleKind(strings.ToUpper(args[2]))}
+		start := time.Now()
+		if len(args) >= 4 {
+			var err error
+			start, err = time.ParseInLocation("2006-01-02", args[3], time.Local)
+			if err != nil {
+				return false, fmt.Errorf("day must be YYYY-MM-DD: %v", err)
+			}
+			template.Due = start.Unix()
+		}
+		if len(args) == 5 {
+			count, err := strconv.Atoi(args[4])
+			if err != nil {
+				return false, fmt.Errorf("invalid count %q", args[4])
+			}
+			template.Count = count
+		}
+		// ежемесячное расписание платит в тот же день месяца, что и первый платёж
+		if template.Kind == types.ScheduleMonthly {
+			template.Day = start.Day()
+		}
+		schedule, err := a.Actor.ScheduleFavorite(args[1], template)
+		if err != nil {
+			return false, err
+		}
+		return true, a.printSchedules([]types.Schedule{*schedule})
+	case len(args) == 2 && args[0] == "list":
+		schedules, err := a.Actor.Schedules(args[1])
+		if err != nil {
+			return false, err
+		}
+		return false, a.printSchedules(schedules)
+	case len(args) == 2 && args[0] == "cancel":
+		err := a.Actor.CancelSchedule(args[1])
+		if err != nil {
+			return false, err
+		}
+		schedule, _ := a.Svc.FindScheduleByID(args[1])
+		return true, a.printSchedules([]types.Schedule{*schedule})
+	case len(args) == 1 && args[0] == "run":
+		runs, err := a.Actor.RunDueSchedules()
+		if err != nil {
+			return false, err
+		}
+		return len(runs) > 0, a.printValue(runs, func(w io.Writer) {
+			fmt.Fprintln(w, "SCHEDULE\tPAYMENT\tAMOUNT\tRESULT")
+			for _, run := range runs {
+				paymentID, amount, result := "-", types.Money(0), "paid"
+				if run.Payment != nil {
+					paymentID, amount = run.Payment.ID, run.Payment.Amount
+				}
+				if run.Err != nil {
+					result = run.Err.Error()
+				}
+				if run.Retry {
+					result += ", retry"
+				}
+				fmt.Fprintf(w, "%s\t%s\t%d\t%s\n", run.ScheduleID, paymentID, amount, result)
+			}
+		})
+	}
+	return false, errUsage
+}
+
+func (a *App) audit(args []string) error {
+	switch {
+	case len(args) == 1 && args[0] == "list":
+		entries, err := a.Actor.AuditLog()
+		if err != nil {
+			return err
+		}
+		return a.printValue(entries, func(w io.Writer) {
+			fmt.Fprintln(w, "SEQ\tTIME\tACTOR\tACTION\tTARGET\tBEFORE\tAFTER\tREASON")
+			for _, entry := range entries {
+				fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n", entry.Seq, time.Unix(entry.Time, 0).Format("2006-01-02 15:04"), entry.Actor, entry.Action, entry.Target, entry.Before, entry.After, entry.Reason)
+			}
+		})
+	case len(args) >= 1 && len(args) <= 2 && args[0] == "verify":
+		err := a.Actor.Allow(wallet.PermReport, 0)
+		if err != nil {
+			return err
+		}
+		path := a.Dir + "/audit.dump"
+		if len(args) == 2 {
+			path = args[1]
+		}
+		summary, err := wallet.VerifyAuditFile(path)
+		if err != nil {
+			return err
+		}
+		return a.printValue(summary, func(w io.Writer) {
+			fmt.Fprintf(w, "OK\t%d entries\thead %s\n", summary.Entries, summary.Head)
+		})
+	}
+	return errUsage
+}
+
+func (a *App) points(args []string) (bool, error) {
+	switch {
+	case len(args) == 1:
+		accountID, err := parseID(args[0])
+		if err != nil {
+			return false, err
+		}
+		points, err := a.Actor.Points(accountID)
+		if err != nil {
+			return false, err
+		}
+		movements, _ := a.Actor.RewardMovements(accountID)
+		value := struct {
+			Points    int64                   `json:"points"`
+			Movements []wallet.RewardMovement `json:"movements"`
+		}{points, movements}
+		return false, a.printValue(value, func(w io.Writer) {
+			fmt.Fprintf(w, "POINTS\t%d\n", points)
+			fmt.Fprintln(w, "TIME\tKIND\tREASON\tAMOUNT\tPAYMENT")
+			for _, movement := range movements {
+				fmt.Fprintf(w, "%s\t%s\t%s\t%d\t%s\n", time.Unix(movement.Time, 0).Format("2006-01-02 15:04"), movement.Kind, movement.Reason, movement.Amount, movement.PaymentID)
+			}
+		})
+	case len(args) == 3 && args[0] == "redeem":
+		accountID, err := parseID(args[1])
+		if err != nil {
+			return false, err
+		}
+		points, err := strconv.ParseInt(args[2], 10, 64)
+		if err != nil {
+			return false, fmt.Errorf("invalid points %q", args[2])
+		}
+		_, err = a.Actor.RedeemPoints(accountID, points)
+		if err != nil {
+			return false, err
+		}
+		account, _ := a.Svc.FindAccountByID(accountID)
+		return true, a.printAccounts([]types.Account{*account})
+	}
+	return false, errUsage
+}
+
+func (a *App) credit(args []string) (bool, error) {
+	switch {
+	case len(args) == 4 && args[0] == "set":
+		accountID, limit, err := parseIDAmount(args[1], args[2])
+		if err != nil {
+			return false, err
+		}
+		rate, err := strconv.ParseInt(args[3], 10, 64)
+		if err != nil {
+			return false, fmt.Errorf("invalid rate %q", args[3])
+		}
+		return true, a.Actor.SetCreditLine(accountID, limit, rate)
+	case len(args) == 2 && args[0] == "remove":
+		accountID, err := parseID(args[1])
+		if err != nil {
+			return false, err
+		}
+		return true, a.Actor.RemoveCreditLine(accountID)
+	case len(args) == 1 && args[0] == "accrue":
+		payments, err := a.Actor.AccrueOverdraftInterest()
+		if err != nil {
+			return false, err
+		}
+		// время последнего начисления сдвигается и без списаний
+		return true, a.printPayments(payments)
+	case len(args) == 1 && args[0] == "report":
+		report, err := a.Actor.OverdraftReport()
+		if err != nil {
+			return false, err
+		}
+		return false, a.printValue(report, func(w io.Writer) {
+			fmt.Fprintln(w, "ACCOUNT\tPHONE\tBALANCE\tLIMIT\tPRINCIPAL\tINTEREST\tAVAILABLE")
+			for _, line := range report {
+				fmt.Fprintf(w, "%d\t%s\t%d\t%d\t%d\t%d\t%d\n", line.AccountID, line.Phone, line.Balance, line.Limit, line.Principal, line.Interest, line.Available)
+			}
+		})
+	}
+	return false, errUsage
+}
+
+func (a *App) savings(args []string) (bool, error) {
+	switch {
+	case len(args) >= 1 && args[0] == "tiers":
+		tiers := []wallet.SavingsTier{}
+		for _, arg := range args[1:] {
+			parts := strings.Split(arg, ":")
+			if len(parts) != 2 {
+				return false, fmt.Errorf("tier must be from:rate, got %q", arg)
+			}
+			from, err := strconv.ParseInt(parts[0], 10, 64)
+			if err != nil {
+				return false, fmt.Errorf("invalid amount %q", parts[0])
+			}
+			rate, err := strconv.ParseInt(parts[1], 10, 64)
+			if err != nil {
+				return false, fmt.Errorf("invalid rate %q", parts[1])
+			}
+			tiers = append(tiers, wallet.SavingsTier{From: types.Money(from), Rate: rate})
+		}
+		return true, a.Actor.SetSavingsTiers(tiers)
+	case len(args) >= 1 && len(args) <= 2 && args[0] == "accrue":
+		day := time.Now()
+		if len(args) == 2 {
+			var err error
+			day, err = time.Parse("2006-01-02", args[1])
+			if err != nil {
+				return false, fmt.Errorf("day must be YYYY-MM-DD: %v", err)
+			}
+		}
+		run, err := a.Actor.AccrueSavingsInterest(day, runtime.NumCPU())
+		if err != nil {
+			return false, err
+		}
+		return run.Accounts > 0, a.printValue(run, func(w io.Writer) {
+			fmt.Fprintf(w, "DAY\t%s\nACCOUNTS\t%d\nACCRUED\t%d/%d\nCAPITALIZED\t%d\n", run.Day, run.Accounts, run.Accrued, wallet.SavingsScale, len(run.Capitalized))
+		})
+	}
+	return false, errUsage
+}
+
+func (a *App) risk(args []string) (bool, error) {
+	switch {
+	case len(args) >= 1 && len(args) <= 2 && args[0] == "decisions":
+		accountID := int64(0)
+		if len(args) == 2 {
+			var err error
+			accountID, err = parseID(args[1])
+			if err != nil {
+				return false, err
+			}
+		}
+		decisions, err := a.Actor.RiskDecisions(accountID)
+		if err != nil {
+			return false, err
+		}
+		return false, a.printValue(decisions, func(w io.Writer) {
+			fmt.Fprintln(w, "TIME\tACCOUNT\tAMOUNT\tCATEGORY\tOUTCOME\tRULE\tREASON")
+			for _, d := range decisions {
+				fmt.Fprintf(w, "%s\t%d\t%d\t%s\t%s\t%s\t%s\n", time.Unix(d.Time, 0).Format("2006-01-02 15:04"), d.AccountID, d.Amount, d.Category, d.Outcome, d.Rule, d.Reason)
+			}
+		})
+	case len(args) == 1 && args[0] == "rules":
+		rules, err := a.Actor.RiskRules()
+		if err != nil {
+			return false, err
+		}
+		lines := [][]string{}
+		for _, rule := range rules {
+			fields, ok := wallet.RiskRuleFields(rule)
+			if !ok {
+				fields = []string{rule.Name(), ""}
+			}
+			lines = append(lines, fields)
+		}
+		return false, a.printValue(lines, func(w io.Writer) {
+			fmt.Fprintln(w, "RULE\tOUTCOME\tPARAMETERS")
+			for _, fields := range lines {
+				fmt.Fprintf(w, "%s\t%s\t%s\n", fields[0], fields[1], strings.Join(fields[2:], " "))
+			}
+		})
+	case len(args) >= 3 && args[0] == "set":
+		params := args[2:]
+		outcome := wallet.RiskOutcome("")
+		switch last := wallet.RiskOutcome(strings.ToUpper(params[len(params)-1])); last {
+		case wallet.RiskAllow, wallet.RiskHold, wallet.RiskDeny:
+			outcome, params = last, params[:len(params)-1]
+		}
+		rule, err := wallet.ParseRiskRule(args[1], outcome, params)
+		if err != nil {
+			return false, err
+		}
+		return true, a.Actor.AddRiskRule(rule)
+	case len(args) == 2 && args[0] == "remove":
+		return true, a.Actor.RemoveRiskRule(args[1])
+	}
+	return false, errUsage
+}
+
+func (a *App) review(args []string) (bool, error) {
+	switch {
+	case len(args) == 1 && args[0] == "list":
+		queue, err := a.Actor.ReviewQueue()
+		if err != nil {
+			return false, err
+		}
+		return false, a.printValue(queue, func(w io.Writer) {
+			fmt.Fprintln(w, "CREATED\tPAYMENT\tACCOUNT\tAMOUNT\tSOURCE\tASSIGNEE\tREASON")
+			for _, item := range queue {
+				fmt.Fprintf(w, "%s\t%s\t%d\t%d\t%s\t%s\t%s\n", time.Unix(item.Created, 0).Format("2006-01-02 15:04"), item.PaymentID, item.AccountID, item.Amount, item.Source, item.Assignee, item.Reason)
+			}
+		})
+	case len(args) == 3 && args[0] == "assign":
+		return true, a.Actor.AssignReview(args[1], args[2])
+	case len(args) >= 3 && args[0] == "comment":
+		return true, a.Actor.CommentReview(args[1], strings.Join(args[2:], " "))
+	case len(args) == 2 && args[0] == "approve":
+		return true, a.Actor.ApproveReview(args[1])
+	case len(args) == 2 && args[0] == "decline":
+		return true, a.Actor.DeclineReview(args[1])
+	case len(args) >= 1 && len(args) <= 2 && args[0] == "sla":
+		sla := time.Duration(0)
+		if len(args) == 2 {
+			hours, err := strconv.ParseInt(args[1], 10, 64)
+			if err != nil || hours <= 0 {
+				return false, fmt.Errorf("invalid hours %q", args[1])
+			}
+			sla = time.Duration(hours) * time.Hour
+		}
+		report, err := a.Actor.ReviewSLA(sla)
+		if err != nil {
+			return false, err
+		}
+		return false, a.printValue(report, func(w io.Writer) {
+			fmt.Fprintf(w, "SLA\t%s\nOPEN\t%d\nBREACHED\t%d\nOLDEST\t%s\n", report.SLA, report.Open, report.Breached, report.Oldest.Truncate(time.Minute))
+			for _, bucket := range report.Buckets {
+				fmt.Fprintf(w, "%s\t%d\n", bucket.Label, bucket.Count)
+			}
+		})
+	}
+	return false, errUsage
+}
+
+func (a *App) webhook(args []string) error {
+	err := a.Actor.Allow(wallet.PermManage, 0)
+	if err != nil {
+		return err
+	}
+	switch {
+	case len(args) == 3 && args[0] == "add":
+		err = os.MkdirAll(a.Dir, 0755)
+		if err != nil {
+			return err
+		}
+		endpoint, err := a.Hooks.AddEndpoint(args[1], args[2])
+		if err != nil {
+			return err
+		}
+		return a.printEndpoints([]webhook.Endpoint{*endpoint})
+	case len(args) == 1 && args[0] == "list":
+		return a.printEndpoints(a.Hooks.Endpoints())
+	case len(args) == 2 && args[0] == "remove":
+		return a.Hooks.RemoveEndpoint(args[1])
+	case len(args) == 1 && (args[0] == "pending" || args[0] == "dead"):
+		messages := a.Hooks.Pending()
+		if args[0] == "dead" {
+			messages = a.Hooks.DeadLetters()
+		}
+		return a.printValue(messages, func(w io.Writer) {
+			fmt.Fprintln(w, "ID\tENDPOINT\tEVENT\tATTEMPTS\tNEXT\tERROR")
+			for _, message := range messages {
+				fmt.Fprintf(w, "%s\t%s\t%s\t%d\t%s\t%s\n", message.ID, message.EndpointID, message.Event, message.Attempts, time.Unix(message.NextAttempt, 0).Format("2006-01-02 15:04:05"), message.LastError)
+			}
+		})
+	case len(args) == 2 && args[0] == "redeliver":
+		return a.Hooks.Redeliver(args[1])
+	case len(args) == 1 && args[0] == "deliver":
+		delivered, err := a.Hooks.DeliverDue(context.Background())
+		if err != nil {
+			return err
+		}
+		return a.printValue(map[string]int{"delivered": delivered, "pending": len(a.Hooks.Pending())}, func(w io.Writer) {
+			fmt.Fprintf(w, "DELIVERED\t%d\nPENDING\t%d\n", delivered, len(a.Hooks.Pending()))
+		})
+	}
+	return errUsage
+}
+
+func (a *App) printEndpoints(endpoints []webhook.Endpoint) error {
+	// секрет наружу не отдаём
+	result := make([]map[string]string, 0, len(endpoints))
+	for _, endpoint := range endpoints {
+		result = append(result, map[string]string{"id": endpoint.ID, "url": endpoint.URL})
+	}
+	return a.printValue(result, func(w io.Writer) {
+		fmt.Fprintln(w, "ID\tURL")
+		for _, endpoint := range endpoints {
+			fmt.Fprintf(w, "%s\t%s\n", endpoint.ID, endpoint.URL)
+		}
+	})
+}
+
+func (a *App) merchant(args []string) (bool, error) {
+	switch {
+	case len(args) == 5 && args[0] == "register":
+		accountID, err := parseID(args[4])
+		if err != nil {
+			return false, err
+		}
+		merchant, err := a.Actor.RegisterMerchant(args[1], types.PaymentCategory(args[2]), args[3], accountID)
+		if err != nil {
+			return false, err
+		}
+		return true, a.printMerchants([]types.Merchant{*merchant})
+	case len(args) == 1 && args[0] == "list":
+		merchants, err := a.Actor.Merchants()
+		if err != nil {
+			return false, err
+		}
+		return false, a.printMerchants(merchants)
+	case len(args) == 4 && args[0] == "pay":
+		accountID, amount, err := parseIDAmount(args[1], args[3])
+		if err != nil {
+			return false, err
+		}
+		payment, err := a.Actor.PayMerchant(accountID, args[2], amount)
+		if err != nil {
+			return false, err
+		}
+		return true, a.printPayments([]types.Payment{*payment})
+	case len(args) == 2 && args[0] == "payments":
+		payments, err := a.Actor.MerchantPayments(args[1])
+		if err != nil {
+			return false, err
+		}
+		return false, a.printPayments(payments)
+	case len(args) == 2 && args[0] == "totals":
+		totals, err := a.Actor.MerchantDailyTotals(args[1])
+		if err != nil {
+			return false, err
+		}
+		return false, a.printValue(totals, func(w io.Writer) {
+			fmt.Fprintln(w, "DAY\tCOUNT\tAMOUNT")
+			for _, day := range totals {
+				fmt.Fprintf(w, "%s\t%d\t%d\n", day.Day, day.Count, day.Amount)
+			}
+		})
+	}
+	return false, errUsage
+}
+
+func (a *App) printMerchants(merchants []types.Merchant) error {
+	result := make([]api.Merchant, 0, len(merchants))
+	for _, merchant := range merchants {
+		result = append(result, api.FromMerchant(merchant))
+	}
+	return a.printValue(result, func(w io.Writer) {
+		fmt.Fprintln(w, "ID\tNAME\tCATEGORY\tMCC\tSETTLEMENT")
+		for _, merchant := range merchants {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%d\n", merchant.ID, merchant.Name, merchant.Category, merchant.MCC, merchant.SettlementAccount)
+		}
+	})
+}
+
+func (a *App) reconcile(args []string) error {
+	if len(args) < 1 || len(args) > 2 {
+		return errUsage
+	}
+	opts := reconcile.Options{}
+	if len(args) == 2 {
+		hours, err := strconv.Atoi(args[1])
+		if err != nil || hours <= 0 {
+			return fmt.Errorf("invalid tolerance %q", args[1])
+		}
+		opts.Tolerance = time.Duration(hours) * time.Hour
+	}
+	statement, err := reconcile.ReadStatementFile(args[0])
+	if err != nil {
+		return err
+	}
+	result, err := reconcile.Service(a.Actor, statement, opts)
+	if err != nil {
+		return err
+	}
+	if a.Format == "json" {
+		return reconcile.WriteJSON(a.Out, result)
+	}
+	return reconcile.WriteText(a.Out, result)
+}
+
+func (a *App) report(args []string) error {
+	if len(args) < 1 || len(args) > 3 {
+		return errUsage
+	}
+	accountID, err := parseID(args[0])
+	if err != nil {
+		return err
+	}
+	month := time.Now()
+	if len(args) > 1 {
+		month, err = time.Parse("2006-01", args[1])
+		if err != nil {
+			return fmt.Errorf("month must be YYYY-MM: %v", err)
+		}
+	}
+	st, err := report.Monthly(a.Actor, accountID, month.Year(), month.Month(), time.Local)
+	if err != nil {
+		return err
+	}
+	format := "text"
+	if a.Format == "json" {
+		format = "json"
+	}
+	if len(args) > 2 {
+		format = args[2]
+	}
+	switch format {
+	case "text":
+		return report.WriteText(a.Out, st)
+	case "json":
+		return report.WriteJSON(a.Out, st)
+	case "html":
+		return report.WriteHTML(a.Out, st)
+	}
+	return errUsage
+}
+
+func (a *App) printAccounts(accounts []types.Account) error {
+	result := make([]api.Account, 0, len(accounts))
+	for _, account := range accounts {
+		result = append(result, api.FromAccount(account))
+	}
+	return a.printValue(result, func(w io.Writer) {
+		fmt.Fprintln(w, "ID\tPHONE\tBALANCE\tSTATUS\tREASON")
+		for _, account := range accounts {
+			fmt.Fprintf(w, "%d\t%s\t%d\t%s\t%s\n", account.ID, account.Phone, account.Balance, account.Status, account.StatusReason)
+		}
+	})
+}
+
+func (a *App) printPayments(payments []types.Payment) error {
+	result := make([]api.Payment, 0, len(payments))
+	for _, payment := range payments {
+		result = append(result, api.FromPayment(payment))
+	}
+	return a.printValue(result, func(w io.Writer) {
+		fmt.Fprintln(w, "ID\tACCOUNT\tAMOUNT\tCATEGORY\tSTATUS\tTIME")
+		for _, payment := range payments {
+			fmt.Fprintf(w, "%s\t%d\t%d\t%s\t%s\t%s\n", payment.ID, payment.AccountID, payment.Amount, payment.Category, payment.Status, time.Unix(payment.Time, 0).Format("2006-01-02 15:04"))
+		}
+	})
+}
+
+func (a *App) printSchedules(schedules []types.Schedule) error {
+	return a.printValue(schedules, func(w io.Writer) {
+		fmt.Fprintln(w, "ID\tFAVORITE\tKIND\tNEXT\tRUNS\tCOUNT\tACTIVE")
+		for _, schedule := range schedules {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%d\t%d\t%t\n", schedule.ID, schedule.FavoriteID, schedule.Kind, time.Unix(schedule.Next, 0).Format("2006-01-02 15:04"), schedule.Runs, schedule.Count, schedule.Active)
+		}
+	})
+}
+
+func (a *App) printFavorites(favorites []types.Favorite) error {
+	result := make([]api.Favorite, 0, len(favorites))
+	for _, favorite := range favorites {
+		result = append(result, api.FromFavorite(favorite))
+	}
+	return a.printValue(result, func(w io.Writer) {
+		fmt.Fprintln(w, "ID\tACCOUNT\tNAME\tAMOUNT\tCATEGORY")
+		for _, favorite := range favorites {
+			fmt.Fprintf(w, "%s\t%d\t%s\t%d\t%s\n", favorite.ID, favorite.AccountID, favorite.Name, favorite.Amount, favorite.Category)
+		}
+	})
+}
+
+func (a *App) printValue(value interface{}, table func(w io.Writer)) error {
+	if a.Format == "json" {
+		encoder := json.NewEncoder(a.Out)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(value)
+	}
+	tw := tabwriter.NewWriter(a.Out, 0, 0, 2, ' ', 0)
+	table(tw)
+	return tw.Flush()
+}
+
+func parseID(raw string) (int64, error) {
+	id, err := strconv.ParseInt(raw, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid id %q", raw)
+	}
+	return id, nil
+}
+
+func parseIDAmount(rawID string, rawAmount string) (int64, types.Money, error) {
+	id, err := parseID(rawID)
+	if err != nil {
+		return 0, 0, err
+	}
+	amount, err := strconv.ParseInt(rawAmount, 10, 64)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid amount %q", rawAmount)
+	}
+	return id, types.Money(amount), nil
+}
@@ -0,0 +1,231 @@
+package cli
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/SsSJKK/wallet/pkg/api"
+	"github.com/SsSJKK/wallet/pkg/types"
+)
+
+// newDir каталог с первым админом, от его имени работает run
+func newDir(t *testing.T) string {
+	dir := t.TempDir()
+	if out, code := run(t, dir, "staff", "init", "admin"); code != 0 {
+		t.Fatalf("ERROR: staff init %v %q", code, out)
+	}
+	return dir
+}
+
+func run(t *testing.T, dir string, args ...string) (string, int) {
+	out := &bytes.Buffer{}
+	errOut := &bytes.Buffer{}
+	os.Setenv(EnvPassword, "secret")
+	code := Run(append([]string{"-data", dir, "-user", "admin"}, args...), out, errOut)
+	if code != 0 {
+		return errOut.String(), code
+	}
+	return out.String(), code
+}
+
+func Test_CLI_Flow(t *testing.T) {
+	dir := newDir(t)
+	if out, code := run(t, dir, "account", "register", "992000000001"); code != 0 || !strings.Contains(out, "992000000001") {
+		t.Fatalf("ERROR: register %v %q", code, out)
+	}
+	if out, code := run(t, dir, "account", "register", "992000000001"); code != 1 || !strings.Contains(out, "phone already registered") {
+		t.Errorf("ERROR: register twice %v %q", code, out)
+	}
+	run(t, dir, "deposit", "1", "100")
+
+	out, code := run(t, dir, "-format", "json", "pay", "1", "30", "auto")
+	payments := []api.Payment{}
+	if code != 0 || json.Unmarshal([]byte(out), &payments) != nil || len(payments) != 1 {
+		t.Fatalf("ERROR: pay %v %q", code, out)
+	}
+	paymentID := payments[0].ID
+
+	if _, code := run(t, dir, "repeat", paymentID); code != 0 {
+		t.Errorf("ERROR: repeat %v", code)
+	}
+	if out, code := run(t, dir, "reject", paymentID); code != 0 || !strings.Contains(out, "FAIL") {
+		t.Errorf("ERROR: reject %v %q", code, out)
+	}
+	if _, code := run(t, dir, "favorite", "add", paymentID, "car"); code != 0 {
+		t.Errorf("ERROR: favorite add %v", code)
+	}
+	if _, code := run(t, dir, "favorite", "add", "1", "phone", "5", "mobile"); code != 0 {
+		t.Errorf("ERROR: favorite new %v", code)
+	}
+
+	out, _ = run(t, dir, "-format", "json", "favorite", "list", "1")
+	favorites := []api.Favorite{}
+	if json.Unmarshal([]byte(out), &favorites) != nil || len(favorites) != 2 {
+		t.Fatalf("ERROR: favorite list %q", out)
+	}
+	if _, code := run(t, dir, "favorite", "pay", favorites[1].ID); code != 0 {
+		t.Errorf("ERROR: favorite pay %v", code)
+	}
+	if out, code := run(t, dir, "favorite", "update", favorites[0].ID, "fuel", "25", "auto"); code != 0 || !strings.Contains(out, "fuel") {
+		t.Errorf("ERROR: favorite update %v %q", code, out)
+	}
+	if out, code := run(t, dir, "favorite", "reorder", "1", favorites[1].ID, favorites[0].ID); code != 0 || strings.Index(out, "phone") > strings.Index(out, "fuel") {
+		t.Errorf("ERROR: favorite reorder %v %q", code, out)
+	}
+	if _, code := run(t, dir, "favorite", "delete", favorites[0].ID); code != 0 {
+		t.Errorf("ERROR: favorite delete %v", code)
+	}
+	if out, _ := run(t, dir, "favorite", "list", "1"); strings.Contains(out, "fuel") {
+		t.Errorf("ERROR: favorite list after delete %q", out)
+	}
+
+	out, _ = run(t, dir, "-format", "json", "account", "show", "1")
+	accounts := []api.Account{}
+	if json.Unmarshal([]byte(out), &accounts) != nil || len(accounts) != 1 || accounts[0].Balance != 65 {
+		t.Errorf("ERROR: account show %q", out)
+	}
+	if out, _ := run(t, dir, "history", "1"); strings.Count(out, "\n") != 4 {
+		t.Errorf("ERROR: history %q", out)
+	}
+	if out, code := run(t, dir, "report", "1"); code != 0 || !strings.Contains(out, "Closing balance") {
+		t.Errorf("ERROR: report %v %q", code, out)
+	}
+	if out, code := run(t, dir, "audit", "verify"); code != 0 || !strings.Contains(out, "OK") {
+		t.Errorf("ERROR: audit verify %v %q", code, out)
+	}
+
+	backup := t.TempDir()
+	if _, code := run(t, dir, "export", backup); code != 0 {
+		t.Errorf("ERROR: export %v", code)
+	}
+	other := newDir(t)
+	run(t, other, "import", backup)
+	if out, _ := run(t, other, "account", "list"); !strings.Contains(out, "992000000001") {
+		t.Errorf("ERROR: import %q", out)
+	}
+}
+
+func Test_CLI_Webhook(t *testing.T) {
+	received := 0
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { received++ }))
+	defer receiver.Close()
+	dir := newDir(t)
+	if _, code := run(t, dir, "webhook", "add", receiver.URL, "secret"); code != 0 {
+		t.Fatalf("ERROR: webhook add %v", code)
+	}
+	run(t, dir, "account", "register", "992000000001")
+	run(t, dir, "deposit", "1", "100")
+	if out, _ := run(t, dir, "webhook", "pending"); strings.Count(out, "DEPOSIT_MADE") != 1 {
+		t.Errorf("ERROR: pending %q", out)
+	}
+	if out, code := run(t, dir, "webhook", "deliver"); code != 0 || received != 1 || !strings.Contains(out, "DELIVERED  1") {
+		t.Errorf("ERROR: deliver %v %v %q", code, received, out)
+	}
+}
+
+func Test_CLI_Usage(t *testing.T) {
+	dir := newDir(t)
+	if _, code := run(t, dir); code != 2 {
+		t.Errorf("ERROR: no command %v", code)
+	}
+	if _, code := run(t, dir, "pay", "1"); code != 2 {
+		t.Errorf("ERROR: missing args %v", code)
+	}
+	if out, code := run(t, dir, "deposit", "x", "1"); code != 1 || !strings.Contains(out, "invalid id") {
+		t.Errorf("ERROR: bad id %v %q", code, out)
+	}
+	if _, code := run(t, dir, "-format", "xml", "account", "list"); code != 2 {
+		t.Errorf("ERROR: bad format %v", code)
+	}
+}
+
+func Test_CLI_Roles(t *testing.T) {
+	dir := newDir(t)
+	if out, code := run(t, dir, "staff", "init", "root"); code != 1 || !strings.Contains(out, "already registered") {
+		t.Errorf("ERROR: second init %v %q", code, out)
+	}
+	os.Setenv(EnvNewPassword, "secret")
+	if out, code := run(t, dir, "staff", "add", "ivan", "auditor"); code != 0 {
+		t.Fatalf("ERROR: staff add %v %q", code, out)
+	}
+	run(t, dir, "account", "register", "992000000001")
+
+	if out, code := run(t, dir, "-user", "ivan", "deposit", "1", "100"); code != 1 || !strings.Contains(out, "not allowed") {
+		t.Errorf("ERROR: deposit by auditor %v %q", code, out)
+	}
+	if _, code := run(t, dir, "-user", "ivan", "sum"); code != 0 {
+		t.Errorf("ERROR: sum by auditor %v", code)
+	}
+	if out, code := run(t, dir, "-user", "nobody", "sum"); code != 1 || !strings.Contains(out, "invalid") {
+		t.Errorf("ERROR: unknown user %v %q", code, out)
+	}
+	if out, _ := run(t, dir, "audit", "list"); !strings.Contains(out, "auditor:ivan") || !strings.Contains(out, "deny") {
+		t.Errorf("ERROR: denial is not in the audit log %q", out)
+	}
+}
+
+func Test_CLI_RiskRules(t *testing.T) {
+	dir := newDir(t)
+	run(t, dir, "account", "register", "992000000001")
+	run(t, dir, "deposit", "1", "100")
+	if _, code := run(t, dir, "risk", "set", "velocity", "x", "1m"); code != 1 {
+		t.Errorf("ERROR: invalid rule %v", code)
+	}
+	if out, code := run(t, dir, "risk", "set", "blacklist", "casino,lottery", "deny"); code != 0 {
+		t.Fatalf("ERROR: risk set %v %q", code, out)
+	}
+	run(t, dir, "risk", "set", "velocity", "5", "1m")
+	if out, _ := run(t, dir, "risk", "rules"); !strings.Contains(out, "casino,lottery") || !strings.Contains(out, "1m0s") {
+		t.Errorf("ERROR: rules %q", out)
+	}
+	if out, code := run(t, dir, "pay", "1", "10", "casino"); code != 1 || !strings.Contains(out, "blacklist") {
+		t.Errorf("ERROR: pay %v %q", code, out)
+	}
+	run(t, dir, "risk", "remove", "blacklist")
+	if _, code := run(t, dir, "pay", "1", "10", "casino"); code != 0 {
+		t.Errorf("ERROR: pay after remove %v", code)
+	}
+}
+
+func Test_CLI_Schedule(t *testing.T) {
+	dir := newDir(t)
+	run(t, dir, "account", "register", "992000000001")
+	run(t, dir, "deposit", "1", "100")
+	run(t, dir, "favorite", "add", "1", "phone", "5", "mobile")
+	out, _ := run(t, dir, "-format", "json", "favorite", "list", "1")
+	favorites := []api.Favorite{}
+	if json.Unmarshal([]byte(out), &favorites) != nil || len(favorites) != 1 {
+		t.Fatalf("ERROR: favorite list %q", out)
+	}
+	if _, code := run(t, dir, "schedule", "add", favorites[0].ID, "yearly"); code != 1 {
+		t.Errorf("ERROR: invalid kind %v", code)
+	}
+	if out, code := run(t, dir, "schedule", "add", favorites[0].ID, "once"); code != 0 {
+		t.Fatalf("ERROR: schedule add %v %q", code, out)
+	}
+	out, code := run(t, dir, "-format", "json", "schedule", "add", favorites[0].ID, "monthly", "2099-01-31", "3")
+	schedules := []types.Schedule{}
+	if code != 0 || json.Unmarshal([]byte(out), &schedules) != nil || len(schedules) != 1 || schedules[0].Day != 31 || schedules[0].Count != 3 {
+		t.Fatalf("ERROR: schedule monthly %v %q", code, out)
+	}
+	if out, code := run(t, dir, "schedule", "run"); code != 0 || strings.Count(out, "paid") != 1 {
+		t.Errorf("ERROR: schedule run %v %q", code, out)
+	}
+	if out, code := run(t, dir, "schedule", "cancel", schedules[0].ID); code != 0 || !strings.Contains(out, "false") {
+		t.Errorf("ERROR: schedule cancel %v %q", code, out)
+	}
+	out, _ = run(t, dir, "-format", "json", "schedule", "list", favorites[0].ID)
+	if json.Unmarshal([]byte(out), &schedules) != nil || len(schedules) != 2 || schedules[0].Runs != 1 || schedules[1].Active {
+		t.Errorf("ERROR: schedule list %q", out)
+	}
+	out, _ = run(t, dir, "-format", "json", "account", "show", "1")
+	accounts := []api.Account{}
+	if json.Unmarshal([]byte(out), &accounts) != nil || len(accounts) != 1 || accounts[0].Balance != 95 {
+		t.Errorf("ERROR: account show %q", out)
+	}
+}
@@ -0,0 +1,283 @@
+package client
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/SsSJKK/wallet/pkg/api"
+	"github.com/SsSJKK/wallet/pkg/types"
+)
+
+//Client of the wallet HTTP API with the method set of wallet.Service.
+//Errors of the service are returned as the same sentinel errors
+//(wallet.ErrNotEnoughBalance and others) and *wallet.LimitError.
+//Requests go with the Token of the last Login or LoginStaff.
+type Client struct {
+	BaseURL string
+	HTTP    *http.Client
+	Retries int
+	Backoff time.Duration
+	Token   string
+}
+
+//New client with a 10 second timeout and 3 retries
+func New(baseURL string) *Client {
+	return &Client{
+		BaseURL: strings.TrimRight(baseURL, "/"),
+		HTTP:    &http.Client{Timeout: 10 * time.Second},
+		Retries: 3,
+		Backoff: 100 * time.Millisecond,
+	}
+}
+
+//SignUp registers an account with a PIN without a token
+func (c *Client) SignUp(phone types.Phone, pin string) (*types.Account, error) {
+	account := api.Account{}
+	err := c.do(http.MethodPost, "/accounts", api.RegisterRequest{Phone: phone, Pin: pin}, &account)
+	if err != nil {
+		return nil, err
+	}
+	return account.ToAccount(), nil
+}
+
+//Login meth
+func (c *Client) Login(phone types.Phone, pin string) error {
+	return c.login(api.LoginRequest{Phone: phone, Pin: pin})
+}
+
+//LoginStaff meth
+func (c *Client) LoginStaff(name string, password string) error {
+	return c.login(api.LoginRequest{Name: name, Pin: password})
+}
+
+//Logout invalidates the token of the client
+func (c *Client) Logout() error {
+	err := c.do(http.MethodDelete, "/sessions/current", nil, nil)
+	if err != nil {
+		return err
+	}
+	c.Token = ""
+	return nil
+}
+
+//RegisterAccount meth
+func (c *Client) RegisterAccount(phone types.Phone) (*types.Account, error) {
+	account := api.Account{}
+	err := c.do(http.MethodPost, "/accounts", api.RegisterRequest{Phone: phone}, &account)
+	if err != nil {
+		return nil, err
+	}
+	return account.ToAccount(), nil
+}
+
+//FindAccountByID meth
+func (c *Client) FindAccountByID(accountID int64) (*types.Account, error) {
+	account := api.Account{}
+	err := c.do(http.MethodGet, "/accounts/"+strconv.FormatInt(accountID, 10), nil, &account)
+	if err != nil {
+		return nil, err
+	}
+	return account.ToAccount(), nil
+}
+
+//Deposit meth
+func (c *Client) Deposit(accountID int64, amount types.Money) error {
+	return c.do(http.MethodPost, "/accounts/"+strconv.FormatInt(accountID, 10)+"/deposit", api.DepositRequest{Amount: amount}, nil)
+}
+
+//Pay meth
+func (c *Client) Pay(accountID int64, amount types.Money, category types.PaymentCategory) (*types.Payment, error) {
+	return c.payment(http.MethodPost, "/payments", api.PayRequest{AccountID: accountID, Amount: amount, Category: category})
+}
+
+//FindPaymentByID meth
+func (c *Client) FindPaymentByID(paymentID string) (*types.Payment, error) {
+	return c.payment(http.MethodGet, "/payments/"+url.PathEscape(paymentID), nil)
+}
+
+//Reject meth
+func (c *Client) Reject(paymentID string) error {
+	return c.do(http.MethodPost, "/payments/"+url.PathEscape(paymentID)+"/reject", nil, nil)
+}
+
+//Repeat meth
+func (c *Client) Repeat(paymentID string) (*types.Payment, error) {
+	return c.payment(http.MethodPost, "/payments/"+url.PathEscape(paymentID)+"/repeat", nil)
+}
+
+//FavoritePayment meth
+func (c *Client) FavoritePayment(paymentID string, name string) (*types.Favorite, error) {
+	return c.favorite(api.FavoriteRequest{PaymentID: paymentID, Name: name})
+}
+
+//AddFavorite meth
+func (c *Client) AddFavorite(accountID int64, name string, amount types.Money, category types.PaymentCategory) (*types.Favorite, error) {
+	return c.favorite(api.FavoriteRequest{AccountID: accountID, Name: name, Amount: amount, Category: category})
+}
+
+//UpdateFavorite meth
+func (c *Client) UpdateFavorite(favoriteID string, name string, amount types.Money, category types.PaymentCategory) (*types.Favorite, error) {
+	result := api.Favorite{}
+	err := c.do(http.MethodPut, "/favorites/"+url.PathEscape(favoriteID), api.FavoriteUpdateRequest{Name: name, Amount: amount, Category: category}, &result)
+	if err != nil {
+		return nil, err
+	}
+	return result.ToFavorite(), nil
+}
+
+//DeleteFavorite meth
+func (c *Client) DeleteFavorite(favoriteID string) error {
+	return c.do(http.MethodDelete, "/favorites/"+url.PathEscape(favoriteID), nil, nil)
+}
+
+//ReorderFavorites meth
+func (c *Client) ReorderFavorites(accountID int64, favoriteIDs []string) error {
+	return c.do(http.MethodPost, "/accounts/"+strconv.FormatInt(accountID, 10)+"/favorites/order", api.FavoriteOrderRequest{FavoriteIDs: favoriteIDs}, nil)
+}
+
+//PayFromFavorite meth
+func (c *Client) PayFromFavorite(favoriteID string) (*types.Payment, error) {
+	return c.payment(http.MethodPost, "/favorites/"+url.PathEscape(favoriteID)+"/pay", nil)
+}
+
+//AccountFavorites meth
+func (c *Client) AccountFavorites(accountID int64) ([]types.Favorite, error) {
+	result := []api.Favorite{}
+	err := c.do(http.MethodGet, "/accounts/"+strconv.FormatInt(accountID, 10)+"/favorites", nil, &result)
+	if err != nil {
+		return nil, err
+	}
+	favorites := make([]types.Favorite, 0, len(result))
+	for _, favorite := range result {
+		favorites = append(favorites, *favorite.ToFavorite())
+	}
+	return favorites, nil
+}
+
+//ExportAccountHistory meth
+func (c *Client) ExportAccountHistory(accountID int64) ([]types.Payment, error) {
+	result := []api.Payment{}
+	err := c.do(http.MethodGet, "/accounts/"+strconv.FormatInt(accountID, 10)+"/history", nil, &result)
+	if err != nil {
+		return nil, err
+	}
+	payments := make([]types.Payment, 0, len(result))
+	for _, payment := range result {
+		payments = append(payments, *payment.ToPayment())
+	}
+	return payments, nil
+}
+
+func (c *Client) login(req api.LoginRequest) error {
+	session := api.Session{}
+	err := c.do(http.MethodPost, "/sessions", req, &session)
+	if err != nil {
+		return err
+	}
+	c.Token = session.Token
+	return nil
+}
+
+func (c *Client) payment(method string, path string, body interface{}) (*types.Payment, error) {
+	payment := api.Payment{}
+	err := c.do(method, path, body, &payment)
+	if err != nil {
+		return nil, err
+	}
+	return payment.ToPayment(), nil
+}
+
+func (c *Client) favorite(req api.FavoriteRequest) (*types.Favorite, error) {
+	favorite := api.Favorite{}
+	err := c.do(http.MethodPost, "/favorites", req, &favorite)
+	if err != nil {
+		return nil, err
+	}
+	return favorite.ToFavorite(), nil
+}
+
+// do отправляет запрос и повторяет его при сетевых ошибках и ответах 5xx.
+// Все попытки POST идут с одним Idempotency-Key, поэтому сервер применит
+// операцию один раз.
+func (c *Client) do(method string, path string, body interface{}, out interface{}) error {
+	var data []byte
+	if body != nil {
+		var err error
+		data, err = json.Marshal(body)
+		if err != nil {
+			return err
+		}
+	}
+	key := uuid.New().String()
+
+	var lastErr error
+	for attempt := 0; attempt <= c.Retries; attempt++ {
+		if attempt > 0 {
+			time.Sleep(c.Backoff << (attempt - 1))
+		}
+		retry, err := c.send(method, path, data, key, out)
+		if err == nil {
+			return nil
+		}
+		lastErr = err
+		if !retry {
+			return err
+		}
+	}
+	return lastErr
+}
+
+func (c *Client) send(method string, path string, data []byte, key string, out interface{}) (bool, error) {
+	var reader io.Reader
+	if data != nil {
+		reader = bytes.NewReader(data)
+	}
+	req, err := http.NewRequest(method, c.BaseURL+path, reader)
+	if err != nil {
+		return false, err
+	}
+	if data != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if method == http.MethodPost {
+		req.Header.Set("Idempotency-Key", key)
+	}
+	if c.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.Token)
+	}
+
+	resp, err := c.HTTP.Do(req)
+	if err != nil {
+		return true, err
+	}
+	defer resp.Body.Close()
+	payload, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return true, err
+	}
+
+	if resp.StatusCode >= 200 && resp.StatusCode <= 299 {
+		if out == nil {
+			return false, nil
+		}
+		return false, json.Unmarshal(payload, out)
+	}
+
+	e := &api.Error{}
+	if json.Unmarshal(payload, e) != nil || e.Code == "" {
+		e = &api.Error{Code: api.CodeInternal, Message: fmt.Sprintf("%s: %s", resp.Status, strings.TrimSpace(string(payload)))}
+	}
+	e.HTTPCode = resp.StatusCode
+	retry := resp.StatusCode >= http.StatusInternalServerError || resp.StatusCode == http.StatusTooManyRequests
+	return retry, e.Err()
+}
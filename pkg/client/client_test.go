@@ -0,0 +1,193 @@
+package client
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/SsSJKK/wallet/pkg/server"
+	"github.com/SsSJKK/wallet/pkg/types"
+	"github.com/SsSJKK/wallet/pkg/wallet"
+)
+
+func newTestClient(t *testing.T, handler http.Handler) *Client {
+	srv := httptest.NewServer(handler)
+	t.Cleanup(srv.Close)
+	c := New(srv.URL)
+	c.Backoff = time.Millisecond
+	return c
+}
+
+func Test_Client_Flow(t *testing.T) {
+	svc := &wallet.Service{}
+	svc.RegisterStaff("anna", wallet.RoleSupport, "secret")
+	c := newTestClient(t, server.New(svc))
+	support := New(c.BaseURL)
+	if err := support.LoginStaff("anna", "secret"); err != nil {
+		t.Fatalf("ERROR: staff login %v", err)
+	}
+
+	acc, err := c.SignUp("992000000001", "1234")
+	if err != nil {
+		t.Fatalf("ERROR: %v", err)
+	}
+	if _, err := c.SignUp("992000000001", "1234"); err != wallet.ErrPhoneRegistered {
+		t.Errorf("ERROR: %v need %v", err, wallet.ErrPhoneRegistered)
+	}
+	if err := c.Login("992000000001", "0000"); err != wallet.ErrInvalidCredentials {
+		t.Errorf("ERROR: %v need %v", err, wallet.ErrInvalidCredentials)
+	}
+	if err := c.Login("992000000001", "1234"); err != nil {
+		t.Fatalf("ERROR: login %v", err)
+	}
+	if err := c.Deposit(acc.ID, 100); err != wallet.ErrForbidden {
+		t.Errorf("ERROR: deposit by customer %v need %v", err, wallet.ErrForbidden)
+	}
+	if err := support.Deposit(acc.ID, 100); err != nil {
+		t.Errorf("ERROR: %v", err)
+	}
+
+	pay, err := c.Pay(acc.ID, 30, "auto")
+	if err != nil || pay.Amount != 30 || pay.Status != types.PaymentStatusInProgress {
+		t.Fatalf("ERROR: %v %v", err, pay)
+	}
+	if _, err := c.Pay(acc.ID, 1000, "auto"); err != wallet.ErrNotEnoughBalance {
+		t.Errorf("ERROR: %v need %v", err, wallet.ErrNotEnoughBalance)
+	}
+	if _, err := c.Repeat(pay.ID); err != nil {
+		t.Errorf("ERROR: repeat %v", err)
+	}
+	if err := c.Reject(pay.ID); err != wallet.ErrForbidden {
+		t.Errorf("ERROR: reject by customer %v need %v", err, wallet.ErrForbidden)
+	}
+	if err := support.Reject(pay.ID); err != nil {
+		t.Errorf("ERROR: reject %v", err)
+	}
+	if err := support.Reject("unknown"); err != wallet.ErrPaymentNotFound {
+		t.Errorf("ERROR: %v need %v", err, wallet.ErrPaymentNotFound)
+	}
+
+	fav, err := c.FavoritePayment(pay.ID, "car")
+	if err != nil {
+		t.Fatalf("ERROR: %v", err)
+	}
+	if _, err := c.PayFromFavorite(fav.ID); err != nil {
+		t.Errorf("ERROR: pay favorite %v", err)
+	}
+	if _, err := c.PayFromFavorite("unknown"); err != wallet.ErrFavoriteNotFound {
+		t.Errorf("ERROR: %v need %v", err, wallet.ErrFavoriteNotFound)
+	}
+	if fav, err = c.UpdateFavorite(fav.ID, "fuel", 30, "auto"); err != nil || fav.Name != "fuel" {
+		t.Errorf("ERROR: update favorite %v %v", err, fav)
+	}
+	if err := c.ReorderFavorites(acc.ID, []string{fav.ID, fav.ID}); err != wallet.ErrInvalidFavoriteOrder {
+		t.Errorf("ERROR: %v need %v", err, wallet.ErrInvalidFavoriteOrder)
+	}
+	if err := c.DeleteFavorite(fav.ID); err != nil {
+		t.Errorf("ERROR: delete favorite %v", err)
+	}
+	if favorites, err := c.AccountFavorites(acc.ID); err != nil || len(favorites) != 0 {
+		t.Errorf("ERROR: favorites %v %v", err, favorites)
+	}
+
+	history, err := c.ExportAccountHistory(acc.ID)
+	if err != nil || len(history) != 3 {
+		t.Errorf("ERROR: history %v %v", err, history)
+	}
+	got, err := c.FindAccountByID(acc.ID)
+	if err != nil || got.Balance != 40 {
+		t.Errorf("ERROR: account %v %v", err, got)
+	}
+
+	if err := c.Logout(); err != nil {
+		t.Errorf("ERROR: logout %v", err)
+	}
+	if _, err := c.FindAccountByID(acc.ID); err != wallet.ErrUnauthorized {
+		t.Errorf("ERROR: %v need %v", err, wallet.ErrUnauthorized)
+	}
+}
+
+func Test_Client_LimitError(t *testing.T) {
+	svc := &wallet.Service{}
+	acc, _ := svc.RegisterAccount("992000000001")
+	svc.Deposit(acc.ID, 100)
+	svc.SetLimit(wallet.Limit{AccountID: acc.ID, Transaction: 10})
+	svc.SetPIN(acc.ID, "1234")
+	c := newTestClient(t, server.New(svc))
+	c.Login(acc.Phone, "1234")
+
+	_, err := c.Pay(acc.ID, 20, "auto")
+	limitErr, ok := err.(*wallet.LimitError)
+	if !ok || limitErr.Kind != wallet.LimitTransaction || limitErr.Remaining != 10 || !errors.Is(err, wallet.ErrLimitExceeded) {
+		t.Errorf("ERROR: %v", err)
+	}
+}
+
+type flaky struct {
+	mu       sync.Mutex
+	next     http.Handler
+	failures int
+	keys     []string
+}
+
+// ServeHTTP выполняет запрос, но первые ответы теряет, как при обрыве связи
+func (f *flaky) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	fail := f.failures > 0
+	f.failures--
+	f.keys = append(f.keys, r.Header.Get(server.HeaderIdempotencyKey))
+	f.mu.Unlock()
+
+	if fail {
+		f.next.ServeHTTP(httptest.NewRecorder(), r)
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+	f.next.ServeHTTP(w, r)
+}
+
+func Test_Client_RetryIdempotent(t *testing.T) {
+	svc := &wallet.Service{}
+	acc, _ := svc.RegisterAccount("992000000001")
+	svc.Deposit(acc.ID, 100)
+	svc.SetPIN(acc.ID, "1234")
+	f := &flaky{next: server.New(svc)}
+	c := newTestClient(t, f)
+	c.Login(acc.Phone, "1234")
+	f.keys = nil
+	f.failures = 2
+
+	pay, err := c.Pay(acc.ID, 30, "auto")
+	if err != nil {
+		t.Fatalf("ERROR: %v", err)
+	}
+	if len(f.keys) != 3 || f.keys[0] == "" || f.keys[0] != f.keys[1] || f.keys[1] != f.keys[2] {
+		t.Errorf("ERROR: keys %v", f.keys)
+	}
+	history, _ := svc.ExportAccountHistory(acc.ID)
+	if len(history) != 1 || history[0].ID != pay.ID || acc.Balance != 70 {
+		t.Errorf("ERROR: payment applied %d times, balance %v", len(history), acc.Balance)
+	}
+
+	f.failures = 10
+	c.Retries = 1
+	if _, err := c.Pay(acc.ID, 30, "auto"); err == nil {
+		t.Errorf("ERROR: need error after retries")
+	}
+}
+
+func Test_Client_Timeout(t *testing.T) {
+	block := make(chan struct{})
+	c := newTestClient(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-block
+	}))
+	defer close(block)
+	c.HTTP.Timeout = 20 * time.Millisecond
+	c.Retries = 0
+	if _, err := c.FindAccountByID(1); err == nil {
+		t.Errorf("ERROR: need timeout error")
+	}
+}
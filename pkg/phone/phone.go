@@ -0,0 +1,140 @@
+package phone
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/SsSJKK/wallet/pkg/types"
+)
+
+//ErrInvalidPhone err
+var ErrInvalidPhone = errors.New("invalid phone number")
+
+//DefaultCountry calling code used for numbers written without one
+const DefaultCountry = "992"
+
+//Country numbering rules of a calling code
+type Country struct {
+	Code    string
+	ISO     string
+	Lengths []int
+	Trunk   string
+}
+
+//Countries known calling codes. Numbers of other codes are checked only
+//against the E.164 length.
+var Countries = []Country{
+	{Code: "1", ISO: "US", Lengths: []int{10}},
+	{Code: "7", ISO: "RU", Lengths: []int{10}, Trunk: "8"},
+	{Code: "44", ISO: "GB", Lengths: []int{9, 10}, Trunk: "0"},
+	{Code: "49", ISO: "DE", Lengths: []int{10, 11}, Trunk: "0"},
+	{Code: "86", ISO: "CN", Lengths: []int{11}, Trunk: "0"},
+	{Code: "90", ISO: "TR", Lengths: []int{10}, Trunk: "0"},
+	{Code: "91", ISO: "IN", Lengths: []int{10}, Trunk: "0"},
+	{Code: "992", ISO: "TJ", Lengths: []int{9}},
+	{Code: "993", ISO: "TM", Lengths: []int{8}, Trunk: "8"},
+	{Code: "994", ISO: "AZ", Lengths: []int{9}, Trunk: "0"},
+	{Code: "996", ISO: "KG", Lengths: []int{9}, Trunk: "0"},
+	{Code: "998", ISO: "UZ", Lengths: []int{9}},
+}
+
+const (
+	minDigits = 8
+	maxDigits = 15
+)
+
+//Normalize parses a phone number and returns it in E.164 form, e.g.
+//"+992000000001". Numbers without "+" or "00" are read as numbers of
+//defaultCode, with or without the calling code and the trunk prefix.
+//Spaces, dashes, dots and parentheses are ignored.
+func Normalize(raw string, defaultCode string) (types.Phone, error) {
+	text := strings.TrimSpace(raw)
+	international := false
+	switch {
+	case strings.HasPrefix(text, "+"):
+		text = text[1:]
+		international = true
+	case strings.HasPrefix(text, "00"):
+		text = text[2:]
+		international = true
+	}
+	digits := make([]byte, 0, len(text))
+	for i := 0; i < len(text); i++ {
+		c := text[i]
+		switch {
+		case c >= '0' && c <= '9':
+			digits = append(digits, c)
+		case c == ' ' || c == '-' || c == '.' || c == '(' || c == ')':
+		default:
+			return "", ErrInvalidPhone
+		}
+	}
+	number := string(digits)
+	if !international {
+		number = national(number, defaultCode)
+	}
+	if !valid(number) {
+		return "", ErrInvalidPhone
+	}
+	return types.Phone("+" + number), nil
+}
+
+// national дописывает код страны к номеру без него
+func national(number string, code string) string {
+	country, ok := Lookup(code)
+	if !ok {
+		if strings.HasPrefix(number, code) {
+			return number
+		}
+		return code + number
+	}
+	switch {
+	case strings.HasPrefix(number, country.Code) && country.fits(len(number)-len(country.Code)):
+		return number
+	case country.Trunk != "" && strings.HasPrefix(number, country.Trunk) && country.fits(len(number)-len(country.Trunk)):
+		return country.Code + number[len(country.Trunk):]
+	}
+	return country.Code + number
+}
+
+func valid(number string) bool {
+	if len(number) < minDigits || len(number) > maxDigits || number[0] == '0' {
+		return false
+	}
+	country, ok := Find(number)
+	if !ok {
+		return true
+	}
+	return country.fits(len(number) - len(country.Code))
+}
+
+func (c Country) fits(length int) bool {
+	for _, l := range c.Lengths {
+		if l == length {
+			return true
+		}
+	}
+	return false
+}
+
+//Lookup returns the rules of the calling code
+func Lookup(code string) (Country, bool) {
+	for _, country := range Countries {
+		if country.Code == code {
+			return country, true
+		}
+	}
+	return Country{}, false
+}
+
+//Find returns the rules of the country the international number (digits
+//without "+") belongs to. Calling codes don't prefix each other, so at
+//most one matches.
+func Find(number string) (Country, bool) {
+	for _, country := range Countries {
+		if strings.HasPrefix(number, country.Code) {
+			return country, true
+		}
+	}
+	return Country{}, false
+}
@@ -0,0 +1,39 @@
+package phone
+
+import (
+	"testing"
+
+	"github.com/SsSJKK/wallet/pkg/types"
+)
+
+func Test_Normalize(t *testing.T) {
+	tests := []struct {
+		raw  string
+		code string
+		want types.Phone
+	}{
+		{"992000000001", "992", "+992000000001"},
+		{"+992 000 000 001", "992", "+992000000001"},
+		{"00992-000-000-001", "992", "+992000000001"},
+		{"93 123 4567", "992", "+992931234567"},
+		{"(900) 123-45-67", "7", "+79001234567"},
+		{"8 900 123 45 67", "7", "+79001234567"},
+		{"+7 900 123 45 67", "992", "+79001234567"},
+		{"07911 123456", "44", "+447911123456"},
+		{"+359 88 123 4567", "992", "+359881234567"},
+	}
+	for _, test := range tests {
+		got, err := Normalize(test.raw, test.code)
+		if err != nil || got != test.want {
+			t.Errorf("ERROR: %q got %q %v need %q", test.raw, got, err, test.want)
+		}
+	}
+}
+
+func Test_Normalize_Invalid(t *testing.T) {
+	for _, raw := range []string{"", "1010", "+992 00 000 001", "+7 900 123", "99200000000a", "+0123456789", "+1234567890123456"} {
+		if got, err := Normalize(raw, DefaultCountry); err != ErrInvalidPhone {
+			t.Errorf("ERROR: %q got %q %v", raw, got, err)
+		}
+	}
+}
@@ -0,0 +1,221 @@
+package reconcile
+
+import (
+	"sort"
+	"time"
+
+	"github.com/SsSJKK/wallet/pkg/types"
+	"github.com/SsSJKK/wallet/pkg/wallet"
+)
+
+//DefaultTolerance how far the statement time may be from the payment time
+const DefaultTolerance = 24 * time.Hour
+
+//Entry one line of an external statement
+type Entry struct {
+	ID     string      `json:"id,omitempty"`
+	Amount types.Money `json:"amount"`
+	Time   int64       `json:"time"`
+	Line   int         `json:"line"`
+}
+
+//Match payment found in the statement
+type Match struct {
+	PaymentID string `json:"paymentId"`
+	Line      int    `json:"line"`
+	ByID      bool   `json:"byId"`
+}
+
+//Mismatch payment and entry with the same ID that differ in amount, in time
+//by more than the tolerance or because our payment failed
+type Mismatch struct {
+	PaymentID   string      `json:"paymentId"`
+	Line        int         `json:"line"`
+	Reason      string      `json:"reason"`
+	OurAmount   types.Money `json:"ourAmount"`
+	TheirAmount types.Money `json:"theirAmount"`
+	OurTime     int64       `json:"ourTime"`
+	TheirTime   int64       `json:"theirTime"`
+}
+
+//Mismatch reasons
+const (
+	ReasonAmount = "amount"
+	ReasonDate   = "date"
+	ReasonStatus = "status"
+)
+
+//Options of Reconcile. Only payments from From to To are expected in the
+//statement, zero bounds are taken from the statement times widened by the
+//tolerance.
+type Options struct {
+	Tolerance time.Duration
+	From      time.Time
+	To        time.Time
+}
+
+//Result of a reconciliation. MissingOurs are statement entries we have no
+//payment for, MissingTheirs are our payments absent from the statement.
+type Result struct {
+	From          time.Time       `json:"from"`
+	To            time.Time       `json:"to"`
+	Matched       []Match         `json:"matched"`
+	MissingOurs   []Entry         `json:"missingOurs"`
+	MissingTheirs []types.Payment `json:"missingTheirs"`
+	Mismatched    []Mismatch      `json:"mismatched"`
+}
+
+//OK tells whether the statement and the payments agree
+func (r *Result) OK() bool {
+	return len(r.MissingOurs) == 0 && len(r.MissingTheirs) == 0 && len(r.Mismatched) == 0
+}
+
+//Reconcile matches the statement with the payments. An entry is matched to
+//the payment with its ID, entries without a known ID are matched to a
+//payment with the same amount and the nearest time within the tolerance.
+//Failed payments did not move money, an entry with the ID of one is a status
+//mismatch. Fees, interest and payouts are internal and are not expected in
+//the statement.
+func Reconcile(payments []types.Payment, statement []Entry, opts Options) *Result {
+	if opts.Tolerance <= 0 {
+		opts.Tolerance = DefaultTolerance
+	}
+	result := &Result{
+		From:          opts.From,
+		To:            opts.To,
+		Matched:       []Match{},
+		MissingOurs:   []Entry{},
+		MissingTheirs: []types.Payment{},
+		Mismatched:    []Mismatch{},
+	}
+	if result.From.IsZero() || result.To.IsZero() {
+		from, to := bounds(statement)
+		if result.From.IsZero() {
+			result.From = time.Unix(from, 0).Add(-opts.Tolerance).UTC()
+		}
+		if result.To.IsZero() {
+			result.To = time.Unix(to, 0).Add(opts.Tolerance).UTC()
+		}
+	}
+	tolerance := int64(opts.Tolerance / time.Second)
+
+	ours := map[string]*types.Payment{}
+	open := []*types.Payment{}
+	for i := range payments {
+		payment := &payments[i]
+		if internal(payment) {
+			continue
+		}
+		ours[payment.ID] = payment
+		if payment.Status != types.PaymentStatusFail {
+			open = append(open, payment)
+		}
+	}
+	used := map[string]bool{}
+
+	rest := []Entry{}
+	for _, entry := range statement {
+		payment, ok := ours[entry.ID]
+		if !ok || entry.ID == "" || used[entry.ID] {
+			rest = append(rest, entry)
+			continue
+		}
+		used[payment.ID] = true
+		switch {
+		case payment.Status == types.PaymentStatusFail:
+			result.Mismatched = append(result.Mismatched, mismatch(payment, entry, ReasonStatus))
+		case payment.Amount != entry.Amount:
+			result.Mismatched = append(result.Mismatched, mismatch(payment, entry, ReasonAmount))
+		case abs(payment.Time-entry.Time) > tolerance:
+			result.Mismatched = append(result.Mismatched, mismatch(payment, entry, ReasonDate))
+		default:
+			result.Matched = append(result.Matched, Match{PaymentID: payment.ID, Line: entry.Line, ByID: true})
+		}
+	}
+
+	for _, entry := range rest {
+		var best *types.Payment
+		for _, payment := range open {
+			if used[payment.ID] || payment.Amount != entry.Amount || abs(payment.Time-entry.Time) > tolerance {
+				continue
+			}
+			if best == nil || abs(payment.Time-entry.Time) < abs(best.Time-entry.Time) {
+				best = payment
+			}
+		}
+		if best == nil {
+			result.MissingOurs = append(result.MissingOurs, entry)
+			continue
+		}
+		used[best.ID] = true
+		result.Matched = append(result.Matched, Match{PaymentID: best.ID, Line: entry.Line})
+	}
+
+	from, to := result.From.Unix(), result.To.Unix()
+	for _, payment := range open {
+		if !used[payment.ID] && payment.Time >= from && payment.Time <= to {
+			result.MissingTheirs = append(result.MissingTheirs, *payment)
+		}
+	}
+	sort.Slice(result.Matched, func(i, j int) bool { return result.Matched[i].Line < result.Matched[j].Line })
+	return result
+}
+
+//Source payments to reconcile: wallet.Service or wallet.Actor
+type Source interface {
+	FilterPaymentsByFn(filter func(payment types.Payment) bool, goroutines int) ([]types.Payment, error)
+}
+
+//Service reconciles the statement with all payments of the service
+func Service(svc Source, statement []Entry, opts Options) (*Result, error) {
+	payments, err := svc.FilterPaymentsByFn(func(types.Payment) bool { return true }, 1)
+	if err != nil {
+		return nil, err
+	}
+	return Reconcile(payments, statement, opts), nil
+}
+
+func mismatch(payment *types.Payment, entry Entry, reason string) Mismatch {
+	return Mismatch{
+		PaymentID:   payment.ID,
+		Line:        entry.Line,
+		Reason:      reason,
+		OurAmount:   payment.Amount,
+		TheirAmount: entry.Amount,
+		OurTime:     payment.Time,
+		TheirTime:   entry.Time,
+	}
+}
+
+// internal комиссии и другие связанные записи, проценты и выплаты остатка
+// проходят только внутри кошелька
+func internal(payment *types.Payment) bool {
+	switch payment.Category {
+	case wallet.FeeCategory, wallet.InterestCategory, wallet.PayoutCategory:
+		return true
+	}
+	return payment.ParentID != ""
+}
+
+func bounds(statement []Entry) (int64, int64) {
+	if len(statement) == 0 {
+		return 0, 0
+	}
+	from, to := statement[0].Time, statement[0].Time
+	for _, entry := range statement {
+		if entry.Time < from {
+			from = entry.Time
+		}
+		if entry.Time > to {
+			to = entry.Time
+		}
+	}
+	return from, to
+}
+
+func abs(value int64) int64 {
+	if value < 0 {
+		return -value
+	}
+	return value
+}
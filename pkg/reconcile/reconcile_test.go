@@ -0,0 +1,87 @@
+package reconcile
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/SsSJKK/wallet/pkg/types"
+)
+
+var day = time.Date(2020, time.November, 3, 12, 0, 0, 0, time.UTC).Unix()
+
+var payments = []types.Payment{
+	{ID: "p1", AccountID: 1, Amount: 100, Category: "auto", Status: types.PaymentStatusOk, Time: day},
+	{ID: "p2", AccountID: 1, Amount: 200, Category: "food", Status: types.PaymentStatusOk, Time: day + 60},
+	{ID: "p3", AccountID: 1, Amount: 300, Category: "food", Status: types.PaymentStatusOk, Time: day + 120},
+	{ID: "p4", AccountID: 1, Amount: 400, Category: "food", Status: types.PaymentStatusFail, Time: day + 180},
+	{ID: "p5", AccountID: 2, Amount: 500, Category: "auto", Status: types.PaymentStatusInProgress, Time: day + 240},
+	{ID: "p6", AccountID: 2, Amount: 600, Category: "auto", Status: types.PaymentStatusOk, Time: day - 3*24*3600},
+	{ID: "p7", AccountID: 2, Amount: 700, Category: "auto", Status: types.PaymentStatusOk, Time: day + 300},
+	{ID: "f7", AccountID: 2, Amount: 7, Category: "fee", Status: types.PaymentStatusOk, Time: day + 300, ParentID: "p7"},
+	{ID: "i1", AccountID: 2, Amount: 3, Category: "interest", Status: types.PaymentStatusOk, Time: day + 400},
+}
+
+func Test_Reconcile(t *testing.T) {
+	statement := []Entry{
+		{ID: "p1", Amount: 100, Time: day + 3600, Line: 1},
+		{ID: "p2", Amount: 250, Time: day + 60, Line: 2},
+		{ID: "p3", Amount: 300, Time: day + 5*3600, Line: 3},
+		{Amount: 500, Time: day + 200, Line: 4},
+		{ID: "x1", Amount: 900, Time: day, Line: 5},
+		{ID: "p4", Amount: 400, Time: day + 180, Line: 6},
+	}
+	result := Reconcile(payments, statement, Options{Tolerance: 2 * time.Hour})
+
+	if len(result.Matched) != 2 || result.Matched[0] != (Match{PaymentID: "p1", Line: 1, ByID: true}) || result.Matched[1] != (Match{PaymentID: "p5", Line: 4}) {
+		t.Errorf("ERROR: matched %+v", result.Matched)
+	}
+	if len(result.Mismatched) != 3 || result.Mismatched[0].Reason != ReasonAmount || result.Mismatched[1].Reason != ReasonDate || result.Mismatched[2].Reason != ReasonStatus {
+		t.Errorf("ERROR: mismatched %+v", result.Mismatched)
+	}
+	if len(result.MissingOurs) != 1 || result.MissingOurs[0].Line != 5 {
+		t.Errorf("ERROR: missing ours %+v", result.MissingOurs)
+	}
+	// p6 раньше периода выписки, комиссии и проценты в выписку не попадают
+	if len(result.MissingTheirs) != 1 || result.MissingTheirs[0].ID != "p7" {
+		t.Errorf("ERROR: missing theirs %+v", result.MissingTheirs)
+	}
+	if result.OK() {
+		t.Error("ERROR: result must not be OK")
+	}
+
+	buf := &bytes.Buffer{}
+	WriteJSON(buf, result)
+	decoded := Result{}
+	if err := json.Unmarshal(buf.Bytes(), &decoded); err != nil || len(decoded.Mismatched) != 3 {
+		t.Errorf("ERROR: json %v %s", err, buf)
+	}
+}
+
+func Test_ReadStatement(t *testing.T) {
+	dump := "p1;1;100;auto;OK;1604404800;\np4;1;400;food;FAIL;1604404800;\np2;1;200;food;INPROGRESS;1604404860;;m1;\n"
+	entries, err := ReadStatement(strings.NewReader(dump))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 || entries[1] != (Entry{ID: "p2", Amount: 200, Time: 1604404860, Line: 3}) {
+		t.Errorf("ERROR: dump entries %+v", entries)
+	}
+
+	csv := "Date,Amount,ID\n2020-11-03 12:00:00,100,p1\n2020-11-03,\"200\",\n"
+	entries, err = ReadStatement(strings.NewReader(csv))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 || entries[0] != (Entry{ID: "p1", Amount: 100, Time: day, Line: 2}) || entries[1].ID != "" || entries[1].Time != day-12*3600 {
+		t.Errorf("ERROR: csv entries %+v", entries)
+	}
+
+	_, err = ReadStatement(strings.NewReader("id,amount,time\np1,1.5,2020-11-03\n"))
+	if !errors.Is(err, ErrInvalidStatement) {
+		t.Errorf("ERROR: %v need %v", err, ErrInvalidStatement)
+	}
+}
@@ -0,0 +1,40 @@
+package reconcile
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"text/tabwriter"
+	"time"
+)
+
+const timeLayout = "2006-01-02 15:04"
+
+//WriteText renders the result for people
+func WriteText(w io.Writer, r *Result) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(tw, "Period\t%s - %s\n", r.From.Format(timeLayout), r.To.Format(timeLayout))
+	fmt.Fprintf(tw, "Matched\t%d\n", len(r.Matched))
+	fmt.Fprintf(tw, "Missing on our side\t%d\n", len(r.MissingOurs))
+	for _, entry := range r.MissingOurs {
+		fmt.Fprintf(tw, "  line %d\t%s\t%d\t%s\n", entry.Line, entry.ID, entry.Amount, time.Unix(entry.Time, 0).UTC().Format(timeLayout))
+	}
+	fmt.Fprintf(tw, "Missing on their side\t%d\n", len(r.MissingTheirs))
+	for _, payment := range r.MissingTheirs {
+		fmt.Fprintf(tw, "  %s\t%d\t%s\n", payment.ID, payment.Amount, time.Unix(payment.Time, 0).UTC().Format(timeLayout))
+	}
+	fmt.Fprintf(tw, "Mismatched\t%d\n", len(r.Mismatched))
+	for _, m := range r.Mismatched {
+		fmt.Fprintf(tw, "  line %d\t%s\t%s\tours %d %s\ttheirs %d %s\n", m.Line, m.PaymentID, m.Reason,
+			m.OurAmount, time.Unix(m.OurTime, 0).UTC().Format(timeLayout),
+			m.TheirAmount, time.Unix(m.TheirTime, 0).UTC().Format(timeLayout))
+	}
+	return tw.Flush()
+}
+
+//WriteJSON renders the result for machines
+func WriteJSON(w io.Writer, r *Result) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(r)
+}
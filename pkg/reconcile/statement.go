@@ -0,0 +1,144 @@
+package reconcile
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/SsSJKK/wallet/pkg/types"
+)
+
+//ErrInvalidStatement err
+var ErrInvalidStatement = errors.New("invalid statement")
+
+var timeLayouts = []string{time.RFC3339, "2006-01-02 15:04:05", "2006-01-02 15:04", "2006-01-02"}
+
+//ReadStatement reads a statement in the payments.dump format of the wallet
+//or as CSV with a header that has id, amount and time (or date) columns.
+//Amounts are in minor units, times are unix seconds or dates in UTC.
+//Failed payments of a dump are skipped.
+func ReadStatement(r io.Reader) ([]Entry, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	first := strings.TrimSpace(strings.SplitN(string(data), "\n", 2)[0])
+	if strings.Contains(first, ";") {
+		return readDump(bytes.NewReader(data))
+	}
+	return readCSV(bytes.NewReader(data))
+}
+
+//ReadStatementFile meth
+func ReadStatementFile(path string) ([]Entry, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return ReadStatement(file)
+}
+
+func readDump(r io.Reader) ([]Entry, error) {
+	entries := []Entry{}
+	scanner := bufio.NewScanner(r)
+	line := 0
+	for scanner.Scan() {
+		line++
+		if strings.TrimSpace(scanner.Text()) == "" {
+			continue
+		}
+		fields := strings.Split(scanner.Text(), ";")
+		if len(fields) < 6 {
+			return nil, fmt.Errorf("line %d: %w", line, ErrInvalidStatement)
+		}
+		if types.PaymentStatus(fields[4]) == types.PaymentStatusFail {
+			continue
+		}
+		amount, err := strconv.ParseInt(fields[2], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: amount %q: %w", line, fields[2], ErrInvalidStatement)
+		}
+		created, err := parseTime(fields[5])
+		if err != nil {
+			return nil, fmt.Errorf("line %d: time %q: %w", line, fields[5], ErrInvalidStatement)
+		}
+		entries = append(entries, Entry{ID: fields[0], Amount: types.Money(amount), Time: created, Line: line})
+	}
+	return entries, scanner.Err()
+}
+
+func readCSV(r io.Reader) ([]Entry, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	header, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("header: %w", ErrInvalidStatement)
+	}
+	columns := map[string]int{}
+	for i, name := range header {
+		columns[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	id, hasID := columns["id"]
+	amountColumn, hasAmount := columns["amount"]
+	timeColumn, hasTime := columns["time"]
+	if !hasTime {
+		timeColumn, hasTime = columns["date"]
+	}
+	if !hasAmount || !hasTime {
+		return nil, fmt.Errorf("header must have amount and time columns: %w", ErrInvalidStatement)
+	}
+
+	entries := []Entry{}
+	line := 1
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		line++
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v: %w", line, err, ErrInvalidStatement)
+		}
+		if len(record) <= amountColumn || len(record) <= timeColumn || (hasID && len(record) <= id) {
+			return nil, fmt.Errorf("line %d: %w", line, ErrInvalidStatement)
+		}
+		amount, err := strconv.ParseInt(strings.TrimSpace(record[amountColumn]), 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: amount %q: %w", line, record[amountColumn], ErrInvalidStatement)
+		}
+		created, err := parseTime(record[timeColumn])
+		if err != nil {
+			return nil, fmt.Errorf("line %d: time %q: %w", line, record[timeColumn], ErrInvalidStatement)
+		}
+		entry := Entry{Amount: types.Money(amount), Time: created, Line: line}
+		if hasID {
+			entry.ID = strings.TrimSpace(record[id])
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+func parseTime(raw string) (int64, error) {
+	raw = strings.TrimSpace(raw)
+	unix, err := strconv.ParseInt(raw, 10, 64)
+	if err == nil {
+		return unix, nil
+	}
+	for _, layout := range timeLayouts {
+		parsed, err := time.Parse(layout, raw)
+		if err == nil {
+			return parsed.Unix(), nil
+		}
+	}
+	return 0, ErrInvalidStatement
+}
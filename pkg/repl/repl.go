@@ -0,0 +1,357 @@
+package repl
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/SsSJKK/wallet/pkg/types"
+	"github.com/SsSJKK/wallet/pkg/wallet"
+)
+
+const help = `commands:
+  find <phone>        open the account with the phone
+  account <id>        open the account by id
+  history             first page of payments of the open account
+  next, prev          scroll the history
+  show <n|paymentID>  payment details (n is the row of the history page)
+  reject <n|id>       reject an in-progress payment and return the money
+  refund <n|id>       return the money of a completed payment
+  repeat <n|id>       pay the same amount and category again
+  favorites           favorites of the open account
+  favorite update <n|id> <name> <amount> <category>
+  favorite delete <n|id>
+  favorite order <n|id>...  new order of all favorites of the account
+  save                export to the data directory
+  help                this text
+  quit                leave, asks to save unsaved changes
+`
+
+//REPL interactive console for support staff, commands run with the rights
+//of Actor
+type REPL struct {
+	Svc      *wallet.Service
+	Actor    *wallet.Actor
+	Dir      string
+	PageSize int
+
+	in      *bufio.Scanner
+	out     io.Writer
+	account *types.Account
+	page    int
+	rows    []types.Payment
+	favs    []types.Favorite
+	dirty   bool
+}
+
+//New console of the logged in staff member reading commands from in
+func New(svc *wallet.Service, principal *wallet.Principal, dir string, in io.Reader, out io.Writer) *REPL {
+	return &REPL{
+		Svc:      svc,
+		Actor:    svc.As(principal),
+		Dir:      dir,
+		PageSize: 10,
+		in:       bufio.NewScanner(in),
+		out:      out,
+	}
+}
+
+//Run reads commands until quit or the end of input
+func (r *REPL) Run() error {
+	fmt.Fprint(r.out, help)
+	for {
+		fmt.Fprint(r.out, r.prompt())
+		if !r.in.Scan() {
+			fmt.Fprintln(r.out)
+			return r.in.Err()
+		}
+		fields := strings.Fields(r.in.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		if fields[0] == "quit" || fields[0] == "exit" {
+			if r.dirty && r.confirm("save changes to "+r.Dir+"?") {
+				return r.save()
+			}
+			return nil
+		}
+		err := r.exec(fields[0], fields[1:])
+		if err != nil {
+			fmt.Fprintln(r.out, "error:", err)
+		}
+	}
+}
+
+func (r *REPL) prompt() string {
+	if r.account == nil {
+		return "> "
+	}
+	return fmt.Sprintf("[%d %s %d] > ", r.account.ID, r.account.Phone, r.account.Balance)
+}
+
+func (r *REPL) exec(command string, args []string) error {
+	switch command {
+	case "help":
+		fmt.Fprint(r.out, help)
+	case "find":
+		if len(args) != 1 {
+			return fmt.Errorf("usage: find <phone>")
+		}
+		account, err := r.Actor.FindAccountByPhone(types.Phone(args[0]))
+		if err != nil {
+			return err
+		}
+		return r.open(account)
+	case "account":
+		if len(args) != 1 {
+			return fmt.Errorf("usage: account <id>")
+		}
+		id, err := strconv.ParseInt(args[0], 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid id %q", args[0])
+		}
+		account, err := r.Actor.FindAccountByID(id)
+		if err != nil {
+			return err
+		}
+		return r.open(account)
+	case "history":
+		return r.history(0)
+	case "next":
+		return r.history(r.page + 1)
+	case "prev":
+		if r.page == 0 {
+			return fmt.Errorf("already on the first page")
+		}
+		return r.history(r.page - 1)
+	case "show":
+		payment, err := r.payment(args)
+		if err != nil {
+			return err
+		}
+		r.detail(payment)
+	case "reject":
+		payment, err := r.payment(args)
+		if err != nil {
+			return err
+		}
+		return r.giveBack(payment, "reject")
+	case "refund":
+		payment, err := r.payment(args)
+		if err != nil {
+			return err
+		}
+		return r.giveBack(payment, "refund")
+	case "repeat":
+		payment, err := r.payment(args)
+		if err != nil {
+			return err
+		}
+		if !r.confirm(fmt.Sprintf("pay %d to %s from account %d again?", payment.Amount, payment.Category, payment.AccountID)) {
+			return nil
+		}
+		repeated, err := r.Actor.Repeat(payment.ID)
+		if err != nil {
+			return err
+		}
+		r.dirty = true
+		fmt.Fprintln(r.out, "created payment", repeated.ID)
+	case "favorites":
+		return r.favorites()
+	case "favorite":
+		return r.favorite(args)
+	case "save":
+		return r.save()
+	default:
+		return fmt.Errorf("unknown command %q, type help", command)
+	}
+	return nil
+}
+
+func (r *REPL) open(account *types.Account) error {
+	r.account = account
+	r.rows = nil
+	r.favs = nil
+	r.page = 0
+	return r.history(0)
+}
+
+func (r *REPL) history(page int) error {
+	if r.account == nil {
+		return fmt.Errorf("open an account first")
+	}
+	payments, err := r.Actor.ExportAccountHistory(r.account.ID)
+	if err != nil {
+		return err
+	}
+	// новые платежи сверху
+	for i, j := 0, len(payments)-1; i < j; i, j = i+1, j-1 {
+		payments[i], payments[j] = payments[j], payments[i]
+	}
+	pages := (len(payments) + r.PageSize - 1) / r.PageSize
+	if page > 0 && page >= pages {
+		return fmt.Errorf("no more payments")
+	}
+	start := page * r.PageSize
+	end := start + r.PageSize
+	if end > len(payments) {
+		end = len(payments)
+	}
+	r.page = page
+	r.rows = payments[start:end]
+
+	fmt.Fprintf(r.out, "account %d, phone %s, balance %d, payments %d (page %d of %d)\n", r.account.ID, r.account.Phone, r.account.Balance, len(payments), page+1, pages)
+	tw := tabwriter.NewWriter(r.out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "#\tTIME\tAMOUNT\tCATEGORY\tSTATUS\tID")
+	for i, payment := range r.rows {
+		fmt.Fprintf(tw, "%d\t%s\t%d\t%s\t%s\t%s\n", i+1, formatTime(payment.Time), payment.Amount, payment.Category, payment.Status, payment.ID)
+	}
+	return tw.Flush()
+}
+
+func (r *REPL) favorites() error {
+	if r.account == nil {
+		return fmt.Errorf("open an account first")
+	}
+	favorites, err := r.Actor.AccountFavorites(r.account.ID)
+	if err != nil {
+		return err
+	}
+	r.favs = favorites
+	tw := tabwriter.NewWriter(r.out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "#\tNAME\tAMOUNT\tCATEGORY\tID")
+	for i, favorite := range favorites {
+		fmt.Fprintf(tw, "%d\t%s\t%d\t%s\t%s\n", i+1, favorite.Name, favorite.Amount, favorite.Category, favorite.ID)
+	}
+	return tw.Flush()
+}
+
+func (r *REPL) favorite(args []string) error {
+	switch {
+	case len(args) == 5 && args[0] == "update":
+		amount, err := strconv.ParseInt(args[3], 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid amount %q", args[3])
+		}
+		favorite, err := r.Actor.UpdateFavorite(r.favoriteID(args[1]), args[2], types.Money(amount), types.PaymentCategory(args[4]))
+		if err != nil {
+			return err
+		}
+		r.dirty = true
+		fmt.Fprintln(r.out, "updated favorite", favorite.ID)
+	case len(args) == 2 && args[0] == "delete":
+		favoriteID := r.favoriteID(args[1])
+		if !r.confirm("delete favorite " + favoriteID + " and cancel its schedules?") {
+			return nil
+		}
+		err := r.Actor.DeleteFavorite(favoriteID)
+		if err != nil {
+			return err
+		}
+		r.dirty = true
+		return r.favorites()
+	case len(args) >= 2 && args[0] == "order":
+		if r.account == nil {
+			return fmt.Errorf("open an account first")
+		}
+		ids := make([]string, 0, len(args)-1)
+		for _, arg := range args[1:] {
+			ids = append(ids, r.favoriteID(arg))
+		}
+		err := r.Actor.ReorderFavorites(r.account.ID, ids)
+		if err != nil {
+			return err
+		}
+		r.dirty = true
+		return r.favorites()
+	default:
+		return fmt.Errorf("usage: favorite update|delete|order, type help")
+	}
+	return nil
+}
+
+// favoriteID номер строки последнего списка favorites или сам id
+func (r *REPL) favoriteID(arg string) string {
+	n, err := strconv.Atoi(arg)
+	if err == nil && n >= 1 && n <= len(r.favs) {
+		return r.favs[n-1].ID
+	}
+	return arg
+}
+
+func (r *REPL) payment(args []string) (*types.Payment, error) {
+	if len(args) != 1 {
+		return nil, fmt.Errorf("payment row or id is required")
+	}
+	n, err := strconv.Atoi(args[0])
+	if err == nil {
+		if n < 1 || n > len(r.rows) {
+			return nil, fmt.Errorf("no row %d on this page", n)
+		}
+		return r.Actor.FindPaymentByID(r.rows[n-1].ID)
+	}
+	return r.Actor.FindPaymentByID(args[0])
+}
+
+func (r *REPL) detail(payment *types.Payment) {
+	tw := tabwriter.NewWriter(r.out, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(tw, "ID\t%s\n", payment.ID)
+	fmt.Fprintf(tw, "Account\t%d\n", payment.AccountID)
+	fmt.Fprintf(tw, "Amount\t%d\n", payment.Amount)
+	fmt.Fprintf(tw, "Category\t%s\n", payment.Category)
+	fmt.Fprintf(tw, "Status\t%s\n", payment.Status)
+	fmt.Fprintf(tw, "Time\t%s\n", formatTime(payment.Time))
+	tw.Flush()
+}
+
+func (r *REPL) giveBack(payment *types.Payment, action string) error {
+	if !r.confirm(fmt.Sprintf("%s payment %s and return %d to account %d?", action, payment.ID, payment.Amount, payment.AccountID)) {
+		return nil
+	}
+	giveBack := r.Actor.Reject
+	if action == "refund" {
+		giveBack = r.Actor.Refund
+	}
+	err := giveBack(payment.ID)
+	if err != nil {
+		return err
+	}
+	r.dirty = true
+	fmt.Fprintln(r.out, "done, payment status", payment.Status)
+	return nil
+}
+
+func (r *REPL) confirm(question string) bool {
+	fmt.Fprint(r.out, question+" [y/N] ")
+	if !r.in.Scan() {
+		return false
+	}
+	answer := strings.ToLower(strings.TrimSpace(r.in.Text()))
+	return answer == "y" || answer == "yes"
+}
+
+func (r *REPL) save() error {
+	err := os.MkdirAll(r.Dir, 0755)
+	if err != nil {
+		return err
+	}
+	err = r.Svc.Export(r.Dir)
+	if err != nil {
+		return err
+	}
+	r.dirty = false
+	fmt.Fprintln(r.out, "saved to", r.Dir)
+	return nil
+}
+
+func formatTime(unix int64) string {
+	if unix == 0 {
+		return "-"
+	}
+	return time.Unix(unix, 0).Format("2006-01-02 15:04")
+}
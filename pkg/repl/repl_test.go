@@ -0,0 +1,119 @@
+package repl
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/SsSJKK/wallet/pkg/types"
+	"github.com/SsSJKK/wallet/pkg/wallet"
+)
+
+func Test_REPL_RejectWithConfirmation(t *testing.T) {
+	dir := t.TempDir()
+	svc := &wallet.Service{}
+	acc, _ := svc.RegisterAccount("992000000001")
+	svc.Deposit(acc.ID, 100)
+	first, _ := svc.Pay(acc.ID, 10, "auto")
+	svc.Pay(acc.ID, 20, "food")
+
+	input := strings.Join([]string{
+		"find 992000000001",
+		"show 2",
+		"reject 2",
+		"n",
+		"reject 2",
+		"y",
+		"reject 2",
+		"y",
+		"refund 2",
+		"y",
+		"repeat 1",
+		"y",
+		"quit",
+		"y",
+	}, "\n")
+	out := &bytes.Buffer{}
+	support := &wallet.Principal{Name: "anna", Role: wallet.RoleSupport}
+	err := New(svc, support, dir, strings.NewReader(input), out).Run()
+	if err != nil {
+		t.Fatalf("ERROR: %v", err)
+	}
+
+	if first.Status != types.PaymentStatusFail || acc.Balance != 80 {
+		t.Errorf("ERROR: status %v balance %v", first.Status, acc.Balance)
+	}
+	text := out.String()
+	for _, want := range []string{"Category  auto", "only INPROGRESS and REVIEW payments can be rejected", "only settled OK payments can be refunded", "not allowed to pay", "saved to"} {
+		if !strings.Contains(text, want) {
+			t.Errorf("ERROR: output has no %q:\n%s", want, text)
+		}
+	}
+
+	loaded := &wallet.Service{}
+	loaded.Import(dir)
+	payment, err := loaded.FindPaymentByID(first.ID)
+	if err != nil || payment.Status != types.PaymentStatusFail {
+		t.Errorf("ERROR: saved payment %v %v", err, payment)
+	}
+}
+
+func Test_REPL_Scroll(t *testing.T) {
+	svc := &wallet.Service{}
+	acc, _ := svc.RegisterAccount("992000000001")
+	svc.Deposit(acc.ID, 100)
+	for i := 1; i <= 5; i++ {
+		svc.Pay(acc.ID, types.Money(i), "auto")
+	}
+
+	out := &bytes.Buffer{}
+	admin := &wallet.Principal{Name: "root", Role: wallet.RoleAdmin}
+	r := New(svc, admin, t.TempDir(), strings.NewReader("account 1\nnext\nnext\nnext\nprev\nrepeat 1\ny\n"), out)
+	r.PageSize = 2
+	r.Run()
+
+	text := out.String()
+	if !strings.Contains(text, "page 3 of 3") || !strings.Contains(text, "no more payments") {
+		t.Errorf("ERROR: scrolling:\n%s", text)
+	}
+	history, _ := svc.ExportAccountHistory(acc.ID)
+	if len(history) != 6 || history[5].Amount != 3 {
+		t.Errorf("ERROR: repeat from page 2 row 1 %v", history)
+	}
+}
+
+func Test_REPL_Favorites(t *testing.T) {
+	svc := &wallet.Service{}
+	acc, _ := svc.RegisterAccount("992000000001")
+	first, _ := svc.AddFavorite(acc.ID, "internet", 30, "internet")
+	second, _ := svc.AddFavorite(acc.ID, "phone", 5, "mobile")
+
+	input := strings.Join([]string{
+		"account 1",
+		"favorites",
+		"favorite update 1 home 40 internet",
+		"favorite order 2 1",
+		"favorite delete 1",
+		"n",
+		"favorite delete " + first.ID,
+		"y",
+	}, "\n")
+	out := &bytes.Buffer{}
+	admin := &wallet.Principal{Name: "root", Role: wallet.RoleAdmin}
+	New(svc, admin, t.TempDir(), strings.NewReader(input), out).Run()
+
+	favorites, _ := svc.AccountFavorites(acc.ID)
+	if len(favorites) != 1 || favorites[0].ID != second.ID {
+		t.Errorf("ERROR: favorites %v\n%s", favorites, out.String())
+	}
+	if first.Name != "home" || first.Amount != 40 {
+		t.Errorf("ERROR: updated %v", first)
+	}
+
+	out.Reset()
+	support := &wallet.Principal{Name: "anna", Role: wallet.RoleSupport}
+	New(svc, support, t.TempDir(), strings.NewReader("account 1\nfavorites\n"), out).Run()
+	if !strings.Contains(out.String(), "not allowed") {
+		t.Errorf("ERROR: support favorites:\n%s", out.String())
+	}
+}
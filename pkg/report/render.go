@@ -0,0 +1,69 @@
+package report
+
+import (
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"io"
+	"text/tabwriter"
+)
+
+const dateLayout = "2006-01-02"
+
+//WriteText renders the statement as plain text
+func WriteText(w io.Writer, st *Statement) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(tw, "Statement\taccount %d (%s)\n", st.AccountID, st.Phone)
+	fmt.Fprintf(tw, "Period\t%s - %s\n", st.From.Format(dateLayout), st.To.Format(dateLayout))
+	fmt.Fprintf(tw, "Opening balance\t%d\n", st.OpeningBalance)
+	fmt.Fprintf(tw, "Deposits\t%d\t(%d)\n", st.Deposits, st.DepositCount)
+	for _, category := range st.Categories {
+		fmt.Fprintf(tw, "  %s\t%d\t(%d)\n", category.Category, category.Total, category.Count)
+	}
+	fmt.Fprintf(tw, "Payments\t%d\n", st.Payments)
+	fmt.Fprintf(tw, "Refunds\t%d\t(%d)\n", st.Refunds, st.RefundCount)
+	for _, payment := range st.Rejected {
+		fmt.Fprintf(tw, "  %s\t%d\t%s\n", payment.ID, payment.Amount, payment.Category)
+	}
+	fmt.Fprintf(tw, "Closing balance\t%d\n", st.ClosingBalance)
+	return tw.Flush()
+}
+
+//WriteJSON renders the statement as JSON
+func WriteJSON(w io.Writer, st *Statement) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(st)
+}
+
+var htmlStatement = template.Must(template.New("statement").Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>Statement {{.AccountID}}</title></head>
+<body>
+<h1>Statement: account {{.AccountID}} ({{.Phone}})</h1>
+<p>Period: {{.From.Format "2006-01-02"}} - {{.To.Format "2006-01-02"}}</p>
+<table>
+<tr><th>Opening balance</th><td>{{.OpeningBalance}}</td></tr>
+<tr><th>Deposits</th><td>{{.Deposits}} ({{.DepositCount}})</td></tr>
+<tr><th>Payments</th><td>{{.Payments}}</td></tr>
+<tr><th>Refunds</th><td>{{.Refunds}} ({{.RefundCount}})</td></tr>
+<tr><th>Closing balance</th><td>{{.ClosingBalance}}</td></tr>
+</table>
+<h2>Payments by category</h2>
+<table>
+<tr><th>Category</th><th>Count</th><th>Total</th></tr>
+{{range .Categories}}<tr><td>{{.Category}}</td><td>{{.Count}}</td><td>{{.Total}}</td></tr>
+{{end}}</table>
+<h2>Refunds</h2>
+<table>
+<tr><th>Payment</th><th>Category</th><th>Amount</th></tr>
+{{range .Rejected}}<tr><td>{{.ID}}</td><td>{{.Category}}</td><td>{{.Amount}}</td></tr>
+{{end}}</table>
+</body>
+</html>
+`))
+
+//WriteHTML renders the statement as an HTML page
+func WriteHTML(w io.Writer, st *Statement) error {
+	return htmlStatement.Execute(w, st)
+}
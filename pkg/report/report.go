@@ -0,0 +1,154 @@
+package report
+
+import (
+	"sort"
+	"time"
+
+	"github.com/SsSJKK/wallet/pkg/types"
+	"github.com/SsSJKK/wallet/pkg/wallet"
+)
+
+//CategoryTotal payments of one category within a statement period
+type CategoryTotal struct {
+	Category types.PaymentCategory `json:"category"`
+	Count    int                   `json:"count"`
+	Total    types.Money           `json:"total"`
+}
+
+//Statement account statement for the period [From, To)
+type Statement struct {
+	AccountID      int64           `json:"accountId"`
+	Phone          types.Phone     `json:"phone"`
+	From           time.Time       `json:"from"`
+	To             time.Time       `json:"to"`
+	OpeningBalance types.Money     `json:"openingBalance"`
+	ClosingBalance types.Money     `json:"closingBalance"`
+	Deposits       types.Money     `json:"deposits"`
+	DepositCount   int             `json:"depositCount"`
+	Payments       types.Money     `json:"payments"`
+	Categories     []CategoryTotal `json:"categories"`
+	Refunds        types.Money     `json:"refunds"`
+	RefundCount    int             `json:"refundCount"`
+	Rejected       []types.Payment `json:"rejected"`
+}
+
+//Source data of a statement: wallet.Service, or wallet.Actor to check the
+//caller's access to the account
+type Source interface {
+	FindAccountByID(accountID int64) (*types.Account, error)
+	ExportAccountHistory(accountID int64) ([]types.Payment, error)
+	AccountDeposits(accountID int64) ([]types.Deposit, error)
+}
+
+//Build makes the statement of the account for the period [from, to).
+//A rejected payment is two movements: the debit counts in the period of
+//the payment and the return is listed as a refund of the period in which
+//the payment was rejected.
+func Build(svc Source, accountID int64, from time.Time, to time.Time) (*Statement, error) {
+	account, err := svc.FindAccountByID(accountID)
+	if err != nil {
+		return nil, err
+	}
+	payments, err := svc.ExportAccountHistory(accountID)
+	if err != nil {
+		return nil, err
+	}
+	deposits, err := svc.AccountDeposits(accountID)
+	if err != nil {
+		return nil, err
+	}
+
+	st := &Statement{
+		AccountID:      account.ID,
+		Phone:          account.Phone,
+		From:           from,
+		To:             to,
+		OpeningBalance: account.Balance,
+		ClosingBalance: account.Balance,
+		Rejected:       []types.Payment{},
+	}
+	start := from.Unix()
+	end := to.Unix()
+
+	for _, deposit := range deposits {
+		if deposit.Time >= start {
+			st.OpeningBalance -= deposit.Amount
+		}
+		if deposit.Time >= end {
+			st.ClosingBalance -= deposit.Amount
+		}
+		if deposit.Time >= start && deposit.Time < end {
+			st.Deposits += deposit.Amount
+			st.DepositCount++
+		}
+	}
+
+	categories := map[types.PaymentCategory]*CategoryTotal{}
+	for _, payment := range payments {
+		if payment.Status == types.PaymentStatusFail {
+			returned := payment.RejectedAt
+			// в старых дампах времени отказа нет, считаем возврат в момент платежа
+			if returned == 0 {
+				returned = payment.Time
+			}
+			if returned >= start {
+				st.OpeningBalance -= payment.Amount
+			}
+			if returned >= end {
+				st.ClosingBalance -= payment.Amount
+			}
+			if returned >= start && returned < end {
+				st.Refunds += payment.Amount
+				st.RefundCount++
+				st.Rejected = append(st.Rejected, payment)
+			}
+		}
+		if payment.Time >= start {
+			st.OpeningBalance += payment.Amount
+		}
+		if payment.Time >= end {
+			st.ClosingBalance += payment.Amount
+		}
+		if payment.Time >= start && payment.Time < end {
+			st.Payments += payment.Amount
+			total, ok := categories[payment.Category]
+			if !ok {
+				total = &CategoryTotal{Category: payment.Category}
+				categories[payment.Category] = total
+			}
+			total.Count++
+			total.Total += payment.Amount
+		}
+	}
+
+	st.Categories = make([]CategoryTotal, 0, len(categories))
+	for _, total := range categories {
+		st.Categories = append(st.Categories, *total)
+	}
+	sort.Slice(st.Categories, func(i, j int) bool {
+		return st.Categories[i].Category < st.Categories[j].Category
+	})
+	return st, nil
+}
+
+//Monthly makes the statement of the account for the calendar month
+func Monthly(svc Source, accountID int64, year int, month time.Month, loc *time.Location) (*Statement, error) {
+	if loc == nil {
+		loc = time.UTC
+	}
+	from := time.Date(year, month, 1, 0, 0, 0, 0, loc)
+	return Build(svc, accountID, from, from.AddDate(0, 1, 0))
+}
+
+//All makes statements of every account for the period [from, to)
+func All(svc *wallet.Service, from time.Time, to time.Time) ([]*Statement, error) {
+	statements := []*Statement{}
+	for _, account := range svc.Accounts() {
+		st, err := Build(svc, account.ID, from, to)
+		if err != nil {
+			return nil, err
+		}
+		statements = append(statements, st)
+	}
+	return statements, nil
+}
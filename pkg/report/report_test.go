@@ -0,0 +1,121 @@
+package report
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/SsSJKK/wallet/pkg/types"
+	"github.com/SsSJKK/wallet/pkg/wallet"
+)
+
+func newStatementService(t *testing.T) (*wallet.Service, *types.Account) {
+	svc := &wallet.Service{}
+	now := time.Date(2020, time.October, 20, 12, 0, 0, 0, time.UTC)
+	svc.SetClock(func() time.Time { return now })
+
+	acc, _ := svc.RegisterAccount("992000000001")
+	svc.Deposit(acc.ID, 1000)
+	svc.Pay(acc.ID, 100, "auto")
+
+	now = time.Date(2020, time.November, 3, 12, 0, 0, 0, time.UTC)
+	svc.Deposit(acc.ID, 500)
+	svc.Pay(acc.ID, 50, "food")
+	svc.Pay(acc.ID, 30, "food")
+	pay, _ := svc.Pay(acc.ID, 200, "auto")
+	svc.Reject(pay.ID)
+
+	now = time.Date(2020, time.December, 1, 12, 0, 0, 0, time.UTC)
+	svc.Pay(acc.ID, 20, "food")
+	return svc, acc
+}
+
+func Test_Monthly_OK(t *testing.T) {
+	svc, acc := newStatementService(t)
+	st, err := Monthly(svc, acc.ID, 2020, time.November, nil)
+	if err != nil {
+		t.Fatalf("ERROR: %v", err)
+	}
+	if st.OpeningBalance != 900 {
+		t.Errorf("ERROR: opening %v need 900", st.OpeningBalance)
+	}
+	if st.ClosingBalance != 1320 {
+		t.Errorf("ERROR: closing %v need 1320", st.ClosingBalance)
+	}
+	if st.Deposits != 500 || st.DepositCount != 1 {
+		t.Errorf("ERROR: deposits %v (%v)", st.Deposits, st.DepositCount)
+	}
+	if st.Refunds != 200 || st.RefundCount != 1 {
+		t.Errorf("ERROR: refunds %v (%v)", st.Refunds, st.RefundCount)
+	}
+	// отклонённый платёж списан и возвращён в ноябре: он и в платежах, и в возвратах
+	if st.Payments != 280 {
+		t.Errorf("ERROR: payments %v need 280", st.Payments)
+	}
+	want := []CategoryTotal{{Category: "auto", Count: 1, Total: 200}, {Category: "food", Count: 2, Total: 80}}
+	if len(st.Categories) != 2 || st.Categories[0] != want[0] || st.Categories[1] != want[1] {
+		t.Errorf("ERROR: categories %v need %v", st.Categories, want)
+	}
+}
+
+func Test_Build_RejectedNextMonth(t *testing.T) {
+	svc := &wallet.Service{}
+	now := time.Date(2020, time.November, 30, 23, 0, 0, 0, time.UTC)
+	svc.SetClock(func() time.Time { return now })
+	acc, _ := svc.RegisterAccount("992000000001")
+	svc.Deposit(acc.ID, 1000)
+	pay, _ := svc.Pay(acc.ID, 200, "auto")
+	now = time.Date(2020, time.December, 1, 9, 0, 0, 0, time.UTC)
+	svc.Reject(pay.ID)
+
+	november, _ := Monthly(svc, acc.ID, 2020, time.November, nil)
+	if november.OpeningBalance != 0 || november.ClosingBalance != 800 || november.Payments != 200 || november.RefundCount != 0 {
+		t.Errorf("ERROR: november %+v", november)
+	}
+	december, _ := Monthly(svc, acc.ID, 2020, time.December, nil)
+	if december.OpeningBalance != 800 || december.ClosingBalance != 1000 || december.Payments != 0 || december.Refunds != 200 || december.RefundCount != 1 {
+		t.Errorf("ERROR: december %+v", december)
+	}
+
+	dir := t.TempDir()
+	svc.Export(dir)
+	imported := &wallet.Service{}
+	imported.Import(dir)
+	if payment, err := imported.FindPaymentByID(pay.ID); err != nil || payment.RejectedAt != now.Unix() {
+		t.Errorf("ERROR: imported %v %v", err, payment)
+	}
+}
+
+func Test_Build_AccountNotFound(t *testing.T) {
+	svc := &wallet.Service{}
+	_, err := Build(svc, 1, time.Time{}, time.Now())
+	if err != wallet.ErrAccountNotFound {
+		t.Errorf("ERROR: %v need %v", err, wallet.ErrAccountNotFound)
+	}
+}
+
+func Test_Render_OK(t *testing.T) {
+	svc, acc := newStatementService(t)
+	st, _ := Monthly(svc, acc.ID, 2020, time.November, nil)
+
+	buf := &bytes.Buffer{}
+	if err := WriteText(buf, st); err != nil || !strings.Contains(buf.String(), "Closing balance") {
+		t.Errorf("ERROR: text %v %q", err, buf.String())
+	}
+
+	buf.Reset()
+	decoded := &Statement{}
+	if err := WriteJSON(buf, st); err != nil {
+		t.Errorf("ERROR: json %v", err)
+	}
+	if err := json.Unmarshal(buf.Bytes(), decoded); err != nil || decoded.ClosingBalance != st.ClosingBalance {
+		t.Errorf("ERROR: json %v %v", err, decoded)
+	}
+
+	buf.Reset()
+	if err := WriteHTML(buf, st); err != nil || !strings.Contains(buf.String(), "<td>food</td>") {
+		t.Errorf("ERROR: html %v %q", err, buf.String())
+	}
+}
@@ -0,0 +1,73 @@
+package server
+
+import (
+	"bytes"
+	"net/http"
+)
+
+//HeaderIdempotencyKey repeated POST requests with the same key get the
+//stored response instead of being applied again
+const HeaderIdempotencyKey = "Idempotency-Key"
+
+const maxIdempotencyKeys = 10000
+
+type storedResponse struct {
+	status int
+	header http.Header
+	body   []byte
+}
+
+type recorder struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (r *recorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *recorder) Write(data []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	r.body.Write(data)
+	return r.ResponseWriter.Write(data)
+}
+
+// idempotent вызывается под s.mu
+func (s *Server) idempotent(w http.ResponseWriter, r *http.Request, handle func(w http.ResponseWriter)) {
+	key := r.Header.Get(HeaderIdempotencyKey)
+	if key == "" || r.Method != http.MethodPost {
+		handle(w)
+		return
+	}
+	// ключ свой у каждого пользователя, чужой ответ не повторяем
+	key = principalOf(r).String() + " " + r.URL.Path + " " + key
+	if stored, ok := s.responses[key]; ok {
+		for name, values := range stored.header {
+			w.Header()[name] = values
+		}
+		w.Header().Set("Idempotent-Replayed", "true")
+		w.WriteHeader(stored.status)
+		w.Write(stored.body)
+		return
+	}
+
+	rec := &recorder{ResponseWriter: w}
+	handle(rec)
+	// ответы 5xx не запоминаем, чтобы повтор мог пройти
+	if rec.status >= http.StatusInternalServerError {
+		return
+	}
+	if s.responses == nil {
+		s.responses = map[string]storedResponse{}
+	}
+	if len(s.keys) >= maxIdempotencyKeys {
+		delete(s.responses, s.keys[0])
+		s.keys = s.keys[1:]
+	}
+	s.responses[key] = storedResponse{status: rec.status, header: w.Header().Clone(), body: rec.body.Bytes()}
+	s.keys = append(s.keys, key)
+}
@@ -0,0 +1,554 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/SsSJKK/wallet/pkg/api"
+	"github.com/SsSJKK/wallet/pkg/report"
+	"github.com/SsSJKK/wallet/pkg/types"
+	"github.com/SsSJKK/wallet/pkg/wallet"
+)
+
+const maxBodySize = 1 << 20
+
+//Server JSON HTTP API of the wallet. wallet.Service is not safe for
+//concurrent use, so every request holds the server lock.
+type Server struct {
+	mu        sync.Mutex
+	svc       *wallet.Service
+	responses map[string]storedResponse
+	keys      []string
+}
+
+//New meth
+func New(svc *wallet.Service) *Server {
+	return &Server{svc: svc}
+}
+
+//Do runs fn with exclusive access to the service
+func (s *Server) Do(fn func(svc *wallet.Service) error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return fn(s.svc)
+}
+
+//ServeHTTP routes
+//
+//	POST /sessions                      login, public
+//	DELETE /sessions/current            logout
+//	POST /accounts                      register, public for a sign up with a PIN
+//	GET  /accounts/{id}                 account
+//	POST /accounts/{id}/deposit         deposit
+//	GET  /accounts/{id}/history         payments of the account
+//	GET  /accounts/{id}/favorites       favorites of the account
+//	POST /accounts/{id}/favorites/order reorder favorites of the account
+//	GET  /accounts/{id}/statement       ?from=2006-01-02&to=2006-01-02&format=json|text|html
+//	POST /payments                      pay
+//	GET  /payments/{id}                 payment
+//	POST /payments/{id}/reject          reject
+//	POST /payments/{id}/repeat          repeat
+//	POST /favorites                     favorite from a payment or a new favorite
+//	PUT  /favorites/{id}                change name, amount and category
+//	DELETE /favorites/{id}              delete favorite
+//	POST /favorites/{id}/pay            pay from favorite
+//
+//Every other request needs the "Authorization: Bearer <token>" header with
+//a token of POST /sessions. POST requests with the Idempotency-Key header
+//are applied once.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	principal, err := s.authenticate(r)
+	if err != nil {
+		writeError(w, api.FromError(err))
+		return
+	}
+	r = r.WithContext(context.WithValue(r.Context(), principalKey{}, principal))
+	s.idempotent(w, r, func(w http.ResponseWriter) {
+		s.route(w, r)
+	})
+}
+
+// без токена можно только войти и зарегистрироваться
+var public = map[string]bool{
+	http.MethodPost + " /sessions": true,
+	http.MethodPost + " /accounts": true,
+}
+
+type principalKey struct{}
+
+// authenticate возвращает nil без ошибки для публичного запроса без токена
+func (s *Server) authenticate(r *http.Request) (*wallet.Principal, error) {
+	header := r.Header.Get("Authorization")
+	if header == "" && public[r.Method+" /"+strings.Trim(r.URL.Path, "/")] {
+		return nil, nil
+	}
+	token := strings.TrimPrefix(header, "Bearer ")
+	if token == "" || token == header {
+		return nil, wallet.ErrUnauthorized
+	}
+	return s.svc.Authenticate(token)
+}
+
+func principalOf(r *http.Request) *wallet.Principal {
+	principal, _ := r.Context().Value(principalKey{}).(*wallet.Principal)
+	return principal
+}
+
+// as операции от имени автора запроса, права проверяет wallet.Actor
+func (s *Server) as(r *http.Request) *wallet.Actor {
+	return s.svc.As(principalOf(r))
+}
+
+func (s *Server) route(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	switch {
+	case match(parts, "sessions"):
+		if allow(w, r, http.MethodPost) {
+			s.login(w, r)
+		}
+	case match(parts, "sessions", "current"):
+		if allow(w, r, http.MethodDelete) {
+			s.logout(w, r)
+		}
+	case match(parts, "accounts"):
+		if allow(w, r, http.MethodPost) {
+			s.register(w, r)
+		}
+	case match(parts, "accounts", "*"):
+		if allow(w, r, http.MethodGet) {
+			s.account(w, r, parts[1])
+		}
+	case match(parts, "accounts", "*", "deposit"):
+		if allow(w, r, http.MethodPost) {
+			s.deposit(w, r, parts[1])
+		}
+	case match(parts, "accounts", "*", "history"):
+		if allow(w, r, http.MethodGet) {
+			s.history(w, r, parts[1])
+		}
+	case match(parts, "accounts", "*", "favorites"):
+		if allow(w, r, http.MethodGet) {
+			s.favorites(w, r, parts[1])
+		}
+	case match(parts, "accounts", "*", "favorites", "order"):
+		if allow(w, r, http.MethodPost) {
+			s.reorderFavorites(w, r, parts[1])
+		}
+	case match(parts, "accounts", "*", "statement"):
+		if allow(w, r, http.MethodGet) {
+			s.statement(w, r, parts[1])
+		}
+	case match(parts, "payments"):
+		if allow(w, r, http.MethodPost) {
+			s.pay(w, r)
+		}
+	case match(parts, "payments", "*"):
+		if allow(w, r, http.MethodGet) {
+			s.payment(w, r, parts[1])
+		}
+	case match(parts, "payments", "*", "reject"):
+		if allow(w, r, http.MethodPost) {
+			s.reject(w, r, parts[1])
+		}
+	case match(parts, "payments", "*", "repeat"):
+		if allow(w, r, http.MethodPost) {
+			s.repeat(w, r, parts[1])
+		}
+	case match(parts, "favorites"):
+		if allow(w, r, http.MethodPost) {
+			s.addFavorite(w, r)
+		}
+	case match(parts, "favorites", "*"):
+		switch r.Method {
+		case http.MethodPut:
+			s.updateFavorite(w, r, parts[1])
+		case http.MethodDelete:
+			s.deleteFavorite(w, r, parts[1])
+		default:
+			allow(w, r, http.MethodPut+", "+http.MethodDelete)
+		}
+	case match(parts, "favorites", "*", "pay"):
+		if allow(w, r, http.MethodPost) {
+			s.payFromFavorite(w, r, parts[1])
+		}
+	default:
+		writeError(w, &api.Error{Code: api.CodeNotFound, Message: "no route for " + r.Method + " " + r.URL.Path, HTTPCode: http.StatusNotFound})
+	}
+}
+
+// allow отвечает 405 с Allow, если метод запроса не тот, что у пути
+func allow(w http.ResponseWriter, r *http.Request, method string) bool {
+	if r.Method == method {
+		return true
+	}
+	w.Header().Set("Allow", method)
+	writeError(w, &api.Error{Code: api.CodeMethodNotAllowed, Message: r.Method + " is not allowed for " + r.URL.Path, HTTPCode: http.StatusMethodNotAllowed})
+	return false
+}
+
+func match(parts []string, pattern ...string) bool {
+	if len(parts) != len(pattern) {
+		return false
+	}
+	for i, p := range pattern {
+		if p != "*" && p != parts[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func (s *Server) login(w http.ResponseWriter, r *http.Request) {
+	req := api.LoginRequest{}
+	if !decode(w, r, &req) {
+		return
+	}
+	var session *wallet.Session
+	var err error
+	if req.Name != "" {
+		session, err = s.svc.LoginStaff(req.Name, req.Pin)
+	} else {
+		session, err = s.svc.Login(req.Phone, req.Pin)
+	}
+	if err != nil {
+		writeError(w, api.FromError(err))
+		return
+	}
+	writeJSON(w, http.StatusCreated, api.FromSession(*session))
+}
+
+func (s *Server) logout(w http.ResponseWriter, r *http.Request) {
+	s.svc.Logout(strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer "))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) register(w http.ResponseWriter, r *http.Request) {
+	req := api.RegisterRequest{}
+	if !decode(w, r, &req) {
+		return
+	}
+	if strings.TrimSpace(string(req.Phone)) == "" {
+		badRequest(w, "phone is required")
+		return
+	}
+	// клиент без токена регистрирует себя и сразу задаёт PIN; PIN проверяем
+	// до регистрации, иначе останется счёт без PIN и с занятым телефоном
+	if principalOf(r) == nil || req.Pin != "" {
+		err := wallet.CheckPIN(req.Pin)
+		if err != nil {
+			writeError(w, api.FromError(err))
+			return
+		}
+	}
+	var account *types.Account
+	var err error
+	if principalOf(r) == nil {
+		account, err = s.svc.RegisterAccount(req.Phone)
+	} else {
+		account, err = s.as(r).RegisterAccount(req.Phone)
+	}
+	if err != nil {
+		writeError(w, api.FromError(err))
+		return
+	}
+	if req.Pin != "" {
+		err = s.svc.SetPIN(account.ID, req.Pin)
+		if err != nil {
+			writeError(w, api.FromError(err))
+			return
+		}
+	}
+	writeJSON(w, http.StatusCreated, api.FromAccount(*account))
+}
+
+func (s *Server) account(w http.ResponseWriter, r *http.Request, rawID string) {
+	accountID, ok := parseID(w, rawID)
+	if !ok {
+		return
+	}
+	account, err := s.as(r).FindAccountByID(accountID)
+	if err != nil {
+		writeError(w, api.FromError(err))
+		return
+	}
+	writeJSON(w, http.StatusOK, api.FromAccount(*account))
+}
+
+func (s *Server) deposit(w http.ResponseWriter, r *http.Request, rawID string) {
+	accountID, ok := parseID(w, rawID)
+	if !ok {
+		return
+	}
+	req := api.DepositRequest{}
+	if !decode(w, r, &req) {
+		return
+	}
+	err := s.as(r).Deposit(accountID, req.Amount)
+	if err != nil {
+		writeError(w, api.FromError(err))
+		return
+	}
+	account, _ := s.svc.FindAccountByID(accountID)
+	writeJSON(w, http.StatusOK, api.FromAccount(*account))
+}
+
+func (s *Server) history(w http.ResponseWriter, r *http.Request, rawID string) {
+	accountID, ok := parseID(w, rawID)
+	if !ok {
+		return
+	}
+	payments, err := s.as(r).ExportAccountHistory(accountID)
+	if err != nil {
+		writeError(w, api.FromError(err))
+		return
+	}
+	result := make([]api.Payment, 0, len(payments))
+	for _, payment := range payments {
+		result = append(result, api.FromPayment(payment))
+	}
+	writeJSON(w, http.StatusOK, result)
+}
+
+func (s *Server) favorites(w http.ResponseWriter, r *http.Request, rawID string) {
+	accountID, ok := parseID(w, rawID)
+	if !ok {
+		return
+	}
+	favorites, err := s.as(r).AccountFavorites(accountID)
+	if err != nil {
+		writeError(w, api.FromError(err))
+		return
+	}
+	result := make([]api.Favorite, 0, len(favorites))
+	for _, favorite := range favorites {
+		result = append(result, api.FromFavorite(favorite))
+	}
+	writeJSON(w, http.StatusOK, result)
+}
+
+func (s *Server) statement(w http.ResponseWriter, r *http.Request, rawID string) {
+	accountID, ok := parseID(w, rawID)
+	if !ok {
+		return
+	}
+	query := r.URL.Query()
+	now := time.Now().UTC()
+	from := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+	to := from.AddDate(0, 1, 0)
+	var err error
+	if value := query.Get("from"); value != "" {
+		from, err = time.Parse("2006-01-02", value)
+		if err != nil {
+			badRequest(w, "from must be YYYY-MM-DD")
+			return
+		}
+		to = from.AddDate(0, 1, 0)
+	}
+	if value := query.Get("to"); value != "" {
+		to, err = time.Parse("2006-01-02", value)
+		if err != nil {
+			badRequest(w, "to must be YYYY-MM-DD")
+			return
+		}
+	}
+	if !to.After(from) {
+		badRequest(w, "to must be after from")
+		return
+	}
+
+	st, err := report.Build(s.as(r), accountID, from, to)
+	if err != nil {
+		writeError(w, api.FromError(err))
+		return
+	}
+	switch query.Get("format") {
+	case "", "json":
+		w.Header().Set("Content-Type", "application/json")
+		err = report.WriteJSON(w, st)
+	case "text":
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		err = report.WriteText(w, st)
+	case "html":
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		err = report.WriteHTML(w, st)
+	default:
+		badRequest(w, "format must be json, text or html")
+		return
+	}
+	if err != nil {
+		log.Print(err)
+	}
+}
+
+func (s *Server) pay(w http.ResponseWriter, r *http.Request) {
+	req := api.PayRequest{}
+	if !decode(w, r, &req) {
+		return
+	}
+	if strings.TrimSpace(string(req.Category)) == "" {
+		badRequest(w, "category is required")
+		return
+	}
+	payment, err := s.as(r).Pay(req.AccountID, req.Amount, req.Category)
+	if err != nil {
+		writeError(w, api.FromError(err))
+		return
+	}
+	writeJSON(w, http.StatusCreated, api.FromPayment(*payment))
+}
+
+func (s *Server) payment(w http.ResponseWriter, r *http.Request, paymentID string) {
+	payment, err := s.as(r).FindPaymentByID(paymentID)
+	if err != nil {
+		writeError(w, api.FromError(err))
+		return
+	}
+	writeJSON(w, http.StatusOK, api.FromPayment(*payment))
+}
+
+func (s *Server) reject(w http.ResponseWriter, r *http.Request, paymentID string) {
+	err := s.as(r).Reject(paymentID)
+	if err != nil {
+		writeError(w, api.FromError(err))
+		return
+	}
+	payment, _ := s.svc.FindPaymentByID(paymentID)
+	writeJSON(w, http.StatusOK, api.FromPayment(*payment))
+}
+
+func (s *Server) repeat(w http.ResponseWriter, r *http.Request, paymentID string) {
+	payment, err := s.as(r).Repeat(paymentID)
+	if err != nil {
+		writeError(w, api.FromError(err))
+		return
+	}
+	writeJSON(w, http.StatusCreated, api.FromPayment(*payment))
+}
+
+func (s *Server) addFavorite(w http.ResponseWriter, r *http.Request) {
+	req := api.FavoriteRequest{}
+	if !decode(w, r, &req) {
+		return
+	}
+	var favorite *types.Favorite
+	var err error
+	switch {
+	case req.PaymentID != "":
+		favorite, err = s.as(r).FavoritePayment(req.PaymentID, req.Name)
+	case req.AccountID != 0:
+		if strings.TrimSpace(string(req.Category)) == "" {
+			badRequest(w, "category is required")
+			return
+		}
+		favorite, err = s.as(r).AddFavorite(req.AccountID, req.Name, req.Amount, req.Category)
+	default:
+		badRequest(w, "paymentId or accountId is required")
+		return
+	}
+	if err != nil {
+		writeError(w, api.FromError(err))
+		return
+	}
+	writeJSON(w, http.StatusCreated, api.FromFavorite(*favorite))
+}
+
+func (s *Server) updateFavorite(w http.ResponseWriter, r *http.Request, favoriteID string) {
+	req := api.FavoriteUpdateRequest{}
+	if !decode(w, r, &req) {
+		return
+	}
+	if strings.TrimSpace(string(req.Category)) == "" {
+		badRequest(w, "category is required")
+		return
+	}
+	favorite, err := s.as(r).UpdateFavorite(favoriteID, req.Name, req.Amount, req.Category)
+	if err != nil {
+		writeError(w, api.FromError(err))
+		return
+	}
+	writeJSON(w, http.StatusOK, api.FromFavorite(*favorite))
+}
+
+func (s *Server) deleteFavorite(w http.ResponseWriter, r *http.Request, favoriteID string) {
+	err := s.as(r).DeleteFavorite(favoriteID)
+	if err != nil {
+		writeError(w, api.FromError(err))
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) reorderFavorites(w http.ResponseWriter, r *http.Request, rawID string) {
+	accountID, ok := parseID(w, rawID)
+	if !ok {
+		return
+	}
+	req := api.FavoriteOrderRequest{}
+	if !decode(w, r, &req) {
+		return
+	}
+	err := s.as(r).ReorderFavorites(accountID, req.FavoriteIDs)
+	if err != nil {
+		writeError(w, api.FromError(err))
+		return
+	}
+	s.favorites(w, r, rawID)
+}
+
+func (s *Server) payFromFavorite(w http.ResponseWriter, r *http.Request, favoriteID string) {
+	payment, err := s.as(r).PayFromFavorite(favoriteID)
+	if err != nil {
+		writeError(w, api.FromError(err))
+		return
+	}
+	writeJSON(w, http.StatusCreated, api.FromPayment(*payment))
+}
+
+func parseID(w http.ResponseWriter, raw string) (int64, bool) {
+	id, err := strconv.ParseInt(raw, 10, 64)
+	if err != nil || id <= 0 {
+		badRequest(w, "invalid account id "+raw)
+		return 0, false
+	}
+	return id, true
+}
+
+func decode(w http.ResponseWriter, r *http.Request, v interface{}) bool {
+	decoder := json.NewDecoder(io.LimitReader(r.Body, maxBodySize))
+	decoder.DisallowUnknownFields()
+	err := decoder.Decode(v)
+	if err != nil {
+		badRequest(w, "invalid json: "+err.Error())
+		return false
+	}
+	return true
+}
+
+func badRequest(w http.ResponseWriter, message string) {
+	writeError(w, &api.Error{Code: api.CodeBadRequest, Message: message, HTTPCode: http.StatusBadRequest})
+}
+
+func writeError(w http.ResponseWriter, e *api.Error) {
+	if e.HTTPCode == http.StatusInternalServerError {
+		log.Print(e.Message)
+	}
+	writeJSON(w, e.HTTPCode, e)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	err := json.NewEncoder(w).Encode(v)
+	if err != nil {
+		log.Print(err)
+	}
+}
@@ -0,0 +1,234 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/SsSJKK/wallet/pkg/api"
+	"github.com/SsSJKK/wallet/pkg/types"
+	"github.com/SsSJKK/wallet/pkg/wallet"
+)
+
+func do(t *testing.T, srv *httptest.Server, method string, path string, body interface{}, out interface{}) int {
+	return doAs(t, srv, "", method, path, body, out)
+}
+
+func doAs(t *testing.T, srv *httptest.Server, token string, method string, path string, body interface{}, out interface{}) int {
+	var reader *bytes.Reader
+	if body != nil {
+		data, _ := json.Marshal(body)
+		reader = bytes.NewReader(data)
+	} else {
+		reader = bytes.NewReader(nil)
+	}
+	req, _ := http.NewRequest(method, srv.URL+path, reader)
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("ERROR: %v", err)
+	}
+	defer resp.Body.Close()
+	if out != nil {
+		json.NewDecoder(resp.Body).Decode(out)
+	}
+	return resp.StatusCode
+}
+
+func login(t *testing.T, srv *httptest.Server, phone string, pin string) string {
+	session := api.Session{}
+	if code := do(t, srv, "POST", "/sessions", api.LoginRequest{Phone: types.Phone(phone), Pin: pin}, &session); code != http.StatusCreated {
+		t.Fatalf("ERROR: login %v", code)
+	}
+	return session.Token
+}
+
+func Test_Server_Flow(t *testing.T) {
+	svc := &wallet.Service{}
+	svc.RegisterStaff("anna", wallet.RoleSupport, "secret")
+	srv := httptest.NewServer(New(svc))
+	defer srv.Close()
+
+	account := api.Account{}
+	if code := do(t, srv, "POST", "/accounts", api.RegisterRequest{Phone: "992000000001", Pin: "1234"}, &account); code != http.StatusCreated {
+		t.Fatalf("ERROR: register %v", code)
+	}
+	token := login(t, srv, "992000000001", "1234")
+	e := api.Error{}
+	if code := do(t, srv, "POST", "/accounts", api.RegisterRequest{Phone: "992000000001", Pin: "1234"}, &e); code != http.StatusConflict || e.Code != api.CodePhoneRegistered {
+		t.Errorf("ERROR: register twice %v %v", code, e)
+	}
+	if code := doAs(t, srv, token, "POST", "/accounts/1/deposit", api.DepositRequest{Amount: 100}, &e); code != http.StatusForbidden || e.Code != api.CodeForbidden {
+		t.Errorf("ERROR: deposit by customer %v %v", code, e)
+	}
+	session := api.Session{}
+	do(t, srv, "POST", "/sessions", api.LoginRequest{Name: "anna", Pin: "secret"}, &session)
+	if code := doAs(t, srv, session.Token, "POST", "/accounts/1/deposit", api.DepositRequest{Amount: 100}, &account); code != http.StatusOK || account.Balance != 100 {
+		t.Errorf("ERROR: deposit %v %v", code, account)
+	}
+
+	payment := api.Payment{}
+	if code := doAs(t, srv, token, "POST", "/payments", api.PayRequest{AccountID: 1, Amount: 30, Category: "auto"}, &payment); code != http.StatusCreated {
+		t.Fatalf("ERROR: pay %v", code)
+	}
+	if code := doAs(t, srv, token, "POST", "/payments", api.PayRequest{AccountID: 1, Amount: 300, Category: "auto"}, &e); code != http.StatusUnprocessableEntity || e.Code != api.CodeNotEnoughBalance {
+		t.Errorf("ERROR: pay too much %v %v", code, e)
+	}
+	if code := doAs(t, srv, token, "POST", "/payments", api.PayRequest{AccountID: 2, Amount: 1, Category: "auto"}, &e); code != http.StatusForbidden || e.Err() != wallet.ErrForbidden {
+		t.Errorf("ERROR: pay other account %v %v", code, e)
+	}
+
+	repeated := api.Payment{}
+	if code := doAs(t, srv, token, "POST", "/payments/"+payment.ID+"/repeat", nil, &repeated); code != http.StatusCreated || repeated.ID == payment.ID {
+		t.Errorf("ERROR: repeat %v %v", code, repeated)
+	}
+	if code := doAs(t, srv, token, "POST", "/payments/"+payment.ID+"/reject", nil, &e); code != http.StatusForbidden || e.Code != api.CodeForbidden {
+		t.Errorf("ERROR: reject by customer %v %v", code, e)
+	}
+	if code := doAs(t, srv, session.Token, "POST", "/payments/"+payment.ID+"/reject", nil, &payment); code != http.StatusOK || payment.Status != "FAIL" {
+		t.Errorf("ERROR: reject %v %v", code, payment)
+	}
+
+	favorite := api.Favorite{}
+	if code := doAs(t, srv, token, "POST", "/favorites", api.FavoriteRequest{PaymentID: payment.ID, Name: "car"}, &favorite); code != http.StatusCreated {
+		t.Fatalf("ERROR: favorite %v", code)
+	}
+	if code := doAs(t, srv, token, "POST", "/favorites/"+favorite.ID+"/pay", nil, &payment); code != http.StatusCreated || payment.Amount != 30 {
+		t.Errorf("ERROR: pay favorite %v %v", code, payment)
+	}
+	favorites := []api.Favorite{}
+	if code := doAs(t, srv, token, "GET", "/accounts/1/favorites", nil, &favorites); code != http.StatusOK || len(favorites) != 1 {
+		t.Errorf("ERROR: favorites %v %v", code, favorites)
+	}
+	if code := doAs(t, srv, token, "PUT", "/favorites/"+favorite.ID, api.FavoriteUpdateRequest{Name: "fuel", Amount: 25, Category: "auto"}, &favorite); code != http.StatusOK || favorite.Name != "fuel" || favorite.Amount != 25 {
+		t.Errorf("ERROR: update favorite %v %v", code, favorite)
+	}
+	if code := doAs(t, srv, token, "POST", "/accounts/1/favorites/order", api.FavoriteOrderRequest{}, &e); code != http.StatusBadRequest || e.Code != api.CodeInvalidOrder {
+		t.Errorf("ERROR: reorder %v %v", code, e)
+	}
+	if code := doAs(t, srv, session.Token, "DELETE", "/favorites/"+favorite.ID, nil, &e); code != http.StatusForbidden || e.Code != api.CodeForbidden {
+		t.Errorf("ERROR: delete favorite by support %v %v", code, e)
+	}
+	if code := doAs(t, srv, token, "DELETE", "/favorites/"+favorite.ID, nil, nil); code != http.StatusNoContent {
+		t.Errorf("ERROR: delete favorite %v", code)
+	}
+	if code := doAs(t, srv, token, "GET", "/favorites/"+favorite.ID, nil, nil); code != http.StatusMethodNotAllowed {
+		t.Errorf("ERROR: get favorite %v", code)
+	}
+
+	history := []api.Payment{}
+	if code := doAs(t, srv, token, "GET", "/accounts/1/history", nil, &history); code != http.StatusOK || len(history) != 3 {
+		t.Errorf("ERROR: history %v %v", code, history)
+	}
+	if code := doAs(t, srv, token, "GET", "/accounts/1", nil, &account); code != http.StatusOK || account.Balance != 40 {
+		t.Errorf("ERROR: account %v %v", code, account)
+	}
+}
+
+func Test_Server_Validation(t *testing.T) {
+	svc := &wallet.Service{}
+	svc.RegisterStaff("anna", wallet.RoleSupport, "secret")
+	srv := httptest.NewServer(New(svc))
+	defer srv.Close()
+
+	e := api.Error{}
+	if code := do(t, srv, "POST", "/accounts", map[string]string{"phone": ""}, &e); code != http.StatusBadRequest {
+		t.Errorf("ERROR: empty phone %v", code)
+	}
+	if code := do(t, srv, "POST", "/accounts", map[string]string{"number": "1"}, &e); code != http.StatusBadRequest {
+		t.Errorf("ERROR: unknown field %v", code)
+	}
+	do(t, srv, "POST", "/accounts", api.RegisterRequest{Phone: "992000000001", Pin: "1234"}, nil)
+	token := login(t, srv, "992000000001", "1234")
+	if code := doAs(t, srv, token, "GET", "/accounts/abc", nil, &e); code != http.StatusBadRequest {
+		t.Errorf("ERROR: bad id %v", code)
+	}
+	if code := doAs(t, srv, token, "DELETE", "/accounts/1", nil, &e); code != http.StatusMethodNotAllowed || e.Code != api.CodeMethodNotAllowed {
+		t.Errorf("ERROR: wrong method %v %v", code, e)
+	}
+	if code := doAs(t, srv, token, "GET", "/transfers", nil, &e); code != http.StatusNotFound || e.Code != api.CodeNotFound {
+		t.Errorf("ERROR: unknown route %v %v", code, e)
+	}
+	session := api.Session{}
+	do(t, srv, "POST", "/sessions", api.LoginRequest{Name: "anna", Pin: "secret"}, &session)
+	if code := doAs(t, srv, session.Token, "POST", "/accounts/1/deposit", api.DepositRequest{Amount: -1}, &e); code != http.StatusBadRequest || e.Code != api.CodeInvalidAmount {
+		t.Errorf("ERROR: negative deposit %v %v", code, e)
+	}
+}
+
+func Test_Server_Statement(t *testing.T) {
+	svc := &wallet.Service{}
+	acc, _ := svc.RegisterAccount("992000000001")
+	svc.Deposit(acc.ID, 100)
+	svc.Pay(acc.ID, 10, "food")
+	svc.SetPIN(acc.ID, "1234")
+	session, _ := svc.Login(acc.Phone, "1234")
+	srv := httptest.NewServer(New(svc))
+	defer srv.Close()
+
+	req, _ := http.NewRequest("GET", srv.URL+"/accounts/1/statement?format=text", nil)
+	req.Header.Set("Authorization", "Bearer "+session.Token)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("ERROR: %v", err)
+	}
+	defer resp.Body.Close()
+	buf := &bytes.Buffer{}
+	buf.ReadFrom(resp.Body)
+	if resp.StatusCode != http.StatusOK || !strings.Contains(buf.String(), "food") {
+		t.Errorf("ERROR: statement %v %q", resp.StatusCode, buf.String())
+	}
+	e := api.Error{}
+	if code := doAs(t, srv, session.Token, "GET", "/accounts/1/statement?from=2020-13-01", nil, &e); code != http.StatusBadRequest {
+		t.Errorf("ERROR: bad date %v", code)
+	}
+}
+
+func Test_Server_Auth(t *testing.T) {
+	srv := httptest.NewServer(New(&wallet.Service{}))
+	defer srv.Close()
+
+	e := api.Error{}
+	if code := do(t, srv, "POST", "/accounts", api.RegisterRequest{Phone: "992000000001"}, &e); code != http.StatusBadRequest || e.Code != api.CodeWeakPIN {
+		t.Errorf("ERROR: sign up without pin %v %v", code, e)
+	}
+	if code := do(t, srv, "POST", "/accounts", api.RegisterRequest{Phone: "992000000001", Pin: "123"}, &e); code != http.StatusBadRequest || e.Code != api.CodeWeakPIN {
+		t.Errorf("ERROR: sign up with weak pin %v %v", code, e)
+	}
+	if code := do(t, srv, "POST", "/accounts", api.RegisterRequest{Phone: "992000000001", Pin: "1234"}, nil); code != http.StatusCreated {
+		t.Errorf("ERROR: sign up after weak pin %v", code)
+	}
+	if code := do(t, srv, "GET", "/accounts/1", nil, &e); code != http.StatusUnauthorized || e.Code != api.CodeUnauthorized {
+		t.Errorf("ERROR: no token %v %v", code, e)
+	}
+	if code := doAs(t, srv, "forged", "GET", "/accounts/1", nil, &e); code != http.StatusUnauthorized || e.Code != api.CodeUnauthorized {
+		t.Errorf("ERROR: bad token %v %v", code, e)
+	}
+	if code := do(t, srv, "POST", "/sessions", api.LoginRequest{Phone: "992000000001", Pin: "0000"}, &e); code != http.StatusUnauthorized || e.Code != api.CodeInvalidCredentials {
+		t.Errorf("ERROR: wrong pin %v %v", code, e)
+	}
+
+	do(t, srv, "POST", "/accounts", api.RegisterRequest{Phone: "992000000002", Pin: "5678"}, nil)
+	token := login(t, srv, "992000000001", "1234")
+	if code := doAs(t, srv, token, "GET", "/accounts/2", nil, &e); code != http.StatusForbidden || e.Code != api.CodeForbidden {
+		t.Errorf("ERROR: other account %v %v", code, e)
+	}
+	if code := doAs(t, srv, token, "POST", "/accounts", api.RegisterRequest{Phone: "992000000003", Pin: "1234"}, &e); code != http.StatusForbidden {
+		t.Errorf("ERROR: register by customer %v %v", code, e)
+	}
+	account := api.Account{}
+	if code := doAs(t, srv, token, "GET", "/accounts/1", nil, &account); code != http.StatusOK || account.ID != 1 {
+		t.Errorf("ERROR: account %v %v", code, account)
+	}
+	if code := doAs(t, srv, token, "DELETE", "/sessions/current", nil, nil); code != http.StatusNoContent {
+		t.Errorf("ERROR: logout %v", code)
+	}
+	if code := doAs(t, srv, token, "GET", "/accounts/1", nil, &e); code != http.StatusUnauthorized {
+		t.Errorf("ERROR: token after logout %v", code)
+	}
+}
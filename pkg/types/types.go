@@ -14,24 +14,62 @@ const (
 	PaymentStatusOk         PaymentStatus = "OK"
 	PaymentStatusFail       PaymentStatus = "FAIL"
 	PaymentStatusInProgress PaymentStatus = "INPROGRESS"
+	PaymentStatusReview     PaymentStatus = "REVIEW"
 )
 
 // Payment представляет информацию о платеже.
+// ParentID задан у связанных записей, например у комиссии за платёж,
+// MerchantID — у платежей мерчанту.
 type Payment struct {
-	ID        string
-	AccountID int64
-	Amount    Money
-	Category  PaymentCategory
-	Status    PaymentStatus
+	ID         string
+	AccountID  int64
+	Amount     Money
+	Category   PaymentCategory
+	Status     PaymentStatus
+	Time       int64
+	ParentID   string
+	MerchantID string
+	RejectedAt int64
+}
+
+// Merchant представляет получателя платежей. SettlementAccount — счёт, на
+// который зачисляются его платежи, MCC — код категории мерчанта.
+type Merchant struct {
+	ID                string
+	Name              string
+	Category          PaymentCategory
+	MCC               string
+	SettlementAccount int64
 }
+
 // Phone p
 type Phone string
 
+// AccountStatus представляет собой состояние счёта.
+type AccountStatus string
+
+// Предопределённые состояния счёта. Пустое состояние считается активным.
+const (
+	AccountStatusActive AccountStatus = "ACTIVE"
+	AccountStatusFrozen AccountStatus = "FROZEN"
+	AccountStatusClosed AccountStatus = "CLOSED"
+)
+
 // Account представляет информацию о счёте пользователя.
 type Account struct {
-	ID      int64
-	Phone   Phone
-	Balance Money
+	ID           int64
+	Phone        Phone
+	Balance      Money
+	Status       AccountStatus
+	StatusReason string
+}
+
+// Deposit представляет информацию о пополнении счёта.
+type Deposit struct {
+	ID        string
+	AccountID int64
+	Amount    Money
+	Time      int64
 }
 
 // Favorite представляет информацию об элементе "Избранное".
@@ -42,3 +80,34 @@ type Favorite struct {
 	Name      string
 	Category  PaymentCategory
 }
+
+// ScheduleKind представляет собой периодичность платежа по расписанию.
+type ScheduleKind string
+
+// Предопределённые периодичности.
+const (
+	ScheduleOnce    ScheduleKind = "ONCE"
+	ScheduleDaily   ScheduleKind = "DAILY"
+	ScheduleWeekly  ScheduleKind = "WEEKLY"
+	ScheduleMonthly ScheduleKind = "MONTHLY"
+)
+
+// Schedule представляет информацию о платеже по расписанию из "Избранного".
+// Runs считает наступившие периоды, а не успешные платежи: пропущенные
+// за время простоя периоды и платежи, не прошедшие после всех повторов,
+// тоже входят в Runs, так что Count ограничивает число периодов.
+type Schedule struct {
+	ID            string
+	FavoriteID    string
+	Kind          ScheduleKind
+	Day           int
+	Due           int64
+	End           int64
+	Count         int
+	Runs          int
+	RetryLimit    int
+	RetryInterval int64
+	Attempt       int
+	Next          int64
+	Active        bool
+}
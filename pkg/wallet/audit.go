@@ -0,0 +1,269 @@
+package wallet
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/SsSJKK/wallet/pkg/types"
+)
+
+//ErrAuditTampered err
+var ErrAuditTampered = errors.New("audit log is modified")
+
+//AuditEntry one record of the audit log. Hash covers Prev and all the other
+//fields, so an entry can't be changed, removed or moved without breaking the
+//chain.
+type AuditEntry struct {
+	Seq    int64
+	Time   int64
+	Actor  string
+	Action string
+	Target string
+	Before string
+	After  string
+	Reason string
+	Prev   string
+	Hash   string
+}
+
+//AuditError position of the first broken entry found by VerifyAudit
+type AuditError struct {
+	Line   int
+	Seq    int64
+	Reason string
+}
+
+func (e *AuditError) Error() string {
+	return fmt.Sprintf("audit line %d (seq %d): %s", e.Line, e.Seq, e.Reason)
+}
+
+//Is makes errors.Is(err, ErrAuditTampered) work
+func (e *AuditError) Is(target error) bool {
+	return target == ErrAuditTampered
+}
+
+//AuditSummary result of a successful verification
+type AuditSummary struct {
+	Entries int64
+	Head    string
+}
+
+//AuditLog returns the entries in the order they were recorded
+func (s *Service) AuditLog() []AuditEntry {
+	result := make([]AuditEntry, 0, len(s.audit))
+	for _, entry := range s.audit {
+		result = append(result, *entry)
+	}
+	return result
+}
+
+// enter ставит автора и причину для записей аудита до вызова возвращённой функции
+func (s *Service) enter(principal *Principal, reason string) func() {
+	caller, oldReason := s.caller, s.reason
+	s.caller, s.reason = principal, reason
+	return func() {
+		s.caller, s.reason = caller, oldReason
+	}
+}
+
+func (s *Service) record(entry AuditEntry) {
+	if entry.Actor == "" {
+		entry.Actor = "system"
+		if s.caller != nil {
+			entry.Actor = s.caller.String()
+		}
+	}
+	if entry.Reason == "" {
+		entry.Reason = s.reason
+	}
+	entry.Actor = auditField(entry.Actor)
+	entry.Target = auditField(entry.Target)
+	entry.Before = auditField(entry.Before)
+	entry.After = auditField(entry.After)
+	entry.Reason = auditField(entry.Reason)
+	entry.Time = s.now().Unix()
+	entry.Seq = 1
+	if len(s.audit) > 0 {
+		last := s.audit[len(s.audit)-1]
+		entry.Seq = last.Seq + 1
+		entry.Prev = last.Hash
+	}
+	entry.Hash = auditHash(&entry)
+	s.audit = append(s.audit, &entry)
+}
+
+// разделитель и переводы строк ломают формат дампов
+func auditField(value string) string {
+	return strings.NewReplacer(";", ",", "\n", " ", "\r", " ").Replace(value)
+}
+
+func auditLine(entry *AuditEntry) string {
+	return strconv.FormatInt(entry.Seq, 10) + ";" +
+		strconv.FormatInt(entry.Time, 10) + ";" +
+		entry.Actor + ";" +
+		entry.Action + ";" +
+		entry.Target + ";" +
+		entry.Before + ";" +
+		entry.After + ";" +
+		entry.Reason
+}
+
+func auditHash(entry *AuditEntry) string {
+	sum := sha256.Sum256([]byte(entry.Prev + ";" + auditLine(entry)))
+	return hex.EncodeToString(sum[:])
+}
+
+func accountState(account *types.Account) string {
+	return "phone=" + string(account.Phone) + " balance=" + strconv.FormatInt(int64(account.Balance), 10)
+}
+
+func paymentState(payment *types.Payment) string {
+	return "account=" + strconv.FormatInt(payment.AccountID, 10) +
+		" amount=" + strconv.FormatInt(int64(payment.Amount), 10) +
+		" category=" + string(payment.Category) +
+		" status=" + string(payment.Status)
+}
+
+func favoriteState(favorite *types.Favorite) string {
+	return "account=" + strconv.FormatInt(favorite.AccountID, 10) +
+		" name=" + favorite.Name +
+		" amount=" + strconv.FormatInt(int64(favorite.Amount), 10) +
+		" category=" + string(favorite.Category)
+}
+
+//VerifyAudit reads an audit.dump and checks the hash chain. Changed entries
+//fail the hash check, deleted and reordered ones break the sequence and the
+//link to the previous hash. Entries cut from the end can be detected only by
+//comparing the returned Head with a head saved earlier.
+func VerifyAudit(r io.Reader) (AuditSummary, error) {
+	summary := AuditSummary{}
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	line := 0
+	for scanner.Scan() {
+		line++
+		entry, err := parseAuditLine(scanner.Text())
+		if err != nil {
+			return summary, &AuditError{Line: line, Reason: err.Error()}
+		}
+		if entry.Seq != summary.Entries+1 {
+			return summary, &AuditError{Line: line, Seq: entry.Seq, Reason: fmt.Sprintf("sequence %d expected", summary.Entries+1)}
+		}
+		if entry.Prev != summary.Head {
+			return summary, &AuditError{Line: line, Seq: entry.Seq, Reason: "previous hash does not match"}
+		}
+		if auditHash(entry) != entry.Hash {
+			return summary, &AuditError{Line: line, Seq: entry.Seq, Reason: "entry hash does not match"}
+		}
+		summary.Entries = entry.Seq
+		summary.Head = entry.Hash
+	}
+	return summary, scanner.Err()
+}
+
+//VerifyAuditFile meth
+func VerifyAuditFile(path string) (AuditSummary, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return AuditSummary{}, err
+	}
+	defer file.Close()
+	return VerifyAudit(file)
+}
+
+func parseAuditLine(text string) (*AuditEntry, error) {
+	line := strings.Split(text, ";")
+	if len(line) != 10 {
+		return nil, errors.New("entry must have 10 fields")
+	}
+	seq, err := strconv.ParseInt(line[0], 10, 64)
+	if err != nil {
+		return nil, errors.New("invalid sequence")
+	}
+	unix, err := strconv.ParseInt(line[1], 10, 64)
+	if err != nil {
+		return nil, errors.New("invalid time")
+	}
+	return &AuditEntry{
+		Seq:    seq,
+		Time:   unix,
+		Actor:  line[2],
+		Action: line[3],
+		Target: line[4],
+		Before: line[5],
+		After:  line[6],
+		Reason: line[7],
+		Prev:   line[8],
+		Hash:   line[9],
+	}, nil
+}
+
+// exportAudit дописывает в audit.dump только новые записи, записанное раньше
+// не переписывается. Файл должен быть началом журнала сервиса.
+func (s *Service) exportAudit(dir string) error {
+	if s.audit == nil {
+		return nil
+	}
+	path := dir + "/audit.dump"
+	saved, err := VerifyAuditFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if saved.Entries > int64(len(s.audit)) || (saved.Entries > 0 && s.audit[saved.Entries-1].Hash != saved.Head) {
+		return &AuditError{Line: int(saved.Entries), Seq: saved.Entries, Reason: path + " is not the start of the audit log"}
+	}
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	text := ""
+	for _, entry := range s.audit[saved.Entries:] {
+		text += auditLine(entry) + ";" + entry.Prev + ";" + entry.Hash + "\n"
+	}
+	_, err = file.Write([]byte(text))
+	if err != nil {
+		return err
+	}
+	return file.Sync()
+}
+
+// importAudit не загружает журнал с разорванной цепочкой
+func (s *Service) importAudit(dir string) error {
+	if len(s.audit) > 0 {
+		log.Print("audit log is not empty, audit.dump is not imported")
+		return nil
+	}
+	path := dir + "/audit.dump"
+	_, err := VerifyAuditFile(path)
+	if os.IsNotExist(err) {
+		log.Print(err)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		entry, err := parseAuditLine(scanner.Text())
+		if err != nil {
+			return err
+		}
+		s.audit = append(s.audit, entry)
+	}
+	return scanner.Err()
+}
@@ -0,0 +1,99 @@
+package wallet
+
+import (
+	"errors"
+	"io/ioutil"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_AuditLog_Chain(t *testing.T) {
+	now := time.Date(2020, time.November, 3, 12, 0, 0, 0, time.UTC)
+	svc := &Service{}
+	svc.SetClock(func() time.Time { return now })
+	acc, _ := svc.RegisterAccount("992000000001")
+	svc.Deposit(acc.ID, 100)
+	pay, _ := svc.Pay(acc.ID, 30, "auto")
+	svc.RegisterStaff("anna", RoleSupport, "secret")
+	session, _ := svc.LoginStaff("anna", "secret")
+	principal, _ := svc.Authenticate(session.Token)
+	svc.As(principal).Because("ticket 17").Reject(pay.ID)
+	svc.As(principal).Pay(acc.ID, 10, "auto")
+
+	entries := svc.AuditLog()
+	actions := []string{}
+	for _, entry := range entries {
+		actions = append(actions, entry.Action)
+	}
+	if strings.Join(actions, ",") != "account.register,deposit,pay,staff.register,reject,deny" {
+		t.Fatalf("ERROR: actions %v", actions)
+	}
+	reject := entries[4]
+	if reject.Actor != "support:anna" || reject.Reason != "ticket 17" || reject.Before != "phone=+992000000001 balance=70 account=1 amount=30 category=auto status=INPROGRESS" || reject.After != "phone=+992000000001 balance=100 account=1 amount=30 category=auto status=FAIL" {
+		t.Errorf("ERROR: reject entry %+v", reject)
+	}
+	if entries[1].Actor != "system" || entries[1].Before != "phone=+992000000001 balance=0" || entries[1].Time != now.Unix() {
+		t.Errorf("ERROR: deposit entry %+v", entries[1])
+	}
+	if entries[5].Prev != entries[4].Hash || entries[0].Prev != "" {
+		t.Errorf("ERROR: chain %v %v", entries[5].Prev, entries[4].Hash)
+	}
+
+	dir := t.TempDir()
+	svc.Export(dir)
+	summary, err := VerifyAuditFile(dir + "/audit.dump")
+	if err != nil || summary.Entries != 6 || summary.Head != entries[5].Hash {
+		t.Errorf("ERROR: verify %v %v", summary, err)
+	}
+
+	saved, _ := ioutil.ReadFile(dir + "/audit.dump")
+	imported := &Service{}
+	imported.Import(dir)
+	imported.Deposit(acc.ID, 5)
+	imported.Export(dir)
+	summary, err = VerifyAuditFile(dir + "/audit.dump")
+	if err != nil || summary.Entries != 7 {
+		t.Errorf("ERROR: verify after import %v %v", summary, err)
+	}
+	appended, _ := ioutil.ReadFile(dir + "/audit.dump")
+	if !strings.HasPrefix(string(appended), string(saved)) || strings.Count(string(appended), "\n") != 7 {
+		t.Errorf("ERROR: export rewrote the log:\n%s", appended)
+	}
+
+	other := &Service{}
+	other.RegisterAccount("992000000002")
+	if err := other.Export(dir); !errors.Is(err, ErrAuditTampered) {
+		t.Errorf("ERROR: export over another log %v need %v", err, ErrAuditTampered)
+	}
+}
+
+func Test_VerifyAudit_Tampered(t *testing.T) {
+	svc := &Service{}
+	acc, _ := svc.RegisterAccount("992000000001")
+	svc.Deposit(acc.ID, 100)
+	svc.Deposit(acc.ID, 200)
+	svc.Pay(acc.ID, 10, "auto")
+	dir := t.TempDir()
+	svc.Export(dir)
+	data, _ := ioutil.ReadFile(dir + "/audit.dump")
+	lines := strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+
+	cases := map[string][]string{
+		"modified":  {lines[0], strings.Replace(lines[1], "balance=100", "balance=900", 1), lines[2], lines[3]},
+		"deleted":   {lines[0], lines[2], lines[3]},
+		"reordered": {lines[0], lines[2], lines[1], lines[3]},
+	}
+	for name, tampered := range cases {
+		_, err := VerifyAudit(strings.NewReader(strings.Join(tampered, "\n")))
+		var auditErr *AuditError
+		if !errors.Is(err, ErrAuditTampered) || !errors.As(err, &auditErr) || auditErr.Line != 2 {
+			t.Errorf("ERROR: %s %v", name, err)
+		}
+	}
+
+	ioutil.WriteFile(dir+"/audit.dump", []byte(strings.Join(cases["modified"], "\n")+"\n"), 0644)
+	if err := (&Service{}).Import(dir); !errors.Is(err, ErrAuditTampered) {
+		t.Errorf("ERROR: import of a modified log %v need %v", err, ErrAuditTampered)
+	}
+}
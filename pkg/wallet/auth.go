@@ -0,0 +1,267 @@
+package wallet
+
+import (
+	"bufio"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/pbkdf2"
+
+	"github.com/SsSJKK/wallet/pkg/types"
+)
+
+//ErrWeakPIN err
+var ErrWeakPIN = errors.New("pin must be at least 4 characters")
+
+//ErrInvalidCredentials err
+var ErrInvalidCredentials = errors.New("invalid phone or pin")
+
+//ErrAccountLocked err
+var ErrAccountLocked = errors.New("account is locked after failed logins")
+
+//ErrUnauthorized err
+var ErrUnauthorized = errors.New("invalid or expired token")
+
+//ErrForbidden err
+var ErrForbidden = errors.New("operation is not allowed for this account")
+
+//Auth settings
+const (
+	MaxLoginAttempts = 5
+	LockoutDuration  = 15 * time.Minute
+	SessionDuration  = 30 * time.Minute
+	pinIterations    = 10000
+)
+
+type credential struct {
+	AccountID   int64
+	Name        string
+	Role        Role
+	Salt        []byte
+	Hash        []byte
+	Failures    int
+	LockedUntil int64
+}
+
+//Session issued by Login and LoginStaff
+type Session struct {
+	Token     string
+	Principal Principal
+	Expires   int64
+}
+
+//Principal authenticated caller: a customer with AccountID or a staff
+//member with Name
+type Principal struct {
+	AccountID int64
+	Name      string
+	Role      Role
+}
+
+func (p *Principal) String() string {
+	if p == nil {
+		return "anonymous"
+	}
+	if p.Name != "" {
+		return string(p.Role) + ":" + p.Name
+	}
+	return string(p.Role) + ":" + strconv.FormatInt(p.AccountID, 10)
+}
+
+//SetPIN sets or replaces the PIN or password of the account
+func (s *Service) SetPIN(accountID int64, pin string) error {
+	_, err := s.FindAccountByID(accountID)
+	if err != nil {
+		return err
+	}
+	err = CheckPIN(pin)
+	if err != nil {
+		return err
+	}
+	salt := make([]byte, 16)
+	_, err = rand.Read(salt)
+	if err != nil {
+		return err
+	}
+	cred := &credential{AccountID: accountID, Salt: salt, Hash: hashPIN(pin, salt, pinIterations)}
+	if s.credentials == nil {
+		s.credentials = map[int64]*credential{}
+	}
+	s.credentials[accountID] = cred
+	s.record(AuditEntry{Action: "pin.set", Target: "account:" + strconv.FormatInt(accountID, 10), After: "pin changed"})
+	// после смены PIN старые сессии недействительны
+	s.endSessions(accountID)
+	return nil
+}
+
+//CheckPIN returns the error SetPIN gives for the pin, callers check the pin
+//before they create anything for it
+func CheckPIN(pin string) error {
+	if len(pin) < 4 {
+		return ErrWeakPIN
+	}
+	return nil
+}
+
+func (s *Service) endSessions(accountID int64) {
+	for token, session := range s.sessions {
+		if session.Principal.Name == "" && session.Principal.AccountID == accountID {
+			delete(s.sessions, token)
+		}
+	}
+}
+
+//Login checks the PIN and issues a session token. After MaxLoginAttempts
+//wrong PINs the account is locked for LockoutDuration.
+func (s *Service) Login(phone types.Phone, pin string) (*Session, error) {
+	account, err := s.FindAccountByPhone(phone)
+	if err != nil {
+		return nil, ErrInvalidCredentials
+	}
+	cred, ok := s.credentials[account.ID]
+	if !ok {
+		return nil, ErrInvalidCredentials
+	}
+	err = s.verify(cred, pin)
+	if err != nil {
+		return nil, err
+	}
+	return s.issueSession(Principal{AccountID: account.ID, Role: RoleCustomer})
+}
+
+func (s *Service) verify(cred *credential, pin string) error {
+	target := "account:" + strconv.FormatInt(cred.AccountID, 10)
+	if cred.Name != "" {
+		target = "staff:" + cred.Name
+	}
+	now := s.now()
+	if cred.LockedUntil > now.Unix() {
+		return ErrAccountLocked
+	}
+	if subtle.ConstantTimeCompare(hashPIN(pin, cred.Salt, pinIterations), cred.Hash) != 1 {
+		cred.Failures++
+		if cred.Failures >= MaxLoginAttempts {
+			cred.Failures = 0
+			cred.LockedUntil = now.Add(LockoutDuration).Unix()
+			s.record(AuditEntry{
+				Actor:  "system",
+				Action: "login.lock",
+				Target: target,
+				After:  "locked_until=" + strconv.FormatInt(cred.LockedUntil, 10),
+				Reason: strconv.Itoa(MaxLoginAttempts) + " failed logins",
+			})
+			return ErrAccountLocked
+		}
+		return ErrInvalidCredentials
+	}
+	cred.Failures = 0
+	cred.LockedUntil = 0
+	return nil
+}
+
+func (s *Service) issueSession(principal Principal) (*Session, error) {
+	token := make([]byte, 32)
+	_, err := rand.Read(token)
+	if err != nil {
+		return nil, err
+	}
+	session := &Session{
+		Token:     hex.EncodeToString(token),
+		Principal: principal,
+		Expires:   s.now().Add(SessionDuration).Unix(),
+	}
+	if s.sessions == nil {
+		s.sessions = map[string]*Session{}
+	}
+	s.sessions[session.Token] = session
+	copied := *session
+	return &copied, nil
+}
+
+//Authenticate returns the principal of a valid token
+func (s *Service) Authenticate(token string) (*Principal, error) {
+	session, ok := s.sessions[token]
+	if !ok {
+		return nil, ErrUnauthorized
+	}
+	if session.Expires <= s.now().Unix() {
+		delete(s.sessions, token)
+		return nil, ErrUnauthorized
+	}
+	principal := session.Principal
+	return &principal, nil
+}
+
+//Logout invalidates the token
+func (s *Service) Logout(token string) {
+	delete(s.sessions, token)
+}
+
+// hashPIN PBKDF2-HMAC-SHA256, 32 байта — те же хэши, что уже лежат в дампах
+func hashPIN(pin string, salt []byte, iterations int) []byte {
+	return pbkdf2.Key([]byte(pin), salt, iterations, sha256.Size, sha256.New)
+}
+
+func (s *Service) exportCredentials(dir string) error {
+	if s.credentials == nil {
+		return nil
+	}
+	file, err := os.Create(dir + "/credentials.dump")
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	text := ""
+	for _, account := range s.accounts {
+		cred, ok := s.credentials[account.ID]
+		if !ok {
+			continue
+		}
+		text += strconv.FormatInt(cred.AccountID, 10) + ";" +
+			hex.EncodeToString(cred.Salt) + ";" +
+			hex.EncodeToString(cred.Hash) + ";" +
+			strconv.Itoa(cred.Failures) + ";" +
+			strconv.FormatInt(cred.LockedUntil, 10) + ";\n"
+	}
+	_, err = file.Write([]byte(text))
+	return err
+}
+
+func (s *Service) importCredentials(dir string) {
+	file, err := os.Open(dir + "/credentials.dump")
+	if err != nil {
+		log.Print(err)
+		return
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.Split(scanner.Text(), ";")
+		if len(line) < 5 {
+			continue
+		}
+		accountID, _ := strconv.ParseInt(line[0], 10, 64)
+		salt, _ := hex.DecodeString(line[1])
+		hash, _ := hex.DecodeString(line[2])
+		failures, _ := strconv.Atoi(line[3])
+		lockedUntil, _ := strconv.ParseInt(line[4], 10, 64)
+		if s.credentials == nil {
+			s.credentials = map[int64]*credential{}
+		}
+		s.credentials[accountID] = &credential{
+			AccountID:   accountID,
+			Salt:        salt,
+			Hash:        hash,
+			Failures:    failures,
+			LockedUntil: lockedUntil,
+		}
+	}
+}
@@ -0,0 +1,113 @@
+package wallet
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func Test_Login_OK(t *testing.T) {
+	now := time.Date(2020, time.November, 3, 12, 0, 0, 0, time.UTC)
+	svc := &Service{}
+	svc.SetClock(func() time.Time { return now })
+	acc, _ := svc.RegisterAccount("992000000001")
+	if err := svc.SetPIN(acc.ID, "12"); err != ErrWeakPIN {
+		t.Errorf("ERROR: %v need %v", err, ErrWeakPIN)
+	}
+	svc.SetPIN(acc.ID, "1234")
+
+	if _, err := svc.Login("992000000001", "0000"); err != ErrInvalidCredentials {
+		t.Errorf("ERROR: %v need %v", err, ErrInvalidCredentials)
+	}
+	session, err := svc.Login("992000000001", "1234")
+	if err != nil {
+		t.Fatalf("ERROR: %v", err)
+	}
+	principal, err := svc.Authenticate(session.Token)
+	if err != nil || principal.AccountID != acc.ID {
+		t.Errorf("ERROR: %v %v", err, principal)
+	}
+
+	now = now.Add(SessionDuration)
+	if _, err := svc.Authenticate(session.Token); err != ErrUnauthorized {
+		t.Errorf("ERROR: expired %v need %v", err, ErrUnauthorized)
+	}
+
+	session, _ = svc.Login("992000000001", "1234")
+	svc.Logout(session.Token)
+	if _, err := svc.Authenticate(session.Token); err != ErrUnauthorized {
+		t.Errorf("ERROR: logout %v need %v", err, ErrUnauthorized)
+	}
+}
+
+func Test_Login_Lockout(t *testing.T) {
+	now := time.Date(2020, time.November, 3, 12, 0, 0, 0, time.UTC)
+	svc := &Service{}
+	svc.SetClock(func() time.Time { return now })
+	acc, _ := svc.RegisterAccount("992000000001")
+	svc.SetPIN(acc.ID, "1234")
+
+	var err error
+	for i := 0; i < MaxLoginAttempts; i++ {
+		_, err = svc.Login("992000000001", "0000")
+	}
+	if err != ErrAccountLocked {
+		t.Errorf("ERROR: %v need %v", err, ErrAccountLocked)
+	}
+	if _, err := svc.Login("992000000001", "1234"); err != ErrAccountLocked {
+		t.Errorf("ERROR: right pin while locked %v", err)
+	}
+
+	dir := t.TempDir()
+	svc.Export(dir)
+	imported := &Service{}
+	imported.SetClock(func() time.Time { return now })
+	imported.Import(dir)
+	if _, err := imported.Login("992000000001", "1234"); err != ErrAccountLocked {
+		t.Errorf("ERROR: lock must survive export %v", err)
+	}
+
+	now = now.Add(LockoutDuration)
+	if _, err := imported.Login("992000000001", "1234"); err != nil {
+		t.Errorf("ERROR: after lockout %v", err)
+	}
+}
+
+func Test_Actor_OwnAccountsOnly(t *testing.T) {
+	svc := &Service{}
+	acc, _ := svc.RegisterAccount("992000000001")
+	other, _ := svc.RegisterAccount("992000000002")
+	svc.Deposit(acc.ID, 100)
+	svc.Deposit(other.ID, 100)
+	otherPay, _ := svc.Pay(other.ID, 10, "auto")
+	svc.SetPIN(acc.ID, "1234")
+	session, _ := svc.Login("992000000001", "1234")
+	principal, _ := svc.Authenticate(session.Token)
+	actor := svc.As(principal)
+
+	pay, err := actor.Pay(acc.ID, 10, "auto")
+	if err != nil {
+		t.Fatalf("ERROR: %v", err)
+	}
+	if _, err := actor.Pay(other.ID, 10, "auto"); !errors.Is(err, ErrForbidden) {
+		t.Errorf("ERROR: pay other %v need %v", err, ErrForbidden)
+	}
+	if _, err := actor.FindPaymentByID(otherPay.ID); !errors.Is(err, ErrForbidden) {
+		t.Errorf("ERROR: payment of other %v need %v", err, ErrForbidden)
+	}
+	if err := actor.Reject(otherPay.ID); !errors.Is(err, ErrForbidden) {
+		t.Errorf("ERROR: reject other %v need %v", err, ErrForbidden)
+	}
+	if _, err := actor.Repeat(pay.ID); err != nil {
+		t.Errorf("ERROR: repeat own %v", err)
+	}
+	if _, err := actor.ExportAccountHistory(other.ID); !errors.Is(err, ErrForbidden) {
+		t.Errorf("ERROR: history other %v need %v", err, ErrForbidden)
+	}
+	if _, err := svc.As(nil).Pay(acc.ID, 10, "auto"); !errors.Is(err, ErrUnauthorized) {
+		t.Errorf("ERROR: no principal %v need %v", err, ErrUnauthorized)
+	}
+	if other.Balance != 90 {
+		t.Errorf("ERROR: other balance %v need 90", other.Balance)
+	}
+}
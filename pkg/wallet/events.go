@@ -0,0 +1,212 @@
+package wallet
+
+import (
+	"sync"
+	"sync/atomic"
+
+	"github.com/SsSJKK/wallet/pkg/types"
+)
+
+//EventType kind of a wallet domain event
+type EventType string
+
+//Event types
+const (
+	EventAccountRegistered    EventType = "ACCOUNT_REGISTERED"
+	EventDepositMade          EventType = "DEPOSIT_MADE"
+	EventPaymentCreated       EventType = "PAYMENT_CREATED"
+	EventPaymentStatusChanged EventType = "PAYMENT_STATUS_CHANGED"
+	EventFavoriteCreated      EventType = "FAVORITE_CREATED"
+	EventAccountStatusChanged EventType = "ACCOUNT_STATUS_CHANGED"
+)
+
+//Event wallet domain event. Only the field matching the type is set,
+//values are copies and may be kept by subscribers.
+type Event struct {
+	Type      EventType
+	AccountID int64
+	Time      int64
+	Account   *types.Account
+	Deposit   *types.Deposit
+	Payment   *types.Payment
+	Favorite  *types.Favorite
+	OldStatus types.PaymentStatus
+}
+
+//EventHandler receives published events
+type EventHandler func(event Event)
+
+//DeliveryMode how events reach a subscriber
+type DeliveryMode int
+
+//Delivery modes
+const (
+	//DeliverSync calls the handler inside the publishing call
+	DeliverSync DeliveryMode = iota
+	//DeliverAsync queues events to a buffer, events of one account are handled in order
+	DeliverAsync
+)
+
+//SubscribeOptions options of a subscription
+type SubscribeOptions struct {
+	Mode    DeliveryMode
+	Buffer  int
+	Workers int
+	Types   []EventType
+}
+
+//EventBus delivers events to in-process subscribers
+type EventBus struct {
+	mu          sync.RWMutex
+	subscribers []*Subscription
+}
+
+//Subscription of a handler to the bus
+type Subscription struct {
+	bus     *EventBus
+	handler EventHandler
+	mode    DeliveryMode
+	types   map[EventType]bool
+	mu      sync.RWMutex
+	closed  bool
+	queues  []chan Event
+	wg      sync.WaitGroup
+	dropped uint64
+}
+
+//Subscribe registers the handler. Async subscriptions never block the
+//publisher: when the buffer of a worker is full the event is dropped and
+//counted in Dropped.
+func (b *EventBus) Subscribe(handler EventHandler, options SubscribeOptions) *Subscription {
+	sub := &Subscription{
+		bus:     b,
+		handler: handler,
+		mode:    options.Mode,
+	}
+	if len(options.Types) > 0 {
+		sub.types = map[EventType]bool{}
+		for _, eventType := range options.Types {
+			sub.types[eventType] = true
+		}
+	}
+	if sub.mode == DeliverAsync {
+		workers := options.Workers
+		if workers <= 0 {
+			workers = 1
+		}
+		buffer := options.Buffer
+		if buffer <= 0 {
+			buffer = 100
+		}
+		for i := 0; i < workers; i++ {
+			queue := make(chan Event, buffer)
+			sub.queues = append(sub.queues, queue)
+			sub.wg.Add(1)
+			go func() {
+				defer sub.wg.Done()
+				for event := range queue {
+					handler(event)
+				}
+			}()
+		}
+	}
+
+	b.mu.Lock()
+	b.subscribers = append(b.subscribers, sub)
+	b.mu.Unlock()
+	return sub
+}
+
+//Publish delivers the event to every subscriber
+func (b *EventBus) Publish(event Event) {
+	b.mu.RLock()
+	subscribers := b.subscribers
+	b.mu.RUnlock()
+	for _, sub := range subscribers {
+		sub.deliver(event)
+	}
+}
+
+func (sub *Subscription) deliver(event Event) {
+	if sub.types != nil && !sub.types[event.Type] {
+		return
+	}
+	if sub.mode == DeliverSync {
+		sub.handler(event)
+		return
+	}
+
+	sub.mu.RLock()
+	defer sub.mu.RUnlock()
+	if sub.closed {
+		return
+	}
+	shard := event.AccountID % int64(len(sub.queues))
+	if shard < 0 {
+		shard = -shard
+	}
+	select {
+	case sub.queues[shard] <- event:
+	default:
+		atomic.AddUint64(&sub.dropped, 1)
+	}
+}
+
+//Dropped count of events dropped because the buffer was full
+func (sub *Subscription) Dropped() uint64 {
+	return atomic.LoadUint64(&sub.dropped)
+}
+
+//Close unsubscribes and waits until queued events are handled
+func (sub *Subscription) Close() {
+	b := sub.bus
+	b.mu.Lock()
+	for i, s := range b.subscribers {
+		if s == sub {
+			subscribers := make([]*Subscription, 0, len(b.subscribers)-1)
+			subscribers = append(subscribers, b.subscribers[:i]...)
+			b.subscribers = append(subscribers, b.subscribers[i+1:]...)
+			break
+		}
+	}
+	b.mu.Unlock()
+
+	sub.mu.Lock()
+	if !sub.closed {
+		sub.closed = true
+		for _, queue := range sub.queues {
+			close(queue)
+		}
+	}
+	sub.mu.Unlock()
+	sub.wg.Wait()
+}
+
+//SetEventBus sets the bus the service publishes its events to
+func (s *Service) SetEventBus(bus *EventBus) {
+	s.events = bus
+}
+
+func (s *Service) publish(event Event) {
+	if s.events == nil {
+		return
+	}
+	event.Time = s.now().Unix()
+	s.events.Publish(event)
+}
+
+func (s *Service) publishPayment(eventType EventType, payment *types.Payment, oldStatus types.PaymentStatus) {
+	if s.events == nil {
+		return
+	}
+	copied := *payment
+	s.publish(Event{Type: eventType, AccountID: payment.AccountID, Payment: &copied, OldStatus: oldStatus})
+}
+
+func (s *Service) publishFavorite(favorite *types.Favorite) {
+	if s.events == nil {
+		return
+	}
+	copied := *favorite
+	s.publish(Event{Type: EventFavoriteCreated, AccountID: favorite.AccountID, Favorite: &copied})
+}
@@ -0,0 +1,105 @@
+package wallet
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/SsSJKK/wallet/pkg/types"
+)
+
+func Test_Events_Sync(t *testing.T) {
+	bus := &EventBus{}
+	svc := &Service{}
+	svc.SetEventBus(bus)
+
+	var events []Event
+	bus.Subscribe(func(event Event) {
+		events = append(events, event)
+	}, SubscribeOptions{Mode: DeliverSync})
+
+	acc, _ := svc.RegisterAccount("992000000001")
+	svc.Deposit(acc.ID, 100)
+	pay, _ := svc.Pay(acc.ID, 10, "auto")
+	svc.FavoritePayment(pay.ID, "car")
+	svc.Reject(pay.ID)
+
+	want := []EventType{
+		EventAccountRegistered,
+		EventDepositMade,
+		EventPaymentCreated,
+		EventFavoriteCreated,
+		EventPaymentStatusChanged,
+	}
+	if len(events) != len(want) {
+		t.Fatalf("ERROR: events %v need %v", events, want)
+	}
+	for i, event := range events {
+		if event.Type != want[i] || event.AccountID != acc.ID {
+			t.Errorf("ERROR: event %d %v need %v", i, event, want[i])
+		}
+	}
+	last := events[4]
+	if last.OldStatus != types.PaymentStatusInProgress || last.Payment.Status != types.PaymentStatusFail {
+		t.Errorf("ERROR: status change %v -> %v", last.OldStatus, last.Payment.Status)
+	}
+	if events[2].Payment.Status != types.PaymentStatusInProgress {
+		t.Errorf("ERROR: created event payment must be a copy, got %v", events[2].Payment.Status)
+	}
+}
+
+func Test_Events_AsyncOrderPerAccount(t *testing.T) {
+	bus := &EventBus{}
+	svc := &Service{}
+	svc.SetEventBus(bus)
+
+	mu := sync.Mutex{}
+	amounts := map[int64][]types.Money{}
+	sub := bus.Subscribe(func(event Event) {
+		mu.Lock()
+		amounts[event.AccountID] = append(amounts[event.AccountID], event.Deposit.Amount)
+		mu.Unlock()
+	}, SubscribeOptions{Mode: DeliverAsync, Workers: 4, Buffer: 1000, Types: []EventType{EventDepositMade}})
+
+	acc1, _ := svc.RegisterAccount("992000000001")
+	acc2, _ := svc.RegisterAccount("992000000002")
+	for i := 1; i <= 100; i++ {
+		svc.Deposit(acc1.ID, types.Money(i))
+		svc.Deposit(acc2.ID, types.Money(i))
+	}
+	sub.Close()
+
+	for _, id := range []int64{acc1.ID, acc2.ID} {
+		if len(amounts[id]) != 100 {
+			t.Fatalf("ERROR: account %d got %d events", id, len(amounts[id]))
+		}
+		for i, amount := range amounts[id] {
+			if amount != types.Money(i+1) {
+				t.Fatalf("ERROR: account %d event %d amount %v", id, i, amount)
+			}
+		}
+	}
+}
+
+func Test_Events_AsyncDropsWhenFull(t *testing.T) {
+	bus := &EventBus{}
+	svc := &Service{}
+	svc.SetEventBus(bus)
+
+	block := make(chan struct{})
+	sub := bus.Subscribe(func(event Event) {
+		<-block
+	}, SubscribeOptions{Mode: DeliverAsync, Buffer: 1})
+
+	acc, _ := svc.RegisterAccount("992000000001")
+	for i := 0; i < 10; i++ {
+		svc.Deposit(acc.ID, 10)
+	}
+	if acc.Balance != 100 {
+		t.Errorf("ERROR: balance %v need 100", acc.Balance)
+	}
+	if sub.Dropped() == 0 {
+		t.Errorf("ERROR: nothing dropped")
+	}
+	close(block)
+	sub.Close()
+}
@@ -0,0 +1,179 @@
+package wallet
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+
+	"github.com/google/uuid"
+
+	"github.com/SsSJKK/wallet/pkg/types"
+)
+
+//ErrFavoriteNameExists err
+var ErrFavoriteNameExists = errors.New("favorite with this name already exists")
+
+//ErrInvalidFavoriteName err
+var ErrInvalidFavoriteName = errors.New("invalid favorite name")
+
+//ErrInvalidFavoriteOrder err
+var ErrInvalidFavoriteOrder = errors.New("order must list every favorite of the account once")
+
+//AddFavorite creates a favorite without an existing payment
+func (s *Service) AddFavorite(accountID int64, name string, amount types.Money, category types.PaymentCategory) (*types.Favorite, error) {
+	if amount <= 0 {
+		return nil, ErrAmountMustBePositive
+	}
+	_, err := s.FindAccountByID(accountID)
+	if err != nil {
+		return nil, err
+	}
+	err = s.checkFavoriteName(accountID, "", name)
+	if err != nil {
+		return nil, err
+	}
+
+	favorite := &types.Favorite{
+		ID:        uuid.New().String(),
+		AccountID: accountID,
+		Amount:    amount,
+		Name:      name,
+		Category:  category,
+	}
+	s.favorites = append(s.favorites, favorite)
+	s.record(AuditEntry{
+		Action: "favorite.add",
+		Target: "favorite:" + favorite.ID,
+		After:  favoriteState(favorite),
+	})
+	s.publishFavorite(favorite)
+	return favorite, nil
+}
+
+//AccountFavorites returns favorites of the account in their order
+func (s *Service) AccountFavorites(accountID int64) ([]types.Favorite, error) {
+	_, err := s.FindAccountByID(accountID)
+	if err != nil {
+		return nil, err
+	}
+	favorites := []types.Favorite{}
+	for _, favorite := range s.favorites {
+		if favorite.AccountID == accountID {
+			favorites = append(favorites, *favorite)
+		}
+	}
+	return favorites, nil
+}
+
+//UpdateFavorite changes name, amount and category of the favorite
+func (s *Service) UpdateFavorite(favoriteID string, name string, amount types.Money, category types.PaymentCategory) (*types.Favorite, error) {
+	if amount <= 0 {
+		return nil, ErrAmountMustBePositive
+	}
+	favorite, err := s.FindFavoriteByID(favoriteID)
+	if err != nil {
+		return nil, err
+	}
+	err = s.checkFavoriteName(favorite.AccountID, favorite.ID, name)
+	if err != nil {
+		return nil, err
+	}
+
+	before := favoriteState(favorite)
+	favorite.Name = name
+	favorite.Amount = amount
+	favorite.Category = category
+	s.record(AuditEntry{
+		Action: "favorite.update",
+		Target: "favorite:" + favorite.ID,
+		Before: before,
+		After:  favoriteState(favorite),
+	})
+	return favorite, nil
+}
+
+//DeleteFavorite removes the favorite and cancels its schedules
+func (s *Service) DeleteFavorite(favoriteID string) error {
+	for i, favorite := range s.favorites {
+		if favorite.ID != favoriteID {
+			continue
+		}
+		s.favorites = append(s.favorites[:i], s.favorites[i+1:]...)
+		for _, schedule := range s.schedules {
+			if schedule.FavoriteID == favoriteID {
+				schedule.Active = false
+			}
+		}
+		s.record(AuditEntry{
+			Action: "favorite.delete",
+			Target: "favorite:" + favoriteID,
+			Before: favoriteState(favorite),
+		})
+		return nil
+	}
+	return ErrFavoriteNotFound
+}
+
+//ReorderFavorites puts favorites of the account in the given order
+func (s *Service) ReorderFavorites(accountID int64, favoriteIDs []string) error {
+	_, err := s.FindAccountByID(accountID)
+	if err != nil {
+		return err
+	}
+	ordered := make([]*types.Favorite, 0, len(favoriteIDs))
+	seen := map[string]bool{}
+	for _, id := range favoriteIDs {
+		favorite, err := s.FindFavoriteByID(id)
+		if err != nil {
+			return err
+		}
+		if favorite.AccountID != accountID || seen[id] {
+			return ErrInvalidFavoriteOrder
+		}
+		seen[id] = true
+		ordered = append(ordered, favorite)
+	}
+
+	count := 0
+	for _, favorite := range s.favorites {
+		if favorite.AccountID == accountID {
+			count++
+		}
+	}
+	if count != len(ordered) {
+		return ErrInvalidFavoriteOrder
+	}
+
+	// места избранного этого счёта занимаем в новом порядке
+	before := []string{}
+	next := 0
+	for i, favorite := range s.favorites {
+		if favorite.AccountID == accountID {
+			before = append(before, favorite.ID)
+			s.favorites[i] = ordered[next]
+			next++
+		}
+	}
+	s.record(AuditEntry{
+		Action: "favorite.reorder",
+		Target: "account:" + strconv.FormatInt(accountID, 10),
+		Before: "order=" + strings.Join(before, ","),
+		After:  "order=" + strings.Join(favoriteIDs, ","),
+	})
+	return nil
+}
+
+func (s *Service) checkFavoriteName(accountID int64, favoriteID string, name string) error {
+	if strings.TrimSpace(name) == "" || strings.ContainsAny(name, ";\n") {
+		return ErrInvalidFavoriteName
+	}
+	for _, favorite := range s.favorites {
+		if favorite.AccountID != accountID || favorite.ID == favoriteID {
+			continue
+		}
+		if strings.EqualFold(strings.TrimSpace(favorite.Name), strings.TrimSpace(name)) {
+			return ErrFavoriteNameExists
+		}
+	}
+	return nil
+}
@@ -0,0 +1,103 @@
+package wallet
+
+import (
+	"testing"
+
+	"github.com/SsSJKK/wallet/pkg/types"
+)
+
+func Test_AddFavorite_OK(t *testing.T) {
+	svc := &Service{}
+	acc, _ := svc.RegisterAccount("992000000001")
+	svc.Deposit(acc.ID, 100)
+	fav, err := svc.AddFavorite(acc.ID, "Internet", 30, "internet")
+	if err != nil {
+		t.Fatalf("ERROR: %v", err)
+	}
+	pay, err := svc.PayFromFavorite(fav.ID)
+	if err != nil || pay.Amount != 30 || pay.Category != "internet" {
+		t.Errorf("ERROR: %v %v", err, pay)
+	}
+
+	_, err = svc.AddFavorite(acc.ID, "internet ", 10, "internet")
+	if err != ErrFavoriteNameExists {
+		t.Errorf("ERROR: %v need %v", err, ErrFavoriteNameExists)
+	}
+	_, err = svc.FavoritePayment(pay.ID, "Internet")
+	if err != ErrFavoriteNameExists {
+		t.Errorf("ERROR: %v need %v", err, ErrFavoriteNameExists)
+	}
+	_, err = svc.AddFavorite(acc.ID, "a;b", 10, "internet")
+	if err != ErrInvalidFavoriteName {
+		t.Errorf("ERROR: %v need %v", err, ErrInvalidFavoriteName)
+	}
+
+	other, _ := svc.RegisterAccount("992000000002")
+	_, err = svc.AddFavorite(other.ID, "Internet", 10, "internet")
+	if err != nil {
+		t.Errorf("ERROR: other account %v", err)
+	}
+}
+
+func Test_UpdateDeleteFavorite_OK(t *testing.T) {
+	svc := &Service{}
+	acc, _ := svc.RegisterAccount("992000000001")
+	fav, _ := svc.AddFavorite(acc.ID, "Phone", 10, "mobile")
+	svc.AddFavorite(acc.ID, "Water", 10, "utilities")
+
+	_, err := svc.UpdateFavorite(fav.ID, "Water", 20, "mobile")
+	if err != ErrFavoriteNameExists {
+		t.Errorf("ERROR: %v need %v", err, ErrFavoriteNameExists)
+	}
+	updated, err := svc.UpdateFavorite(fav.ID, "Mobile", 20, "tcell")
+	if err != nil || updated.Name != "Mobile" || updated.Amount != 20 || updated.Category != "tcell" {
+		t.Errorf("ERROR: %v %v", err, updated)
+	}
+
+	schedule, _ := svc.ScheduleFavorite(fav.ID, types.Schedule{Kind: types.ScheduleDaily})
+	if err := svc.DeleteFavorite(fav.ID); err != nil {
+		t.Errorf("ERROR: %v", err)
+	}
+	if _, err := svc.FindFavoriteByID(fav.ID); err != ErrFavoriteNotFound {
+		t.Errorf("ERROR: %v need %v", err, ErrFavoriteNotFound)
+	}
+	if schedule.Active {
+		t.Errorf("ERROR: schedule of deleted favorite is active")
+	}
+	if err := svc.DeleteFavorite(fav.ID); err != ErrFavoriteNotFound {
+		t.Errorf("ERROR: %v need %v", err, ErrFavoriteNotFound)
+	}
+}
+
+func Test_ReorderFavorites_OK(t *testing.T) {
+	svc := &Service{}
+	acc, _ := svc.RegisterAccount("992000000001")
+	other, _ := svc.RegisterAccount("992000000002")
+	a, _ := svc.AddFavorite(acc.ID, "A", 10, "a")
+	x, _ := svc.AddFavorite(other.ID, "X", 10, "x")
+	b, _ := svc.AddFavorite(acc.ID, "B", 10, "b")
+	c, _ := svc.AddFavorite(acc.ID, "C", 10, "c")
+
+	if err := svc.ReorderFavorites(acc.ID, []string{c.ID, a.ID}); err != ErrInvalidFavoriteOrder {
+		t.Errorf("ERROR: %v need %v", err, ErrInvalidFavoriteOrder)
+	}
+	if err := svc.ReorderFavorites(acc.ID, []string{c.ID, x.ID, a.ID}); err != ErrInvalidFavoriteOrder {
+		t.Errorf("ERROR: %v need %v", err, ErrInvalidFavoriteOrder)
+	}
+	if err := svc.ReorderFavorites(acc.ID, []string{c.ID, a.ID, b.ID}); err != nil {
+		t.Fatalf("ERROR: %v", err)
+	}
+
+	favorites, _ := svc.AccountFavorites(acc.ID)
+	got := []string{}
+	for _, fav := range favorites {
+		got = append(got, fav.Name)
+	}
+	if len(got) != 3 || got[0] != "C" || got[1] != "A" || got[2] != "B" {
+		t.Errorf("ERROR: order %v need [C A B]", got)
+	}
+	others, _ := svc.AccountFavorites(other.ID)
+	if len(others) != 1 || others[0].ID != x.ID {
+		t.Errorf("ERROR: other account favorites %v", others)
+	}
+}
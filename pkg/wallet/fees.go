@@ -0,0 +1,303 @@
+package wallet
+
+import (
+	"bufio"
+	"errors"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/SsSJKK/wallet/pkg/types"
+)
+
+//ErrInvalidFeeRule err
+var ErrInvalidFeeRule = errors.New("invalid fee rule")
+
+//OperationType kind of money movement a fee is charged for
+type OperationType string
+
+//Operation types. Pay charges OperationPay, the payout of CloseAccount
+//charges OperationWithdrawal, Settle charges merchants OperationSettlement.
+const (
+	OperationPay        OperationType = "PAY"
+	OperationTransfer   OperationType = "TRANSFER"
+	OperationWithdrawal OperationType = "WITHDRAWAL"
+)
+
+//FeeCategory category of fee payments, they are linked to the charged
+//payment by ParentID
+const FeeCategory types.PaymentCategory = "fee"
+
+//FeeRule fee for operations of a type, category and amount tier. Empty
+//Operation and Category match any, the tier is From <= amount <= To, zero To
+//has no upper bound. The fee is Fixed plus Percent basis points (150 is 1.5%)
+//of the amount, raised to Min and cut to Max (zero Max has no maximum). The
+//first FreePerMonth matching operations of an account in a calendar month are
+//free.
+type FeeRule struct {
+	Operation    OperationType
+	Category     types.PaymentCategory
+	From         types.Money
+	To           types.Money
+	Fixed        types.Money
+	Percent      int64
+	Min          types.Money
+	Max          types.Money
+	FreePerMonth int
+}
+
+func (r *FeeRule) sameKey(other *FeeRule) bool {
+	return r.Operation == other.Operation && r.Category == other.Category && r.From == other.From && r.To == other.To
+}
+
+func (r *FeeRule) matches(operation OperationType, amount types.Money, category types.PaymentCategory) bool {
+	if r.Operation != "" && r.Operation != operation {
+		return false
+	}
+	if r.Category != "" && r.Category != category {
+		return false
+	}
+	return amount >= r.From && (r.To == 0 || amount <= r.To)
+}
+
+func (r *FeeRule) fee(amount types.Money) types.Money {
+	// проценты округляем до ближайшей минимальной единицы
+	fee := r.Fixed + types.Money((int64(amount)*r.Percent+5000)/10000)
+	if fee < r.Min {
+		fee = r.Min
+	}
+	if r.Max > 0 && fee > r.Max {
+		fee = r.Max
+	}
+	return fee
+}
+
+//SetFeeRule adds the rule or replaces the rule with the same operation,
+//category and tier
+func (s *Service) SetFeeRule(rule FeeRule) error {
+	if rule.From < 0 || rule.To < 0 || rule.Fixed < 0 || rule.Percent < 0 || rule.Min < 0 || rule.Max < 0 || rule.FreePerMonth < 0 {
+		return ErrInvalidFeeRule
+	}
+	if (rule.To != 0 && rule.To < rule.From) || (rule.Max != 0 && rule.Max < rule.Min) {
+		return ErrInvalidFeeRule
+	}
+	entry := AuditEntry{Action: "fee.set", Target: feeTarget(&rule), After: feeState(&rule)}
+	for _, r := range s.fees {
+		if r.sameKey(&rule) {
+			entry.Before = feeState(r)
+			*r = rule
+			s.record(entry)
+			return nil
+		}
+	}
+	s.fees = append(s.fees, &rule)
+	s.record(entry)
+	return nil
+}
+
+//RemoveFeeRule removes the rule with the operation, category and tier of rule
+func (s *Service) RemoveFeeRule(rule FeeRule) {
+	for i, r := range s.fees {
+		if r.sameKey(&rule) {
+			s.fees = append(s.fees[:i], s.fees[i+1:]...)
+			s.record(AuditEntry{Action: "fee.remove", Target: feeTarget(r), Before: feeState(r)})
+			return
+		}
+	}
+}
+
+//FeeRules returns copies of the fee rules
+func (s *Service) FeeRules() []FeeRule {
+	rules := make([]FeeRule, 0, len(s.fees))
+	for _, r := range s.fees {
+		rules = append(rules, *r)
+	}
+	return rules
+}
+
+//QuoteFee returns the fee the account would pay for the operation now
+func (s *Service) QuoteFee(accountID int64, operation OperationType, amount types.Money, category types.PaymentCategory) (types.Money, error) {
+	_, err := s.FindAccountByID(accountID)
+	if err != nil {
+		return 0, err
+	}
+	return s.feeFor(accountID, operation, amount, category), nil
+}
+
+func (s *Service) feeFor(accountID int64, operation OperationType, amount types.Money, category types.PaymentCategory) types.Money {
+	rule := s.feeRule(operation, amount, category)
+	if rule == nil {
+		return 0
+	}
+	if rule.FreePerMonth > 0 && s.feeRuleUsed(accountID, rule) < rule.FreePerMonth {
+		return 0
+	}
+	return rule.fee(amount)
+}
+
+// feeRule самое точное правило: операция важнее категории, при равенстве первое
+func (s *Service) feeRule(operation OperationType, amount types.Money, category types.PaymentCategory) *FeeRule {
+	var best *FeeRule
+	bestScore := -1
+	for _, r := range s.fees {
+		if !r.matches(operation, amount, category) {
+			continue
+		}
+		score := 0
+		if r.Operation != "" {
+			score += 2
+		}
+		if r.Category != "" {
+			score++
+		}
+		if score > bestScore {
+			best, bestScore = r, score
+		}
+	}
+	return best
+}
+
+func (s *Service) feeRuleUsed(accountID int64, rule *FeeRule) int {
+	now := s.now()
+	year, month, _ := now.Date()
+	from := time.Date(year, month, 1, 0, 0, 0, 0, now.Location()).Unix()
+	used := 0
+	for _, payment := range s.payments {
+		if payment.AccountID != accountID || payment.ParentID != "" || payment.Category == InterestCategory || payment.Status == types.PaymentStatusFail || payment.Time < from {
+			continue
+		}
+		if s.feeRule(operationOf(payment), payment.Amount, payment.Category) == rule {
+			used++
+		}
+	}
+	return used
+}
+
+func operationOf(payment *types.Payment) OperationType {
+	if payment.Category == PayoutCategory {
+		return OperationWithdrawal
+	}
+	return OperationPay
+}
+
+func (s *Service) postFee(account *types.Account, parent *types.Payment, fee types.Money) *types.Payment {
+	before := accountState(account)
+	oldBalance := account.Balance
+	account.Balance -= fee
+	s.startOverdraft(account, oldBalance)
+	payment := &types.Payment{
+		ID:        uuid.New().String(),
+		AccountID: account.ID,
+		Amount:    fee,
+		Category:  FeeCategory,
+		Status:    parent.Status,
+		Time:      parent.Time,
+		ParentID:  parent.ID,
+	}
+	s.payments = append(s.payments, payment)
+	s.record(AuditEntry{
+		Action: "fee",
+		Target: "payment:" + payment.ID,
+		Before: before,
+		After:  accountState(account) + " " + paymentState(payment) + " parent=" + parent.ID,
+	})
+	s.publishPayment(EventPaymentCreated, payment, "")
+	return payment
+}
+
+//LinkedPayments returns the fees and other records linked to the payment
+func (s *Service) LinkedPayments(paymentID string) []types.Payment {
+	linked := []types.Payment{}
+	for _, payment := range s.payments {
+		if payment.ParentID == paymentID {
+			linked = append(linked, *payment)
+		}
+	}
+	return linked
+}
+
+func feeTarget(rule *FeeRule) string {
+	return "fee:" + string(rule.Operation) + "/" + string(rule.Category) + "/" +
+		strconv.FormatInt(int64(rule.From), 10) + "-" + strconv.FormatInt(int64(rule.To), 10)
+}
+
+func feeState(rule *FeeRule) string {
+	return "fixed=" + strconv.FormatInt(int64(rule.Fixed), 10) +
+		" percent=" + strconv.FormatInt(rule.Percent, 10) +
+		" min=" + strconv.FormatInt(int64(rule.Min), 10) +
+		" max=" + strconv.FormatInt(int64(rule.Max), 10) +
+		" free=" + strconv.Itoa(rule.FreePerMonth)
+}
+
+func (s *Service) exportFees(dir string) error {
+	if s.fees == nil {
+		return nil
+	}
+	file, err := os.Create(dir + "/fees.dump")
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	text := ""
+	for _, r := range s.fees {
+		text += string(r.Operation) + ";" +
+			string(r.Category) + ";" +
+			strconv.FormatInt(int64(r.From), 10) + ";" +
+			strconv.FormatInt(int64(r.To), 10) + ";" +
+			strconv.FormatInt(int64(r.Fixed), 10) + ";" +
+			strconv.FormatInt(r.Percent, 10) + ";" +
+			strconv.FormatInt(int64(r.Min), 10) + ";" +
+			strconv.FormatInt(int64(r.Max), 10) + ";" +
+			strconv.Itoa(r.FreePerMonth) + ";\n"
+	}
+	_, err = file.Write([]byte(text))
+	return err
+}
+
+func (s *Service) importFees(dir string) {
+	file, err := os.Open(dir + "/fees.dump")
+	if err != nil {
+		log.Print(err)
+		return
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.Split(scanner.Text(), ";")
+		if len(line) < 9 {
+			continue
+		}
+		from, _ := strconv.ParseInt(line[2], 10, 64)
+		to, _ := strconv.ParseInt(line[3], 10, 64)
+		fixed, _ := strconv.ParseInt(line[4], 10, 64)
+		percent, _ := strconv.ParseInt(line[5], 10, 64)
+		min, _ := strconv.ParseInt(line[6], 10, 64)
+		max, _ := strconv.ParseInt(line[7], 10, 64)
+		free, _ := strconv.Atoi(line[8])
+		rule := &FeeRule{
+			Operation:    OperationType(line[0]),
+			Category:     types.PaymentCategory(line[1]),
+			From:         types.Money(from),
+			To:           types.Money(to),
+			Fixed:        types.Money(fixed),
+			Percent:      percent,
+			Min:          types.Money(min),
+			Max:          types.Money(max),
+			FreePerMonth: free,
+		}
+		replaced := false
+		for _, r := range s.fees {
+			if r.sameKey(rule) {
+				*r = *rule
+				replaced = true
+			}
+		}
+		if !replaced {
+			s.fees = append(s.fees, rule)
+		}
+	}
+}
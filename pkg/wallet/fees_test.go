@@ -0,0 +1,100 @@
+package wallet
+
+import (
+	"testing"
+	"time"
+
+	"github.com/SsSJKK/wallet/pkg/types"
+)
+
+func Test_FeeRule_Amount(t *testing.T) {
+	tests := []struct {
+		rule   FeeRule
+		amount types.Money
+		want   types.Money
+	}{
+		{FeeRule{Fixed: 5}, 1000, 5},
+		{FeeRule{Percent: 150}, 1000, 15},
+		{FeeRule{Percent: 150}, 33, 0},
+		{FeeRule{Percent: 150}, 34, 1},
+		{FeeRule{Fixed: 1, Percent: 100, Min: 3}, 100, 3},
+		{FeeRule{Percent: 100, Max: 50}, 10000, 50},
+	}
+	for _, test := range tests {
+		if got := test.rule.fee(test.amount); got != test.want {
+			t.Errorf("ERROR: %+v %v got %v need %v", test.rule, test.amount, got, test.want)
+		}
+	}
+}
+
+func Test_Pay_Fees(t *testing.T) {
+	now := time.Date(2020, time.November, 3, 12, 0, 0, 0, time.UTC)
+	svc := &Service{}
+	svc.SetClock(func() time.Time { return now })
+	acc, _ := svc.RegisterAccount("992000000001")
+	svc.Deposit(acc.ID, 10000)
+
+	if err := svc.SetFeeRule(FeeRule{From: 100, To: 10}); err != ErrInvalidFeeRule {
+		t.Errorf("ERROR: %v need %v", err, ErrInvalidFeeRule)
+	}
+	svc.SetFeeRule(FeeRule{Fixed: 1})
+	svc.SetFeeRule(FeeRule{Operation: OperationPay, Category: "mobile", FreePerMonth: 1, Fixed: 2})
+	svc.SetFeeRule(FeeRule{Operation: OperationPay, From: 1000, Percent: 100, Max: 20})
+
+	if fee, _ := svc.QuoteFee(acc.ID, OperationPay, 5000, "auto"); fee != 20 {
+		t.Errorf("ERROR: tier fee %v need 20", fee)
+	}
+	if fee, _ := svc.QuoteFee(acc.ID, OperationTransfer, 5000, "auto"); fee != 1 {
+		t.Errorf("ERROR: default fee %v need 1", fee)
+	}
+
+	free, _ := svc.Pay(acc.ID, 10, "mobile")
+	if linked := svc.LinkedPayments(free.ID); len(linked) != 0 {
+		t.Errorf("ERROR: first mobile payment must be free %v", linked)
+	}
+	paid, _ := svc.Pay(acc.ID, 10, "mobile")
+	linked := svc.LinkedPayments(paid.ID)
+	if len(linked) != 1 || linked[0].Amount != 2 || linked[0].Category != FeeCategory || linked[0].Status != types.PaymentStatusInProgress {
+		t.Fatalf("ERROR: fee %v", linked)
+	}
+	big, _ := svc.Pay(acc.ID, 3000, "auto")
+	if acc.Balance != 10000-10-12-3020 {
+		t.Errorf("ERROR: balance %v", acc.Balance)
+	}
+
+	if err := svc.Reject(paid.ID); err != nil {
+		t.Fatalf("ERROR: %v", err)
+	}
+	fee, _ := svc.FindPaymentByID(linked[0].ID)
+	if fee.Status != types.PaymentStatusFail || acc.Balance != 10000-10-3020 {
+		t.Errorf("ERROR: fee after reject %v balance %v", fee.Status, acc.Balance)
+	}
+
+	acc.Balance = 100
+	if _, err := svc.Pay(acc.ID, 100, "auto"); err != ErrNotEnoughBalance {
+		t.Errorf("ERROR: fee over balance %v", err)
+	}
+
+	dir := t.TempDir()
+	svc.Export(dir)
+	imported := &Service{}
+	imported.Import(dir)
+	if len(imported.FeeRules()) != 3 || len(imported.LinkedPayments(big.ID)) != 1 {
+		t.Errorf("ERROR: imported %v %v", imported.FeeRules(), imported.LinkedPayments(big.ID))
+	}
+}
+
+func Test_CloseAccount_WithdrawalFee(t *testing.T) {
+	svc := &Service{}
+	acc, _ := svc.RegisterAccount("992000000001")
+	svc.Deposit(acc.ID, 100)
+	svc.SetFeeRule(FeeRule{Operation: OperationWithdrawal, Fixed: 3})
+
+	payout, err := svc.CloseAccount(acc.ID, "moved", true)
+	if err != nil || payout.Amount != 97 || acc.Balance != 0 {
+		t.Fatalf("ERROR: %v %v balance %v", err, payout, acc.Balance)
+	}
+	if linked := svc.LinkedPayments(payout.ID); len(linked) != 1 || linked[0].Amount != 3 {
+		t.Errorf("ERROR: withdrawal fee %v", linked)
+	}
+}
@@ -0,0 +1,166 @@
+package wallet
+
+import (
+	"errors"
+	"strconv"
+
+	"github.com/google/uuid"
+
+	"github.com/SsSJKK/wallet/pkg/types"
+)
+
+//ErrAccountFrozen err
+var ErrAccountFrozen = errors.New("account is frozen")
+
+//ErrAccountClosed err
+var ErrAccountClosed = errors.New("account is closed")
+
+//ErrBalanceNotZero err
+var ErrBalanceNotZero = errors.New("account balance is not zero")
+
+//ErrInvalidStatusChange err
+var ErrInvalidStatusChange = errors.New("account status can't be changed this way")
+
+//PayoutCategory category of the payment that takes the rest of the balance
+//when an account is closed
+const PayoutCategory types.PaymentCategory = "payout"
+
+//FrozenPolicy which incoming money a frozen account accepts. Outgoing
+//payments of a frozen account are always rejected.
+type FrozenPolicy struct {
+	AllowDeposits bool
+	AllowRefunds  bool
+}
+
+//SetFrozenPolicy meth
+func (s *Service) SetFrozenPolicy(policy FrozenPolicy) {
+	s.frozenPolicy = policy
+}
+
+func accountStatus(account *types.Account) types.AccountStatus {
+	if account.Status == "" {
+		return types.AccountStatusActive
+	}
+	return account.Status
+}
+
+func checkOutgoing(account *types.Account) error {
+	switch accountStatus(account) {
+	case types.AccountStatusFrozen:
+		return ErrAccountFrozen
+	case types.AccountStatusClosed:
+		return ErrAccountClosed
+	}
+	return nil
+}
+
+func (s *Service) checkIncoming(account *types.Account, allowFrozen bool) error {
+	switch accountStatus(account) {
+	case types.AccountStatusFrozen:
+		if !allowFrozen {
+			return ErrAccountFrozen
+		}
+	case types.AccountStatusClosed:
+		return ErrAccountClosed
+	}
+	return nil
+}
+
+//FreezeAccount blocks outgoing payments of an active account
+func (s *Service) FreezeAccount(accountID int64, reason string) error {
+	return s.setStatus(accountID, types.AccountStatusActive, types.AccountStatusFrozen, reason)
+}
+
+//UnfreezeAccount makes a frozen account active again
+func (s *Service) UnfreezeAccount(accountID int64, reason string) error {
+	return s.setStatus(accountID, types.AccountStatusFrozen, types.AccountStatusActive, reason)
+}
+
+//ReopenAccount makes a closed account active again
+func (s *Service) ReopenAccount(accountID int64, reason string) error {
+	return s.setStatus(accountID, types.AccountStatusClosed, types.AccountStatusActive, reason)
+}
+
+//CloseAccount closes an active or frozen account. The balance must be zero,
+//unless payout is set: then the rest, less the OperationWithdrawal fee, is
+//paid out with a PayoutCategory payment, which is returned.
+func (s *Service) CloseAccount(accountID int64, reason string, payout bool) (*types.Payment, error) {
+	account, err := s.FindAccountByID(accountID)
+	if err != nil {
+		return nil, err
+	}
+	if accountStatus(account) == types.AccountStatusClosed {
+		return nil, ErrInvalidStatusChange
+	}
+	// долг по овердрафту выплатой не закрыть
+	if account.Balance < 0 || account.Balance != 0 && !payout {
+		return nil, ErrBalanceNotZero
+	}
+
+	var payment *types.Payment
+	if account.Balance > 0 {
+		// выплата остатка не проходит лимиты и проверку заморозки, комиссия
+		// за вывод удерживается из остатка, если не съедает его целиком
+		fee := s.feeFor(accountID, OperationWithdrawal, account.Balance, PayoutCategory)
+		if fee >= account.Balance {
+			fee = 0
+		}
+		decision, err := s.checkDebit(account, account.Balance, PayoutCategory)
+		if err != nil {
+			return nil, err
+		}
+		before := accountState(account)
+		payment = &types.Payment{
+			ID:        uuid.New().String(),
+			AccountID: accountID,
+			Amount:    account.Balance - fee,
+			Category:  PayoutCategory,
+			Status:    types.PaymentStatusOk,
+			Time:      s.now().Unix(),
+		}
+		account.Balance = fee
+		s.payments = append(s.payments, payment)
+		if decision != nil {
+			decision.PaymentID = payment.ID
+		}
+		s.record(AuditEntry{
+			Action: "payout",
+			Target: "payment:" + payment.ID,
+			Before: before,
+			After:  accountState(account) + " " + paymentState(payment),
+			Reason: reason,
+		})
+		s.publishPayment(EventPaymentCreated, payment, "")
+		if fee > 0 {
+			s.postFee(account, payment, fee)
+		}
+	}
+	err = s.setStatus(accountID, accountStatus(account), types.AccountStatusClosed, reason)
+	if err != nil {
+		return nil, err
+	}
+	return payment, nil
+}
+
+func (s *Service) setStatus(accountID int64, from types.AccountStatus, to types.AccountStatus, reason string) error {
+	account, err := s.FindAccountByID(accountID)
+	if err != nil {
+		return err
+	}
+	if accountStatus(account) != from {
+		return ErrInvalidStatusChange
+	}
+	before := "status=" + string(accountStatus(account)) + " reason=" + account.StatusReason
+	account.Status = to
+	account.StatusReason = auditField(reason)
+	s.record(AuditEntry{
+		Action: "account.status",
+		Target: "account:" + strconv.FormatInt(accountID, 10),
+		Before: before,
+		After:  "status=" + string(to) + " reason=" + account.StatusReason,
+		Reason: reason,
+	})
+	copied := *account
+	s.publish(Event{Type: EventAccountStatusChanged, AccountID: accountID, Account: &copied})
+	return nil
+}
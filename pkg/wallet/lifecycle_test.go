@@ -0,0 +1,90 @@
+package wallet
+
+import (
+	"testing"
+
+	"github.com/SsSJKK/wallet/pkg/types"
+)
+
+func Test_FreezeAccount(t *testing.T) {
+	svc := &Service{}
+	acc, _ := svc.RegisterAccount("992000000001")
+	svc.Deposit(acc.ID, 100)
+	pay, _ := svc.Pay(acc.ID, 10, "auto")
+	favorite, _ := svc.FavoritePayment(pay.ID, "car")
+
+	if err := svc.FreezeAccount(acc.ID, "stolen phone"); err != nil {
+		t.Fatalf("ERROR: %v", err)
+	}
+	if err := svc.FreezeAccount(acc.ID, "again"); err != ErrInvalidStatusChange {
+		t.Errorf("ERROR: freeze twice %v", err)
+	}
+	if _, err := svc.Pay(acc.ID, 10, "auto"); err != ErrAccountFrozen {
+		t.Errorf("ERROR: pay %v need %v", err, ErrAccountFrozen)
+	}
+	if _, err := svc.PayFromFavorite(favorite.ID); err != ErrAccountFrozen {
+		t.Errorf("ERROR: favorite %v need %v", err, ErrAccountFrozen)
+	}
+	if err := svc.Deposit(acc.ID, 10); err != ErrAccountFrozen {
+		t.Errorf("ERROR: deposit %v need %v", err, ErrAccountFrozen)
+	}
+	if err := svc.Reject(pay.ID); err != ErrAccountFrozen {
+		t.Errorf("ERROR: refund %v need %v", err, ErrAccountFrozen)
+	}
+
+	svc.SetFrozenPolicy(FrozenPolicy{AllowDeposits: true, AllowRefunds: true})
+	if err := svc.Deposit(acc.ID, 10); err != nil {
+		t.Errorf("ERROR: deposit allowed %v", err)
+	}
+	if err := svc.Reject(pay.ID); err != nil {
+		t.Errorf("ERROR: refund allowed %v", err)
+	}
+	if acc.Balance != 110 {
+		t.Errorf("ERROR: balance %v need 110", acc.Balance)
+	}
+
+	dir := t.TempDir()
+	svc.Export(dir)
+	imported := &Service{}
+	imported.Import(dir)
+	account, _ := imported.FindAccountByID(acc.ID)
+	if account.Status != types.AccountStatusFrozen || account.StatusReason != "stolen phone" {
+		t.Errorf("ERROR: imported %+v", account)
+	}
+
+	if err := svc.UnfreezeAccount(acc.ID, "phone found"); err != nil {
+		t.Errorf("ERROR: unfreeze %v", err)
+	}
+	if _, err := svc.Pay(acc.ID, 10, "auto"); err != nil {
+		t.Errorf("ERROR: pay after unfreeze %v", err)
+	}
+}
+
+func Test_CloseAccount(t *testing.T) {
+	svc := &Service{}
+	acc, _ := svc.RegisterAccount("992000000001")
+	svc.Deposit(acc.ID, 100)
+
+	if _, err := svc.CloseAccount(acc.ID, "customer left", false); err != ErrBalanceNotZero {
+		t.Errorf("ERROR: close %v need %v", err, ErrBalanceNotZero)
+	}
+	payout, err := svc.CloseAccount(acc.ID, "customer left", true)
+	if err != nil || payout.Amount != 100 || payout.Category != PayoutCategory || payout.Status != types.PaymentStatusOk {
+		t.Fatalf("ERROR: payout %v %v", err, payout)
+	}
+	if acc.Balance != 0 || acc.Status != types.AccountStatusClosed {
+		t.Errorf("ERROR: closed %+v", acc)
+	}
+	if err := svc.Deposit(acc.ID, 10); err != ErrAccountClosed {
+		t.Errorf("ERROR: deposit %v need %v", err, ErrAccountClosed)
+	}
+	if err := svc.UnfreezeAccount(acc.ID, "x"); err != ErrInvalidStatusChange {
+		t.Errorf("ERROR: unfreeze closed %v", err)
+	}
+	if err := svc.ReopenAccount(acc.ID, "came back"); err != nil {
+		t.Errorf("ERROR: reopen %v", err)
+	}
+	if err := svc.Deposit(acc.ID, 10); err != nil {
+		t.Errorf("ERROR: deposit after reopen %v", err)
+	}
+}
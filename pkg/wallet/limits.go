@@ -0,0 +1,262 @@
+package wallet
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/SsSJKK/wallet/pkg/types"
+)
+
+//ErrLimitExceeded err
+var ErrLimitExceeded = errors.New("limit exceeded")
+
+//LimitKind which of the limits was hit
+type LimitKind string
+
+//Limit kinds
+const (
+	LimitTransaction LimitKind = "TRANSACTION"
+	LimitDaily       LimitKind = "DAILY"
+	LimitWeekly      LimitKind = "WEEKLY"
+	LimitMonthly     LimitKind = "MONTHLY"
+)
+
+//Limit spending limits of an account. AccountID 0 applies to every account,
+//empty Category applies to payments of any category. Zero amount means no limit.
+//With Hold a payment over the limit is held for review instead of failing.
+type Limit struct {
+	AccountID   int64
+	Category    types.PaymentCategory
+	Transaction types.Money
+	Daily       types.Money
+	Weekly      types.Money
+	Monthly     types.Money
+	Hold        bool
+}
+
+//LimitError returned by Pay when a payment breaches a limit
+type LimitError struct {
+	AccountID int64
+	Category  types.PaymentCategory
+	Kind      LimitKind
+	Limit     types.Money
+	Remaining types.Money
+	Hold      bool
+}
+
+func (e *LimitError) Error() string {
+	scope := "all categories"
+	if e.Category != "" {
+		scope = "category " + string(e.Category)
+	}
+	return fmt.Sprintf("%v: %s limit %d for %s, remaining %d", ErrLimitExceeded, strings.ToLower(string(e.Kind)), e.Limit, scope, e.Remaining)
+}
+
+//Is makes errors.Is(err, ErrLimitExceeded) true
+func (e *LimitError) Is(target error) bool {
+	return target == ErrLimitExceeded
+}
+
+//SetLimit adds or replaces the limit for the account and category
+func (s *Service) SetLimit(limit Limit) error {
+	if limit.Transaction < 0 || limit.Daily < 0 || limit.Weekly < 0 || limit.Monthly < 0 {
+		return ErrAmountMustBePositive
+	}
+	if limit.AccountID != 0 {
+		_, err := s.FindAccountByID(limit.AccountID)
+		if err != nil {
+			return err
+		}
+	}
+	entry := AuditEntry{Action: "limit.set", Target: limitTarget(limit.AccountID, limit.Category), After: limitState(&limit)}
+	for _, l := range s.limits {
+		if l.AccountID == limit.AccountID && l.Category == limit.Category {
+			entry.Before = limitState(l)
+			*l = limit
+			s.record(entry)
+			return nil
+		}
+	}
+	s.limits = append(s.limits, &limit)
+	s.record(entry)
+	return nil
+}
+
+//RemoveLimit removes the limit for the account and category
+func (s *Service) RemoveLimit(accountID int64, category types.PaymentCategory) {
+	for i, l := range s.limits {
+		if l.AccountID == accountID && l.Category == category {
+			s.limits = append(s.limits[:i], s.limits[i+1:]...)
+			s.record(AuditEntry{Action: "limit.remove", Target: limitTarget(accountID, category), Before: limitState(l)})
+			return
+		}
+	}
+}
+
+func limitTarget(accountID int64, category types.PaymentCategory) string {
+	return "limit:" + strconv.FormatInt(accountID, 10) + "/" + string(category)
+}
+
+func limitState(limit *Limit) string {
+	state := "transaction=" + strconv.FormatInt(int64(limit.Transaction), 10) +
+		" daily=" + strconv.FormatInt(int64(limit.Daily), 10) +
+		" weekly=" + strconv.FormatInt(int64(limit.Weekly), 10) +
+		" monthly=" + strconv.FormatInt(int64(limit.Monthly), 10)
+	if limit.Hold {
+		state += " hold"
+	}
+	return state
+}
+
+//Limits returns limits that apply to payments of the account in the category
+func (s *Service) Limits(accountID int64, category types.PaymentCategory) []Limit {
+	limits := []Limit{}
+	for _, l := range s.limits {
+		if l.AccountID != 0 && l.AccountID != accountID {
+			continue
+		}
+		if l.Category != "" && l.Category != category {
+			continue
+		}
+		limits = append(limits, *l)
+	}
+	return limits
+}
+
+func (s *Service) checkLimits(accountID int64, amount types.Money, category types.PaymentCategory) error {
+	now := s.now()
+	year, month, day := now.Date()
+	dayStart := time.Date(year, month, day, 0, 0, 0, 0, now.Location())
+	weekStart := dayStart.AddDate(0, 0, -((int(dayStart.Weekday()) + 6) % 7))
+	monthStart := time.Date(year, month, 1, 0, 0, 0, 0, now.Location())
+
+	// жёсткий лимит важнее удерживающего: удержание возвращается, только если других превышений нет
+	var held *LimitError
+	for _, l := range s.Limits(accountID, category) {
+		var exceeded *LimitError
+		if l.Transaction > 0 && amount > l.Transaction {
+			exceeded = &LimitError{AccountID: accountID, Category: l.Category, Kind: LimitTransaction, Limit: l.Transaction, Remaining: l.Transaction, Hold: l.Hold}
+		}
+		checks := []struct {
+			kind  LimitKind
+			limit types.Money
+			from  time.Time
+		}{
+			{LimitDaily, l.Daily, dayStart},
+			{LimitWeekly, l.Weekly, weekStart},
+			{LimitMonthly, l.Monthly, monthStart},
+		}
+		for _, check := range checks {
+			if exceeded != nil || check.limit <= 0 {
+				continue
+			}
+			spent := s.spentSince(accountID, l.Category, check.from.Unix())
+			remaining := check.limit - spent
+			if remaining < 0 {
+				remaining = 0
+			}
+			if amount > remaining {
+				exceeded = &LimitError{AccountID: accountID, Category: l.Category, Kind: check.kind, Limit: check.limit, Remaining: remaining, Hold: l.Hold}
+			}
+		}
+		if exceeded != nil && !exceeded.Hold {
+			return exceeded
+		}
+		if exceeded != nil && held == nil {
+			held = exceeded
+		}
+	}
+	if held != nil {
+		return held
+	}
+	return nil
+}
+
+func (s *Service) spentSince(accountID int64, category types.PaymentCategory, from int64) types.Money {
+	spent := types.Money(0)
+	for _, payment := range s.payments {
+		// комиссии и проценты в лимиты не входят
+		if payment.AccountID != accountID || payment.Status == types.PaymentStatusFail || payment.Time < from || payment.ParentID != "" || payment.Category == InterestCategory {
+			continue
+		}
+		if category != "" && payment.Category != category {
+			continue
+		}
+		spent += payment.Amount
+	}
+	return spent
+}
+
+func (s *Service) exportLimits(dir string) error {
+	if s.limits == nil {
+		return nil
+	}
+	file, err := os.Create(dir + "/limits.dump")
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	text := ""
+	for _, l := range s.limits {
+		text += strconv.FormatInt(l.AccountID, 10) + ";" +
+			string(l.Category) + ";" +
+			strconv.FormatInt(int64(l.Transaction), 10) + ";" +
+			strconv.FormatInt(int64(l.Daily), 10) + ";" +
+			strconv.FormatInt(int64(l.Weekly), 10) + ";" +
+			strconv.FormatInt(int64(l.Monthly), 10) + ";" +
+			strconv.FormatBool(l.Hold) + ";\n"
+	}
+	_, err = file.Write([]byte(text))
+	return err
+}
+
+func (s *Service) importLimits(dir string) {
+	file, err := os.Open(dir + "/limits.dump")
+	if err != nil {
+		log.Print(err)
+		return
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.Split(scanner.Text(), ";")
+		if len(line) < 6 {
+			continue
+		}
+		accountID, _ := strconv.ParseInt(line[0], 10, 64)
+		transaction, _ := strconv.ParseInt(line[2], 10, 64)
+		daily, _ := strconv.ParseInt(line[3], 10, 64)
+		weekly, _ := strconv.ParseInt(line[4], 10, 64)
+		monthly, _ := strconv.ParseInt(line[5], 10, 64)
+		var hold bool
+		if len(line) > 6 {
+			hold, _ = strconv.ParseBool(line[6])
+		}
+		limit := Limit{
+			AccountID:   accountID,
+			Category:    types.PaymentCategory(line[1]),
+			Transaction: types.Money(transaction),
+			Daily:       types.Money(daily),
+			Weekly:      types.Money(weekly),
+			Monthly:     types.Money(monthly),
+			Hold:        hold,
+		}
+		// замена без RemoveLimit, загрузка дампа не пишется в журнал аудита
+		replaced := false
+		for _, l := range s.limits {
+			if l.AccountID == limit.AccountID && l.Category == limit.Category {
+				*l = limit
+				replaced = true
+			}
+		}
+		if !replaced {
+			s.limits = append(s.limits, &limit)
+		}
+	}
+}
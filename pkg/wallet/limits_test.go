@@ -0,0 +1,73 @@
+package wallet
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/SsSJKK/wallet/pkg/types"
+)
+
+func Test_Limit_Transaction(t *testing.T) {
+	svc := &Service{}
+	acc, _ := svc.RegisterAccount("992000000001")
+	svc.Deposit(acc.ID, 1000)
+	svc.SetLimit(Limit{AccountID: acc.ID, Transaction: 100})
+
+	_, err := svc.Pay(acc.ID, 101, "auto")
+	limitErr, ok := err.(*LimitError)
+	if !ok || limitErr.Kind != LimitTransaction || limitErr.Remaining != 100 {
+		t.Errorf("ERROR: %v", err)
+	}
+	if !errors.Is(err, ErrLimitExceeded) {
+		t.Errorf("ERROR: %v is not ErrLimitExceeded", err)
+	}
+	if acc.Balance != 1000 {
+		t.Errorf("ERROR: balance %v need 1000", acc.Balance)
+	}
+}
+
+func Test_Limit_DailyCategory(t *testing.T) {
+	svc := &Service{}
+	now := time.Date(2020, time.November, 3, 12, 0, 0, 0, time.UTC)
+	svc.SetClock(func() time.Time { return now })
+	acc, _ := svc.RegisterAccount("992000000001")
+	svc.Deposit(acc.ID, 1000)
+	svc.SetLimit(Limit{Category: "food", Daily: 100})
+
+	pay, _ := svc.Pay(acc.ID, 60, "food")
+	_, err := svc.Repeat(pay.ID)
+	limitErr, ok := err.(*LimitError)
+	if !ok || limitErr.Kind != LimitDaily || limitErr.Category != "food" || limitErr.Remaining != 40 {
+		t.Errorf("ERROR: %v", err)
+	}
+
+	_, err = svc.Pay(acc.ID, 500, "auto")
+	if err != nil {
+		t.Errorf("ERROR: other category %v", err)
+	}
+
+	now = now.AddDate(0, 0, 1)
+	_, err = svc.Repeat(pay.ID)
+	if err != nil {
+		t.Errorf("ERROR: next day %v", err)
+	}
+}
+
+func Test_Limit_MonthlyFavorite(t *testing.T) {
+	svc := &Service{}
+	now := time.Date(2020, time.November, 3, 12, 0, 0, 0, time.UTC)
+	svc.SetClock(func() time.Time { return now })
+	acc, _ := svc.RegisterAccount("992000000001")
+	svc.Deposit(acc.ID, 1000)
+	pay, _ := svc.Pay(acc.ID, 200, "auto")
+	fav, _ := svc.FavoritePayment(pay.ID, "car")
+	svc.SetLimit(Limit{AccountID: acc.ID, Weekly: 1000, Monthly: 300})
+
+	now = now.AddDate(0, 0, 10)
+	_, err := svc.PayFromFavorite(fav.ID)
+	limitErr, ok := err.(*LimitError)
+	if !ok || limitErr.Kind != LimitMonthly || limitErr.Remaining != types.Money(100) {
+		t.Errorf("ERROR: %v", err)
+	}
+}
@@ -0,0 +1,384 @@
+package wallet
+
+import (
+	"bufio"
+	"errors"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/SsSJKK/wallet/pkg/types"
+)
+
+//ErrInvalidRewardRule err
+var ErrInvalidRewardRule = errors.New("invalid reward rule")
+
+//ErrNotEnoughPoints err
+var ErrNotEnoughPoints = errors.New("not enough points")
+
+//RewardKind what a reward rule gives
+type RewardKind string
+
+//Reward kinds. Points are kept in the loyalty ledger until redeemed,
+//cashback is deposited to the account right away.
+const (
+	RewardPoints   RewardKind = "POINTS"
+	RewardCashback RewardKind = "CASHBACK"
+)
+
+//Reward movement reasons
+const (
+	RewardAccrual  = "accrual"
+	RewardReversal = "reversal"
+	RewardRedeem   = "redeem"
+)
+
+//RewardRule reward for payments of a category, empty Category applies to
+//categories without their own rule of the kind. Rate is in basis points of
+//the payment amount (150 is 1.5%), Cap limits the reward of an account in a
+//calendar month, zero Cap has no limit.
+type RewardRule struct {
+	Category types.PaymentCategory
+	Kind     RewardKind
+	Rate     int64
+	Cap      int64
+}
+
+//RewardMovement one line of the loyalty ledger. Amount is in points or, for
+//cashback, in minor units; reversals and redemptions are negative.
+type RewardMovement struct {
+	ID        string
+	AccountID int64
+	PaymentID string
+	Kind      RewardKind
+	Amount    int64
+	Reason    string
+	Time      int64
+}
+
+//SetRewardRule adds the rule or replaces the rule of the same category and kind
+func (s *Service) SetRewardRule(rule RewardRule) error {
+	if rule.Kind != RewardPoints && rule.Kind != RewardCashback || rule.Rate < 0 || rule.Cap < 0 {
+		return ErrInvalidRewardRule
+	}
+	// кэшбэк больше платежа увёл бы баланс в минус при отмене
+	if rule.Kind == RewardCashback && rule.Rate > 10000 {
+		return ErrInvalidRewardRule
+	}
+	entry := AuditEntry{Action: "reward.rule", Target: "reward:" + string(rule.Kind) + "/" + string(rule.Category), After: rewardRuleState(&rule)}
+	for _, r := range s.rewardRules {
+		if r.Category == rule.Category && r.Kind == rule.Kind {
+			entry.Before = rewardRuleState(r)
+			*r = rule
+			s.record(entry)
+			return nil
+		}
+	}
+	s.rewardRules = append(s.rewardRules, &rule)
+	s.record(entry)
+	return nil
+}
+
+//RewardRules returns copies of the reward rules
+func (s *Service) RewardRules() []RewardRule {
+	rules := make([]RewardRule, 0, len(s.rewardRules))
+	for _, r := range s.rewardRules {
+		rules = append(rules, *r)
+	}
+	return rules
+}
+
+//SetPointValue sets how much a point is worth on redemption, in basis points
+//of a minor unit; 10000 (the default) is one minor unit per point
+func (s *Service) SetPointValue(value int64) {
+	s.pointValue = value
+}
+
+//Points returns the points balance of the account
+func (s *Service) Points(accountID int64) (int64, error) {
+	_, err := s.FindAccountByID(accountID)
+	if err != nil {
+		return 0, err
+	}
+	return s.rewardSum(accountID, RewardPoints, nil, 0), nil
+}
+
+//RewardMovements returns the loyalty ledger of the account
+func (s *Service) RewardMovements(accountID int64) ([]RewardMovement, error) {
+	_, err := s.FindAccountByID(accountID)
+	if err != nil {
+		return nil, err
+	}
+	movements := []RewardMovement{}
+	for _, movement := range s.rewards {
+		if movement.AccountID == accountID {
+			movements = append(movements, *movement)
+		}
+	}
+	return movements, nil
+}
+
+//RedeemPoints turns points into a deposit to the account
+func (s *Service) RedeemPoints(accountID int64, points int64) (*types.Deposit, error) {
+	if points <= 0 {
+		return nil, ErrAmountMustBePositive
+	}
+	balance, err := s.Points(accountID)
+	if err != nil {
+		return nil, err
+	}
+	if balance < points {
+		return nil, ErrNotEnoughPoints
+	}
+	value := s.pointValue
+	if value == 0 {
+		value = 10000
+	}
+	amount := types.Money(points * value / 10000)
+	if amount <= 0 {
+		return nil, ErrAmountMustBePositive
+	}
+	err = s.Deposit(accountID, amount)
+	if err != nil {
+		return nil, err
+	}
+	s.addReward(accountID, "", RewardPoints, -points, RewardRedeem)
+	deposit := *s.deposits[len(s.deposits)-1]
+	return &deposit, nil
+}
+
+func (s *Service) rewardRule(category types.PaymentCategory, kind RewardKind) *RewardRule {
+	var fallback *RewardRule
+	for _, r := range s.rewardRules {
+		if r.Kind != kind {
+			continue
+		}
+		if r.Category == category {
+			return r
+		}
+		if r.Category == "" {
+			fallback = r
+		}
+	}
+	return fallback
+}
+
+func (s *Service) accrueRewards(account *types.Account, payment *types.Payment) {
+	if payment.Category == FeeCategory || payment.Category == PayoutCategory || payment.Category == InterestCategory {
+		return
+	}
+	now := s.now()
+	year, month, _ := now.Date()
+	monthStart := time.Date(year, month, 1, 0, 0, 0, 0, now.Location()).Unix()
+	for _, kind := range []RewardKind{RewardPoints, RewardCashback} {
+		rule := s.rewardRule(payment.Category, kind)
+		if rule == nil {
+			continue
+		}
+		reward := int64(payment.Amount) * rule.Rate / 10000
+		if rule.Cap > 0 {
+			left := rule.Cap - s.rewardSum(account.ID, kind, rule, monthStart)
+			if reward > left {
+				reward = left
+			}
+		}
+		if reward <= 0 {
+			continue
+		}
+		if kind == RewardCashback {
+			err := s.Deposit(account.ID, types.Money(reward))
+			if err != nil {
+				log.Print(err)
+				continue
+			}
+		}
+		s.addReward(account.ID, payment.ID, kind, reward, RewardAccrual)
+	}
+}
+
+func (s *Service) reverseRewards(account *types.Account, paymentID string) {
+	for _, kind := range []RewardKind{RewardPoints, RewardCashback} {
+		net := int64(0)
+		for _, movement := range s.rewards {
+			if movement.PaymentID == paymentID && movement.Kind == kind {
+				net += movement.Amount
+			}
+		}
+		if kind == RewardPoints {
+			// погашенные баллы уже не забрать, баланс баллов не уходит в минус
+			left := s.rewardSum(account.ID, RewardPoints, nil, 0)
+			if net > left {
+				net = left
+			}
+		}
+		if net <= 0 {
+			continue
+		}
+		if kind == RewardCashback {
+			before := accountState(account)
+			account.Balance -= types.Money(net)
+			s.record(AuditEntry{
+				Action: "cashback.reverse",
+				Target: "payment:" + paymentID,
+				Before: before,
+				After:  accountState(account),
+			})
+		}
+		s.addReward(account.ID, paymentID, kind, -net, RewardReversal)
+	}
+}
+
+// rewardSum сумма движений вида kind; rule ограничивает платежами, к которым
+// применяется это правило, from — временем
+func (s *Service) rewardSum(accountID int64, kind RewardKind, rule *RewardRule, from int64) int64 {
+	categories := map[string]types.PaymentCategory{}
+	if rule != nil {
+		for _, payment := range s.payments {
+			if payment.AccountID == accountID {
+				categories[payment.ID] = payment.Category
+			}
+		}
+	}
+	sum := int64(0)
+	for _, movement := range s.rewards {
+		if movement.AccountID != accountID || movement.Kind != kind || movement.Time < from {
+			continue
+		}
+		if rule != nil {
+			category, ok := categories[movement.PaymentID]
+			if !ok || s.rewardRule(category, kind) != rule {
+				continue
+			}
+		}
+		sum += movement.Amount
+	}
+	return sum
+}
+
+func (s *Service) addReward(accountID int64, paymentID string, kind RewardKind, amount int64, reason string) {
+	movement := &RewardMovement{
+		ID:        uuid.New().String(),
+		AccountID: accountID,
+		PaymentID: paymentID,
+		Kind:      kind,
+		Amount:    amount,
+		Reason:    reason,
+		Time:      s.now().Unix(),
+	}
+	s.rewards = append(s.rewards, movement)
+	s.record(AuditEntry{
+		Action: "reward." + reason,
+		Target: "account:" + strconv.FormatInt(accountID, 10),
+		After:  "kind=" + string(kind) + " amount=" + strconv.FormatInt(amount, 10) + " payment=" + paymentID,
+	})
+}
+
+func rewardRuleState(rule *RewardRule) string {
+	return "rate=" + strconv.FormatInt(rule.Rate, 10) + " cap=" + strconv.FormatInt(rule.Cap, 10)
+}
+
+func (s *Service) exportRewards(dir string) error {
+	if s.rewardRules != nil {
+		file, err := os.Create(dir + "/reward_rules.dump")
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		text := ""
+		for _, r := range s.rewardRules {
+			text += string(r.Category) + ";" +
+				string(r.Kind) + ";" +
+				strconv.FormatInt(r.Rate, 10) + ";" +
+				strconv.FormatInt(r.Cap, 10) + ";\n"
+		}
+		_, err = file.Write([]byte(text))
+		if err != nil {
+			return err
+		}
+	}
+	if s.rewards != nil {
+		file, err := os.Create(dir + "/rewards.dump")
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		text := ""
+		for _, movement := range s.rewards {
+			text += movement.ID + ";" +
+				strconv.FormatInt(movement.AccountID, 10) + ";" +
+				movement.PaymentID + ";" +
+				string(movement.Kind) + ";" +
+				strconv.FormatInt(movement.Amount, 10) + ";" +
+				movement.Reason + ";" +
+				strconv.FormatInt(movement.Time, 10) + ";\n"
+		}
+		_, err = file.Write([]byte(text))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *Service) importRewards(dir string) {
+	file, err := os.Open(dir + "/reward_rules.dump")
+	if err != nil {
+		log.Print(err)
+	} else {
+		defer file.Close()
+		scanner := bufio.NewScanner(file)
+		for scanner.Scan() {
+			line := strings.Split(scanner.Text(), ";")
+			if len(line) < 4 {
+				continue
+			}
+			rate, _ := strconv.ParseInt(line[2], 10, 64)
+			limit, _ := strconv.ParseInt(line[3], 10, 64)
+			rule := &RewardRule{Category: types.PaymentCategory(line[0]), Kind: RewardKind(line[1]), Rate: rate, Cap: limit}
+			replaced := false
+			for _, r := range s.rewardRules {
+				if r.Category == rule.Category && r.Kind == rule.Kind {
+					*r = *rule
+					replaced = true
+				}
+			}
+			if !replaced {
+				s.rewardRules = append(s.rewardRules, rule)
+			}
+		}
+	}
+
+	fileRewards, err := os.Open(dir + "/rewards.dump")
+	if err != nil {
+		log.Print(err)
+		return
+	}
+	defer fileRewards.Close()
+	known := map[string]bool{}
+	for _, movement := range s.rewards {
+		known[movement.ID] = true
+	}
+	scanner := bufio.NewScanner(fileRewards)
+	for scanner.Scan() {
+		line := strings.Split(scanner.Text(), ";")
+		if len(line) < 7 || known[line[0]] {
+			continue
+		}
+		accountID, _ := strconv.ParseInt(line[1], 10, 64)
+		amount, _ := strconv.ParseInt(line[4], 10, 64)
+		created, _ := strconv.ParseInt(line[6], 10, 64)
+		s.rewards = append(s.rewards, &RewardMovement{
+			ID:        line[0],
+			AccountID: accountID,
+			PaymentID: line[2],
+			Kind:      RewardKind(line[3]),
+			Amount:    amount,
+			Reason:    line[5],
+			Time:      created,
+		})
+	}
+}
@@ -0,0 +1,119 @@
+package wallet
+
+import (
+	"os"
+	"testing"
+	"time"
+)
+
+func Test_Pay_Rewards(t *testing.T) {
+	now := time.Date(2020, time.November, 3, 12, 0, 0, 0, time.UTC)
+	svc := &Service{}
+	svc.SetClock(func() time.Time { return now })
+	acc, _ := svc.RegisterAccount("992000000001")
+	svc.Deposit(acc.ID, 100000)
+
+	if err := svc.SetRewardRule(RewardRule{Kind: RewardCashback, Rate: 20000}); err != ErrInvalidRewardRule {
+		t.Errorf("ERROR: %v need %v", err, ErrInvalidRewardRule)
+	}
+	svc.SetRewardRule(RewardRule{Kind: RewardPoints, Rate: 100})
+	svc.SetRewardRule(RewardRule{Category: "food", Kind: RewardCashback, Rate: 500, Cap: 60})
+
+	svc.Pay(acc.ID, 1000, "auto")
+	if points, _ := svc.Points(acc.ID); points != 10 {
+		t.Errorf("ERROR: points %v need 10", points)
+	}
+	food, _ := svc.Pay(acc.ID, 1000, "food")
+	if acc.Balance != 100000-2000+50 {
+		t.Errorf("ERROR: balance %v need cashback 50", acc.Balance)
+	}
+	svc.Pay(acc.ID, 1000, "food")
+	if acc.Balance != 100000-3000+60 {
+		t.Errorf("ERROR: balance %v need cashback cut to the cap", acc.Balance)
+	}
+
+	err := svc.Reject(food.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if acc.Balance != 100000-2000+10 {
+		t.Errorf("ERROR: balance %v after reject", acc.Balance)
+	}
+	if points, _ := svc.Points(acc.ID); points != 20 {
+		t.Errorf("ERROR: points %v need 20", points)
+	}
+	svc.Reject(food.ID)
+	if points, _ := svc.Points(acc.ID); points != 20 {
+		t.Errorf("ERROR: second reject changed points %v", points)
+	}
+
+	if _, err := svc.RedeemPoints(acc.ID, 21); err != ErrNotEnoughPoints {
+		t.Errorf("ERROR: %v need %v", err, ErrNotEnoughPoints)
+	}
+	svc.SetPointValue(5000)
+	deposit, err := svc.RedeemPoints(acc.ID, 20)
+	if err != nil || deposit.Amount != 10 {
+		t.Fatalf("ERROR: redeem %v %v", deposit, err)
+	}
+	if points, _ := svc.Points(acc.ID); points != 0 {
+		t.Errorf("ERROR: points %v after redeem", points)
+	}
+}
+
+func Test_Rewards_FallbackCap(t *testing.T) {
+	svc := &Service{}
+	acc, _ := svc.RegisterAccount("992000000001")
+	svc.Deposit(acc.ID, 100000)
+	svc.SetRewardRule(RewardRule{Kind: RewardPoints, Rate: 1000, Cap: 150})
+	svc.SetRewardRule(RewardRule{Category: "food", Kind: RewardPoints, Rate: 1000})
+
+	svc.Pay(acc.ID, 1000, "food")
+	svc.Pay(acc.ID, 1000, "auto")
+	svc.Pay(acc.ID, 1000, "auto")
+	if points, _ := svc.Points(acc.ID); points != 100+150 {
+		t.Errorf("ERROR: points %v, food points must not count in the cap of other categories", points)
+	}
+}
+
+func Test_Rewards_ReverseRedeemed(t *testing.T) {
+	svc := &Service{}
+	acc, _ := svc.RegisterAccount("992000000001")
+	svc.Deposit(acc.ID, 100000)
+	svc.SetRewardRule(RewardRule{Kind: RewardPoints, Rate: 1000})
+
+	payment, _ := svc.Pay(acc.ID, 1000, "auto")
+	svc.RedeemPoints(acc.ID, 60)
+	svc.Reject(payment.ID)
+	if points, _ := svc.Points(acc.ID); points != 0 {
+		t.Errorf("ERROR: points %v need 0", points)
+	}
+}
+
+func Test_ExportImport_Rewards(t *testing.T) {
+	svc := &Service{}
+	acc, _ := svc.RegisterAccount("992000000001")
+	svc.Deposit(acc.ID, 10000)
+	svc.SetRewardRule(RewardRule{Kind: RewardPoints, Rate: 1000, Cap: 500})
+	svc.Pay(acc.ID, 1000, "auto")
+
+	dir := t.TempDir()
+	err := svc.Export(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(dir + "/rewards.dump"); err != nil {
+		t.Fatal(err)
+	}
+	restored := &Service{}
+	err = restored.Import(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rules := restored.RewardRules(); len(rules) != 1 || rules[0].Cap != 500 {
+		t.Errorf("ERROR: rules %v", rules)
+	}
+	movements, _ := restored.RewardMovements(acc.ID)
+	if len(movements) != 1 || movements[0].Amount != 100 || movements[0].Reason != RewardAccrual {
+		t.Errorf("ERROR: movements %v", movements)
+	}
+}
@@ -0,0 +1,208 @@
+package wallet
+
+import (
+	"bufio"
+	"errors"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/SsSJKK/wallet/pkg/types"
+)
+
+//ErrMerchantNotFound err
+var ErrMerchantNotFound = errors.New("merchant not found")
+
+//ErrMerchantExists err
+var ErrMerchantExists = errors.New("merchant with the name already exists")
+
+//ErrInvalidMerchant err
+var ErrInvalidMerchant = errors.New("invalid merchant")
+
+//MerchantDay payments received by a merchant in a day
+type MerchantDay struct {
+	Day    string
+	Count  int
+	Amount types.Money
+}
+
+//RegisterMerchant adds a merchant. MCC is the four digit merchant category
+//code and may be empty, the settlement account must exist. The category is
+//written to the dumps as is, so it must not contain ";" or line breaks.
+func (s *Service) RegisterMerchant(name string, category types.PaymentCategory, mcc string, settlementAccountID int64) (*types.Merchant, error) {
+	name = auditField(strings.TrimSpace(name))
+	// mcc из одних цифр, разделителей в нём быть не может
+	if name == "" || category == "" || strings.ContainsAny(string(category), ";\n\r") || !validMCC(mcc) {
+		return nil, ErrInvalidMerchant
+	}
+	_, err := s.FindAccountByID(settlementAccountID)
+	if err != nil {
+		return nil, err
+	}
+	for _, merchant := range s.merchants {
+		if strings.EqualFold(merchant.Name, name) {
+			return nil, ErrMerchantExists
+		}
+	}
+	merchant := &types.Merchant{
+		ID:                uuid.New().String(),
+		Name:              name,
+		Category:          category,
+		MCC:               mcc,
+		SettlementAccount: settlementAccountID,
+	}
+	s.merchants = append(s.merchants, merchant)
+	s.record(AuditEntry{
+		Action: "merchant.register",
+		Target: "merchant:" + merchant.ID,
+		After:  merchantState(merchant),
+	})
+	return merchant, nil
+}
+
+//FindMerchantByID meth
+func (s *Service) FindMerchantByID(merchantID string) (*types.Merchant, error) {
+	for _, merchant := range s.merchants {
+		if merchant.ID == merchantID {
+			return merchant, nil
+		}
+	}
+	return nil, ErrMerchantNotFound
+}
+
+//Merchants returns copies of all merchants
+func (s *Service) Merchants() []types.Merchant {
+	merchants := make([]types.Merchant, 0, len(s.merchants))
+	for _, merchant := range s.merchants {
+		merchants = append(merchants, *merchant)
+	}
+	return merchants
+}
+
+//PayMerchant pays the merchant from the account, the payment gets the
+//category of the merchant
+func (s *Service) PayMerchant(accountID int64, merchantID string, amount types.Money) (*types.Payment, error) {
+	merchant, err := s.FindMerchantByID(merchantID)
+	if err != nil {
+		return nil, err
+	}
+	return s.pay(accountID, amount, merchant.Category, merchant.ID)
+}
+
+//MerchantPayments returns the payments the merchant received
+func (s *Service) MerchantPayments(merchantID string) ([]types.Payment, error) {
+	_, err := s.FindMerchantByID(merchantID)
+	if err != nil {
+		return nil, err
+	}
+	payments := []types.Payment{}
+	for _, payment := range s.payments {
+		if payment.MerchantID == merchantID {
+			payments = append(payments, *payment)
+		}
+	}
+	return payments, nil
+}
+
+//MerchantDailyTotals sums the not failed payments of the merchant by day
+//of the service clock, days go in order
+func (s *Service) MerchantDailyTotals(merchantID string) ([]MerchantDay, error) {
+	payments, err := s.MerchantPayments(merchantID)
+	if err != nil {
+		return nil, err
+	}
+	location := s.now().Location()
+	totals := []MerchantDay{}
+	index := map[string]int{}
+	for _, payment := range payments {
+		if payment.Status == types.PaymentStatusFail {
+			continue
+		}
+		day := time.Unix(payment.Time, 0).In(location).Format("2006-01-02")
+		i, ok := index[day]
+		if !ok {
+			i = len(totals)
+			index[day] = i
+			totals = append(totals, MerchantDay{Day: day})
+		}
+		totals[i].Count++
+		totals[i].Amount += payment.Amount
+	}
+	// платежи добавляются по времени, но импорт может их перемешать
+	sort.Slice(totals, func(i, j int) bool { return totals[i].Day < totals[j].Day })
+	return totals, nil
+}
+
+func validMCC(mcc string) bool {
+	if mcc == "" {
+		return true
+	}
+	if len(mcc) != 4 {
+		return false
+	}
+	for _, r := range mcc {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+func merchantState(merchant *types.Merchant) string {
+	return "name=" + merchant.Name +
+		" category=" + string(merchant.Category) +
+		" mcc=" + merchant.MCC +
+		" settlement=" + strconv.FormatInt(merchant.SettlementAccount, 10)
+}
+
+func (s *Service) exportMerchants(dir string) error {
+	if s.merchants == nil {
+		return nil
+	}
+	file, err := os.Create(dir + "/merchants.dump")
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	text := ""
+	for _, merchant := range s.merchants {
+		text += merchant.ID + ";" +
+			merchant.Name + ";" +
+			string(merchant.Category) + ";" +
+			merchant.MCC + ";" +
+			strconv.FormatInt(merchant.SettlementAccount, 10) + ";\n"
+	}
+	_, err = file.Write([]byte(text))
+	return err
+}
+
+func (s *Service) importMerchants(dir string) {
+	file, err := os.Open(dir + "/merchants.dump")
+	if err != nil {
+		log.Print(err)
+		return
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.Split(scanner.Text(), ";")
+		if len(line) < 5 {
+			continue
+		}
+		settlement, _ := strconv.ParseInt(line[4], 10, 64)
+		merchant, err := s.FindMerchantByID(line[0])
+		if err != nil {
+			merchant = &types.Merchant{ID: line[0]}
+			s.merchants = append(s.merchants, merchant)
+		}
+		merchant.Name = line[1]
+		merchant.Category = types.PaymentCategory(line[2])
+		merchant.MCC = line[3]
+		merchant.SettlementAccount = settlement
+	}
+}
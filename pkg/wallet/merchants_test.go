@@ -0,0 +1,94 @@
+package wallet
+
+import (
+	"io/ioutil"
+	"testing"
+	"time"
+
+	"github.com/SsSJKK/wallet/pkg/types"
+)
+
+func Test_PayMerchant(t *testing.T) {
+	now := time.Date(2020, time.November, 3, 12, 0, 0, 0, time.UTC)
+	svc := &Service{}
+	svc.SetClock(func() time.Time { return now })
+	acc, _ := svc.RegisterAccount("992000000001")
+	shop, _ := svc.RegisterAccount("992000000002")
+	svc.Deposit(acc.ID, 10000)
+
+	if _, err := svc.RegisterMerchant("Shop", "food", "54a1", shop.ID); err != ErrInvalidMerchant {
+		t.Errorf("ERROR: %v need %v", err, ErrInvalidMerchant)
+	}
+	for _, category := range []types.PaymentCategory{"fo;od", "food\n", "fo\rod"} {
+		if _, err := svc.RegisterMerchant("Shop", category, "5411", shop.ID); err != ErrInvalidMerchant {
+			t.Errorf("ERROR: category %q %v need %v", category, err, ErrInvalidMerchant)
+		}
+	}
+	if _, err := svc.RegisterMerchant("Shop", "food", "5;41", shop.ID); err != ErrInvalidMerchant {
+		t.Errorf("ERROR: %v need %v", err, ErrInvalidMerchant)
+	}
+	if _, err := svc.RegisterMerchant("Shop", "food", "5411", 99); err != ErrAccountNotFound {
+		t.Errorf("ERROR: %v need %v", err, ErrAccountNotFound)
+	}
+	merchant, err := svc.RegisterMerchant("Shop", "food", "5411", shop.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := svc.RegisterMerchant("shop", "auto", "", shop.ID); err != ErrMerchantExists {
+		t.Errorf("ERROR: %v need %v", err, ErrMerchantExists)
+	}
+	if _, err := svc.PayMerchant(acc.ID, "unknown", 10); err != ErrMerchantNotFound {
+		t.Errorf("ERROR: %v need %v", err, ErrMerchantNotFound)
+	}
+
+	first, _ := svc.PayMerchant(acc.ID, merchant.ID, 100)
+	if first.MerchantID != merchant.ID || first.Category != "food" {
+		t.Errorf("ERROR: payment %+v", first)
+	}
+	failed, _ := svc.PayMerchant(acc.ID, merchant.ID, 200)
+	svc.Reject(failed.ID)
+	now = now.Add(24 * time.Hour)
+	svc.Repeat(first.ID)
+	svc.Pay(acc.ID, 50, "food")
+
+	payments, _ := svc.MerchantPayments(merchant.ID)
+	if len(payments) != 3 {
+		t.Errorf("ERROR: merchant payments %v", payments)
+	}
+	totals, _ := svc.MerchantDailyTotals(merchant.ID)
+	want := []MerchantDay{{"2020-11-03", 1, 100}, {"2020-11-04", 1, 100}}
+	if len(totals) != len(want) || totals[0] != want[0] || totals[1] != want[1] {
+		t.Errorf("ERROR: totals %v need %v", totals, want)
+	}
+}
+
+func Test_Import_PaymentsWithoutMerchant(t *testing.T) {
+	dir := t.TempDir()
+	ioutil.WriteFile(dir+"/accounts.dump", []byte("1;+992000000001;100;\n"), 0644)
+	ioutil.WriteFile(dir+"/payments.dump", []byte("p1;1;10;auto;OK;1604404800;\np2;1;5;fee;OK;1604404800;p1;\n"), 0644)
+	svc := &Service{}
+	err := svc.Import(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	payment, err := svc.FindPaymentByID("p2")
+	if err != nil || payment.ParentID != "p1" || payment.MerchantID != "" {
+		t.Fatalf("ERROR: %+v %v", payment, err)
+	}
+
+	merchant, _ := svc.RegisterMerchant("Shop", "food", "", 1)
+	svc.PayMerchant(1, merchant.ID, 10)
+	err = svc.Export(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	restored := &Service{}
+	restored.Import(dir)
+	payments, err := restored.MerchantPayments(merchant.ID)
+	if err != nil || len(payments) != 1 {
+		t.Errorf("ERROR: %v %v", payments, err)
+	}
+	if len(restored.Merchants()) != 1 || restored.Merchants()[0] != (types.Merchant{ID: merchant.ID, Name: "Shop", Category: "food", SettlementAccount: 1}) {
+		t.Errorf("ERROR: merchants %v", restored.Merchants())
+	}
+}
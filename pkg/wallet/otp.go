@@ -0,0 +1,301 @@
+package wallet
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"math/big"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/SsSJKK/wallet/pkg/types"
+)
+
+//ErrNoCodeSender err
+var ErrNoCodeSender = errors.New("code sender is not set")
+
+//ErrChallengeNotFound err
+var ErrChallengeNotFound = errors.New("verification not found")
+
+//ErrInvalidCode err
+var ErrInvalidCode = errors.New("invalid verification code")
+
+//ErrCodeExpired err
+var ErrCodeExpired = errors.New("verification code expired")
+
+//ErrTooManyAttempts err
+var ErrTooManyAttempts = errors.New("too many wrong verification codes")
+
+//Verification code settings
+const (
+	CodeLength      = 6
+	CodeTTL         = 5 * time.Minute
+	MaxCodeAttempts = 3
+)
+
+//CodeSender delivers one-time codes to a phone, e.g. by SMS
+type CodeSender interface {
+	SendCode(phone types.Phone, code string) error
+}
+
+//SentCode code delivered by MemorySender
+type SentCode struct {
+	Phone types.Phone
+	Code  string
+}
+
+//MemorySender keeps the codes instead of sending them, for tests
+type MemorySender struct {
+	mu   sync.Mutex
+	sent []SentCode
+}
+
+//SendCode meth
+func (m *MemorySender) SendCode(phone types.Phone, code string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.sent = append(m.sent, SentCode{Phone: phone, Code: code})
+	return nil
+}
+
+//Last returns the last code sent to the phone
+func (m *MemorySender) Last(phone types.Phone) (string, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for i := len(m.sent) - 1; i >= 0; i-- {
+		if m.sent[i].Phone == phone {
+			return m.sent[i].Code, true
+		}
+	}
+	return "", false
+}
+
+//Sent returns all delivered codes
+func (m *MemorySender) Sent() []SentCode {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	result := make([]SentCode, len(m.sent))
+	copy(result, m.sent)
+	return result
+}
+
+type challengePurpose string
+
+const (
+	purposePhoneChange challengePurpose = "phone_change"
+	purposeRecovery    challengePurpose = "recovery"
+)
+
+type challenge struct {
+	ID        string
+	Purpose   challengePurpose
+	AccountID int64
+	Phone     types.Phone
+	Hash      string
+	OldHash   string
+	Expires   int64
+	Attempts  int
+}
+
+//SetCodeSender meth
+func (s *Service) SetCodeSender(sender CodeSender) {
+	s.codeSender = sender
+}
+
+//StartPhoneChange sends a code to the new phone, the change is made by
+//ConfirmPhoneChange with the returned verification id. The owner confirms
+//the change with the PIN of the account or, when pin is empty, with a
+//second code sent to the old phone.
+func (s *Service) StartPhoneChange(accountID int64, newPhone types.Phone, pin string) (string, error) {
+	account, err := s.FindAccountByID(accountID)
+	if err != nil {
+		return "", err
+	}
+	if accountStatus(account) == types.AccountStatusClosed {
+		return "", ErrAccountClosed
+	}
+	newPhone, err = s.checkNewPhone(newPhone)
+	if err != nil {
+		return "", err
+	}
+	oldPhone := account.Phone
+	if pin != "" {
+		cred, ok := s.credentials[accountID]
+		if !ok {
+			return "", ErrInvalidCredentials
+		}
+		err = s.verify(cred, pin)
+		if err != nil {
+			return "", err
+		}
+		oldPhone = ""
+	}
+	return s.challenge(purposePhoneChange, accountID, newPhone, oldPhone)
+}
+
+//ConfirmPhoneChange checks the code of the new phone and, if the change was
+//started without the PIN, oldCode of the old phone. The new phone ends all
+//sessions of the account.
+func (s *Service) ConfirmPhoneChange(challengeID string, code string, oldCode string) error {
+	ch, err := s.verifyCode(challengeID, purposePhoneChange, code, oldCode)
+	if err != nil {
+		return err
+	}
+	account, err := s.FindAccountByID(ch.AccountID)
+	if err != nil {
+		return err
+	}
+	// номер могли занять, пока код шёл
+	phone, err := s.checkNewPhone(ch.Phone)
+	if err != nil {
+		return err
+	}
+	before := account.Phone
+	account.Phone = phone
+	s.record(AuditEntry{
+		Action: "account.phone",
+		Target: "account:" + strconv.FormatInt(account.ID, 10),
+		Before: "phone=" + string(before),
+		After:  "phone=" + string(phone),
+		Reason: "phone change confirmed by code",
+	})
+	// сессии, открытые со старым номером, больше не действуют
+	s.endSessions(account.ID)
+	return nil
+}
+
+//StartRecovery sends a code to the registered phone of the account, the PIN
+//is replaced by CompleteRecovery with the returned verification id. The
+//answer is the same for unknown and closed accounts, so it can't be used to
+//find out whether a phone is registered.
+func (s *Service) StartRecovery(phone types.Phone) (string, error) {
+	account, err := s.FindAccountByPhone(phone)
+	if err != nil || accountStatus(account) == types.AccountStatusClosed {
+		// код никуда не уходит, и ни один код к такой проверке не подойдёт
+		return s.challenge(purposeRecovery, 0, "", "")
+	}
+	return s.challenge(purposeRecovery, account.ID, account.Phone, "")
+}
+
+//CompleteRecovery checks the code and sets the new PIN, which also unlocks
+//the account and ends its sessions
+func (s *Service) CompleteRecovery(challengeID string, code string, newPIN string) error {
+	err := CheckPIN(newPIN)
+	if err != nil {
+		return err
+	}
+	ch, err := s.verifyCode(challengeID, purposeRecovery, code, "")
+	if err != nil {
+		return err
+	}
+	return s.SetPIN(ch.AccountID, newPIN)
+}
+
+func (s *Service) checkNewPhone(raw types.Phone) (types.Phone, error) {
+	phone, err := s.NormalizePhone(raw)
+	if err != nil {
+		return "", err
+	}
+	for _, account := range s.accounts {
+		if s.phoneKey(account.Phone) == phone {
+			return "", ErrPhoneRegistered
+		}
+	}
+	return phone, nil
+}
+
+// challenge отправляет код на phone и, если oldPhone задан, второй код на
+// oldPhone. Без phone код не отправляется.
+func (s *Service) challenge(purpose challengePurpose, accountID int64, phone types.Phone, oldPhone types.Phone) (string, error) {
+	if s.codeSender == nil {
+		return "", ErrNoCodeSender
+	}
+	code, err := newCode()
+	if err != nil {
+		return "", err
+	}
+	// новый код отменяет прежний с той же целью
+	for id, ch := range s.challenges {
+		if ch.AccountID == accountID && ch.Purpose == purpose {
+			delete(s.challenges, id)
+		}
+	}
+	ch := &challenge{
+		ID:        uuid.New().String(),
+		Purpose:   purpose,
+		AccountID: accountID,
+		Phone:     phone,
+		Expires:   s.now().Add(CodeTTL).Unix(),
+	}
+	ch.Hash = codeHash(ch.ID, code)
+	if phone != "" {
+		err = s.codeSender.SendCode(phone, code)
+		if err != nil {
+			return "", err
+		}
+	}
+	if oldPhone != "" {
+		oldCode, err := newCode()
+		if err != nil {
+			return "", err
+		}
+		ch.OldHash = codeHash(ch.ID+";old", oldCode)
+		err = s.codeSender.SendCode(oldPhone, oldCode)
+		if err != nil {
+			return "", err
+		}
+	}
+	if s.challenges == nil {
+		s.challenges = map[string]*challenge{}
+	}
+	s.challenges[ch.ID] = ch
+	return ch.ID, nil
+}
+
+// verifyCode проверяет код и, если он был отправлен, код старого номера
+func (s *Service) verifyCode(challengeID string, purpose challengePurpose, code string, oldCode string) (*challenge, error) {
+	ch, ok := s.challenges[challengeID]
+	if !ok || ch.Purpose != purpose {
+		return nil, ErrChallengeNotFound
+	}
+	if ch.Expires <= s.now().Unix() {
+		delete(s.challenges, challengeID)
+		return nil, ErrCodeExpired
+	}
+	valid := subtle.ConstantTimeCompare([]byte(codeHash(ch.ID, code)), []byte(ch.Hash)) == 1
+	if ch.OldHash != "" && subtle.ConstantTimeCompare([]byte(codeHash(ch.ID+";old", oldCode)), []byte(ch.OldHash)) != 1 {
+		valid = false
+	}
+	if !valid {
+		ch.Attempts++
+		if ch.Attempts >= MaxCodeAttempts {
+			delete(s.challenges, challengeID)
+			return nil, ErrTooManyAttempts
+		}
+		return nil, ErrInvalidCode
+	}
+	delete(s.challenges, challengeID)
+	return ch, nil
+}
+
+func newCode() (string, error) {
+	code := make([]byte, CodeLength)
+	for i := range code {
+		n, err := rand.Int(rand.Reader, big.NewInt(10))
+		if err != nil {
+			return "", err
+		}
+		code[i] = byte('0' + n.Int64())
+	}
+	return string(code), nil
+}
+
+func codeHash(challengeID string, code string) string {
+	sum := sha256.Sum256([]byte(challengeID + ";" + code))
+	return hex.EncodeToString(sum[:])
+}
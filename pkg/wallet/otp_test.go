@@ -0,0 +1,134 @@
+package wallet
+
+import (
+	"testing"
+	"time"
+)
+
+func Test_PhoneChange(t *testing.T) {
+	now := time.Date(2020, time.November, 3, 12, 0, 0, 0, time.UTC)
+	svc := &Service{}
+	svc.SetClock(func() time.Time { return now })
+	acc, _ := svc.RegisterAccount("992000000001")
+	svc.RegisterAccount("992000000002")
+
+	if _, err := svc.StartPhoneChange(acc.ID, "992000000003", ""); err != ErrNoCodeSender {
+		t.Errorf("ERROR: %v need %v", err, ErrNoCodeSender)
+	}
+	sender := &MemorySender{}
+	svc.SetCodeSender(sender)
+	if _, err := svc.StartPhoneChange(acc.ID, "+992 000 000 002", ""); err != ErrPhoneRegistered {
+		t.Errorf("ERROR: taken phone %v need %v", err, ErrPhoneRegistered)
+	}
+
+	id, err := svc.StartPhoneChange(acc.ID, "992 000 000 003", "")
+	if err != nil {
+		t.Fatalf("ERROR: %v", err)
+	}
+	code, ok := sender.Last("+992000000003")
+	if !ok || len(code) != CodeLength {
+		t.Fatalf("ERROR: code %q %v", code, ok)
+	}
+	oldCode, ok := sender.Last("+992000000001")
+	if !ok || len(oldCode) != CodeLength {
+		t.Fatalf("ERROR: old phone code %q %v", oldCode, ok)
+	}
+	if err := svc.ConfirmPhoneChange(id, "x", oldCode); err != ErrInvalidCode {
+		t.Errorf("ERROR: %v need %v", err, ErrInvalidCode)
+	}
+	if err := svc.ConfirmPhoneChange(id, code, ""); err != ErrInvalidCode {
+		t.Errorf("ERROR: without old phone %v need %v", err, ErrInvalidCode)
+	}
+	if err := svc.ConfirmPhoneChange(id, code, oldCode); err != nil {
+		t.Fatalf("ERROR: confirm %v", err)
+	}
+	if acc.Phone != "+992000000003" {
+		t.Errorf("ERROR: phone %v", acc.Phone)
+	}
+	if err := svc.ConfirmPhoneChange(id, code, oldCode); err != ErrChallengeNotFound {
+		t.Errorf("ERROR: code used twice %v", err)
+	}
+	if _, err := svc.RegisterAccount("992000000001"); err != nil {
+		t.Errorf("ERROR: old phone must be free %v", err)
+	}
+
+	id, _ = svc.StartPhoneChange(acc.ID, "992000000004", "")
+	now = now.Add(CodeTTL)
+	code, _ = sender.Last("+992000000004")
+	oldCode, _ = sender.Last("+992000000003")
+	if err := svc.ConfirmPhoneChange(id, code, oldCode); err != ErrCodeExpired {
+		t.Errorf("ERROR: %v need %v", err, ErrCodeExpired)
+	}
+}
+
+func Test_PhoneChange_PIN(t *testing.T) {
+	svc := &Service{}
+	sender := &MemorySender{}
+	svc.SetCodeSender(sender)
+	acc, _ := svc.RegisterAccount("992000000001")
+	svc.SetPIN(acc.ID, "1234")
+	session, _ := svc.Login("992000000001", "1234")
+
+	if _, err := svc.StartPhoneChange(acc.ID, "992000000003", "0000"); err != ErrInvalidCredentials {
+		t.Errorf("ERROR: %v need %v", err, ErrInvalidCredentials)
+	}
+	id, err := svc.StartPhoneChange(acc.ID, "992000000003", "1234")
+	if err != nil {
+		t.Fatalf("ERROR: %v", err)
+	}
+	if _, ok := sender.Last("+992000000001"); ok {
+		t.Error("ERROR: the PIN confirms the change, the old phone gets no code")
+	}
+	code, _ := sender.Last("+992000000003")
+	if err := svc.ConfirmPhoneChange(id, code, ""); err != nil {
+		t.Fatalf("ERROR: confirm %v", err)
+	}
+	if _, err := svc.Authenticate(session.Token); err == nil {
+		t.Error("ERROR: session must end with the phone change")
+	}
+}
+
+func Test_Recovery(t *testing.T) {
+	svc := &Service{}
+	sender := &MemorySender{}
+	svc.SetCodeSender(sender)
+	acc, _ := svc.RegisterAccount("992000000001")
+	svc.SetPIN(acc.ID, "1234")
+	for i := 0; i < MaxLoginAttempts; i++ {
+		svc.Login("992000000001", "0000")
+	}
+
+	id, err := svc.StartRecovery("992000000001")
+	if err != nil {
+		t.Fatalf("ERROR: %v", err)
+	}
+	for i := 1; i < MaxCodeAttempts; i++ {
+		if err := svc.CompleteRecovery(id, "x", "5678"); err != ErrInvalidCode {
+			t.Errorf("ERROR: %v need %v", err, ErrInvalidCode)
+		}
+	}
+	if err := svc.CompleteRecovery(id, "x", "5678"); err != ErrTooManyAttempts {
+		t.Errorf("ERROR: %v need %v", err, ErrTooManyAttempts)
+	}
+	code, _ := sender.Last("+992000000001")
+	if err := svc.CompleteRecovery(id, code, "5678"); err != ErrChallengeNotFound {
+		t.Errorf("ERROR: after too many attempts %v", err)
+	}
+
+	unknown, err := svc.StartRecovery("992000000009")
+	if err != nil || unknown == "" {
+		t.Errorf("ERROR: unknown phone must look like a known one %q %v", unknown, err)
+	}
+	if err := svc.CompleteRecovery(unknown, "x", "5678"); err != ErrInvalidCode {
+		t.Errorf("ERROR: %v need %v", err, ErrInvalidCode)
+	}
+
+	id, _ = svc.StartRecovery("992000000001")
+	code, _ = sender.Last("+992000000001")
+	if err := svc.CompleteRecovery(id, code, "5678"); err != nil {
+		t.Fatalf("ERROR: %v", err)
+	}
+	if _, err := svc.Login("992000000001", "5678"); err != nil {
+		t.Errorf("ERROR: login with new pin %v", err)
+	}
+}
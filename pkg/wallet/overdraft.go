@@ -0,0 +1,329 @@
+package wallet
+
+import (
+	"bufio"
+	"errors"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/google/uuid"
+
+	"github.com/SsSJKK/wallet/pkg/types"
+)
+
+//ErrInvalidCreditLine err
+var ErrInvalidCreditLine = errors.New("invalid credit line")
+
+//ErrCreditLineInUse err
+var ErrCreditLineInUse = errors.New("credit line is in use")
+
+//InterestCategory category of overdraft interest payments
+const InterestCategory types.PaymentCategory = "interest"
+
+const secondsPerDay = 24 * 60 * 60
+
+//CreditLine lets the balance of the account go down to -Limit. Rate is the
+//daily interest in basis points (10 is 0.1% a day) charged on the used
+//credit. Interest is the part of the debt that is unpaid interest, deposits
+//repay it before the credit itself.
+type CreditLine struct {
+	AccountID   int64
+	Limit       types.Money
+	Rate        int64
+	Interest    types.Money
+	LastAccrual int64
+}
+
+//OverdraftLine one account of the overdraft report
+type OverdraftLine struct {
+	AccountID int64
+	Phone     types.Phone
+	Balance   types.Money
+	Limit     types.Money
+	Principal types.Money
+	Interest  types.Money
+	Available types.Money
+}
+
+//SetCreditLine opens or changes the credit line of the account. The limit
+//can't be cut below the credit already used.
+func (s *Service) SetCreditLine(accountID int64, limit types.Money, rate int64) error {
+	account, err := s.FindAccountByID(accountID)
+	if err != nil {
+		return err
+	}
+	if limit <= 0 || rate < 0 {
+		return ErrInvalidCreditLine
+	}
+	if -account.Balance > limit {
+		return ErrCreditLineInUse
+	}
+	line := s.creditLine(accountID)
+	entry := AuditEntry{
+		Action: "credit.set",
+		Target: "account:" + strconv.FormatInt(accountID, 10),
+		After:  creditState(&CreditLine{Limit: limit, Rate: rate}),
+	}
+	if line == nil {
+		line = &CreditLine{AccountID: accountID, LastAccrual: s.now().Unix()}
+		s.credits = append(s.credits, line)
+	} else {
+		entry.Before = creditState(line)
+	}
+	line.Limit = limit
+	line.Rate = rate
+	s.record(entry)
+	return nil
+}
+
+//RemoveCreditLine closes the credit line, the account must not be in overdraft
+func (s *Service) RemoveCreditLine(accountID int64) error {
+	account, err := s.FindAccountByID(accountID)
+	if err != nil {
+		return err
+	}
+	if account.Balance < 0 {
+		return ErrCreditLineInUse
+	}
+	for i, line := range s.credits {
+		if line.AccountID == accountID {
+			s.credits = append(s.credits[:i], s.credits[i+1:]...)
+			s.record(AuditEntry{
+				Action: "credit.remove",
+				Target: "account:" + strconv.FormatInt(accountID, 10),
+				Before: creditState(line),
+			})
+			return nil
+		}
+	}
+	return nil
+}
+
+//FindCreditLine returns a copy of the credit line of the account
+func (s *Service) FindCreditLine(accountID int64) (*CreditLine, error) {
+	_, err := s.FindAccountByID(accountID)
+	if err != nil {
+		return nil, err
+	}
+	line := s.creditLine(accountID)
+	if line == nil {
+		return nil, nil
+	}
+	copied := *line
+	copied.Interest = s.unpaidInterest(line)
+	return &copied, nil
+}
+
+//AccrueOverdraftInterest charges the daily interest for every whole day since
+//the last accrual or, if it is later, since the balance went negative. Like
+//RunDueSchedules it is meant to be called regularly. Interest is charged on
+//the used credit without the unpaid interest and may take the balance below
+//the limit.
+func (s *Service) AccrueOverdraftInterest() []types.Payment {
+	now := s.now().Unix()
+	charged := []types.Payment{}
+	for _, line := range s.credits {
+		days := (now - line.LastAccrual) / secondsPerDay
+		if days <= 0 {
+			continue
+		}
+		account, err := s.FindAccountByID(line.AccountID)
+		if err != nil || account.Balance >= 0 {
+			line.LastAccrual += days * secondsPerDay
+			line.Interest = 0
+			continue
+		}
+		line.Interest = s.unpaidInterest(line)
+		principal := -account.Balance - line.Interest
+		// проценты округляем до ближайшей минимальной единицы
+		interest := types.Money((int64(principal)*line.Rate*days + 5000) / 10000)
+		if interest <= 0 {
+			line.LastAccrual += days * secondsPerDay
+			continue
+		}
+		// после отказа дни не сдвигаются, проценты спишутся при следующем начислении
+		decision, err := s.checkDebit(account, interest, InterestCategory)
+		if err != nil {
+			log.Print(err)
+			continue
+		}
+		line.LastAccrual += days * secondsPerDay
+		before := accountState(account)
+		account.Balance -= interest
+		line.Interest += interest
+		payment := &types.Payment{
+			ID:        uuid.New().String(),
+			AccountID: account.ID,
+			Amount:    interest,
+			Category:  InterestCategory,
+			Status:    types.PaymentStatusOk,
+			Time:      now,
+		}
+		s.payments = append(s.payments, payment)
+		if decision != nil {
+			decision.PaymentID = payment.ID
+		}
+		s.record(AuditEntry{
+			Action: "credit.interest",
+			Target: "payment:" + payment.ID,
+			Before: before,
+			After:  accountState(account) + " " + paymentState(payment) + " days=" + strconv.FormatInt(days, 10),
+		})
+		s.publishPayment(EventPaymentCreated, payment, "")
+		charged = append(charged, *payment)
+	}
+	return charged
+}
+
+//OverdraftReport returns the accounts with a negative balance
+func (s *Service) OverdraftReport() []OverdraftLine {
+	report := []OverdraftLine{}
+	for _, account := range s.accounts {
+		if account.Balance >= 0 {
+			continue
+		}
+		line := OverdraftLine{
+			AccountID: account.ID,
+			Phone:     account.Phone,
+			Balance:   account.Balance,
+		}
+		credit := s.creditLine(account.ID)
+		if credit != nil {
+			line.Limit = credit.Limit
+			line.Interest = s.unpaidInterest(credit)
+		}
+		line.Principal = -account.Balance - line.Interest
+		line.Available = line.Limit + account.Balance
+		if line.Available < 0 {
+			line.Available = 0
+		}
+		report = append(report, line)
+	}
+	return report
+}
+
+func (s *Service) creditLine(accountID int64) *CreditLine {
+	for _, line := range s.credits {
+		if line.AccountID == accountID {
+			return line
+		}
+	}
+	return nil
+}
+
+// available сколько можно потратить с учётом кредитной линии
+func (s *Service) available(account *types.Account) types.Money {
+	line := s.creditLine(account.ID)
+	if line == nil {
+		return account.Balance
+	}
+	return account.Balance + line.Limit
+}
+
+// startOverdraft проценты считаются с момента, когда баланс ушёл в минус,
+// а не с последнего начисления
+func (s *Service) startOverdraft(account *types.Account, before types.Money) {
+	line := s.creditLine(account.ID)
+	if line == nil || before < 0 || account.Balance >= 0 {
+		return
+	}
+	line.LastAccrual = s.now().Unix()
+}
+
+// unpaidInterest не больше долга: возвраты и отмены тоже гасят долг
+func (s *Service) unpaidInterest(line *CreditLine) types.Money {
+	account, err := s.FindAccountByID(line.AccountID)
+	if err != nil || account.Balance >= 0 {
+		return 0
+	}
+	if line.Interest > -account.Balance {
+		return -account.Balance
+	}
+	return line.Interest
+}
+
+// repayCredit зачисление сначала гасит проценты, потом сам кредит
+func (s *Service) repayCredit(account *types.Account, before types.Money) {
+	line := s.creditLine(account.ID)
+	if line == nil || before >= 0 {
+		return
+	}
+	debt := -before
+	repaid := account.Balance - before
+	if repaid > debt {
+		repaid = debt
+	}
+	interest := line.Interest
+	if interest > debt {
+		interest = debt
+	}
+	interestPaid := interest
+	if interestPaid > repaid {
+		interestPaid = repaid
+	}
+	line.Interest = interest - interestPaid
+	s.record(AuditEntry{
+		Action: "credit.repay",
+		Target: "account:" + strconv.FormatInt(account.ID, 10),
+		After: "interest=" + strconv.FormatInt(int64(interestPaid), 10) +
+			" principal=" + strconv.FormatInt(int64(repaid-interestPaid), 10) +
+			" unpaid=" + strconv.FormatInt(int64(line.Interest), 10),
+	})
+}
+
+func creditState(line *CreditLine) string {
+	return "limit=" + strconv.FormatInt(int64(line.Limit), 10) + " rate=" + strconv.FormatInt(line.Rate, 10)
+}
+
+func (s *Service) exportCredits(dir string) error {
+	if s.credits == nil {
+		return nil
+	}
+	file, err := os.Create(dir + "/credits.dump")
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	text := ""
+	for _, line := range s.credits {
+		text += strconv.FormatInt(line.AccountID, 10) + ";" +
+			strconv.FormatInt(int64(line.Limit), 10) + ";" +
+			strconv.FormatInt(line.Rate, 10) + ";" +
+			strconv.FormatInt(int64(line.Interest), 10) + ";" +
+			strconv.FormatInt(line.LastAccrual, 10) + ";\n"
+	}
+	_, err = file.Write([]byte(text))
+	return err
+}
+
+func (s *Service) importCredits(dir string) {
+	file, err := os.Open(dir + "/credits.dump")
+	if err != nil {
+		log.Print(err)
+		return
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.Split(scanner.Text(), ";")
+		if len(line) < 5 {
+			continue
+		}
+		accountID, _ := strconv.ParseInt(line[0], 10, 64)
+		limit, _ := strconv.ParseInt(line[1], 10, 64)
+		rate, _ := strconv.ParseInt(line[2], 10, 64)
+		interest, _ := strconv.ParseInt(line[3], 10, 64)
+		lastAccrual, _ := strconv.ParseInt(line[4], 10, 64)
+		credit := s.creditLine(accountID)
+		if credit == nil {
+			credit = &CreditLine{AccountID: accountID}
+			s.credits = append(s.credits, credit)
+		}
+		credit.Limit = types.Money(limit)
+		credit.Rate = rate
+		credit.Interest = types.Money(interest)
+		credit.LastAccrual = lastAccrual
+	}
+}
@@ -0,0 +1,94 @@
+package wallet
+
+import (
+	"testing"
+	"time"
+
+	"github.com/SsSJKK/wallet/pkg/types"
+)
+
+func Test_Pay_Overdraft(t *testing.T) {
+	now := time.Date(2020, time.November, 3, 12, 0, 0, 0, time.UTC)
+	svc := &Service{}
+	svc.SetClock(func() time.Time { return now })
+	acc, _ := svc.RegisterAccount("992000000001")
+	svc.Deposit(acc.ID, 100)
+
+	if _, err := svc.Pay(acc.ID, 300, "auto"); err != ErrNotEnoughBalance {
+		t.Errorf("ERROR: %v need %v", err, ErrNotEnoughBalance)
+	}
+	if err := svc.SetCreditLine(acc.ID, 0, 10); err != ErrInvalidCreditLine {
+		t.Errorf("ERROR: %v need %v", err, ErrInvalidCreditLine)
+	}
+	svc.SetCreditLine(acc.ID, 1000, 10)
+	if _, err := svc.Pay(acc.ID, 1101, "auto"); err != ErrNotEnoughBalance {
+		t.Errorf("ERROR: %v over the limit", err)
+	}
+	_, err := svc.Pay(acc.ID, 1100, "auto")
+	if err != nil || acc.Balance != -1000 {
+		t.Fatalf("ERROR: %v balance %v", err, acc.Balance)
+	}
+	if err := svc.SetCreditLine(acc.ID, 500, 10); err != ErrCreditLineInUse {
+		t.Errorf("ERROR: %v need %v", err, ErrCreditLineInUse)
+	}
+	if _, err := svc.CloseAccount(acc.ID, "test", true); err != ErrBalanceNotZero {
+		t.Errorf("ERROR: %v need %v", err, ErrBalanceNotZero)
+	}
+
+	if charged := svc.AccrueOverdraftInterest(); len(charged) != 0 {
+		t.Errorf("ERROR: charged before a day passed %v", charged)
+	}
+	now = now.Add(3 * 24 * time.Hour)
+	charged := svc.AccrueOverdraftInterest()
+	if len(charged) != 1 || charged[0].Amount != 3 || charged[0].Category != InterestCategory || acc.Balance != -1003 {
+		t.Fatalf("ERROR: interest %v balance %v", charged, acc.Balance)
+	}
+
+	report := svc.OverdraftReport()
+	if len(report) != 1 || report[0].Principal != 1000 || report[0].Interest != 3 || report[0].Available != 0 {
+		t.Errorf("ERROR: report %+v", report)
+	}
+
+	// зачисление сначала гасит проценты
+	svc.Deposit(acc.ID, 2)
+	line, _ := svc.FindCreditLine(acc.ID)
+	if line.Interest != 1 {
+		t.Errorf("ERROR: unpaid interest %v need 1", line.Interest)
+	}
+	svc.Deposit(acc.ID, 1001)
+	if report := svc.OverdraftReport(); len(report) != 0 {
+		t.Errorf("ERROR: report %+v after repayment", report)
+	}
+	if err := svc.RemoveCreditLine(acc.ID); err != nil {
+		t.Error(err)
+	}
+}
+
+func Test_Overdraft_InterestFromStart(t *testing.T) {
+	now := time.Date(2020, time.November, 3, 12, 0, 0, 0, time.UTC)
+	svc := &Service{}
+	svc.SetClock(func() time.Time { return now })
+	acc, _ := svc.RegisterAccount("992000000001")
+	svc.SetCreditLine(acc.ID, 1000, 10)
+
+	// линия открыта десять дней назад, в минус счёт ушёл только сейчас
+	now = now.Add(10 * 24 * time.Hour)
+	svc.Pay(acc.ID, 1000, "auto")
+	now = now.Add(2 * 24 * time.Hour)
+	charged := svc.AccrueOverdraftInterest()
+	if len(charged) != 1 || charged[0].Amount != 2 {
+		t.Errorf("ERROR: interest %v need 2 days", charged)
+	}
+
+	// отказ риска откладывает проценты до следующего начисления
+	svc.AddRiskRule(&BlacklistRule{Categories: []types.PaymentCategory{InterestCategory}})
+	now = now.Add(24 * time.Hour)
+	if charged := svc.AccrueOverdraftInterest(); len(charged) != 0 {
+		t.Errorf("ERROR: denied interest charged %v", charged)
+	}
+	svc.RemoveRiskRule("blacklist")
+	now = now.Add(24 * time.Hour)
+	if charged := svc.AccrueOverdraftInterest(); len(charged) != 1 || charged[0].Amount != 2 {
+		t.Errorf("ERROR: interest %v need 2 days", charged)
+	}
+}
@@ -0,0 +1,105 @@
+package wallet
+
+import (
+	"sort"
+	"strconv"
+
+	"github.com/SsSJKK/wallet/pkg/phone"
+	"github.com/SsSJKK/wallet/pkg/types"
+)
+
+//ErrInvalidPhone err
+var ErrInvalidPhone = phone.ErrInvalidPhone
+
+//PhoneChange phone of the account rewritten by MigratePhones
+type PhoneChange struct {
+	AccountID int64
+	From      types.Phone
+	To        types.Phone
+}
+
+//PhoneCollision accounts whose phones are the same number
+type PhoneCollision struct {
+	Phone      types.Phone
+	AccountIDs []int64
+}
+
+//PhoneMigration report of MigratePhones
+type PhoneMigration struct {
+	Changed    []PhoneChange
+	Invalid    []PhoneChange
+	Collisions []PhoneCollision
+}
+
+//SetDefaultCountry sets the calling code of phones written without one,
+//phone.DefaultCountry by default
+func (s *Service) SetDefaultCountry(code string) {
+	s.country = code
+}
+
+//NormalizePhone returns the phone in E.164 form or ErrInvalidPhone
+func (s *Service) NormalizePhone(raw types.Phone) (types.Phone, error) {
+	country := s.country
+	if country == "" {
+		country = phone.DefaultCountry
+	}
+	return phone.Normalize(string(raw), country)
+}
+
+// phoneKey для сравнения номеров; номера из старых дампов, которые не
+// разбираются, сравниваются как есть
+func (s *Service) phoneKey(raw types.Phone) types.Phone {
+	normalized, err := s.NormalizePhone(raw)
+	if err != nil {
+		return raw
+	}
+	return normalized
+}
+
+//MigratePhones rewrites phones of all accounts to E.164. Phones that can't be
+//parsed and phones that turn out to be the same number are left as they are
+//and reported.
+func (s *Service) MigratePhones() PhoneMigration {
+	report := PhoneMigration{Changed: []PhoneChange{}, Invalid: []PhoneChange{}, Collisions: []PhoneCollision{}}
+	owners := map[types.Phone][]*types.Account{}
+	order := []types.Phone{}
+	for _, account := range s.accounts {
+		normalized, err := s.NormalizePhone(account.Phone)
+		if err != nil {
+			report.Invalid = append(report.Invalid, PhoneChange{AccountID: account.ID, From: account.Phone})
+			continue
+		}
+		if owners[normalized] == nil {
+			order = append(order, normalized)
+		}
+		owners[normalized] = append(owners[normalized], account)
+	}
+
+	for _, normalized := range order {
+		accounts := owners[normalized]
+		if len(accounts) > 1 {
+			collision := PhoneCollision{Phone: normalized}
+			for _, account := range accounts {
+				collision.AccountIDs = append(collision.AccountIDs, account.ID)
+			}
+			sort.Slice(collision.AccountIDs, func(i, j int) bool { return collision.AccountIDs[i] < collision.AccountIDs[j] })
+			report.Collisions = append(report.Collisions, collision)
+			continue
+		}
+		account := accounts[0]
+		if account.Phone == normalized {
+			continue
+		}
+		change := PhoneChange{AccountID: account.ID, From: account.Phone, To: normalized}
+		account.Phone = normalized
+		s.record(AuditEntry{
+			Action: "account.phone",
+			Target: "account:" + strconv.FormatInt(account.ID, 10),
+			Before: "phone=" + string(change.From),
+			After:  "phone=" + string(change.To),
+			Reason: "phone migration",
+		})
+		report.Changed = append(report.Changed, change)
+	}
+	return report
+}
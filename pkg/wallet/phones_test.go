@@ -0,0 +1,59 @@
+package wallet
+
+import (
+	"io/ioutil"
+	"testing"
+
+	"github.com/SsSJKK/wallet/pkg/types"
+)
+
+func Test_RegisterAccount_NormalizedPhone(t *testing.T) {
+	svc := &Service{}
+	acc, err := svc.RegisterAccount("992 000 000 001")
+	if err != nil || acc.Phone != "+992000000001" {
+		t.Fatalf("ERROR: %v %v", err, acc)
+	}
+	for _, phone := range []types.Phone{"992000000001", "+992 000 000 001", "(992) 000-000-001"} {
+		if _, err := svc.RegisterAccount(phone); err != ErrPhoneRegistered {
+			t.Errorf("ERROR: %q %v need %v", phone, err, ErrPhoneRegistered)
+		}
+	}
+	if _, err := svc.RegisterAccount(""); err != ErrInvalidPhone {
+		t.Errorf("ERROR: empty %v need %v", err, ErrInvalidPhone)
+	}
+	if found, err := svc.FindAccountByPhone("00992-000-000-001"); err != nil || found.ID != acc.ID {
+		t.Errorf("ERROR: find %v %v", err, found)
+	}
+
+	svc.SetDefaultCountry("7")
+	other, err := svc.RegisterAccount("8 (900) 123-45-67")
+	if err != nil || other.Phone != "+79001234567" {
+		t.Errorf("ERROR: default country %v %v", err, other)
+	}
+}
+
+func Test_MigratePhones(t *testing.T) {
+	dir := t.TempDir()
+	dump := "1;992000000001;10\n2;+992 000 000 001;20\n3;1010;30\n4;992 93 123 4567;40\n5;+992931234568;50\n"
+	ioutil.WriteFile(dir+"/accounts.dump", []byte(dump), 0644)
+	svc := &Service{}
+	svc.Import(dir)
+
+	report := svc.MigratePhones()
+	if len(report.Changed) != 1 || report.Changed[0] != (PhoneChange{AccountID: 4, From: "992 93 123 4567", To: "+992931234567"}) {
+		t.Errorf("ERROR: changed %v", report.Changed)
+	}
+	if len(report.Invalid) != 1 || report.Invalid[0].AccountID != 3 {
+		t.Errorf("ERROR: invalid %v", report.Invalid)
+	}
+	if len(report.Collisions) != 1 || report.Collisions[0].Phone != "+992000000001" || len(report.Collisions[0].AccountIDs) != 2 {
+		t.Errorf("ERROR: collisions %v", report.Collisions)
+	}
+	acc, _ := svc.FindAccountByID(1)
+	if acc.Phone != "992000000001" {
+		t.Errorf("ERROR: colliding phone changed %v", acc.Phone)
+	}
+	if again := svc.MigratePhones(); len(again.Changed) != 0 {
+		t.Errorf("ERROR: second run %v", again.Changed)
+	}
+}
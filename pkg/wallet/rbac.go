@@ -0,0 +1,1047 @@
+package wallet
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/SsSJKK/wallet/pkg/types"
+)
+
+//ErrInvalidRole err
+var ErrInvalidRole = errors.New("unknown role")
+
+//ErrStaffExists err
+var ErrStaffExists = errors.New("staff name already registered")
+
+//Role of a principal
+type Role string
+
+//Roles
+const (
+	RoleCustomer Role = "customer"
+	RoleSupport  Role = "support"
+	RoleAuditor  Role = "auditor"
+	RoleAdmin    Role = "admin"
+)
+
+//Permission to call a group of operations
+type Permission string
+
+//Permissions
+const (
+	PermAccountRead   Permission = "account.read"
+	PermAccountCreate Permission = "account.create"
+	PermAccountStatus Permission = "account.status"
+	PermPhoneChange   Permission = "phone.change"
+	PermDeposit       Permission = "deposit"
+	PermPay           Permission = "pay"
+	PermReject        Permission = "reject"
+	PermFavorite      Permission = "favorite"
+	PermHistory       Permission = "history"
+	PermExport        Permission = "export"
+	PermImport        Permission = "import"
+	PermReport        Permission = "report"
+	PermManage        Permission = "manage"
+	PermReview        Permission = "review"
+)
+
+// клиент работает только со своими счетами, сотрудники — со всеми.
+// Пополняют счёт только сотрудники: клиент создал бы деньги из ничего
+var rolePermissions = map[Role][]Permission{
+	RoleCustomer: {PermAccountRead, PermPhoneChange, PermPay, PermFavorite, PermHistory},
+	RoleSupport:  {PermAccountRead, PermAccountCreate, PermAccountStatus, PermPhoneChange, PermDeposit, PermReject, PermHistory, PermReview},
+	RoleAuditor:  {PermAccountRead, PermHistory, PermExport, PermReport},
+	RoleAdmin: {
+		PermAccountRead, PermAccountCreate, PermAccountStatus, PermPhoneChange, PermDeposit, PermPay, PermReject, PermFavorite,
+		PermHistory, PermExport, PermImport, PermReport, PermManage, PermReview,
+	},
+}
+
+//Can reports whether the role has the permission
+func (r Role) Can(permission Permission) bool {
+	for _, p := range rolePermissions[r] {
+		if p == permission {
+			return true
+		}
+	}
+	return false
+}
+
+//AccessError returned for every denied operation
+type AccessError struct {
+	Actor      string
+	Role       Role
+	Permission Permission
+	AccountID  int64
+	Reason     string
+}
+
+func (e *AccessError) Error() string {
+	text := e.Actor + " is not allowed to " + string(e.Permission)
+	if e.AccountID != 0 {
+		text += " on account " + strconv.FormatInt(e.AccountID, 10)
+	}
+	return text + ": " + e.Reason
+}
+
+//Is makes errors.Is(err, ErrForbidden) and errors.Is(err, ErrUnauthorized) work
+func (e *AccessError) Is(target error) bool {
+	if e.Role == "" {
+		return target == ErrUnauthorized
+	}
+	return target == ErrForbidden
+}
+
+//Denial audit entry of a denied operation
+type Denial struct {
+	Time       int64
+	Actor      string
+	Role       Role
+	Permission Permission
+	AccountID  int64
+	Reason     string
+}
+
+//Denials returns the denied operations in the order they happened
+func (s *Service) Denials() []Denial {
+	result := make([]Denial, len(s.denials))
+	copy(result, s.denials)
+	return result
+}
+
+func (s *Service) deny(principal *Principal, permission Permission, accountID int64, reason string) error {
+	err := &AccessError{
+		Actor:      principal.String(),
+		Permission: permission,
+		AccountID:  accountID,
+		Reason:     reason,
+	}
+	if principal != nil {
+		err.Role = principal.Role
+	}
+	s.denials = append(s.denials, Denial{
+		Time:       s.now().Unix(),
+		Actor:      err.Actor,
+		Role:       err.Role,
+		Permission: permission,
+		AccountID:  accountID,
+		Reason:     reason,
+	})
+	s.record(AuditEntry{
+		Actor:  err.Actor,
+		Action: "deny",
+		Target: "account:" + strconv.FormatInt(accountID, 10),
+		After:  "permission=" + string(permission),
+		Reason: reason,
+	})
+	return err
+}
+
+//RegisterStaff adds a support, auditor or admin user with a password
+func (s *Service) RegisterStaff(name string, role Role, password string) error {
+	name = strings.TrimSpace(name)
+	if name == "" || strings.ContainsAny(name, ";\n") {
+		return ErrInvalidCredentials
+	}
+	if role == RoleCustomer || rolePermissions[role] == nil {
+		return ErrInvalidRole
+	}
+	if _, ok := s.staff[name]; ok {
+		return ErrStaffExists
+	}
+	if len(password) < 4 {
+		return ErrWeakPIN
+	}
+	salt := make([]byte, 16)
+	_, err := rand.Read(salt)
+	if err != nil {
+		return err
+	}
+	if s.staff == nil {
+		s.staff = map[string]*credential{}
+	}
+	s.staff[name] = &credential{Name: name, Role: role, Salt: salt, Hash: hashPIN(password, salt, pinIterations)}
+	s.record(AuditEntry{Action: "staff.register", Target: "staff:" + name, After: "role=" + string(role)})
+	return nil
+}
+
+//InitStaff registers the first admin of an empty staff list, later staff
+//is added by an admin with RegisterStaff
+func (s *Service) InitStaff(name string, password string) error {
+	if len(s.staff) != 0 {
+		return ErrStaffExists
+	}
+	return s.RegisterStaff(name, RoleAdmin, password)
+}
+
+//LoginStaff checks the password of a staff user and issues a session token
+func (s *Service) LoginStaff(name string, password string) (*Session, error) {
+	cred, ok := s.staff[name]
+	if !ok {
+		return nil, ErrInvalidCredentials
+	}
+	err := s.verify(cred, password)
+	if err != nil {
+		return nil, err
+	}
+	return s.issueSession(Principal{Name: cred.Name, Role: cred.Role})
+}
+
+//As returns operations of the service allowed to the principal
+func (s *Service) As(principal *Principal) *Actor {
+	return &Actor{svc: s, principal: principal}
+}
+
+//Actor operations of the service on behalf of an authenticated principal.
+//Every call checks the role's permission, customers can use only their own
+//accounts. accountID 0 means the operation is not tied to an account.
+type Actor struct {
+	svc       *Service
+	principal *Principal
+	reason    string
+}
+
+//Because returns the same actor whose operations are written to the audit
+//log with the reason
+func (a *Actor) Because(reason string) *Actor {
+	return &Actor{svc: a.svc, principal: a.principal, reason: reason}
+}
+
+func (a *Actor) allow(permission Permission, accountID int64) error {
+	if a.principal == nil {
+		return a.svc.deny(nil, permission, accountID, "not authenticated")
+	}
+	if !a.principal.Role.Can(permission) {
+		return a.svc.deny(a.principal, permission, accountID, "role has no permission")
+	}
+	if a.principal.Role == RoleCustomer && a.principal.AccountID != accountID {
+		return a.svc.deny(a.principal, permission, accountID, "not the owner of the account")
+	}
+	return nil
+}
+
+//Allow checks the permission like every operation of the actor, for
+//operations outside the wallet package such as webhooks
+func (a *Actor) Allow(permission Permission, accountID int64) error {
+	return a.allow(permission, accountID)
+}
+
+func (a *Actor) allowPayment(permission Permission, paymentID string) (*types.Payment, error) {
+	payment, err := a.svc.FindPaymentByID(paymentID)
+	if err != nil {
+		return nil, err
+	}
+	err = a.allow(permission, payment.AccountID)
+	if err != nil {
+		return nil, err
+	}
+	return payment, nil
+}
+
+//RegisterAccount meth
+func (a *Actor) RegisterAccount(phone types.Phone) (*types.Account, error) {
+	err := a.allow(PermAccountCreate, 0)
+	if err != nil {
+		return nil, err
+	}
+	defer a.svc.enter(a.principal, a.reason)()
+	return a.svc.RegisterAccount(phone)
+}
+
+//FreezeAccount meth
+func (a *Actor) FreezeAccount(accountID int64, reason string) error {
+	err := a.allow(PermAccountStatus, accountID)
+	if err != nil {
+		return err
+	}
+	defer a.svc.enter(a.principal, a.reason)()
+	return a.svc.FreezeAccount(accountID, reason)
+}
+
+//UnfreezeAccount meth
+func (a *Actor) UnfreezeAccount(accountID int64, reason string) error {
+	err := a.allow(PermAccountStatus, accountID)
+	if err != nil {
+		return err
+	}
+	defer a.svc.enter(a.principal, a.reason)()
+	return a.svc.UnfreezeAccount(accountID, reason)
+}
+
+//CloseAccount meth
+func (a *Actor) CloseAccount(accountID int64, reason string, payout bool) (*types.Payment, error) {
+	err := a.allow(PermAccountStatus, accountID)
+	if err != nil {
+		return nil, err
+	}
+	defer a.svc.enter(a.principal, a.reason)()
+	return a.svc.CloseAccount(accountID, reason, payout)
+}
+
+//ReopenAccount meth
+func (a *Actor) ReopenAccount(accountID int64, reason string) error {
+	err := a.allow(PermAccountStatus, accountID)
+	if err != nil {
+		return err
+	}
+	defer a.svc.enter(a.principal, a.reason)()
+	return a.svc.ReopenAccount(accountID, reason)
+}
+
+//SetFrozenPolicy meth
+func (a *Actor) SetFrozenPolicy(policy FrozenPolicy) error {
+	err := a.allow(PermManage, 0)
+	if err != nil {
+		return err
+	}
+	defer a.svc.enter(a.principal, a.reason)()
+	a.svc.SetFrozenPolicy(policy)
+	return nil
+}
+
+//StartPhoneChange meth
+func (a *Actor) StartPhoneChange(accountID int64, newPhone types.Phone, pin string) (string, error) {
+	err := a.allow(PermPhoneChange, accountID)
+	if err != nil {
+		return "", err
+	}
+	return a.svc.StartPhoneChange(accountID, newPhone, pin)
+}
+
+//Accounts meth
+func (a *Actor) Accounts() ([]types.Account, error) {
+	err := a.allow(PermAccountRead, 0)
+	if err != nil {
+		return nil, err
+	}
+	return a.svc.Accounts(), nil
+}
+
+//FindAccountByPhone meth
+func (a *Actor) FindAccountByPhone(phone types.Phone) (*types.Account, error) {
+	account, err := a.svc.FindAccountByPhone(phone)
+	if err != nil {
+		return nil, err
+	}
+	err = a.allow(PermAccountRead, account.ID)
+	if err != nil {
+		return nil, err
+	}
+	return account, nil
+}
+
+//MigratePhones meth
+func (a *Actor) MigratePhones() (PhoneMigration, error) {
+	err := a.allow(PermManage, 0)
+	if err != nil {
+		return PhoneMigration{}, err
+	}
+	defer a.svc.enter(a.principal, a.reason)()
+	return a.svc.MigratePhones(), nil
+}
+
+//FindAccountByID meth
+func (a *Actor) FindAccountByID(accountID int64) (*types.Account, error) {
+	err := a.allow(PermAccountRead, accountID)
+	if err != nil {
+		return nil, err
+	}
+	return a.svc.FindAccountByID(accountID)
+}
+
+//Deposit meth
+func (a *Actor) Deposit(accountID int64, amount types.Money) error {
+	err := a.allow(PermDeposit, accountID)
+	if err != nil {
+		return err
+	}
+	defer a.svc.enter(a.principal, a.reason)()
+	return a.svc.Deposit(accountID, amount)
+}
+
+//Pay meth
+func (a *Actor) Pay(accountID int64, amount types.Money, category types.PaymentCategory) (*types.Payment, error) {
+	err := a.allow(PermPay, accountID)
+	if err != nil {
+		return nil, err
+	}
+	defer a.svc.enter(a.principal, a.reason)()
+	return a.svc.Pay(accountID, amount, category)
+}
+
+//FindPaymentByID meth
+func (a *Actor) FindPaymentByID(paymentID string) (*types.Payment, error) {
+	return a.allowPayment(PermAccountRead, paymentID)
+}
+
+//Reject meth
+func (a *Actor) Reject(paymentID string) error {
+	_, err := a.allowPayment(PermReject, paymentID)
+	if err != nil {
+		return err
+	}
+	defer a.svc.enter(a.principal, a.reason)()
+	return a.svc.Reject(paymentID)
+}
+
+//Refund meth
+func (a *Actor) Refund(paymentID string) error {
+	_, err := a.allowPayment(PermReject, paymentID)
+	if err != nil {
+		return err
+	}
+	defer a.svc.enter(a.principal, a.reason)()
+	return a.svc.Refund(paymentID)
+}
+
+//Repeat meth
+func (a *Actor) Repeat(paymentID string) (*types.Payment, error) {
+	_, err := a.allowPayment(PermPay, paymentID)
+	if err != nil {
+		return nil, err
+	}
+	defer a.svc.enter(a.principal, a.reason)()
+	return a.svc.Repeat(paymentID)
+}
+
+//FavoritePayment meth
+func (a *Actor) FavoritePayment(paymentID string, name string) (*types.Favorite, error) {
+	_, err := a.allowPayment(PermFavorite, paymentID)
+	if err != nil {
+		return nil, err
+	}
+	defer a.svc.enter(a.principal, a.reason)()
+	return a.svc.FavoritePayment(paymentID, name)
+}
+
+//PayFromFavorite meth
+func (a *Actor) PayFromFavorite(favoriteID string) (*types.Payment, error) {
+	favorite, err := a.svc.FindFavoriteByID(favoriteID)
+	if err != nil {
+		return nil, err
+	}
+	err = a.allow(PermPay, favorite.AccountID)
+	if err != nil {
+		return nil, err
+	}
+	defer a.svc.enter(a.principal, a.reason)()
+	return a.svc.PayFromFavorite(favoriteID)
+}
+
+//AddFavorite meth
+func (a *Actor) AddFavorite(accountID int64, name string, amount types.Money, category types.PaymentCategory) (*types.Favorite, error) {
+	err := a.allow(PermFavorite, accountID)
+	if err != nil {
+		return nil, err
+	}
+	defer a.svc.enter(a.principal, a.reason)()
+	return a.svc.AddFavorite(accountID, name, amount, category)
+}
+
+//AccountFavorites meth
+func (a *Actor) AccountFavorites(accountID int64) ([]types.Favorite, error) {
+	err := a.allow(PermFavorite, accountID)
+	if err != nil {
+		return nil, err
+	}
+	return a.svc.AccountFavorites(accountID)
+}
+
+//UpdateFavorite meth
+func (a *Actor) UpdateFavorite(favoriteID string, name string, amount types.Money, category types.PaymentCategory) (*types.Favorite, error) {
+	favorite, err := a.svc.FindFavoriteByID(favoriteID)
+	if err != nil {
+		return nil, err
+	}
+	err = a.allow(PermFavorite, favorite.AccountID)
+	if err != nil {
+		return nil, err
+	}
+	defer a.svc.enter(a.principal, a.reason)()
+	return a.svc.UpdateFavorite(favoriteID, name, amount, category)
+}
+
+//DeleteFavorite meth
+func (a *Actor) DeleteFavorite(favoriteID string) error {
+	favorite, err := a.svc.FindFavoriteByID(favoriteID)
+	if err != nil {
+		return err
+	}
+	err = a.allow(PermFavorite, favorite.AccountID)
+	if err != nil {
+		return err
+	}
+	defer a.svc.enter(a.principal, a.reason)()
+	return a.svc.DeleteFavorite(favoriteID)
+}
+
+//ReorderFavorites meth
+func (a *Actor) ReorderFavorites(accountID int64, favoriteIDs []string) error {
+	err := a.allow(PermFavorite, accountID)
+	if err != nil {
+		return err
+	}
+	defer a.svc.enter(a.principal, a.reason)()
+	return a.svc.ReorderFavorites(accountID, favoriteIDs)
+}
+
+//ScheduleFavorite meth
+func (a *Actor) ScheduleFavorite(favoriteID string, template types.Schedule) (*types.Schedule, error) {
+	favorite, err := a.svc.FindFavoriteByID(favoriteID)
+	if err != nil {
+		return nil, err
+	}
+	// расписание платит от имени владельца, поэтому нужно право платить
+	err = a.allow(PermPay, favorite.AccountID)
+	if err != nil {
+		return nil, err
+	}
+	defer a.svc.enter(a.principal, a.reason)()
+	return a.svc.ScheduleFavorite(favoriteID, template)
+}
+
+//Schedules meth
+func (a *Actor) Schedules(favoriteID string) ([]types.Schedule, error) {
+	favorite, err := a.svc.FindFavoriteByID(favoriteID)
+	if err != nil {
+		return nil, err
+	}
+	err = a.allow(PermFavorite, favorite.AccountID)
+	if err != nil {
+		return nil, err
+	}
+	return a.svc.Schedules(favoriteID), nil
+}
+
+//CancelSchedule meth
+func (a *Actor) CancelSchedule(scheduleID string) error {
+	schedule, err := a.svc.FindScheduleByID(scheduleID)
+	if err != nil {
+		return err
+	}
+	favorite, err := a.svc.FindFavoriteByID(schedule.FavoriteID)
+	if err != nil {
+		return err
+	}
+	err = a.allow(PermFavorite, favorite.AccountID)
+	if err != nil {
+		return err
+	}
+	defer a.svc.enter(a.principal, a.reason)()
+	return a.svc.CancelSchedule(scheduleID)
+}
+
+//RunDueSchedules meth
+func (a *Actor) RunDueSchedules() ([]ScheduleRun, error) {
+	err := a.allow(PermManage, 0)
+	if err != nil {
+		return nil, err
+	}
+	defer a.svc.enter(a.principal, a.reason)()
+	return a.svc.RunDueSchedules(), nil
+}
+
+//ExportAccountHistory meth
+func (a *Actor) ExportAccountHistory(accountID int64) ([]types.Payment, error) {
+	err := a.allow(PermHistory, accountID)
+	if err != nil {
+		return nil, err
+	}
+	return a.svc.ExportAccountHistory(accountID)
+}
+
+//AccountDeposits meth
+func (a *Actor) AccountDeposits(accountID int64) ([]types.Deposit, error) {
+	err := a.allow(PermHistory, accountID)
+	if err != nil {
+		return nil, err
+	}
+	return a.svc.AccountDeposits(accountID)
+}
+
+//FilterPaymentsByFn meth
+func (a *Actor) FilterPaymentsByFn(filter func(payment types.Payment) bool, goroutines int) ([]types.Payment, error) {
+	err := a.allow(PermReport, 0)
+	if err != nil {
+		return nil, err
+	}
+	return a.svc.FilterPaymentsByFn(filter, goroutines)
+}
+
+//FilterPayments meth
+func (a *Actor) FilterPayments(accountID int64, goroutines int) ([]types.Payment, error) {
+	err := a.allow(PermHistory, accountID)
+	if err != nil {
+		return nil, err
+	}
+	return a.svc.FilterPayments(accountID, goroutines)
+}
+
+//AuditLog meth
+func (a *Actor) AuditLog() ([]AuditEntry, error) {
+	err := a.allow(PermReport, 0)
+	if err != nil {
+		return nil, err
+	}
+	return a.svc.AuditLog(), nil
+}
+
+//SetLimit meth
+func (a *Actor) SetLimit(limit Limit) error {
+	err := a.allow(PermManage, limit.AccountID)
+	if err != nil {
+		return err
+	}
+	defer a.svc.enter(a.principal, a.reason)()
+	return a.svc.SetLimit(limit)
+}
+
+//RemoveLimit meth
+func (a *Actor) RemoveLimit(accountID int64, category types.PaymentCategory) error {
+	err := a.allow(PermManage, accountID)
+	if err != nil {
+		return err
+	}
+	defer a.svc.enter(a.principal, a.reason)()
+	a.svc.RemoveLimit(accountID, category)
+	return nil
+}
+
+//Limits meth
+func (a *Actor) Limits(accountID int64, category types.PaymentCategory) ([]Limit, error) {
+	err := a.allow(PermAccountRead, accountID)
+	if err != nil {
+		return nil, err
+	}
+	return a.svc.Limits(accountID, category), nil
+}
+
+//SetFeeRule meth
+func (a *Actor) SetFeeRule(rule FeeRule) error {
+	err := a.allow(PermManage, 0)
+	if err != nil {
+		return err
+	}
+	defer a.svc.enter(a.principal, a.reason)()
+	return a.svc.SetFeeRule(rule)
+}
+
+//RemoveFeeRule meth
+func (a *Actor) RemoveFeeRule(rule FeeRule) error {
+	err := a.allow(PermManage, 0)
+	if err != nil {
+		return err
+	}
+	defer a.svc.enter(a.principal, a.reason)()
+	a.svc.RemoveFeeRule(rule)
+	return nil
+}
+
+//FeeRules meth
+func (a *Actor) FeeRules() ([]FeeRule, error) {
+	err := a.allow(PermReport, 0)
+	if err != nil {
+		return nil, err
+	}
+	return a.svc.FeeRules(), nil
+}
+
+//SetRewardRule meth
+func (a *Actor) SetRewardRule(rule RewardRule) error {
+	err := a.allow(PermManage, 0)
+	if err != nil {
+		return err
+	}
+	defer a.svc.enter(a.principal, a.reason)()
+	return a.svc.SetRewardRule(rule)
+}
+
+//SetPointValue meth
+func (a *Actor) SetPointValue(value int64) error {
+	err := a.allow(PermManage, 0)
+	if err != nil {
+		return err
+	}
+	defer a.svc.enter(a.principal, a.reason)()
+	a.svc.SetPointValue(value)
+	return nil
+}
+
+//RewardMovements meth
+func (a *Actor) RewardMovements(accountID int64) ([]RewardMovement, error) {
+	err := a.allow(PermHistory, accountID)
+	if err != nil {
+		return nil, err
+	}
+	return a.svc.RewardMovements(accountID)
+}
+
+//Points meth
+func (a *Actor) Points(accountID int64) (int64, error) {
+	err := a.allow(PermHistory, accountID)
+	if err != nil {
+		return 0, err
+	}
+	return a.svc.Points(accountID)
+}
+
+//RedeemPoints meth
+func (a *Actor) RedeemPoints(accountID int64, points int64) (*types.Deposit, error) {
+	err := a.allow(PermPay, accountID)
+	if err != nil {
+		return nil, err
+	}
+	defer a.svc.enter(a.principal, a.reason)()
+	return a.svc.RedeemPoints(accountID, points)
+}
+
+//SetCreditLine meth
+func (a *Actor) SetCreditLine(accountID int64, limit types.Money, rate int64) error {
+	err := a.allow(PermManage, accountID)
+	if err != nil {
+		return err
+	}
+	defer a.svc.enter(a.principal, a.reason)()
+	return a.svc.SetCreditLine(accountID, limit, rate)
+}
+
+//RemoveCreditLine meth
+func (a *Actor) RemoveCreditLine(accountID int64) error {
+	err := a.allow(PermManage, accountID)
+	if err != nil {
+		return err
+	}
+	defer a.svc.enter(a.principal, a.reason)()
+	return a.svc.RemoveCreditLine(accountID)
+}
+
+//AccrueOverdraftInterest meth
+func (a *Actor) AccrueOverdraftInterest() ([]types.Payment, error) {
+	err := a.allow(PermManage, 0)
+	if err != nil {
+		return nil, err
+	}
+	defer a.svc.enter(a.principal, a.reason)()
+	return a.svc.AccrueOverdraftInterest(), nil
+}
+
+//OverdraftReport meth
+func (a *Actor) OverdraftReport() ([]OverdraftLine, error) {
+	err := a.allow(PermReport, 0)
+	if err != nil {
+		return nil, err
+	}
+	return a.svc.OverdraftReport(), nil
+}
+
+//SetSavingsTiers meth
+func (a *Actor) SetSavingsTiers(tiers []SavingsTier) error {
+	err := a.allow(PermManage, 0)
+	if err != nil {
+		return err
+	}
+	defer a.svc.enter(a.principal, a.reason)()
+	return a.svc.SetSavingsTiers(tiers)
+}
+
+//AccrueSavingsInterest meth
+func (a *Actor) AccrueSavingsInterest(day time.Time, goroutines int) (SavingsRun, error) {
+	err := a.allow(PermManage, 0)
+	if err != nil {
+		return SavingsRun{}, err
+	}
+	defer a.svc.enter(a.principal, a.reason)()
+	return a.svc.AccrueSavingsInterest(day, goroutines), nil
+}
+
+//RegisterMerchant meth
+func (a *Actor) RegisterMerchant(name string, category types.PaymentCategory, mcc string, settlementAccountID int64) (*types.Merchant, error) {
+	err := a.allow(PermManage, 0)
+	if err != nil {
+		return nil, err
+	}
+	defer a.svc.enter(a.principal, a.reason)()
+	return a.svc.RegisterMerchant(name, category, mcc, settlementAccountID)
+}
+
+//Merchants meth
+func (a *Actor) Merchants() ([]types.Merchant, error) {
+	err := a.allow(PermReport, 0)
+	if err != nil {
+		return nil, err
+	}
+	return a.svc.Merchants(), nil
+}
+
+//PayMerchant meth
+func (a *Actor) PayMerchant(accountID int64, merchantID string, amount types.Money) (*types.Payment, error) {
+	err := a.allow(PermPay, accountID)
+	if err != nil {
+		return nil, err
+	}
+	defer a.svc.enter(a.principal, a.reason)()
+	return a.svc.PayMerchant(accountID, merchantID, amount)
+}
+
+//MerchantPayments meth
+func (a *Actor) MerchantPayments(merchantID string) ([]types.Payment, error) {
+	err := a.allow(PermReport, 0)
+	if err != nil {
+		return nil, err
+	}
+	return a.svc.MerchantPayments(merchantID)
+}
+
+//MerchantDailyTotals meth
+func (a *Actor) MerchantDailyTotals(merchantID string) ([]MerchantDay, error) {
+	err := a.allow(PermReport, 0)
+	if err != nil {
+		return nil, err
+	}
+	return a.svc.MerchantDailyTotals(merchantID)
+}
+
+//Settle meth
+func (a *Actor) Settle(day time.Time, dir string) ([]SettlementBatch, error) {
+	err := a.allow(PermManage, 0)
+	if err != nil {
+		return nil, err
+	}
+	defer a.svc.enter(a.principal, a.reason)()
+	return a.svc.Settle(day, dir)
+}
+
+//Settlements meth
+func (a *Actor) Settlements() ([]SettlementBatch, error) {
+	err := a.allow(PermReport, 0)
+	if err != nil {
+		return nil, err
+	}
+	return a.svc.Settlements(), nil
+}
+
+//AddRiskRule meth
+func (a *Actor) AddRiskRule(rule RiskRule) error {
+	err := a.allow(PermManage, 0)
+	if err != nil {
+		return err
+	}
+	defer a.svc.enter(a.principal, a.reason)()
+	a.svc.AddRiskRule(rule)
+	return nil
+}
+
+//RemoveRiskRule meth
+func (a *Actor) RemoveRiskRule(name string) error {
+	err := a.allow(PermManage, 0)
+	if err != nil {
+		return err
+	}
+	defer a.svc.enter(a.principal, a.reason)()
+	a.svc.RemoveRiskRule(name)
+	return nil
+}
+
+//RiskRules meth
+func (a *Actor) RiskRules() ([]RiskRule, error) {
+	err := a.allow(PermReport, 0)
+	if err != nil {
+		return nil, err
+	}
+	return a.svc.RiskRules(), nil
+}
+
+//RiskDecisions meth
+func (a *Actor) RiskDecisions(accountID int64) ([]RiskDecision, error) {
+	err := a.allow(PermReport, 0)
+	if err != nil {
+		return nil, err
+	}
+	return a.svc.RiskDecisions(accountID), nil
+}
+
+//ReviewQueue meth
+func (a *Actor) ReviewQueue() ([]ReviewItem, error) {
+	err := a.allow(PermReview, 0)
+	if err != nil {
+		return nil, err
+	}
+	return a.svc.ReviewQueue(), nil
+}
+
+//FindReview meth
+func (a *Actor) FindReview(paymentID string) (*ReviewItem, error) {
+	err := a.allow(PermReview, 0)
+	if err != nil {
+		return nil, err
+	}
+	return a.svc.FindReview(paymentID)
+}
+
+//AssignReview meth
+func (a *Actor) AssignReview(paymentID string, assignee string) error {
+	err := a.allow(PermReview, 0)
+	if err != nil {
+		return err
+	}
+	defer a.svc.enter(a.principal, a.reason)()
+	return a.svc.AssignReview(paymentID, assignee)
+}
+
+//CommentReview meth
+func (a *Actor) CommentReview(paymentID string, text string) error {
+	err := a.allow(PermReview, 0)
+	if err != nil {
+		return err
+	}
+	defer a.svc.enter(a.principal, a.reason)()
+	return a.svc.CommentReview(paymentID, text)
+}
+
+//ApproveReview meth
+func (a *Actor) ApproveReview(paymentID string) error {
+	err := a.allow(PermReview, 0)
+	if err != nil {
+		return err
+	}
+	defer a.svc.enter(a.principal, a.reason)()
+	return a.svc.ApproveReview(paymentID)
+}
+
+//DeclineReview meth
+func (a *Actor) DeclineReview(paymentID string) error {
+	err := a.allow(PermReview, 0)
+	if err != nil {
+		return err
+	}
+	defer a.svc.enter(a.principal, a.reason)()
+	return a.svc.DeclineReview(paymentID)
+}
+
+//ReviewSLA meth
+func (a *Actor) ReviewSLA(sla time.Duration) (*ReviewReport, error) {
+	err := a.allow(PermReview, 0)
+	if err != nil {
+		return nil, err
+	}
+	return a.svc.ReviewSLA(sla), nil
+}
+
+//RegisterStaff meth
+func (a *Actor) RegisterStaff(name string, role Role, password string) error {
+	err := a.allow(PermManage, 0)
+	if err != nil {
+		return err
+	}
+	defer a.svc.enter(a.principal, a.reason)()
+	return a.svc.RegisterStaff(name, role, password)
+}
+
+//Export meth
+func (a *Actor) Export(dir string) error {
+	err := a.allow(PermExport, 0)
+	if err != nil {
+		return err
+	}
+	return a.svc.Export(dir)
+}
+
+//Import meth
+func (a *Actor) Import(dir string) error {
+	err := a.allow(PermImport, 0)
+	if err != nil {
+		return err
+	}
+	defer a.svc.enter(a.principal, a.reason)()
+	err = a.svc.Import(dir)
+	if err != nil {
+		return err
+	}
+	a.svc.record(AuditEntry{Action: "import", Target: "dir:" + dir})
+	return nil
+}
+
+//SumPayments meth
+func (a *Actor) SumPayments(goroutines int) (types.Money, error) {
+	err := a.allow(PermReport, 0)
+	if err != nil {
+		return 0, err
+	}
+	return a.svc.SumPayments(goroutines), nil
+}
+
+//SumPaymentsWithProgress meth
+func (a *Actor) SumPaymentsWithProgress() (<-chan Progress, error) {
+	err := a.allow(PermReport, 0)
+	if err != nil {
+		return nil, err
+	}
+	return a.svc.SumPaymentsWithProgress(), nil
+}
+
+func (s *Service) exportStaff(dir string) error {
+	if s.staff == nil {
+		return nil
+	}
+	file, err := os.Create(dir + "/staff.dump")
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	names := make([]string, 0, len(s.staff))
+	for name := range s.staff {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	text := ""
+	for _, name := range names {
+		cred := s.staff[name]
+		text += cred.Name + ";" +
+			string(cred.Role) + ";" +
+			hex.EncodeToString(cred.Salt) + ";" +
+			hex.EncodeToString(cred.Hash) + ";" +
+			strconv.Itoa(cred.Failures) + ";" +
+			strconv.FormatInt(cred.LockedUntil, 10) + ";\n"
+	}
+	_, err = file.Write([]byte(text))
+	return err
+}
+
+func (s *Service) importStaff(dir string) {
+	file, err := os.Open(dir + "/staff.dump")
+	if err != nil {
+		log.Print(err)
+		return
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.Split(scanner.Text(), ";")
+		if len(line) < 6 {
+			continue
+		}
+		salt, _ := hex.DecodeString(line[2])
+		hash, _ := hex.DecodeString(line[3])
+		failures, _ := strconv.Atoi(line[4])
+		lockedUntil, _ := strconv.ParseInt(line[5], 10, 64)
+		if s.staff == nil {
+			s.staff = map[string]*credential{}
+		}
+		s.staff[line[0]] = &credential{
+			Name:        line[0],
+			Role:        Role(line[1]),
+			Salt:        salt,
+			Hash:        hash,
+			Failures:    failures,
+			LockedUntil: lockedUntil,
+		}
+	}
+}
@@ -0,0 +1,181 @@
+package wallet
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/SsSJKK/wallet/pkg/types"
+)
+
+func Test_Actor_Roles(t *testing.T) {
+	now := time.Date(2020, time.November, 3, 12, 0, 0, 0, time.UTC)
+	svc := &Service{}
+	svc.SetClock(func() time.Time { return now })
+	acc, _ := svc.RegisterAccount("992000000001")
+	svc.Deposit(acc.ID, 100)
+	pay, _ := svc.Pay(acc.ID, 10, "auto")
+	svc.SetPIN(acc.ID, "1234")
+	svc.RegisterStaff("anna", RoleSupport, "secret")
+	svc.RegisterStaff("igor", RoleAuditor, "secret")
+	svc.RegisterStaff("root", RoleAdmin, "secret")
+	if err := svc.RegisterStaff("anna", RoleAdmin, "secret"); err != ErrStaffExists {
+		t.Errorf("ERROR: %v need %v", err, ErrStaffExists)
+	}
+	if err := svc.RegisterStaff("bob", RoleCustomer, "secret"); err != ErrInvalidRole {
+		t.Errorf("ERROR: %v need %v", err, ErrInvalidRole)
+	}
+
+	login := func(name string) *Actor {
+		session, err := svc.LoginStaff(name, "secret")
+		if err != nil {
+			t.Fatalf("ERROR: login %s %v", name, err)
+		}
+		principal, _ := svc.Authenticate(session.Token)
+		return svc.As(principal)
+	}
+	session, _ := svc.Login("992000000001", "1234")
+	principal, _ := svc.Authenticate(session.Token)
+	customer := svc.As(principal)
+	support := login("anna")
+	auditor := login("igor")
+	admin := login("root")
+
+	if err := customer.Reject(pay.ID); !errors.Is(err, ErrForbidden) {
+		t.Errorf("ERROR: customer reject %v", err)
+	}
+	if _, err := support.Pay(acc.ID, 10, "auto"); !errors.Is(err, ErrForbidden) {
+		t.Errorf("ERROR: support pay %v", err)
+	}
+	if err := support.Reject(pay.ID); err != nil {
+		t.Errorf("ERROR: support reject %v", err)
+	}
+	if _, err := auditor.SumPayments(2); err != nil {
+		t.Errorf("ERROR: auditor sum %v", err)
+	}
+	if err := auditor.Export(t.TempDir()); err != nil {
+		t.Errorf("ERROR: auditor export %v", err)
+	}
+	if err := auditor.Import(t.TempDir()); !errors.Is(err, ErrForbidden) {
+		t.Errorf("ERROR: auditor import %v", err)
+	}
+	if err := auditor.Deposit(acc.ID, 10); !errors.Is(err, ErrForbidden) {
+		t.Errorf("ERROR: auditor deposit %v", err)
+	}
+	if err := admin.SetLimit(Limit{AccountID: acc.ID, Daily: 50}); err != nil {
+		t.Errorf("ERROR: admin limit %v", err)
+	}
+	if acc.Balance != 100 {
+		t.Errorf("ERROR: balance %v need 100", acc.Balance)
+	}
+
+	var accessErr *AccessError
+	err := customer.Reject(pay.ID)
+	if !errors.As(err, &accessErr) || accessErr.Permission != PermReject || accessErr.AccountID != acc.ID {
+		t.Errorf("ERROR: typed error %#v", err)
+	}
+	denials := svc.Denials()
+	if len(denials) != 5 {
+		t.Fatalf("ERROR: denials %v", denials)
+	}
+	want := Denial{
+		Time:       now.Unix(),
+		Actor:      "customer:1",
+		Role:       RoleCustomer,
+		Permission: PermReject,
+		AccountID:  acc.ID,
+		Reason:     "role has no permission",
+	}
+	if denials[4] != want {
+		t.Errorf("ERROR: denial %v need %v", denials[4], want)
+	}
+}
+
+func Test_LoginStaff_Export(t *testing.T) {
+	svc := &Service{}
+	svc.RegisterAccount("992000000001")
+	svc.RegisterStaff("root", RoleAdmin, "secret")
+	dir := t.TempDir()
+	svc.Export(dir)
+
+	imported := &Service{}
+	imported.Import(dir)
+	if _, err := imported.LoginStaff("root", "wrong"); err != ErrInvalidCredentials {
+		t.Errorf("ERROR: %v need %v", err, ErrInvalidCredentials)
+	}
+	session, err := imported.LoginStaff("root", "secret")
+	if err != nil {
+		t.Fatalf("ERROR: %v", err)
+	}
+	principal, _ := imported.Authenticate(session.Token)
+	account, err := imported.As(principal).RegisterAccount("992000000002")
+	if err != nil || account.Phone != types.Phone("+992000000002") {
+		t.Errorf("ERROR: %v %v", err, account)
+	}
+}
+
+func Test_Actor_FavoritesAndRules(t *testing.T) {
+	svc := &Service{}
+	acc, _ := svc.RegisterAccount("992000000001")
+	other, _ := svc.RegisterAccount("992000000002")
+	svc.Deposit(acc.ID, 100)
+	fav, _ := svc.AddFavorite(acc.ID, "Internet", 30, "internet")
+	otherFav, _ := svc.AddFavorite(other.ID, "Internet", 30, "internet")
+	otherSchedule, _ := svc.ScheduleFavorite(otherFav.ID, types.Schedule{Kind: types.ScheduleDaily})
+	svc.SetPIN(acc.ID, "1234")
+	session, _ := svc.Login("992000000001", "1234")
+	principal, _ := svc.Authenticate(session.Token)
+	customer := svc.As(principal)
+
+	if _, err := customer.UpdateFavorite(fav.ID, "Home", 40, "internet"); err != nil {
+		t.Errorf("ERROR: update own %v", err)
+	}
+	if _, err := customer.ScheduleFavorite(fav.ID, types.Schedule{Kind: types.ScheduleOnce}); err != nil {
+		t.Errorf("ERROR: schedule own %v", err)
+	}
+	if _, err := customer.UpdateFavorite(otherFav.ID, "Home", 40, "internet"); !errors.Is(err, ErrForbidden) {
+		t.Errorf("ERROR: update other %v need %v", err, ErrForbidden)
+	}
+	if err := customer.DeleteFavorite(otherFav.ID); !errors.Is(err, ErrForbidden) {
+		t.Errorf("ERROR: delete other %v need %v", err, ErrForbidden)
+	}
+	if err := customer.ReorderFavorites(other.ID, []string{otherFav.ID}); !errors.Is(err, ErrForbidden) {
+		t.Errorf("ERROR: reorder other %v need %v", err, ErrForbidden)
+	}
+	if _, err := customer.ScheduleFavorite(otherFav.ID, types.Schedule{Kind: types.ScheduleOnce}); !errors.Is(err, ErrForbidden) {
+		t.Errorf("ERROR: schedule other %v need %v", err, ErrForbidden)
+	}
+	if _, err := customer.Schedules(otherFav.ID); !errors.Is(err, ErrForbidden) {
+		t.Errorf("ERROR: schedules other %v need %v", err, ErrForbidden)
+	}
+	if err := customer.CancelSchedule(otherSchedule.ID); !errors.Is(err, ErrForbidden) {
+		t.Errorf("ERROR: cancel other %v need %v", err, ErrForbidden)
+	}
+	if _, err := customer.RunDueSchedules(); !errors.Is(err, ErrForbidden) {
+		t.Errorf("ERROR: run schedules %v need %v", err, ErrForbidden)
+	}
+	if _, err := customer.FilterPayments(other.ID, 2); !errors.Is(err, ErrForbidden) {
+		t.Errorf("ERROR: filter other %v need %v", err, ErrForbidden)
+	}
+	if err := customer.RemoveLimit(acc.ID, ""); !errors.Is(err, ErrForbidden) {
+		t.Errorf("ERROR: remove limit %v need %v", err, ErrForbidden)
+	}
+	if err := customer.RemoveFeeRule(FeeRule{}); !errors.Is(err, ErrForbidden) {
+		t.Errorf("ERROR: remove fee rule %v need %v", err, ErrForbidden)
+	}
+	if err := customer.SetPointValue(100); !errors.Is(err, ErrForbidden) {
+		t.Errorf("ERROR: point value %v need %v", err, ErrForbidden)
+	}
+	if err := customer.SetFrozenPolicy(FrozenPolicy{}); !errors.Is(err, ErrForbidden) {
+		t.Errorf("ERROR: frozen policy %v need %v", err, ErrForbidden)
+	}
+	if _, err := customer.Settlements(); !errors.Is(err, ErrForbidden) {
+		t.Errorf("ERROR: settlements %v need %v", err, ErrForbidden)
+	}
+	if _, err := customer.SumPaymentsWithProgress(); !errors.Is(err, ErrForbidden) {
+		t.Errorf("ERROR: sum with progress %v need %v", err, ErrForbidden)
+	}
+	if schedule, _ := svc.FindScheduleByID(otherSchedule.ID); !schedule.Active {
+		t.Errorf("ERROR: other schedule cancelled %v", schedule)
+	}
+}
@@ -0,0 +1,378 @@
+package wallet
+
+import (
+	"bufio"
+	"errors"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/SsSJKK/wallet/pkg/types"
+)
+
+//ErrReviewNotFound err
+var ErrReviewNotFound = errors.New("review not found")
+
+//ErrReviewResolved err
+var ErrReviewResolved = errors.New("review already resolved")
+
+//DefaultReviewSLA how long a held payment may wait for a decision
+const DefaultReviewSLA = 24 * time.Hour
+
+//Sources of a hold
+const (
+	ReviewSourceLimit = "limit"
+	ReviewSourceRisk  = "risk"
+)
+
+//Resolutions of a review
+const (
+	ReviewApproved = "APPROVED"
+	ReviewDeclined = "DECLINED"
+)
+
+//ReviewComment note left on a held payment
+type ReviewComment struct {
+	Time   int64
+	Author string
+	Text   string
+}
+
+//ReviewItem payment held for manual review. Source tells whether a limit or
+//the risk engine held it, Resolved is zero while the item is in the queue.
+type ReviewItem struct {
+	PaymentID  string
+	AccountID  int64
+	Amount     types.Money
+	Source     string
+	Reason     string
+	Created    int64
+	Assignee   string
+	Comments   []ReviewComment
+	Resolved   int64
+	Resolution string
+}
+
+//ReviewAge how long an item of the queue waits
+type ReviewAge struct {
+	PaymentID string
+	Assignee  string
+	Age       time.Duration
+	Breached  bool
+}
+
+//ReviewBucket number of items of the queue in an age range
+type ReviewBucket struct {
+	Label string
+	From  time.Duration
+	Count int
+}
+
+//ReviewReport SLA report of the review queue
+type ReviewReport struct {
+	SLA      time.Duration
+	Open     int
+	Breached int
+	Oldest   time.Duration
+	Buckets  []ReviewBucket
+	Items    []ReviewAge
+}
+
+//ReviewQueue returns the payments waiting for review, the oldest first
+func (s *Service) ReviewQueue() []ReviewItem {
+	queue := []ReviewItem{}
+	for _, item := range s.reviews {
+		if item.Resolved == 0 {
+			queue = append(queue, copyReview(item))
+		}
+	}
+	sort.SliceStable(queue, func(i, j int) bool { return queue[i].Created < queue[j].Created })
+	return queue
+}
+
+//FindReview returns the review of the payment, resolved ones too
+func (s *Service) FindReview(paymentID string) (*ReviewItem, error) {
+	item := s.review(paymentID)
+	if item == nil {
+		return nil, ErrReviewNotFound
+	}
+	found := copyReview(item)
+	return &found, nil
+}
+
+//AssignReview gives the review to the staff member, empty assignee returns it to the queue
+func (s *Service) AssignReview(paymentID string, assignee string) error {
+	item, err := s.openReview(paymentID)
+	if err != nil {
+		return err
+	}
+	before := "assignee=" + item.Assignee
+	item.Assignee = assignee
+	s.record(AuditEntry{
+		Action: "review.assign",
+		Target: "payment:" + paymentID,
+		Before: before,
+		After:  "assignee=" + item.Assignee,
+	})
+	return nil
+}
+
+//CommentReview adds a note to the review, the author is the current caller
+func (s *Service) CommentReview(paymentID string, text string) error {
+	item := s.review(paymentID)
+	if item == nil {
+		return ErrReviewNotFound
+	}
+	author := "system"
+	if s.caller != nil {
+		author = s.caller.String()
+	}
+	item.Comments = append(item.Comments, ReviewComment{Time: s.now().Unix(), Author: author, Text: text})
+	s.record(AuditEntry{
+		Action: "review.comment",
+		Target: "payment:" + paymentID,
+		After:  auditField(text),
+	})
+	return nil
+}
+
+//ApproveReview releases the held payment and its fees, they go on as
+//INPROGRESS payments and earn rewards
+func (s *Service) ApproveReview(paymentID string) error {
+	_, err := s.openReview(paymentID)
+	if err != nil {
+		return err
+	}
+	payment, err := s.FindPaymentByID(paymentID)
+	if err != nil {
+		return err
+	}
+	account, err := s.FindAccountByID(payment.AccountID)
+	if err != nil {
+		return err
+	}
+
+	s.release(payment)
+	for _, linked := range s.payments {
+		if linked.ParentID == paymentID && linked.Status == types.PaymentStatusReview {
+			s.release(linked)
+		}
+	}
+	s.resolveReview(paymentID, ReviewApproved)
+	s.accrueRewards(account, payment)
+	return nil
+}
+
+//DeclineReview refunds the held payment and its fees like Reject
+func (s *Service) DeclineReview(paymentID string) error {
+	_, err := s.openReview(paymentID)
+	if err != nil {
+		return err
+	}
+	return s.Reject(paymentID)
+}
+
+//ReviewSLA reports how long the queue waits. Zero sla means DefaultReviewSLA.
+func (s *Service) ReviewSLA(sla time.Duration) *ReviewReport {
+	if sla <= 0 {
+		sla = DefaultReviewSLA
+	}
+	report := &ReviewReport{
+		SLA: sla,
+		Buckets: []ReviewBucket{
+			{Label: "<1h", From: 0},
+			{Label: "1h-4h", From: time.Hour},
+			{Label: "4h-24h", From: 4 * time.Hour},
+			{Label: ">=24h", From: 24 * time.Hour},
+		},
+		Items: []ReviewAge{},
+	}
+	now := s.now()
+	for _, item := range s.ReviewQueue() {
+		age := now.Sub(time.Unix(item.Created, 0))
+		breached := age > sla
+		report.Open++
+		if breached {
+			report.Breached++
+		}
+		if age > report.Oldest {
+			report.Oldest = age
+		}
+		for i := len(report.Buckets) - 1; i >= 0; i-- {
+			if age >= report.Buckets[i].From {
+				report.Buckets[i].Count++
+				break
+			}
+		}
+		report.Items = append(report.Items, ReviewAge{PaymentID: item.PaymentID, Assignee: item.Assignee, Age: age, Breached: breached})
+	}
+	return report
+}
+
+func (s *Service) holdForReview(payment *types.Payment, source string, reason string) {
+	item := &ReviewItem{
+		PaymentID: payment.ID,
+		AccountID: payment.AccountID,
+		Amount:    payment.Amount,
+		Source:    source,
+		Reason:    reason,
+		Created:   s.now().Unix(),
+	}
+	s.reviews = append(s.reviews, item)
+	s.record(AuditEntry{
+		Action: "review.hold",
+		Target: "payment:" + payment.ID,
+		After:  paymentState(payment),
+		Reason: source + ": " + reason,
+	})
+}
+
+func (s *Service) release(payment *types.Payment) {
+	before := paymentState(payment)
+	payment.Status = types.PaymentStatusInProgress
+	s.record(AuditEntry{
+		Action: "review.release",
+		Target: "payment:" + payment.ID,
+		Before: before,
+		After:  paymentState(payment),
+	})
+	s.publishPayment(EventPaymentStatusChanged, payment, types.PaymentStatusReview)
+}
+
+// resolveReview закрывает открытый разбор, для платежей без разбора ничего не делает
+func (s *Service) resolveReview(paymentID string, resolution string) {
+	item := s.review(paymentID)
+	if item == nil || item.Resolved != 0 {
+		return
+	}
+	item.Resolved = s.now().Unix()
+	item.Resolution = resolution
+	s.record(AuditEntry{
+		Action: "review." + strings.ToLower(resolution),
+		Target: "payment:" + paymentID,
+		Before: "assignee=" + item.Assignee,
+		After:  "resolution=" + resolution,
+	})
+}
+
+func (s *Service) review(paymentID string) *ReviewItem {
+	for _, item := range s.reviews {
+		if item.PaymentID == paymentID {
+			return item
+		}
+	}
+	return nil
+}
+
+func (s *Service) openReview(paymentID string) (*ReviewItem, error) {
+	item := s.review(paymentID)
+	if item == nil {
+		return nil, ErrReviewNotFound
+	}
+	if item.Resolved != 0 {
+		return nil, ErrReviewResolved
+	}
+	return item, nil
+}
+
+func copyReview(item *ReviewItem) ReviewItem {
+	result := *item
+	result.Comments = make([]ReviewComment, len(item.Comments))
+	copy(result.Comments, item.Comments)
+	return result
+}
+
+func (s *Service) exportReviews(dir string) error {
+	if s.reviews == nil {
+		return nil
+	}
+	file, err := os.Create(dir + "/review.dump")
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	comments, err := os.Create(dir + "/review_comments.dump")
+	if err != nil {
+		return err
+	}
+	defer comments.Close()
+	text := ""
+	commentText := ""
+	for _, item := range s.reviews {
+		text += item.PaymentID + ";" +
+			strconv.FormatInt(item.AccountID, 10) + ";" +
+			strconv.FormatInt(int64(item.Amount), 10) + ";" +
+			item.Source + ";" +
+			auditField(item.Reason) + ";" +
+			strconv.FormatInt(item.Created, 10) + ";" +
+			item.Assignee + ";" +
+			strconv.FormatInt(item.Resolved, 10) + ";" +
+			item.Resolution + ";\n"
+		for _, comment := range item.Comments {
+			commentText += item.PaymentID + ";" +
+				strconv.FormatInt(comment.Time, 10) + ";" +
+				comment.Author + ";" +
+				auditField(comment.Text) + ";\n"
+		}
+	}
+	_, err = file.Write([]byte(text))
+	if err != nil {
+		return err
+	}
+	_, err = comments.Write([]byte(commentText))
+	return err
+}
+
+func (s *Service) importReviews(dir string) {
+	file, err := os.Open(dir + "/review.dump")
+	if err != nil {
+		log.Print(err)
+		return
+	}
+	defer file.Close()
+	imported := map[string]*ReviewItem{}
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.Split(scanner.Text(), ";")
+		if len(line) < 9 || s.review(line[0]) != nil {
+			continue
+		}
+		accountID, _ := strconv.ParseInt(line[1], 10, 64)
+		amount, _ := strconv.ParseInt(line[2], 10, 64)
+		created, _ := strconv.ParseInt(line[5], 10, 64)
+		resolved, _ := strconv.ParseInt(line[7], 10, 64)
+		item := &ReviewItem{
+			PaymentID:  line[0],
+			AccountID:  accountID,
+			Amount:     types.Money(amount),
+			Source:     line[3],
+			Reason:     line[4],
+			Created:    created,
+			Assignee:   line[6],
+			Resolved:   resolved,
+			Resolution: line[8],
+		}
+		s.reviews = append(s.reviews, item)
+		imported[item.PaymentID] = item
+	}
+
+	comments, err := os.Open(dir + "/review_comments.dump")
+	if err != nil {
+		log.Print(err)
+		return
+	}
+	defer comments.Close()
+	scanner = bufio.NewScanner(comments)
+	for scanner.Scan() {
+		line := strings.Split(scanner.Text(), ";")
+		if len(line) < 4 || imported[line[0]] == nil {
+			continue
+		}
+		created, _ := strconv.ParseInt(line[1], 10, 64)
+		item := imported[line[0]]
+		item.Comments = append(item.Comments, ReviewComment{Time: created, Author: line[2], Text: line[3]})
+	}
+}
@@ -0,0 +1,114 @@
+package wallet
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/SsSJKK/wallet/pkg/types"
+)
+
+func Test_Review_ApproveDecline(t *testing.T) {
+	now := time.Date(2020, time.November, 3, 12, 0, 0, 0, time.UTC)
+	svc := &Service{}
+	svc.SetClock(func() time.Time { return now })
+	acc, _ := svc.RegisterAccount("992000000001")
+	svc.Deposit(acc.ID, 10000)
+	svc.SetFeeRule(FeeRule{Operation: OperationPay, Fixed: 10})
+	svc.SetLimit(Limit{AccountID: acc.ID, Transaction: 1000, Hold: true})
+	svc.SetLimit(Limit{AccountID: acc.ID, Category: "casino", Transaction: 1000})
+
+	if _, err := svc.Pay(acc.ID, 2000, "casino"); !errors.Is(err, ErrLimitExceeded) {
+		t.Errorf("ERROR: %v need %v", err, ErrLimitExceeded)
+	}
+	first, err := svc.Pay(acc.ID, 2000, "auto")
+	if err != nil || first.Status != types.PaymentStatusReview {
+		t.Fatalf("ERROR: %v need hold", err)
+	}
+	second, _ := svc.Pay(acc.ID, 3000, "auto")
+	fee := svc.LinkedPayments(first.ID)
+	if len(fee) != 1 || fee[0].Status != types.PaymentStatusReview || acc.Balance != 4980 {
+		t.Errorf("ERROR: fee %+v balance %v", fee, acc.Balance)
+	}
+
+	if err := svc.AssignReview(first.ID, "anna"); err != nil {
+		t.Error(err)
+	}
+	svc.CommentReview(first.ID, "called the client")
+	if err := svc.ApproveReview(first.ID); err != nil {
+		t.Error(err)
+	}
+	payment, _ := svc.FindPaymentByID(first.ID)
+	fee = svc.LinkedPayments(first.ID)
+	if payment.Status != types.PaymentStatusInProgress || fee[0].Status != types.PaymentStatusInProgress {
+		t.Errorf("ERROR: approved %v fee %v", payment.Status, fee[0].Status)
+	}
+	if err := svc.ApproveReview(first.ID); err != ErrReviewResolved {
+		t.Errorf("ERROR: %v need %v", err, ErrReviewResolved)
+	}
+
+	if err := svc.DeclineReview(second.ID); err != nil {
+		t.Error(err)
+	}
+	if second.Status != types.PaymentStatusFail || acc.Balance != 7990 {
+		t.Errorf("ERROR: declined %v balance %v", second.Status, acc.Balance)
+	}
+	if err := svc.DeclineReview("unknown"); err != ErrReviewNotFound {
+		t.Errorf("ERROR: %v need %v", err, ErrReviewNotFound)
+	}
+
+	review, _ := svc.FindReview(first.ID)
+	if review.Resolution != ReviewApproved || review.Assignee != "anna" || len(review.Comments) != 1 || review.Source != ReviewSourceLimit {
+		t.Errorf("ERROR: review %+v", review)
+	}
+	if len(svc.ReviewQueue()) != 0 {
+		t.Errorf("ERROR: queue %+v", svc.ReviewQueue())
+	}
+}
+
+func Test_Review_SLA_Export(t *testing.T) {
+	now := time.Date(2020, time.November, 3, 12, 0, 0, 0, time.UTC)
+	svc := &Service{}
+	svc.SetClock(func() time.Time { return now })
+	acc, _ := svc.RegisterAccount("992000000001")
+	svc.Deposit(acc.ID, 10000)
+	svc.SetLimit(Limit{Transaction: 100, Hold: true})
+
+	old, _ := svc.Pay(acc.ID, 200, "auto")
+	now = now.Add(20 * time.Hour)
+	svc.Pay(acc.ID, 300, "auto")
+	now = now.Add(30 * time.Minute)
+	svc.Pay(acc.ID, 400, "auto")
+	svc.CommentReview(old.ID, "waiting; documents")
+
+	report := svc.ReviewSLA(8 * time.Hour)
+	if report.Open != 3 || report.Breached != 1 || report.Oldest != 20*time.Hour+30*time.Minute {
+		t.Errorf("ERROR: report %+v", report)
+	}
+	if report.Buckets[0].Count != 2 || report.Buckets[1].Count != 0 || report.Buckets[2].Count != 1 || report.Buckets[3].Count != 0 {
+		t.Errorf("ERROR: buckets %+v", report.Buckets)
+	}
+	if !report.Items[0].Breached || report.Items[0].PaymentID != old.ID {
+		t.Errorf("ERROR: items %+v", report.Items)
+	}
+
+	dir := t.TempDir()
+	if err := svc.Export(dir); err != nil {
+		t.Fatal(err)
+	}
+	imported := &Service{}
+	if err := imported.Import(dir); err != nil {
+		t.Fatal(err)
+	}
+	queue := imported.ReviewQueue()
+	if len(queue) != 3 || queue[0].PaymentID != old.ID || len(queue[0].Comments) != 1 || queue[0].Comments[0].Text != "waiting, documents" {
+		t.Errorf("ERROR: imported queue %+v", queue)
+	}
+	if err := imported.DeclineReview(old.ID); err != nil {
+		t.Error(err)
+	}
+	account, _ := imported.FindAccountByID(acc.ID)
+	if account.Balance != 9300 {
+		t.Errorf("ERROR: balance %v need 9300", account.Balance)
+	}
+}
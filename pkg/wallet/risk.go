@@ -0,0 +1,520 @@
+package wallet
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/SsSJKK/wallet/pkg/types"
+)
+
+//ErrRiskDenied err
+var ErrRiskDenied = errors.New("payment denied by risk check")
+
+//ErrInvalidRiskRule err
+var ErrInvalidRiskRule = errors.New("invalid risk rule")
+
+//RiskOutcome decision of the risk engine
+type RiskOutcome string
+
+//Risk outcomes, from the mildest
+const (
+	RiskAllow RiskOutcome = "ALLOW"
+	RiskHold  RiskOutcome = "HOLD"
+	RiskDeny  RiskOutcome = "DENY"
+)
+
+func (o RiskOutcome) weight() int {
+	switch o {
+	case RiskHold:
+		return 1
+	case RiskDeny:
+		return 2
+	}
+	return 0
+}
+
+//RiskContext what a risk rule sees of the debit. Amount is the whole debit,
+//a payment together with its fee. Interest and payouts are checked too, with
+//their own categories. History has the earlier payments of the account
+//without the failed and linked ones.
+type RiskContext struct {
+	Account    types.Account
+	Amount     types.Money
+	Category   types.PaymentCategory
+	MerchantID string
+	Now        time.Time
+	History    []types.Payment
+}
+
+//RiskRule one check of the risk engine. Check returns RiskAllow when the
+//payment looks fine, otherwise the outcome and its reason.
+type RiskRule interface {
+	Name() string
+	Check(ctx *RiskContext) (RiskOutcome, string)
+}
+
+//RiskDecision evaluation of one payment, Rule is empty when it was allowed.
+//PaymentID is set when the payment was made or held.
+type RiskDecision struct {
+	PaymentID  string
+	Time       int64
+	AccountID  int64
+	Amount     types.Money
+	Category   types.PaymentCategory
+	MerchantID string
+	Outcome    RiskOutcome
+	Rule       string
+	Reason     string
+}
+
+//RiskError payment denied by a rule
+type RiskError struct {
+	Outcome RiskOutcome
+	Rule    string
+	Reason  string
+}
+
+func (e *RiskError) Error() string {
+	return fmt.Sprintf("risk %s by %s: %s", strings.ToLower(string(e.Outcome)), e.Rule, e.Reason)
+}
+
+//Is makes errors.Is(err, ErrRiskDenied) true
+func (e *RiskError) Is(target error) bool {
+	return target == ErrRiskDenied
+}
+
+//VelocityRule stops the payment when the account already made Max payments
+//within Window (a minute by default). Outcome is RiskHold by default.
+type VelocityRule struct {
+	Max     int
+	Window  time.Duration
+	Outcome RiskOutcome
+}
+
+//Name meth
+func (r *VelocityRule) Name() string { return "velocity" }
+
+//Check meth
+func (r *VelocityRule) Check(ctx *RiskContext) (RiskOutcome, string) {
+	window := r.Window
+	if window <= 0 {
+		window = time.Minute
+	}
+	from := ctx.Now.Add(-window).Unix()
+	count := 0
+	for _, payment := range ctx.History {
+		if payment.Time > from {
+			count++
+		}
+	}
+	if r.Max <= 0 || count < r.Max {
+		return RiskAllow, ""
+	}
+	return outcome(r.Outcome, RiskHold), fmt.Sprintf("%d payments within %s", count, window)
+}
+
+//SpikeRule stops the payment when it is more than Factor times the average
+//of the last payments of the account. Accounts with less than MinHistory
+//payments (3 by default) are not checked, the average is taken over at most
+//Last payments (20 by default). Outcome is RiskHold by default.
+type SpikeRule struct {
+	Factor     int64
+	MinHistory int
+	Last       int
+	Outcome    RiskOutcome
+}
+
+//Name meth
+func (r *SpikeRule) Name() string { return "spike" }
+
+//Check meth
+func (r *SpikeRule) Check(ctx *RiskContext) (RiskOutcome, string) {
+	minHistory, last := r.MinHistory, r.Last
+	if minHistory <= 0 {
+		minHistory = 3
+	}
+	if last <= 0 {
+		last = 20
+	}
+	history := ctx.History
+	if len(history) > last {
+		history = history[len(history)-last:]
+	}
+	if r.Factor <= 0 || len(history) < minHistory {
+		return RiskAllow, ""
+	}
+	sum := types.Money(0)
+	for _, payment := range history {
+		sum += payment.Amount
+	}
+	average := sum / types.Money(len(history))
+	if ctx.Amount <= average*types.Money(r.Factor) {
+		return RiskAllow, ""
+	}
+	return outcome(r.Outcome, RiskHold), fmt.Sprintf("amount %d is over %d times the average %d", ctx.Amount, r.Factor, average)
+}
+
+//FirstLargeRule stops a payment of at least Amount to a merchant, or to a
+//category for payments without a merchant, the account never paid before.
+//Outcome is RiskHold by default.
+type FirstLargeRule struct {
+	Amount  types.Money
+	Outcome RiskOutcome
+}
+
+//Name meth
+func (r *FirstLargeRule) Name() string { return "first_large" }
+
+//Check meth
+func (r *FirstLargeRule) Check(ctx *RiskContext) (RiskOutcome, string) {
+	if r.Amount <= 0 || ctx.Amount < r.Amount {
+		return RiskAllow, ""
+	}
+	for _, payment := range ctx.History {
+		if ctx.MerchantID != "" && payment.MerchantID == ctx.MerchantID ||
+			ctx.MerchantID == "" && payment.MerchantID == "" && payment.Category == ctx.Category {
+			return RiskAllow, ""
+		}
+	}
+	target := "category " + string(ctx.Category)
+	if ctx.MerchantID != "" {
+		target = "merchant " + ctx.MerchantID
+	}
+	return outcome(r.Outcome, RiskHold), fmt.Sprintf("first payment to %s is %d", target, ctx.Amount)
+}
+
+//BlacklistRule stops payments of the categories. Outcome is RiskDeny by
+//default.
+type BlacklistRule struct {
+	Categories []types.PaymentCategory
+	Outcome    RiskOutcome
+}
+
+//Name meth
+func (r *BlacklistRule) Name() string { return "blacklist" }
+
+//Check meth
+func (r *BlacklistRule) Check(ctx *RiskContext) (RiskOutcome, string) {
+	for _, category := range r.Categories {
+		if category == ctx.Category {
+			return outcome(r.Outcome, RiskDeny), "category " + string(category) + " is blacklisted"
+		}
+	}
+	return RiskAllow, ""
+}
+
+func outcome(set RiskOutcome, def RiskOutcome) RiskOutcome {
+	if set == "" {
+		return def
+	}
+	return set
+}
+
+//AddRiskRule adds the rule to the risk engine or replaces the rule with the
+//same name. The built-in rules are exported with the data, other rules are
+//code and must be added again after a restart.
+func (s *Service) AddRiskRule(rule RiskRule) {
+	entry := AuditEntry{Action: "risk.rule", Target: "risk:" + rule.Name(), After: riskRuleState(rule)}
+	for i, r := range s.riskRules {
+		if r.Name() == rule.Name() {
+			entry.Before = riskRuleState(r)
+			s.riskRules[i] = rule
+			s.record(entry)
+			return
+		}
+	}
+	s.riskRules = append(s.riskRules, rule)
+	s.record(entry)
+}
+
+//RemoveRiskRule removes the rule with the name from the risk engine
+func (s *Service) RemoveRiskRule(name string) {
+	for i, r := range s.riskRules {
+		if r.Name() == name {
+			s.riskRules = append(s.riskRules[:i], s.riskRules[i+1:]...)
+			s.record(AuditEntry{Action: "risk.remove", Target: "risk:" + name, Before: riskRuleState(r)})
+			return
+		}
+	}
+}
+
+//RiskRules returns the rules of the risk engine in the order they are checked
+func (s *Service) RiskRules() []RiskRule {
+	return append([]RiskRule{}, s.riskRules...)
+}
+
+//RiskRuleFields returns the name, the outcome and the parameters of a
+//built-in rule the way ParseRiskRule reads them, ok is false for other rules
+func RiskRuleFields(rule RiskRule) (fields []string, ok bool) {
+	switch r := rule.(type) {
+	case *VelocityRule:
+		return []string{r.Name(), string(r.Outcome), strconv.Itoa(r.Max), r.Window.String()}, true
+	case *SpikeRule:
+		return []string{r.Name(), string(r.Outcome), strconv.FormatInt(r.Factor, 10), strconv.Itoa(r.MinHistory), strconv.Itoa(r.Last)}, true
+	case *FirstLargeRule:
+		return []string{r.Name(), string(r.Outcome), strconv.FormatInt(int64(r.Amount), 10)}, true
+	case *BlacklistRule:
+		categories := []string{}
+		for _, category := range r.Categories {
+			categories = append(categories, string(category))
+		}
+		return []string{r.Name(), string(r.Outcome), strings.Join(categories, ",")}, true
+	}
+	return nil, false
+}
+
+//ParseRiskRule makes a built-in rule from its name, outcome and parameters:
+//velocity <max> <window>, spike <factor> [minHistory] [last],
+//first_large <amount>, blacklist <category,...>. Empty outcome is the
+//default of the rule.
+func ParseRiskRule(name string, outcome RiskOutcome, params []string) (RiskRule, error) {
+	if outcome != "" && outcome != RiskAllow && outcome != RiskHold && outcome != RiskDeny {
+		return nil, ErrInvalidRiskRule
+	}
+	numbers := []int64{}
+	for i, param := range params {
+		if name == "blacklist" || name == "velocity" && i == 1 {
+			continue
+		}
+		number, err := strconv.ParseInt(param, 10, 64)
+		if err != nil || number < 0 {
+			return nil, ErrInvalidRiskRule
+		}
+		numbers = append(numbers, number)
+	}
+	switch {
+	case name == "velocity" && len(params) == 2:
+		window, err := time.ParseDuration(params[1])
+		if err != nil || window < 0 {
+			return nil, ErrInvalidRiskRule
+		}
+		return &VelocityRule{Max: int(numbers[0]), Window: window, Outcome: outcome}, nil
+	case name == "spike" && len(params) >= 1 && len(params) <= 3:
+		numbers = append(numbers, 0, 0)
+		return &SpikeRule{Factor: numbers[0], MinHistory: int(numbers[1]), Last: int(numbers[2]), Outcome: outcome}, nil
+	case name == "first_large" && len(params) == 1:
+		return &FirstLargeRule{Amount: types.Money(numbers[0]), Outcome: outcome}, nil
+	case name == "blacklist" && len(params) == 1 && params[0] != "":
+		rule := &BlacklistRule{Outcome: outcome}
+		for _, category := range strings.Split(params[0], ",") {
+			rule.Categories = append(rule.Categories, types.PaymentCategory(category))
+		}
+		return rule, nil
+	}
+	return nil, ErrInvalidRiskRule
+}
+
+//RiskDecisions returns the risk decisions on the payments of the account,
+//zero accountID returns all of them
+func (s *Service) RiskDecisions(accountID int64) []RiskDecision {
+	decisions := []RiskDecision{}
+	for _, decision := range s.riskDecisions {
+		if accountID == 0 || decision.AccountID == accountID {
+			decisions = append(decisions, *decision)
+		}
+	}
+	return decisions
+}
+
+// checkDebit проверка списаний без разбора: процентов и выплаты остатка.
+// Удержание для них тоже отказ — держать в очереди такие списания незачем
+func (s *Service) checkDebit(account *types.Account, amount types.Money, category types.PaymentCategory) (*RiskDecision, error) {
+	decision, err := s.checkRisk(account, amount, category, "")
+	if err != nil {
+		return nil, err
+	}
+	if decision != nil && decision.Outcome == RiskHold {
+		return nil, &RiskError{Outcome: decision.Outcome, Rule: decision.Rule, Reason: decision.Reason}
+	}
+	return decision, nil
+}
+
+// checkRisk прогоняет все правила, побеждает самый строгий исход, при равных — первое правило.
+// Запрет возвращается ошибкой, удержание — в решении, которое живёт до следующей проверки
+func (s *Service) checkRisk(account *types.Account, amount types.Money, category types.PaymentCategory, merchantID string) (*RiskDecision, error) {
+	if len(s.riskRules) == 0 {
+		return nil, nil
+	}
+	ctx := &RiskContext{
+		Account:    *account,
+		Amount:     amount,
+		Category:   category,
+		MerchantID: merchantID,
+		Now:        s.now(),
+		History:    []types.Payment{},
+	}
+	for _, payment := range s.payments {
+		if payment.AccountID == account.ID && payment.ParentID == "" && payment.Status != types.PaymentStatusFail {
+			ctx.History = append(ctx.History, *payment)
+		}
+	}
+	decision := &RiskDecision{
+		Time:       ctx.Now.Unix(),
+		AccountID:  account.ID,
+		Amount:     amount,
+		Category:   category,
+		MerchantID: merchantID,
+		Outcome:    RiskAllow,
+		Reason:     "all rules passed",
+	}
+	for _, rule := range s.riskRules {
+		result, reason := rule.Check(ctx)
+		if result.weight() > decision.Outcome.weight() {
+			decision.Outcome = result
+			decision.Rule = rule.Name()
+			decision.Reason = reason
+		}
+	}
+	// решение хранится по указателю, PaymentID проставляется после списания
+	s.riskDecisions = append(s.riskDecisions, decision)
+	if decision.Outcome == RiskAllow {
+		return decision, nil
+	}
+	s.record(AuditEntry{
+		Action: "risk." + strings.ToLower(string(decision.Outcome)),
+		Target: "account:" + strconv.FormatInt(account.ID, 10),
+		After:  riskState(decision),
+		Reason: decision.Rule + ": " + decision.Reason,
+	})
+	if decision.Outcome == RiskHold {
+		return decision, nil
+	}
+	return nil, &RiskError{Outcome: decision.Outcome, Rule: decision.Rule, Reason: decision.Reason}
+}
+
+func riskRuleState(rule RiskRule) string {
+	fields, ok := RiskRuleFields(rule)
+	if !ok {
+		return rule.Name()
+	}
+	return strings.Join(fields, " ")
+}
+
+func riskState(decision *RiskDecision) string {
+	return "amount=" + strconv.FormatInt(int64(decision.Amount), 10) +
+		" category=" + string(decision.Category) +
+		" merchant=" + decision.MerchantID
+}
+
+func (s *Service) exportRiskRules(dir string) error {
+	if s.riskRules == nil {
+		return nil
+	}
+	file, err := os.Create(dir + "/risk_rules.dump")
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	text := ""
+	for _, rule := range s.riskRules {
+		fields, ok := RiskRuleFields(rule)
+		if !ok {
+			log.Print("risk rule ", rule.Name(), " is code and is not exported")
+			continue
+		}
+		text += strings.Join(fields, ";") + ";\n"
+	}
+	_, err = file.Write([]byte(text))
+	return err
+}
+
+func (s *Service) importRiskRules(dir string) {
+	file, err := os.Open(dir + "/risk_rules.dump")
+	if err != nil {
+		log.Print(err)
+		return
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.Split(scanner.Text(), ";")
+		if len(line) < 3 {
+			continue
+		}
+		rule, err := ParseRiskRule(line[0], RiskOutcome(line[1]), line[2:len(line)-1])
+		if err != nil {
+			log.Print(err)
+			continue
+		}
+		// загрузка дампа не пишется в журнал аудита
+		replaced := false
+		for i, r := range s.riskRules {
+			if r.Name() == rule.Name() {
+				s.riskRules[i] = rule
+				replaced = true
+			}
+		}
+		if !replaced {
+			s.riskRules = append(s.riskRules, rule)
+		}
+	}
+}
+
+func (s *Service) exportRiskDecisions(dir string) error {
+	if s.riskDecisions == nil {
+		return nil
+	}
+	file, err := os.Create(dir + "/risk.dump")
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	text := ""
+	for _, decision := range s.riskDecisions {
+		text += strconv.FormatInt(decision.Time, 10) + ";" +
+			strconv.FormatInt(decision.AccountID, 10) + ";" +
+			strconv.FormatInt(int64(decision.Amount), 10) + ";" +
+			string(decision.Category) + ";" +
+			decision.MerchantID + ";" +
+			string(decision.Outcome) + ";" +
+			decision.Rule + ";" +
+			auditField(decision.Reason) + ";" +
+			decision.PaymentID + ";\n"
+	}
+	_, err = file.Write([]byte(text))
+	return err
+}
+
+func (s *Service) importRiskDecisions(dir string) {
+	if len(s.riskDecisions) > 0 {
+		log.Print("risk decisions are not empty, risk.dump is not imported")
+		return
+	}
+	file, err := os.Open(dir + "/risk.dump")
+	if err != nil {
+		log.Print(err)
+		return
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.Split(scanner.Text(), ";")
+		if len(line) < 8 {
+			continue
+		}
+		created, _ := strconv.ParseInt(line[0], 10, 64)
+		accountID, _ := strconv.ParseInt(line[1], 10, 64)
+		amount, _ := strconv.ParseInt(line[2], 10, 64)
+		var paymentID string
+		if len(line) > 8 {
+			paymentID = line[8]
+		}
+		s.riskDecisions = append(s.riskDecisions, &RiskDecision{
+			PaymentID:  paymentID,
+			Time:       created,
+			AccountID:  accountID,
+			Amount:     types.Money(amount),
+			Category:   types.PaymentCategory(line[3]),
+			MerchantID: line[4],
+			Outcome:    RiskOutcome(line[5]),
+			Rule:       line[6],
+			Reason:     line[7],
+		})
+	}
+}
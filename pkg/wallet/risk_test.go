@@ -0,0 +1,126 @@
+package wallet
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/SsSJKK/wallet/pkg/types"
+)
+
+type stopAll struct{}
+
+func (stopAll) Name() string { return "stop_all" }
+
+func (stopAll) Check(ctx *RiskContext) (RiskOutcome, string) {
+	if ctx.Account.Balance < 100 {
+		return RiskDeny, "low balance"
+	}
+	return RiskAllow, ""
+}
+
+func Test_Pay_Risk(t *testing.T) {
+	now := time.Date(2020, time.November, 3, 12, 0, 0, 0, time.UTC)
+	svc := &Service{}
+	svc.SetClock(func() time.Time { return now })
+	acc, _ := svc.RegisterAccount("992000000001")
+	svc.Deposit(acc.ID, 100000)
+	svc.AddRiskRule(&VelocityRule{Max: 3})
+	svc.AddRiskRule(&SpikeRule{Factor: 5})
+	svc.AddRiskRule(&FirstLargeRule{Amount: 1000})
+	svc.AddRiskRule(&BlacklistRule{Categories: []types.PaymentCategory{"casino"}})
+
+	_, err := svc.Pay(acc.ID, 10, "casino")
+	var riskErr *RiskError
+	if !errors.Is(err, ErrRiskDenied) || !errors.As(err, &riskErr) || riskErr.Rule != "blacklist" {
+		t.Fatalf("ERROR: %v need blacklist deny", err)
+	}
+	if acc.Balance != 100000 {
+		t.Errorf("ERROR: denied payment debited %v", acc.Balance)
+	}
+
+	svc.Pay(acc.ID, 100, "auto")
+	svc.Pay(acc.ID, 100, "auto")
+	svc.Pay(acc.ID, 100, "auto")
+	held := []*types.Payment{}
+	payment, err := svc.Pay(acc.ID, 100, "auto")
+	if err != nil || payment.Status != types.PaymentStatusReview {
+		t.Fatalf("ERROR: %v need velocity hold", err)
+	}
+	held = append(held, payment)
+	now = now.Add(time.Minute)
+	payment, err = svc.Pay(acc.ID, 600, "auto")
+	if err != nil || payment.Status != types.PaymentStatusReview {
+		t.Fatalf("ERROR: %v need spike hold", err)
+	}
+	held = append(held, payment)
+	if payment, err := svc.Pay(acc.ID, 500, "auto"); err != nil || payment.Status != types.PaymentStatusInProgress {
+		t.Errorf("ERROR: %v", err)
+	}
+	payment, err = svc.Pay(acc.ID, 1200, "travel")
+	if err != nil || payment.Status != types.PaymentStatusReview {
+		t.Fatalf("ERROR: %v need first_large hold", err)
+	}
+	held = append(held, payment)
+	if acc.Balance != 97300 {
+		t.Errorf("ERROR: balance %v need 97300, held payments are debited", acc.Balance)
+	}
+
+	queue := svc.ReviewQueue()
+	for i, rule := range []string{"velocity", "spike", "first_large"} {
+		if len(queue) != 3 || queue[i].PaymentID != held[i].ID || queue[i].Source != ReviewSourceRisk || queue[i].Reason[:len(rule)] != rule {
+			t.Errorf("ERROR: queue %+v", queue)
+		}
+	}
+
+	decisions := svc.RiskDecisions(acc.ID)
+	if len(decisions) != 8 || decisions[0].Outcome != RiskDeny || decisions[0].PaymentID != "" ||
+		decisions[1].Outcome != RiskAllow || decisions[1].Reason == "" || decisions[4].PaymentID != held[0].ID {
+		t.Errorf("ERROR: decisions %+v", decisions)
+	}
+
+	svc.AddRiskRule(stopAll{})
+	acc.Balance = 50
+	if _, err := svc.Pay(acc.ID, 10, "auto"); !errors.Is(err, ErrRiskDenied) {
+		t.Errorf("ERROR: custom rule %v", err)
+	}
+}
+
+func Test_RiskRules_ExportImport(t *testing.T) {
+	svc := &Service{}
+	acc, _ := svc.RegisterAccount("992000000001")
+	svc.Deposit(acc.ID, 1000)
+	svc.AddRiskRule(&VelocityRule{Max: 3, Window: time.Hour})
+	svc.AddRiskRule(&SpikeRule{Factor: 5, Outcome: RiskDeny})
+	svc.AddRiskRule(&BlacklistRule{Categories: []types.PaymentCategory{"casino", PayoutCategory}})
+	svc.AddRiskRule(&VelocityRule{Max: 5})
+	svc.AddRiskRule(stopAll{})
+	if rules := svc.RiskRules(); len(rules) != 4 || rules[0].(*VelocityRule).Max != 5 {
+		t.Errorf("ERROR: rules %+v", rules)
+	}
+
+	dir := t.TempDir()
+	if err := svc.Export(dir); err != nil {
+		t.Fatal(err)
+	}
+	imported := &Service{}
+	if err := imported.Import(dir); err != nil {
+		t.Fatal(err)
+	}
+	rules := imported.RiskRules()
+	if len(rules) != 3 || *rules[1].(*SpikeRule) != (SpikeRule{Factor: 5, Outcome: RiskDeny}) || len(rules[2].(*BlacklistRule).Categories) != 2 {
+		t.Errorf("ERROR: imported rules %+v", rules)
+	}
+
+	// выплата остатка тоже проходит проверку риска
+	if _, err := imported.CloseAccount(acc.ID, "test", true); !errors.Is(err, ErrRiskDenied) {
+		t.Errorf("ERROR: payout %v need %v", err, ErrRiskDenied)
+	}
+	imported.RemoveRiskRule("blacklist")
+	if payment, err := imported.CloseAccount(acc.ID, "test", true); err != nil || payment.Amount != 1000 {
+		t.Errorf("ERROR: payout %v %v", payment, err)
+	}
+	if _, err := ParseRiskRule("velocity", RiskHold, []string{"3"}); err != ErrInvalidRiskRule {
+		t.Errorf("ERROR: %v need %v", err, ErrInvalidRiskRule)
+	}
+}
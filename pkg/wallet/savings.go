@@ -0,0 +1,316 @@
+package wallet
+
+import (
+	"bufio"
+	"errors"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/SsSJKK/wallet/pkg/types"
+)
+
+//ErrInvalidSavingsTier err
+var ErrInvalidSavingsTier = errors.New("invalid savings tier")
+
+//SavingsScale accrued interest is kept in 1/SavingsScale of a minor unit
+const SavingsScale = 1_000_000
+
+const daysPerYear = 365
+
+//SavingsTier annual Rate in basis points (500 is 5%) for the part of the
+//balance from From up to the From of the next tier
+type SavingsTier struct {
+	From types.Money
+	Rate int64
+}
+
+//SavingsRun result of AccrueSavingsInterest. Accrued is in 1/SavingsScale of
+//a minor unit, Capitalized holds the deposits made at the end of the month.
+type SavingsRun struct {
+	Day         string
+	Accounts    int
+	Accrued     int64
+	Capitalized []types.Deposit
+}
+
+type savingsState struct {
+	Accrued int64
+	LastDay int64
+}
+
+//SetSavingsTiers replaces the tiers, they must go up by From
+func (s *Service) SetSavingsTiers(tiers []SavingsTier) error {
+	for i, tier := range tiers {
+		if tier.From < 0 || tier.Rate < 0 || (i > 0 && tier.From <= tiers[i-1].From) {
+			return ErrInvalidSavingsTier
+		}
+	}
+	s.record(AuditEntry{
+		Action: "savings.tiers",
+		Target: "savings",
+		Before: savingsTiersState(s.savingsTiers),
+		After:  savingsTiersState(tiers),
+	})
+	s.savingsTiers = append([]SavingsTier{}, tiers...)
+	return nil
+}
+
+//SavingsTiers returns a copy of the tiers
+func (s *Service) SavingsTiers() []SavingsTier {
+	return append([]SavingsTier{}, s.savingsTiers...)
+}
+
+//AccruedInterest returns the interest of the account not capitalized yet, in
+//1/SavingsScale of a minor unit
+func (s *Service) AccruedInterest(accountID int64) (int64, error) {
+	_, err := s.FindAccountByID(accountID)
+	if err != nil {
+		return 0, err
+	}
+	state, ok := s.savings[accountID]
+	if !ok {
+		return 0, nil
+	}
+	return state.Accrued, nil
+}
+
+//AccrueSavingsInterest accrues one day of interest on the positive balances
+//at the end of the day. Accounts already accrued for the day or a later one
+//are skipped, so running it twice for a day changes nothing. The first run
+//on or after the last day of a month deposits the whole minor units accrued
+//for the month, the fraction is carried over. Interest is calculated in
+//goroutines like SumPayments.
+func (s *Service) AccrueSavingsInterest(day time.Time, goroutines int) SavingsRun {
+	key := savingsDay(day)
+	run := SavingsRun{Day: day.Format("2006-01-02"), Capitalized: []types.Deposit{}}
+	year, month, date := day.Date()
+	end := time.Date(year, month, date+1, 0, 0, 0, 0, day.Location()).Unix()
+	daily := s.dailyInterest(key, s.balanceChangesAfter(end), goroutines)
+
+	if s.savings == nil {
+		s.savings = map[int64]*savingsState{}
+	}
+	payouts := map[int64]int64{}
+	for i, account := range s.accounts {
+		if daily[i] < 0 {
+			continue
+		}
+		state, ok := s.savings[account.ID]
+		if !ok {
+			state = &savingsState{}
+			s.savings[account.ID] = state
+		}
+		// конец прошлого месяца пропущен: выплачиваем начисленное до сегодняшнего дня
+		if ok && savingsMonth(state.LastDay) < savingsMonth(key) && !monthEnd(state.LastDay) {
+			payouts[account.ID] = state.Accrued / SavingsScale
+			state.Accrued -= payouts[account.ID] * SavingsScale
+		}
+		state.Accrued += daily[i]
+		state.LastDay = key
+		if monthEnd(key) {
+			amount := state.Accrued / SavingsScale
+			payouts[account.ID] += amount
+			state.Accrued -= amount * SavingsScale
+		}
+		run.Accounts++
+		run.Accrued += daily[i]
+	}
+	if run.Accounts > 0 {
+		s.record(AuditEntry{
+			Action: "savings.accrue",
+			Target: "day:" + run.Day,
+			After:  "accounts=" + strconv.Itoa(run.Accounts) + " accrued=" + strconv.FormatInt(run.Accrued, 10),
+		})
+	}
+
+	if len(payouts) == 0 {
+		return run
+	}
+	defer s.enter(s.caller, "savings interest "+day.Format("2006-01"))()
+	for _, account := range s.accounts {
+		amount := payouts[account.ID]
+		if amount <= 0 {
+			continue
+		}
+		deposit := s.deposit(account, types.Money(amount))
+		run.Capitalized = append(run.Capitalized, *deposit)
+	}
+	return run
+}
+
+// balanceChangesAfter на сколько изменились балансы счетов после момента end;
+// отменённые платежи баланс не меняют
+func (s *Service) balanceChangesAfter(end int64) map[int64]types.Money {
+	changes := map[int64]types.Money{}
+	for _, deposit := range s.deposits {
+		if deposit.Time >= end {
+			changes[deposit.AccountID] += deposit.Amount
+		}
+	}
+	for _, payment := range s.payments {
+		if payment.Time >= end && payment.Status != types.PaymentStatusFail {
+			changes[payment.AccountID] -= payment.Amount
+		}
+	}
+	return changes
+}
+
+// dailyInterest проценты за день по каждому счёту в порядке s.accounts,
+// -1 у счетов, по которым день уже начислен; changes — изменения балансов
+// после конца дня
+func (s *Service) dailyInterest(key int64, changes map[int64]types.Money, goroutines int) []int64 {
+	result := make([]int64, len(s.accounts))
+	if goroutines < 1 {
+		goroutines = 1
+	}
+	wg := sync.WaitGroup{}
+	count := len(s.accounts) / goroutines
+
+	part := func(from int, to int) {
+		defer wg.Done()
+		for j := from; j < to; j++ {
+			account := s.accounts[j]
+			state, ok := s.savings[account.ID]
+			if ok && state.LastDay >= key || accountStatus(account) == types.AccountStatusClosed {
+				result[j] = -1
+				continue
+			}
+			result[j] = s.savingsInterest(account.Balance - changes[account.ID])
+		}
+	}
+
+	i := 0
+	for i = 0; i < goroutines-1; i++ {
+		wg.Add(1)
+		go part(i*count, (i+1)*count)
+	}
+	wg.Add(1)
+	go part(i*count, len(s.accounts))
+	wg.Wait()
+	return result
+}
+
+func (s *Service) savingsInterest(balance types.Money) int64 {
+	interest := int64(0)
+	for i, tier := range s.savingsTiers {
+		if balance <= tier.From {
+			break
+		}
+		top := balance
+		if i+1 < len(s.savingsTiers) && s.savingsTiers[i+1].From < top {
+			top = s.savingsTiers[i+1].From
+		}
+		interest += int64(top-tier.From) * tier.Rate * (SavingsScale / 10000) / daysPerYear
+	}
+	return interest
+}
+
+// savingsDay номер дня от 1970-01-01 по календарной дате
+func savingsDay(day time.Time) int64 {
+	year, month, date := day.Date()
+	return time.Date(year, month, date, 0, 0, 0, 0, time.UTC).Unix() / secondsPerDay
+}
+
+// savingsMonth номер месяца дня key
+func savingsMonth(key int64) int64 {
+	year, month, _ := time.Unix(key*secondsPerDay, 0).UTC().Date()
+	return int64(year)*12 + int64(month)
+}
+
+func monthEnd(key int64) bool {
+	return savingsMonth(key) != savingsMonth(key+1)
+}
+
+func savingsTiersState(tiers []SavingsTier) string {
+	parts := []string{}
+	for _, tier := range tiers {
+		parts = append(parts, strconv.FormatInt(int64(tier.From), 10)+":"+strconv.FormatInt(tier.Rate, 10))
+	}
+	return strings.Join(parts, " ")
+}
+
+func (s *Service) exportSavings(dir string) error {
+	if s.savingsTiers != nil {
+		file, err := os.Create(dir + "/savings_tiers.dump")
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		text := ""
+		for _, tier := range s.savingsTiers {
+			text += strconv.FormatInt(int64(tier.From), 10) + ";" + strconv.FormatInt(tier.Rate, 10) + ";\n"
+		}
+		_, err = file.Write([]byte(text))
+		if err != nil {
+			return err
+		}
+	}
+	if s.savings != nil {
+		file, err := os.Create(dir + "/savings.dump")
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		text := ""
+		for _, account := range s.accounts {
+			state, ok := s.savings[account.ID]
+			if !ok {
+				continue
+			}
+			text += strconv.FormatInt(account.ID, 10) + ";" +
+				strconv.FormatInt(state.Accrued, 10) + ";" +
+				strconv.FormatInt(state.LastDay, 10) + ";\n"
+		}
+		_, err = file.Write([]byte(text))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *Service) importSavings(dir string) {
+	file, err := os.Open(dir + "/savings_tiers.dump")
+	if err != nil {
+		log.Print(err)
+	} else {
+		defer file.Close()
+		tiers := []SavingsTier{}
+		scanner := bufio.NewScanner(file)
+		for scanner.Scan() {
+			line := strings.Split(scanner.Text(), ";")
+			if len(line) < 2 {
+				continue
+			}
+			from, _ := strconv.ParseInt(line[0], 10, 64)
+			rate, _ := strconv.ParseInt(line[1], 10, 64)
+			tiers = append(tiers, SavingsTier{From: types.Money(from), Rate: rate})
+		}
+		s.savingsTiers = tiers
+	}
+
+	fileSavings, err := os.Open(dir + "/savings.dump")
+	if err != nil {
+		log.Print(err)
+		return
+	}
+	defer fileSavings.Close()
+	if s.savings == nil {
+		s.savings = map[int64]*savingsState{}
+	}
+	scanner := bufio.NewScanner(fileSavings)
+	for scanner.Scan() {
+		line := strings.Split(scanner.Text(), ";")
+		if len(line) < 3 {
+			continue
+		}
+		accountID, _ := strconv.ParseInt(line[0], 10, 64)
+		accrued, _ := strconv.ParseInt(line[1], 10, 64)
+		lastDay, _ := strconv.ParseInt(line[2], 10, 64)
+		s.savings[accountID] = &savingsState{Accrued: accrued, LastDay: lastDay}
+	}
+}
@@ -0,0 +1,92 @@
+package wallet
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/SsSJKK/wallet/pkg/types"
+)
+
+func Test_AccrueSavingsInterest(t *testing.T) {
+	now := time.Date(2020, time.November, 28, 12, 0, 0, 0, time.UTC)
+	svc := &Service{}
+	svc.SetClock(func() time.Time { return now })
+	rich, _ := svc.RegisterAccount("992000000001")
+	poor, _ := svc.RegisterAccount("992000000002")
+	empty, _ := svc.RegisterAccount("992000000003")
+	svc.Deposit(rich.ID, 20000)
+	svc.Deposit(poor.ID, 100)
+
+	if err := svc.SetSavingsTiers([]SavingsTier{{From: 100, Rate: 1}, {From: 100, Rate: 2}}); err != ErrInvalidSavingsTier {
+		t.Errorf("ERROR: %v need %v", err, ErrInvalidSavingsTier)
+	}
+	svc.SetSavingsTiers([]SavingsTier{{From: 0, Rate: 365}, {From: 10000, Rate: 730}})
+
+	day := time.Date(2020, time.November, 29, 0, 0, 0, 0, time.UTC)
+	run := svc.AccrueSavingsInterest(day, 2)
+	if run.Accounts != 3 || run.Accrued != 3*SavingsScale+SavingsScale/100 || len(run.Capitalized) != 0 {
+		t.Errorf("ERROR: run %+v", run)
+	}
+	if again := svc.AccrueSavingsInterest(day, 2); again.Accounts != 0 || again.Accrued != 0 {
+		t.Errorf("ERROR: second run for the day %+v", again)
+	}
+	if accrued, _ := svc.AccruedInterest(poor.ID); accrued != SavingsScale/100 {
+		t.Errorf("ERROR: poor accrued %v", accrued)
+	}
+
+	run = svc.AccrueSavingsInterest(day.AddDate(0, 0, 1), 3)
+	if len(run.Capitalized) != 1 || run.Capitalized[0].AccountID != rich.ID || run.Capitalized[0].Amount != 6 {
+		t.Fatalf("ERROR: capitalized %+v", run.Capitalized)
+	}
+	if rich.Balance != 20006 || poor.Balance != 100 || empty.Balance != 0 {
+		t.Errorf("ERROR: balances %v %v %v", rich.Balance, poor.Balance, empty.Balance)
+	}
+	if accrued, _ := svc.AccruedInterest(poor.ID); accrued != SavingsScale/50 {
+		t.Errorf("ERROR: fraction must be carried over, got %v", accrued)
+	}
+}
+
+func Test_AccrueSavingsInterest_EndOfDay(t *testing.T) {
+	now := time.Date(2020, time.November, 28, 12, 0, 0, 0, time.UTC)
+	svc := &Service{}
+	svc.SetClock(func() time.Time { return now })
+	acc, _ := svc.RegisterAccount("992000000001")
+	svc.SetSavingsTiers([]SavingsTier{{From: 0, Rate: 3650}})
+	svc.Deposit(acc.ID, 10000)
+
+	// начисление за 29-е идёт после пополнения 30-го — считается баланс на конец 29-го
+	now = time.Date(2020, time.November, 30, 9, 0, 0, 0, time.UTC)
+	svc.Deposit(acc.ID, 90000)
+	day := time.Date(2020, time.November, 29, 0, 0, 0, 0, time.UTC)
+	if run := svc.AccrueSavingsInterest(day, 1); run.Accrued != 10*SavingsScale {
+		t.Errorf("ERROR: accrued %v on the balance at the end of the day", run.Accrued)
+	}
+
+	// 30-е пропущено, выплата в первый запуск после конца месяца
+	run := svc.AccrueSavingsInterest(day.AddDate(0, 0, 2), 1)
+	if len(run.Capitalized) != 1 || run.Capitalized[0].Amount != 10 {
+		t.Fatalf("ERROR: capitalized %+v", run.Capitalized)
+	}
+	if accrued, _ := svc.AccruedInterest(acc.ID); accrued != 100*SavingsScale {
+		t.Errorf("ERROR: accrued %v, December interest must stay", accrued)
+	}
+}
+
+func Test_AccrueSavingsInterest_Goroutines(t *testing.T) {
+	day := time.Date(2020, time.November, 3, 0, 0, 0, 0, time.UTC)
+	results := []int64{}
+	for _, goroutines := range []int{1, 3, 7} {
+		svc := &Service{}
+		svc.SetClock(func() time.Time { return day })
+		for i := 0; i < 50; i++ {
+			acc, _ := svc.RegisterAccount(types.Phone(fmt.Sprintf("99200000%04d", i)))
+			svc.Deposit(acc.ID, types.Money(1000+i*37))
+		}
+		svc.SetSavingsTiers([]SavingsTier{{From: 0, Rate: 250}, {From: 1500, Rate: 500}})
+		results = append(results, svc.AccrueSavingsInterest(day, goroutines).Accrued)
+	}
+	if results[0] != results[1] || results[0] != results[2] {
+		t.Errorf("ERROR: results depend on goroutines %v", results)
+	}
+}
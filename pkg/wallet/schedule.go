@@ -0,0 +1,338 @@
+package wallet
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/SsSJKK/wallet/pkg/types"
+)
+
+//ErrScheduleNotFound err
+var ErrScheduleNotFound = errors.New("schedule not found")
+
+//ErrInvalidSchedule err
+var ErrInvalidSchedule = errors.New("invalid schedule")
+
+//ScheduleRun result of one attempt to pay by schedule
+type ScheduleRun struct {
+	ScheduleID string
+	Payment    *types.Payment
+	Time       int64
+	Err        error
+	Retry      bool
+}
+
+//ScheduleFavorite attaches a schedule to the favorite. Kind, Due (first run),
+//Day (for monthly schedules), End, Count, RetryLimit and RetryInterval
+//(seconds) are taken from the template.
+func (s *Service) ScheduleFavorite(favoriteID string, template types.Schedule) (*types.Schedule, error) {
+	_, err := s.FindFavoriteByID(favoriteID)
+	if err != nil {
+		return nil, err
+	}
+	switch template.Kind {
+	case types.ScheduleOnce, types.ScheduleDaily, types.ScheduleWeekly:
+	case types.ScheduleMonthly:
+		if template.Day < 1 || template.Day > 31 {
+			return nil, ErrInvalidSchedule
+		}
+	default:
+		return nil, ErrInvalidSchedule
+	}
+	if template.Count < 0 || template.RetryLimit < 0 || template.RetryInterval < 0 {
+		return nil, ErrInvalidSchedule
+	}
+	if template.Due == 0 {
+		template.Due = s.now().Unix()
+	}
+	if template.End != 0 && template.End < template.Due {
+		return nil, ErrInvalidSchedule
+	}
+
+	schedule := &types.Schedule{
+		ID:            uuid.New().String(),
+		FavoriteID:    favoriteID,
+		Kind:          template.Kind,
+		Day:           template.Day,
+		Due:           template.Due,
+		End:           template.End,
+		Count:         template.Count,
+		RetryLimit:    template.RetryLimit,
+		RetryInterval: template.RetryInterval,
+		Active:        true,
+	}
+	if schedule.Kind == types.ScheduleMonthly {
+		schedule.Due = monthDay(time.Unix(schedule.Due, 0).In(s.now().Location()), schedule.Day, 0).Unix()
+		if schedule.Due < template.Due {
+			schedule.Due = monthDay(time.Unix(template.Due, 0).In(s.now().Location()), schedule.Day, 1).Unix()
+		}
+	}
+	schedule.Next = schedule.Due
+	s.schedules = append(s.schedules, schedule)
+	s.record(AuditEntry{
+		Action: "schedule.create",
+		Target: "schedule:" + schedule.ID,
+		After:  "favorite=" + favoriteID + " kind=" + string(schedule.Kind) + " " + scheduleState(schedule),
+	})
+	return schedule, nil
+}
+
+//FindScheduleByID meth
+func (s *Service) FindScheduleByID(scheduleID string) (*types.Schedule, error) {
+	for _, schedule := range s.schedules {
+		if schedule.ID == scheduleID {
+			return schedule, nil
+		}
+	}
+	return nil, ErrScheduleNotFound
+}
+
+//Schedules returns schedules attached to the favorite
+func (s *Service) Schedules(favoriteID string) []types.Schedule {
+	schedules := []types.Schedule{}
+	for _, schedule := range s.schedules {
+		if schedule.FavoriteID == favoriteID {
+			schedules = append(schedules, *schedule)
+		}
+	}
+	return schedules
+}
+
+//CancelSchedule stops the schedule, it stays in history as inactive
+func (s *Service) CancelSchedule(scheduleID string) error {
+	schedule, err := s.FindScheduleByID(scheduleID)
+	if err != nil {
+		return err
+	}
+	before := scheduleState(schedule)
+	schedule.Active = false
+	s.record(AuditEntry{Action: "schedule.cancel", Target: "schedule:" + scheduleID, Before: before, After: scheduleState(schedule)})
+	return nil
+}
+
+//RunDueSchedules pays every schedule that is due at the service clock time.
+//Periods missed while the scheduler was not running are not paid in a burst:
+//only the latest due period is paid, the older ones are skipped and count
+//towards Count. A payment that failed and is not retried counts as well:
+//Count limits due periods, not successful payments.
+func (s *Service) RunDueSchedules() []ScheduleRun {
+	now := s.now().Unix()
+	runs := []ScheduleRun{}
+	for _, schedule := range s.schedules {
+		for schedule.Active && schedule.Next <= now {
+			s.skipMissed(schedule, now)
+			run := ScheduleRun{ScheduleID: schedule.ID, Time: now}
+			before := scheduleState(schedule)
+			leave := s.enter(s.caller, "schedule "+schedule.ID)
+			run.Payment, run.Err = s.PayFromFavorite(schedule.FavoriteID)
+			leave()
+			if run.Err == ErrNotEnoughBalance && schedule.Attempt < schedule.RetryLimit {
+				schedule.Attempt++
+				schedule.Next = now + schedule.RetryInterval
+				run.Retry = true
+				s.record(AuditEntry{Action: "schedule.retry", Target: "schedule:" + schedule.ID, Before: before, After: scheduleState(schedule)})
+				runs = append(runs, run)
+				if schedule.RetryInterval == 0 {
+					break
+				}
+				continue
+			}
+			runs = append(runs, run)
+			s.advanceSchedule(schedule)
+			s.record(AuditEntry{Action: "schedule.run", Target: "schedule:" + schedule.ID, Before: before, After: scheduleState(schedule)})
+		}
+	}
+	return runs
+}
+
+func scheduleState(schedule *types.Schedule) string {
+	return "next=" + strconv.FormatInt(schedule.Next, 10) +
+		" runs=" + strconv.Itoa(schedule.Runs) +
+		" attempt=" + strconv.Itoa(schedule.Attempt) +
+		" active=" + strconv.FormatBool(schedule.Active)
+}
+
+// skipMissed пропускает периоды, наступившие до последнего: после простоя платим только за последний
+func (s *Service) skipMissed(schedule *types.Schedule, now int64) {
+	for {
+		due, ok := s.followingDue(schedule)
+		if !ok || due > now || (schedule.End > 0 && due > schedule.End) || (schedule.Count > 0 && schedule.Runs+1 >= schedule.Count) {
+			return
+		}
+		before := scheduleState(schedule)
+		s.advanceSchedule(schedule)
+		s.record(AuditEntry{Action: "schedule.skip", Target: "schedule:" + schedule.ID, Before: before, After: scheduleState(schedule)})
+	}
+}
+
+// followingDue срок периода после текущего, для разовых расписаний его нет
+func (s *Service) followingDue(schedule *types.Schedule) (int64, bool) {
+	due := time.Unix(schedule.Due, 0).In(s.now().Location())
+	switch schedule.Kind {
+	case types.ScheduleDaily:
+		due = due.AddDate(0, 0, 1)
+	case types.ScheduleWeekly:
+		due = due.AddDate(0, 0, 7)
+	case types.ScheduleMonthly:
+		due = monthDay(due, schedule.Day, 1)
+	default:
+		return 0, false
+	}
+	return due.Unix(), true
+}
+
+func (s *Service) advanceSchedule(schedule *types.Schedule) {
+	schedule.Runs++
+	schedule.Attempt = 0
+	due, ok := s.followingDue(schedule)
+	if ok {
+		schedule.Due = due
+	} else {
+		schedule.Active = false
+	}
+	schedule.Next = schedule.Due
+	if schedule.Count > 0 && schedule.Runs >= schedule.Count {
+		schedule.Active = false
+	}
+	if schedule.End > 0 && schedule.Due > schedule.End {
+		schedule.Active = false
+	}
+}
+
+//monthDay day of the month shifted by months from t, clamped to the month length
+func monthDay(t time.Time, day int, months int) time.Time {
+	first := time.Date(t.Year(), t.Month()+time.Month(months), 1, t.Hour(), t.Minute(), t.Second(), 0, t.Location())
+	last := first.AddDate(0, 1, -1).Day()
+	if day > last {
+		day = last
+	}
+	return first.AddDate(0, 0, day-1)
+}
+
+//Scheduler runs due schedules of the service periodically. Do, when set,
+//gives exclusive access to the service, e.g. the server's Do.
+type Scheduler struct {
+	Service  *Service
+	Interval time.Duration
+	OnRun    func(run ScheduleRun)
+	Do       func(fn func(svc *Service) error) error
+}
+
+//Tick runs due schedules once
+func (sch *Scheduler) Tick() {
+	tick := func(svc *Service) error {
+		for _, run := range svc.RunDueSchedules() {
+			if sch.OnRun != nil {
+				sch.OnRun(run)
+			}
+		}
+		return nil
+	}
+	if sch.Do != nil {
+		sch.Do(tick)
+		return
+	}
+	tick(sch.Service)
+}
+
+//Run ticks every Interval until the context is done
+func (sch *Scheduler) Run(ctx context.Context) {
+	interval := sch.Interval
+	if interval <= 0 {
+		interval = time.Minute
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		sch.Tick()
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (s *Service) exportSchedules(dir string) error {
+	if s.schedules == nil {
+		return nil
+	}
+	file, err := os.Create(dir + "/schedules.dump")
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	text := ""
+	for _, sch := range s.schedules {
+		text += sch.ID + ";" +
+			sch.FavoriteID + ";" +
+			string(sch.Kind) + ";" +
+			strconv.Itoa(sch.Day) + ";" +
+			strconv.FormatInt(sch.Due, 10) + ";" +
+			strconv.FormatInt(sch.End, 10) + ";" +
+			strconv.Itoa(sch.Count) + ";" +
+			strconv.Itoa(sch.Runs) + ";" +
+			strconv.Itoa(sch.RetryLimit) + ";" +
+			strconv.FormatInt(sch.RetryInterval, 10) + ";" +
+			strconv.Itoa(sch.Attempt) + ";" +
+			strconv.FormatInt(sch.Next, 10) + ";" +
+			strconv.FormatBool(sch.Active) + ";\n"
+	}
+	_, err = file.Write([]byte(text))
+	return err
+}
+
+func (s *Service) importSchedules(dir string) {
+	file, err := os.Open(dir + "/schedules.dump")
+	if err != nil {
+		log.Print(err)
+		return
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.Split(scanner.Text(), ";")
+		if len(line) < 13 {
+			continue
+		}
+		day, _ := strconv.Atoi(line[3])
+		due, _ := strconv.ParseInt(line[4], 10, 64)
+		end, _ := strconv.ParseInt(line[5], 10, 64)
+		count, _ := strconv.Atoi(line[6])
+		runs, _ := strconv.Atoi(line[7])
+		retryLimit, _ := strconv.Atoi(line[8])
+		retryInterval, _ := strconv.ParseInt(line[9], 10, 64)
+		attempt, _ := strconv.Atoi(line[10])
+		next, _ := strconv.ParseInt(line[11], 10, 64)
+		active, _ := strconv.ParseBool(line[12])
+		schedule := &types.Schedule{
+			ID:            line[0],
+			FavoriteID:    line[1],
+			Kind:          types.ScheduleKind(line[2]),
+			Day:           day,
+			Due:           due,
+			End:           end,
+			Count:         count,
+			Runs:          runs,
+			RetryLimit:    retryLimit,
+			RetryInterval: retryInterval,
+			Attempt:       attempt,
+			Next:          next,
+			Active:        active,
+		}
+		sch, err := s.FindScheduleByID(schedule.ID)
+		if err == nil {
+			*sch = *schedule
+			continue
+		}
+		s.schedules = append(s.schedules, schedule)
+	}
+}
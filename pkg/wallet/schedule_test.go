@@ -0,0 +1,191 @@
+package wallet
+
+import (
+	"testing"
+	"time"
+
+	"github.com/SsSJKK/wallet/pkg/types"
+)
+
+type fakeClock struct {
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	return c.now
+}
+
+func newScheduleService(t *testing.T, balance types.Money) (*Service, *fakeClock, *types.Favorite) {
+	clock := &fakeClock{now: time.Date(2020, time.January, 30, 9, 0, 0, 0, time.UTC)}
+	svc := &Service{}
+	svc.SetClock(clock.Now)
+	acc, _ := svc.RegisterAccount("992000000001")
+	svc.Deposit(acc.ID, 100)
+	pay, _ := svc.Pay(acc.ID, 100, "rent")
+	svc.Reject(pay.ID)
+	if balance < 100 {
+		svc.Pay(acc.ID, 100-balance, "other")
+	}
+	fav, err := svc.FavoritePayment(pay.ID, "rent")
+	if err != nil {
+		t.Fatalf("ERROR: %v", err)
+	}
+	return svc, clock, fav
+}
+
+func Test_Schedule_MonthlyCount(t *testing.T) {
+	svc, clock, fav := newScheduleService(t, 100)
+	acc, _ := svc.FindAccountByID(fav.AccountID)
+	svc.Deposit(acc.ID, 1000)
+	schedule, err := svc.ScheduleFavorite(fav.ID, types.Schedule{
+		Kind:  types.ScheduleMonthly,
+		Day:   31,
+		Due:   clock.now.Unix(),
+		Count: 2,
+	})
+	if err != nil {
+		t.Fatalf("ERROR: %v", err)
+	}
+	if time.Unix(schedule.Due, 0).UTC().Day() != 31 {
+		t.Errorf("ERROR: due %v", time.Unix(schedule.Due, 0).UTC())
+	}
+
+	scheduler := &Scheduler{Service: svc}
+	scheduler.Tick()
+	if schedule.Runs != 0 {
+		t.Errorf("ERROR: runs %v need 0", schedule.Runs)
+	}
+
+	clock.now = time.Date(2020, time.January, 31, 9, 0, 0, 0, time.UTC)
+	scheduler.Tick()
+	if schedule.Runs != 1 {
+		t.Errorf("ERROR: runs %v need 1", schedule.Runs)
+	}
+	if due := time.Unix(schedule.Due, 0).UTC(); due.Month() != time.February || due.Day() != 29 {
+		t.Errorf("ERROR: next due %v", due)
+	}
+
+	clock.now = time.Date(2020, time.June, 1, 9, 0, 0, 0, time.UTC)
+	scheduler.Tick()
+	if schedule.Runs != 2 || schedule.Active {
+		t.Errorf("ERROR: runs %v active %v", schedule.Runs, schedule.Active)
+	}
+	if acc.Balance != 900 {
+		t.Errorf("ERROR: balance %v need 900", acc.Balance)
+	}
+}
+
+func Test_Schedule_RetryNotEnoughBalance(t *testing.T) {
+	svc, clock, fav := newScheduleService(t, 50)
+	schedule, _ := svc.ScheduleFavorite(fav.ID, types.Schedule{
+		Kind:          types.ScheduleDaily,
+		RetryLimit:    1,
+		RetryInterval: 3600,
+	})
+
+	var runs []ScheduleRun
+	scheduler := &Scheduler{Service: svc, OnRun: func(run ScheduleRun) {
+		runs = append(runs, run)
+	}}
+	scheduler.Tick()
+	if len(runs) != 1 || !runs[0].Retry || runs[0].Err != ErrNotEnoughBalance {
+		t.Fatalf("ERROR: runs %v", runs)
+	}
+
+	svc.Deposit(fav.AccountID, 50)
+	clock.now = clock.now.Add(time.Hour)
+	scheduler.Tick()
+	if len(runs) != 2 || runs[1].Err != nil || runs[1].Payment == nil {
+		t.Fatalf("ERROR: runs %v", runs)
+	}
+	if schedule.Runs != 1 || schedule.Attempt != 0 {
+		t.Errorf("ERROR: runs %v attempt %v", schedule.Runs, schedule.Attempt)
+	}
+}
+
+func Test_Schedule_SkipMissed(t *testing.T) {
+	svc, clock, fav := newScheduleService(t, 100)
+	acc, _ := svc.FindAccountByID(fav.AccountID)
+	svc.Deposit(acc.ID, 1000)
+	schedule, _ := svc.ScheduleFavorite(fav.ID, types.Schedule{Kind: types.ScheduleDaily})
+	svc.RunDueSchedules()
+
+	clock.now = clock.now.AddDate(0, 0, 5).Add(time.Hour)
+	runs := svc.RunDueSchedules()
+	if len(runs) != 1 || runs[0].Err != nil {
+		t.Fatalf("ERROR: runs %v", runs)
+	}
+	if acc.Balance != 900 || schedule.Runs != 6 {
+		t.Errorf("ERROR: balance %v runs %v", acc.Balance, schedule.Runs)
+	}
+	if schedule.Next != clock.now.Add(-time.Hour).AddDate(0, 0, 1).Unix() {
+		t.Errorf("ERROR: next %v", time.Unix(schedule.Next, 0).UTC())
+	}
+}
+
+func Test_Schedule_EndDateAndCancel(t *testing.T) {
+	svc, clock, fav := newScheduleService(t, 100)
+	schedule, _ := svc.ScheduleFavorite(fav.ID, types.Schedule{
+		Kind: types.ScheduleWeekly,
+		End:  clock.now.AddDate(0, 0, 10).Unix(),
+	})
+	svc.RunDueSchedules()
+	clock.now = clock.now.AddDate(0, 0, 7)
+	svc.RunDueSchedules()
+	if schedule.Active {
+		t.Errorf("ERROR: schedule must end after %v", time.Unix(schedule.End, 0))
+	}
+
+	_, err := svc.ScheduleFavorite(fav.ID, types.Schedule{Kind: "HOURLY"})
+	if err != ErrInvalidSchedule {
+		t.Errorf("ERROR: %v need %v", err, ErrInvalidSchedule)
+	}
+	if svc.CancelSchedule("unknown") != ErrScheduleNotFound {
+		t.Errorf("ERROR: cancel unknown schedule")
+	}
+}
+
+func Test_Schedule_ExportImport(t *testing.T) {
+	dir := t.TempDir()
+	svc, _, fav := newScheduleService(t, 100)
+	schedule, _ := svc.ScheduleFavorite(fav.ID, types.Schedule{Kind: types.ScheduleMonthly, Day: 5, Count: 3})
+	if err := svc.Export(dir); err != nil {
+		t.Fatalf("ERROR: %v", err)
+	}
+
+	imported := &Service{}
+	imported.Import(dir)
+	got, err := imported.FindScheduleByID(schedule.ID)
+	if err != nil || *got != *schedule {
+		t.Errorf("ERROR: %v %v need %v", err, got, schedule)
+	}
+}
+
+func Test_Schedule_CountWithDowntime(t *testing.T) {
+	svc, clock, fav := newScheduleService(t, 0)
+	acc, _ := svc.FindAccountByID(fav.AccountID)
+	svc.Deposit(acc.ID, 100)
+	schedule, _ := svc.ScheduleFavorite(fav.ID, types.Schedule{Kind: types.ScheduleDaily, Count: 5})
+	svc.RunDueSchedules()
+	// второй период не оплачен: денег нет, повторов нет, но период прошёл
+	clock.now = clock.now.AddDate(0, 0, 1)
+	if runs := svc.RunDueSchedules(); len(runs) != 1 || runs[0].Err != ErrNotEnoughBalance || runs[0].Retry {
+		t.Fatalf("ERROR: runs %v", runs)
+	}
+	if schedule.Runs != 2 {
+		t.Errorf("ERROR: runs %v need 2", schedule.Runs)
+	}
+
+	svc.Deposit(acc.ID, 1000)
+	clock.now = clock.now.AddDate(0, 0, 10)
+	runs := svc.RunDueSchedules()
+	if len(runs) != 1 || runs[0].Err != nil {
+		t.Fatalf("ERROR: runs %v", runs)
+	}
+	if schedule.Runs != 5 || schedule.Active {
+		t.Errorf("ERROR: runs %v active %v", schedule.Runs, schedule.Active)
+	}
+	if acc.Balance != 900 {
+		t.Errorf("ERROR: balance %v need 900", acc.Balance)
+	}
+}
@@ -9,6 +9,7 @@ import (
 	"strconv"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/google/uuid"
 
@@ -30,6 +31,9 @@ var ErrNotEnoughBalance = errors.New("not enough balance")
 //ErrPaymentNotFound err
 var ErrPaymentNotFound = errors.New("payment not found")
 
+//ErrPaymentNotRejectable err
+var ErrPaymentNotRejectable = errors.New("only INPROGRESS and REVIEW payments can be rejected")
+
 //ErrFavoriteNotFound err
 var ErrFavoriteNotFound = errors.New("favorite not found")
 
@@ -39,6 +43,34 @@ type Service struct {
 	accounts      []*types.Account
 	payments      []*types.Payment
 	favorites     []*types.Favorite
+	deposits      []*types.Deposit
+	limits        []*Limit
+	fees          []*FeeRule
+	rewardRules   []*RewardRule
+	rewards       []*RewardMovement
+	pointValue    int64
+	credits       []*CreditLine
+	savingsTiers  []SavingsTier
+	savings       map[int64]*savingsState
+	merchants     []*types.Merchant
+	settlements   []*SettlementBatch
+	riskRules     []RiskRule
+	riskDecisions []*RiskDecision
+	reviews       []*ReviewItem
+	schedules     []*types.Schedule
+	events        *EventBus
+	credentials   map[int64]*credential
+	sessions      map[string]*Session
+	staff         map[string]*credential
+	denials       []Denial
+	audit         []*AuditEntry
+	frozenPolicy  FrozenPolicy
+	country       string
+	codeSender    CodeSender
+	challenges    map[string]*challenge
+	caller        *Principal
+	reason        string
+	clock         func() time.Time
 }
 
 type Progress struct {
@@ -46,10 +78,26 @@ type Progress struct {
 	Result types.Money
 }
 
-//RegisterAccount meth
+//SetClock sets the time source used for payment and deposit timestamps
+func (s *Service) SetClock(clock func() time.Time) {
+	s.clock = clock
+}
+
+func (s *Service) now() time.Time {
+	if s.clock == nil {
+		return time.Now()
+	}
+	return s.clock()
+}
+
+//RegisterAccount registers the phone in E.164 form, see NormalizePhone
 func (s *Service) RegisterAccount(phone types.Phone) (*types.Account, error) {
+	phone, err := s.NormalizePhone(phone)
+	if err != nil {
+		return nil, err
+	}
 	for _, account := range s.accounts {
-		if account.Phone == phone {
+		if s.phoneKey(account.Phone) == phone {
 			return nil, ErrPhoneRegistered
 		}
 	}
@@ -59,8 +107,16 @@ func (s *Service) RegisterAccount(phone types.Phone) (*types.Account, error) {
 		ID:      s.nextAccountID,
 		Phone:   phone,
 		Balance: 0,
+		Status:  types.AccountStatusActive,
 	}
 	s.accounts = append(s.accounts, account)
+	s.record(AuditEntry{
+		Action: "account.register",
+		Target: "account:" + strconv.FormatInt(account.ID, 10),
+		After:  accountState(account),
+	})
+	copied := *account
+	s.publish(Event{Type: EventAccountRegistered, AccountID: account.ID, Account: &copied})
 
 	return account, nil
 }
@@ -76,6 +132,28 @@ func (s *Service) FindAccountByID(accountID int64) (*types.Account, error) {
 	return nil, ErrAccountNotFound
 }
 
+//FindAccountByPhone finds the account by the phone in any notation
+//NormalizePhone accepts
+func (s *Service) FindAccountByPhone(phone types.Phone) (*types.Account, error) {
+	phone = s.phoneKey(phone)
+	for _, account := range s.accounts {
+		if s.phoneKey(account.Phone) == phone {
+			return account, nil
+		}
+	}
+
+	return nil, ErrAccountNotFound
+}
+
+//Accounts returns copies of all registered accounts
+func (s *Service) Accounts() []types.Account {
+	accounts := make([]types.Account, 0, len(s.accounts))
+	for _, account := range s.accounts {
+		accounts = append(accounts, *account)
+	}
+	return accounts
+}
+
 //Deposit meth
 func (s *Service) Deposit(accountID int64, amount types.Money) error {
 	if amount <= 0 {
@@ -86,14 +164,59 @@ func (s *Service) Deposit(accountID int64, amount types.Money) error {
 	if err != nil {
 		return ErrAccountNotFound
 	}
+	err = s.checkIncoming(account, s.frozenPolicy.AllowDeposits)
+	if err != nil {
+		return err
+	}
+	s.deposit(account, amount)
+	return nil
+}
 
+func (s *Service) deposit(account *types.Account, amount types.Money) *types.Deposit {
 	// зачисление средств пока не рассматриваем как платёж
+	before := accountState(account)
+	oldBalance := account.Balance
 	account.Balance += amount
-	return nil
+	deposit := &types.Deposit{
+		ID:        uuid.New().String(),
+		AccountID: account.ID,
+		Amount:    amount,
+		Time:      s.now().Unix(),
+	}
+	s.deposits = append(s.deposits, deposit)
+	s.record(AuditEntry{
+		Action: "deposit",
+		Target: "account:" + strconv.FormatInt(account.ID, 10),
+		Before: before,
+		After:  accountState(account) + " deposit=" + deposit.ID,
+	})
+	s.repayCredit(account, oldBalance)
+	copied := *deposit
+	s.publish(Event{Type: EventDepositMade, AccountID: account.ID, Deposit: &copied})
+	return deposit
+}
+
+//AccountDeposits returns deposits made to the account
+func (s *Service) AccountDeposits(accountID int64) ([]types.Deposit, error) {
+	_, err := s.FindAccountByID(accountID)
+	if err != nil {
+		return nil, err
+	}
+	deposits := []types.Deposit{}
+	for _, deposit := range s.deposits {
+		if deposit.AccountID == accountID {
+			deposits = append(deposits, *deposit)
+		}
+	}
+	return deposits, nil
 }
 
 //Pay meth
 func (s *Service) Pay(accountID int64, amount types.Money, category types.PaymentCategory) (*types.Payment, error) {
+	return s.pay(accountID, amount, category, "")
+}
+
+func (s *Service) pay(accountID int64, amount types.Money, category types.PaymentCategory, merchantID string) (*types.Payment, error) {
 	if amount <= 0 {
 		return nil, ErrAmountMustBePositive
 	}
@@ -108,21 +231,72 @@ func (s *Service) Pay(accountID int64, amount types.Money, category types.Paymen
 	if account == nil {
 		return nil, ErrAccountNotFound
 	}
+	err := checkOutgoing(account)
+	if err != nil {
+		return nil, err
+	}
 
-	if account.Balance < amount {
+	// удержанный платёж проходит все проверки, но ждёт ручного разбора в статусе REVIEW
+	var holdSource, holdReason string
+	err = s.checkLimits(accountID, amount, category)
+	var limitErr *LimitError
+	if errors.As(err, &limitErr) && limitErr.Hold {
+		holdSource, holdReason = ReviewSourceLimit, err.Error()
+	} else if err != nil {
+		return nil, err
+	}
+
+	// риск проверяет всё списание, платёж вместе с комиссией
+	fee := s.feeFor(accountID, OperationPay, amount, category)
+	decision, err := s.checkRisk(account, amount+fee, category, merchantID)
+	if err != nil {
+		return nil, err
+	}
+	if decision != nil && decision.Outcome == RiskHold && holdSource == "" {
+		holdSource, holdReason = ReviewSourceRisk, decision.Rule+": "+decision.Reason
+	}
+
+	if s.available(account) < amount+fee {
 		return nil, ErrNotEnoughBalance
 	}
+	status := types.PaymentStatusInProgress
+	if holdSource != "" {
+		status = types.PaymentStatusReview
+	}
 
+	before := accountState(account)
+	oldBalance := account.Balance
 	account.Balance -= amount
+	s.startOverdraft(account, oldBalance)
 	paymentID := uuid.New().String()
 	payment := &types.Payment{
-		ID:        paymentID,
-		AccountID: accountID,
-		Amount:    amount,
-		Category:  category,
-		Status:    types.PaymentStatusInProgress,
+		ID:         paymentID,
+		AccountID:  accountID,
+		Amount:     amount,
+		Category:   category,
+		Status:     status,
+		Time:       s.now().Unix(),
+		MerchantID: merchantID,
 	}
 	s.payments = append(s.payments, payment)
+	if decision != nil {
+		decision.PaymentID = paymentID
+	}
+	s.record(AuditEntry{
+		Action: "pay",
+		Target: "payment:" + paymentID,
+		Before: before,
+		After:  accountState(account) + " " + paymentState(payment),
+	})
+	s.publishPayment(EventPaymentCreated, payment, "")
+	if fee > 0 {
+		s.postFee(account, payment, fee)
+	}
+	if holdSource != "" {
+		s.holdForReview(payment, holdSource, holdReason)
+		return payment, nil
+	}
+	s.accrueRewards(account, payment)
 	return payment, nil
 }
 
@@ -137,20 +311,55 @@ func (s *Service) FindPaymentByID(paymentID string) (*types.Payment, error) {
 	return nil, ErrPaymentNotFound
 }
 
-//Reject meth
+//Reject fails the payment and returns its money together with the linked
+//fees, the rewards given for the payment are taken back. Only INPROGRESS and
+//REVIEW payments can be rejected.
 func (s *Service) Reject(paymentID string) error {
 	payment, err := s.FindPaymentByID(paymentID)
 	if err != nil {
 		return err
 	}
+	if payment.Status != types.PaymentStatusInProgress && payment.Status != types.PaymentStatusReview {
+		return ErrPaymentNotRejectable
+	}
 	account, err := s.FindAccountByID(payment.AccountID)
 	if err != nil {
 		return err
 	}
+	err = s.checkIncoming(account, s.frozenPolicy.AllowRefunds)
+	if err != nil {
+		return err
+	}
+
+	s.giveBack(account, payment)
+	return nil
+}
+
+// giveBack возвращает платёж вместе со связанными комиссиями и забирает награды
+func (s *Service) giveBack(account *types.Account, payment *types.Payment) {
+	s.refund(account, payment)
+	for _, linked := range s.payments {
+		if linked.ParentID == payment.ID && linked.Status != types.PaymentStatusFail {
+			s.refund(account, linked)
+		}
+	}
+	s.reverseRewards(account, payment.ID)
+	s.resolveReview(payment.ID, ReviewDeclined)
+}
 
+func (s *Service) refund(account *types.Account, payment *types.Payment) {
+	oldStatus := payment.Status
+	before := accountState(account) + " " + paymentState(payment)
 	payment.Status = types.PaymentStatusFail
+	payment.RejectedAt = s.now().Unix()
 	account.Balance += payment.Amount
-	return nil
+	s.record(AuditEntry{
+		Action: "reject",
+		Target: "payment:" + payment.ID,
+		Before: before,
+		After:  accountState(account) + " " + paymentState(payment),
+	})
+	s.publishPayment(EventPaymentStatusChanged, payment, oldStatus)
 }
 
 //Repeat meth
@@ -160,7 +369,7 @@ func (s *Service) Repeat(paymentID string) (*types.Payment, error) {
 		return nil, err
 	}
 
-	return s.Pay(payment.AccountID, payment.Amount, payment.Category)
+	return s.pay(payment.AccountID, payment.Amount, payment.Category, payment.MerchantID)
 }
 
 //FavoritePayment meth
@@ -169,6 +378,10 @@ func (s *Service) FavoritePayment(paymentID string, name string) (*types.Favorit
 	if err != nil {
 		return nil, err
 	}
+	err = s.checkFavoriteName(payment.AccountID, "", name)
+	if err != nil {
+		return nil, err
+	}
 
 	favorite := &types.Favorite{
 		ID:        uuid.New().String(),
@@ -179,6 +392,12 @@ func (s *Service) FavoritePayment(paymentID string, name string) (*types.Favorit
 	}
 
 	s.favorites = append(s.favorites, favorite)
+	s.record(AuditEntry{
+		Action: "favorite.add",
+		Target: "favorite:" + favorite.ID,
+		After:  favoriteState(favorite) + " payment=" + paymentID,
+	})
+	s.publishFavorite(favorite)
 	return favorite, nil
 }
 
@@ -277,9 +496,10 @@ func (s *Service) Export(dir string) error {
 		for _, acc := range s.accounts {
 			strID := strconv.FormatInt(int64(acc.ID), 10) + ";"
 			strPhone := acc.Phone + ";"
-			strBalance := strconv.FormatInt(int64(acc.Balance), 10)
+			strBalance := strconv.FormatInt(int64(acc.Balance), 10) + ";"
+			strStatus := string(accountStatus(acc)) + ";"
 
-			text += strID + string(strPhone) + strBalance + "\n"
+			text += strID + string(strPhone) + strBalance + strStatus + acc.StatusReason + "\n"
 		}
 
 		_, err = fileAccounts.Write([]byte(text))
@@ -300,8 +520,12 @@ func (s *Service) Export(dir string) error {
 			strAmount := strconv.FormatInt(int64(pay.Amount), 10) + ";"
 			strCategory := string(pay.Category) + ";"
 			strStatus := string(pay.Status) + ";"
+			strTime := strconv.FormatInt(pay.Time, 10) + ";"
+			strParentID := pay.ParentID + ";"
+			strMerchantID := pay.MerchantID + ";"
+			strRejectedAt := strconv.FormatInt(pay.RejectedAt, 10) + ";"
 
-			text += strID + strAccountID + strAmount + strCategory + strStatus + "\n"
+			text += strID + strAccountID + strAmount + strCategory + strStatus + strTime + strParentID + strMerchantID + strRejectedAt + "\n"
 		}
 
 		_, err = filePay.Write([]byte(text))
@@ -309,6 +533,27 @@ func (s *Service) Export(dir string) error {
 			return err
 		}
 	}
+	if s.deposits != nil {
+		fileDep, err := os.Create(dir + "/deposits.dump")
+		if err != nil {
+			return err
+		}
+		defer fileDep.Close()
+		text := ""
+		for _, dep := range s.deposits {
+			strID := dep.ID + ";"
+			strAccountID := strconv.FormatInt(int64(dep.AccountID), 10) + ";"
+			strAmount := strconv.FormatInt(int64(dep.Amount), 10) + ";"
+			strTime := strconv.FormatInt(dep.Time, 10) + ";"
+
+			text += strID + strAccountID + strAmount + strTime + "\n"
+		}
+
+		_, err = fileDep.Write([]byte(text))
+		if err != nil {
+			return err
+		}
+	}
 	if s.favorites != nil {
 		fileFav, err := os.Create(dir + "/favorites.dump")
 		if err != nil {
@@ -331,6 +576,62 @@ func (s *Service) Export(dir string) error {
 			return err
 		}
 	}
+	err := s.exportLimits(dir)
+	if err != nil {
+		return err
+	}
+	err = s.exportSchedules(dir)
+	if err != nil {
+		return err
+	}
+	err = s.exportCredentials(dir)
+	if err != nil {
+		return err
+	}
+	err = s.exportStaff(dir)
+	if err != nil {
+		return err
+	}
+	err = s.exportFees(dir)
+	if err != nil {
+		return err
+	}
+	err = s.exportRewards(dir)
+	if err != nil {
+		return err
+	}
+	err = s.exportCredits(dir)
+	if err != nil {
+		return err
+	}
+	err = s.exportSavings(dir)
+	if err != nil {
+		return err
+	}
+	err = s.exportMerchants(dir)
+	if err != nil {
+		return err
+	}
+	err = s.exportSettlements(dir)
+	if err != nil {
+		return err
+	}
+	err = s.exportRiskRules(dir)
+	if err != nil {
+		return err
+	}
+	err = s.exportRiskDecisions(dir)
+	if err != nil {
+		return err
+	}
+	err = s.exportReviews(dir)
+	if err != nil {
+		return err
+	}
+	err = s.exportAudit(dir)
+	if err != nil {
+		return err
+	}
 	return nil
 }
 
@@ -349,18 +650,27 @@ func (s *Service) Import(dir string) error {
 			ID, _ := strconv.ParseInt(line[0], 10, 64)
 			phone := types.Phone(line[1])
 			balance, _ := strconv.ParseInt(line[2], 10, 64)
+			// в старых дампах нет состояния счёта
+			status, reason := types.AccountStatusActive, ""
+			if len(line) > 4 && line[3] != "" {
+				status, reason = types.AccountStatus(line[3]), line[4]
+			}
 			acc, errAcc := s.FindAccountByID(ID)
 			if errAcc == nil {
 				acc.ID = ID
 				acc.Phone = phone
 				acc.Balance = types.Money(balance)
+				acc.Status = status
+				acc.StatusReason = reason
 			}
 
 			if errAcc != nil {
 				addAcc := &types.Account{
-					ID:      ID,
-					Phone:   phone,
-					Balance: types.Money(balance),
+					ID:           ID,
+					Phone:        phone,
+					Balance:      types.Money(balance),
+					Status:       status,
+					StatusReason: reason,
 				}
 				s.accounts = append(s.accounts, addAcc)
 			}
@@ -386,6 +696,22 @@ func (s *Service) Import(dir string) error {
 			amount, _ := strconv.ParseInt(line[2], 10, 64)
 			category := types.PaymentCategory(line[3])
 			status := types.PaymentStatus(line[4])
+			var created int64
+			if len(line) > 5 {
+				created, _ = strconv.ParseInt(line[5], 10, 64)
+			}
+			var parentID string
+			if len(line) > 6 {
+				parentID = line[6]
+			}
+			var merchantID string
+			if len(line) > 7 {
+				merchantID = line[7]
+			}
+			var rejectedAt int64
+			if len(line) > 8 {
+				rejectedAt, _ = strconv.ParseInt(line[8], 10, 64)
+			}
 			pay, err := s.FindPaymentByID(ID)
 			if err == nil {
 				pay.ID = ID
@@ -393,16 +719,48 @@ func (s *Service) Import(dir string) error {
 				pay.Amount = types.Money(amount)
 				pay.Category = category
 				pay.Status = status
+				pay.Time = created
+				pay.ParentID = parentID
+				pay.MerchantID = merchantID
+				pay.RejectedAt = rejectedAt
 			}
 			if err != nil {
 				addPay := &types.Payment{
+					ID:         ID,
+					AccountID:  accountID,
+					Amount:     types.Money(amount),
+					Category:   category,
+					Status:     status,
+					Time:       created,
+					ParentID:   parentID,
+					MerchantID: merchantID,
+					RejectedAt: rejectedAt,
+				}
+				s.payments = append(s.payments, addPay)
+			}
+		}
+	}
+
+	fileDep, err := os.Open(dir + "/deposits.dump")
+	if err != nil {
+		log.Print(err)
+	}
+	defer fileDep.Close()
+	if err == nil {
+		scanner := bufio.NewScanner(fileDep)
+		for scanner.Scan() {
+			line := strings.Split(scanner.Text(), ";")
+			ID := line[0]
+			accountID, _ := strconv.ParseInt(line[1], 10, 64)
+			amount, _ := strconv.ParseInt(line[2], 10, 64)
+			created, _ := strconv.ParseInt(line[3], 10, 64)
+			if s.findDeposit(ID) == nil {
+				s.deposits = append(s.deposits, &types.Deposit{
 					ID:        ID,
 					AccountID: accountID,
 					Amount:    types.Money(amount),
-					Category:  category,
-					Status:    status,
-				}
-				s.payments = append(s.payments, addPay)
+					Time:      created,
+				})
 			}
 		}
 	}
@@ -421,6 +779,10 @@ func (s *Service) Import(dir string) error {
 			amount, _ := strconv.ParseInt(line[2], 10, 64)
 			name := line[3]
 			category := types.PaymentCategory(line[4])
+			if s.checkFavoriteName(accountID, ID, name) == ErrFavoriteNameExists {
+				log.Printf("favorite %s skipped: %v", ID, ErrFavoriteNameExists)
+				continue
+			}
 
 			fav, err := s.FindFavoriteByID(ID)
 			if err == nil {
@@ -443,6 +805,28 @@ func (s *Service) Import(dir string) error {
 		}
 	}
 
+	s.importLimits(dir)
+	s.importFees(dir)
+	s.importRewards(dir)
+	s.importCredits(dir)
+	s.importSavings(dir)
+	s.importMerchants(dir)
+	s.importSettlements(dir)
+	s.importRiskRules(dir)
+	s.importRiskDecisions(dir)
+	s.importReviews(dir)
+	s.importSchedules(dir)
+	s.importCredentials(dir)
+	s.importStaff(dir)
+	return s.importAudit(dir)
+}
+
+func (s *Service) findDeposit(depositID string) *types.Deposit {
+	for _, deposit := range s.deposits {
+		if deposit.ID == depositID {
+			return deposit
+		}
+	}
 	return nil
 }
 
@@ -509,8 +893,12 @@ func (s *Service) PaymentsToFile(payments []types.Payment, path string) error {
 		strAmount := strconv.FormatInt(int64(pay.Amount), 10) + ";"
 		strCategory := string(pay.Category) + ";"
 		strStatus := string(pay.Status) + ";"
+		strTime := strconv.FormatInt(pay.Time, 10) + ";"
+		strParentID := pay.ParentID + ";"
+		strMerchantID := pay.MerchantID + ";"
+		strRejectedAt := strconv.FormatInt(pay.RejectedAt, 10) + ";"
 
-		text += strID + strAccountID + strAmount + strCategory + strStatus + "\n"
+		text += strID + strAccountID + strAmount + strCategory + strStatus + strTime + strParentID + strMerchantID + strRejectedAt + "\n"
 	}
 
 	log.Print(text)
@@ -650,11 +1038,15 @@ func (s *Service) FilterPaymentsByFn(filter func(payment types.Payment) bool, go
 			payments := s.payments[index*count : (index+1)*count]
 			for _, payment := range payments {
 				p := types.Payment{
-					ID:        payment.ID,
-					AccountID: payment.AccountID,
-					Amount:    payment.Amount,
-					Category:  payment.Category,
-					Status:    payment.Status,
+					ID:         payment.ID,
+					AccountID:  payment.AccountID,
+					Amount:     payment.Amount,
+					Category:   payment.Category,
+					Status:     payment.Status,
+					Time:       payment.Time,
+					ParentID:   payment.ParentID,
+					MerchantID: payment.MerchantID,
+					RejectedAt: payment.RejectedAt,
 				}
 
 				if filter(p) {
@@ -675,11 +1067,15 @@ func (s *Service) FilterPaymentsByFn(filter func(payment types.Payment) bool, go
 		for _, payment := range payments {
 
 			p := types.Payment{
-				ID:        payment.ID,
-				AccountID: payment.AccountID,
-				Amount:    payment.Amount,
-				Category:  payment.Category,
-				Status:    payment.Status,
+				ID:         payment.ID,
+				AccountID:  payment.AccountID,
+				Amount:     payment.Amount,
+				Category:   payment.Category,
+				Status:     payment.Status,
+				Time:       payment.Time,
+				ParentID:   payment.ParentID,
+				MerchantID: payment.MerchantID,
+				RejectedAt: payment.RejectedAt,
 			}
 
 			if filter(p) {
@@ -113,6 +113,18 @@ func Test_FindPaymentById_Fail(t *testing.T) {
 	}
 }
 
+func Test_Reject_Twice(t *testing.T) {
+	svc := &Service{}
+	account, _ := svc.RegisterAccount("992000000001")
+	svc.Deposit(1, 100)
+	payment, _ := svc.Pay(1, 20, "A")
+	svc.Reject(payment.ID)
+	err := svc.Reject(payment.ID)
+	if !reflect.DeepEqual(err, ErrPaymentNotRejectable) || account.Balance != 100 {
+		t.Errorf("ERROR %v, %v balance %v", err, ErrPaymentNotRejectable, account.Balance)
+	}
+}
+
 func Test_Repeat_OK(t *testing.T) {
 	svc := &Service{}
 	acc, _ := svc.RegisterAccount("992999999999")
@@ -181,7 +193,7 @@ func Test_Pay_Favorite_OK(t *testing.T) {
 
 func BenchmarkService_SumPayments(b *testing.B) {
 	s := newTestService()
-	acc, _ := s.RegisterAccount("1010")
+	acc, _ := s.RegisterAccount("992000001010")
 	s.Deposit(acc.ID, 100)
 	s.Pay(acc.ID, 10, "test")
 	s.Pay(acc.ID, 10, "test")
@@ -198,7 +210,7 @@ func BenchmarkService_SumPayments(b *testing.B) {
 
 func TestService_ExportToFile(t *testing.T) {
 	s := newTestService()
-	acc, _ := s.RegisterAccount("1010")
+	acc, _ := s.RegisterAccount("992000001010")
 	s.Deposit(acc.ID, 100)
 	pay, _ := s.Pay(acc.ID, 10, "test")
 	s.Pay(acc.ID, 10, "test")
@@ -215,7 +227,7 @@ func TestService_ImportFromFile(t *testing.T) {
 
 func TestService_Export(t *testing.T) {
 	s := newTestService()
-	acc, _ := s.RegisterAccount("1010")
+	acc, _ := s.RegisterAccount("992000001010")
 	s.Deposit(acc.ID, 100)
 	pay, _ := s.Pay(acc.ID, 10, "test")
 	s.Pay(acc.ID, 10, "test")
@@ -0,0 +1,333 @@
+package wallet
+
+import (
+	"bufio"
+	"errors"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/SsSJKK/wallet/pkg/types"
+)
+
+//ErrPaymentNotRefundable err
+var ErrPaymentNotRefundable = errors.New("only settled OK payments can be refunded")
+
+//OperationSettlement fee rules of this operation give the fee kept from the
+//merchant when its payments are settled
+const OperationSettlement OperationType = "SETTLEMENT"
+
+//SettlementBatch payments of one merchant settled together. AccountID is the
+//settlement account of the merchant, it gets Gross and pays Fee.
+type SettlementBatch struct {
+	ID         string
+	Day        string
+	MerchantID string
+	Category   types.PaymentCategory
+	AccountID  int64
+	Count      int
+	Gross      types.Money
+	Fee        types.Money
+	Net        types.Money
+	PaymentIDs []string
+	File       string
+}
+
+//Settle settles the merchant payments still in progress made before the end
+//of the day: they and their fees become OK, each batch credits the settlement
+//account of the merchant with the sum, charges the OperationSettlement fee as
+//a fee payment of that account and is written to dir as
+//settlement_YYYYMMDD_N.dump. Payments without a known merchant have nobody to
+//pay and stay in progress. Settled payments are not in progress any more, so
+//running it again for the day settles only the payments made since.
+func (s *Service) Settle(day time.Time, dir string) ([]SettlementBatch, error) {
+	year, month, date := day.Date()
+	end := time.Date(year, month, date+1, 0, 0, 0, 0, day.Location()).Unix()
+	dayName := day.Format("2006-01-02")
+
+	batches := []*SettlementBatch{}
+	keys := map[string]*SettlementBatch{}
+	for _, payment := range s.payments {
+		if payment.Status != types.PaymentStatusInProgress || payment.ParentID != "" || payment.Time >= end || payment.MerchantID == "" {
+			continue
+		}
+		batch, ok := keys[payment.MerchantID]
+		if !ok {
+			accountID := s.settlementAccount(payment.MerchantID)
+			if accountID == 0 {
+				continue
+			}
+			batch = &SettlementBatch{Day: dayName, MerchantID: payment.MerchantID, Category: payment.Category, AccountID: accountID}
+			keys[payment.MerchantID] = batch
+			batches = append(batches, batch)
+		}
+		batch.Count++
+		batch.Gross += payment.Amount
+		batch.PaymentIDs = append(batch.PaymentIDs, payment.ID)
+	}
+
+	seq := 0
+	for _, batch := range s.settlements {
+		if batch.Day == dayName {
+			seq++
+		}
+	}
+	for _, batch := range batches {
+		seq++
+		batch.ID = strings.ReplaceAll(dayName, "-", "") + "-" + strconv.Itoa(seq)
+		rule := s.feeRule(OperationSettlement, batch.Gross, batch.Category)
+		// общие правила без операции к мерчантам не относятся
+		if rule != nil && rule.Operation == OperationSettlement {
+			batch.Fee = rule.fee(batch.Gross)
+			if batch.Fee > batch.Gross {
+				batch.Fee = batch.Gross
+			}
+		}
+		batch.Net = batch.Gross - batch.Fee
+		batch.File = dir + "/settlement_" + strings.ReplaceAll(batch.ID, "-", "_") + ".dump"
+		// файл пишем до изменений, чтобы при ошибке ничего не провести
+		err := s.writeSettlement(batch)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	result := []SettlementBatch{}
+	for _, batch := range batches {
+		s.applySettlement(batch)
+		s.settlements = append(s.settlements, batch)
+		copied := *batch
+		copied.PaymentIDs = append([]string{}, batch.PaymentIDs...)
+		result = append(result, copied)
+	}
+	return result, nil
+}
+
+//Settlements returns copies of the settled batches
+func (s *Service) Settlements() []SettlementBatch {
+	result := make([]SettlementBatch, 0, len(s.settlements))
+	for _, batch := range s.settlements {
+		copied := *batch
+		copied.PaymentIDs = append([]string{}, batch.PaymentIDs...)
+		result = append(result, copied)
+	}
+	return result
+}
+
+//Refund returns a settled payment: the settlement account of the merchant
+//gives the amount back and the payment fails with its fees like Reject. The
+//settlement fee is not returned to the merchant.
+func (s *Service) Refund(paymentID string) error {
+	payment, err := s.FindPaymentByID(paymentID)
+	if err != nil {
+		return err
+	}
+	batch := s.settlementOf(paymentID)
+	if payment.Status != types.PaymentStatusOk || batch == nil {
+		return ErrPaymentNotRefundable
+	}
+	account, err := s.FindAccountByID(payment.AccountID)
+	if err != nil {
+		return err
+	}
+	err = s.checkIncoming(account, s.frozenPolicy.AllowRefunds)
+	if err != nil {
+		return err
+	}
+	merchant, err := s.FindAccountByID(batch.AccountID)
+	if err != nil {
+		return err
+	}
+	if s.available(merchant) < payment.Amount {
+		return ErrNotEnoughBalance
+	}
+
+	before := accountState(merchant)
+	oldBalance := merchant.Balance
+	merchant.Balance -= payment.Amount
+	s.startOverdraft(merchant, oldBalance)
+	s.record(AuditEntry{
+		Action: "settlement.reverse",
+		Target: "payment:" + paymentID,
+		Before: before,
+		After:  accountState(merchant),
+		Reason: "settlement " + batch.ID,
+	})
+	s.giveBack(account, payment)
+	return nil
+}
+
+// settlementAccount счёт мерчанта, 0 для неизвестного мерчанта
+func (s *Service) settlementAccount(merchantID string) int64 {
+	for _, merchant := range s.merchants {
+		if merchant.ID == merchantID {
+			return merchant.SettlementAccount
+		}
+	}
+	return 0
+}
+
+func (s *Service) settlementOf(paymentID string) *SettlementBatch {
+	for _, batch := range s.settlements {
+		for _, id := range batch.PaymentIDs {
+			if id == paymentID {
+				return batch
+			}
+		}
+	}
+	return nil
+}
+
+func (s *Service) applySettlement(batch *SettlementBatch) {
+	defer s.enter(s.caller, "settlement "+batch.ID)()
+	settled := map[string]bool{}
+	for _, id := range batch.PaymentIDs {
+		settled[id] = true
+	}
+	for _, payment := range s.payments {
+		if !settled[payment.ID] && !(settled[payment.ParentID] && payment.Status == types.PaymentStatusInProgress) {
+			continue
+		}
+		oldStatus := payment.Status
+		payment.Status = types.PaymentStatusOk
+		s.publishPayment(EventPaymentStatusChanged, payment, oldStatus)
+	}
+	s.record(AuditEntry{
+		Action: "settlement",
+		Target: "settlement:" + batch.ID,
+		After: "merchant=" + batch.MerchantID +
+			" category=" + string(batch.Category) +
+			" account=" + strconv.FormatInt(batch.AccountID, 10) +
+			" count=" + strconv.Itoa(batch.Count) +
+			" gross=" + strconv.FormatInt(int64(batch.Gross), 10) +
+			" fee=" + strconv.FormatInt(int64(batch.Fee), 10) +
+			" net=" + strconv.FormatInt(int64(batch.Net), 10),
+	})
+	account, err := s.FindAccountByID(batch.AccountID)
+	if err != nil || batch.Gross <= 0 {
+		return
+	}
+	s.deposit(account, batch.Gross)
+	if batch.Fee <= 0 {
+		return
+	}
+	// комиссия за расчёт видна в истории счёта мерчанта
+	before := accountState(account)
+	account.Balance -= batch.Fee
+	payment := &types.Payment{
+		ID:        uuid.New().String(),
+		AccountID: account.ID,
+		Amount:    batch.Fee,
+		Category:  FeeCategory,
+		Status:    types.PaymentStatusOk,
+		Time:      s.now().Unix(),
+	}
+	s.payments = append(s.payments, payment)
+	s.record(AuditEntry{
+		Action: "fee",
+		Target: "payment:" + payment.ID,
+		Before: before,
+		After:  accountState(account) + " " + paymentState(payment) + " settlement=" + batch.ID,
+	})
+	s.publishPayment(EventPaymentCreated, payment, "")
+}
+
+func (s *Service) writeSettlement(batch *SettlementBatch) error {
+	file, err := os.Create(batch.File)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	text := "batch;" + batch.ID + ";" + batch.Day + ";" + batch.MerchantID + ";" + string(batch.Category) + ";" +
+		strconv.FormatInt(batch.AccountID, 10) + ";\n"
+	for _, id := range batch.PaymentIDs {
+		payment, err := s.FindPaymentByID(id)
+		if err != nil {
+			continue
+		}
+		text += "payment;" + payment.ID + ";" +
+			strconv.FormatInt(payment.AccountID, 10) + ";" +
+			strconv.FormatInt(int64(payment.Amount), 10) + ";" +
+			strconv.FormatInt(payment.Time, 10) + ";\n"
+	}
+	text += "total;" + strconv.Itoa(batch.Count) + ";" +
+		strconv.FormatInt(int64(batch.Gross), 10) + ";" +
+		strconv.FormatInt(int64(batch.Fee), 10) + ";" +
+		strconv.FormatInt(int64(batch.Net), 10) + ";\n"
+	_, err = file.Write([]byte(text))
+	return err
+}
+
+func (s *Service) exportSettlements(dir string) error {
+	if s.settlements == nil {
+		return nil
+	}
+	file, err := os.Create(dir + "/settlements.dump")
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	text := ""
+	for _, batch := range s.settlements {
+		text += batch.ID + ";" +
+			batch.Day + ";" +
+			batch.MerchantID + ";" +
+			string(batch.Category) + ";" +
+			strconv.FormatInt(batch.AccountID, 10) + ";" +
+			strconv.Itoa(batch.Count) + ";" +
+			strconv.FormatInt(int64(batch.Gross), 10) + ";" +
+			strconv.FormatInt(int64(batch.Fee), 10) + ";" +
+			strconv.FormatInt(int64(batch.Net), 10) + ";" +
+			strings.Join(batch.PaymentIDs, ",") + ";" +
+			batch.File + ";\n"
+	}
+	_, err = file.Write([]byte(text))
+	return err
+}
+
+func (s *Service) importSettlements(dir string) {
+	file, err := os.Open(dir + "/settlements.dump")
+	if err != nil {
+		log.Print(err)
+		return
+	}
+	defer file.Close()
+	known := map[string]bool{}
+	for _, batch := range s.settlements {
+		known[batch.ID] = true
+	}
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		line := strings.Split(scanner.Text(), ";")
+		if len(line) < 11 || known[line[0]] {
+			continue
+		}
+		accountID, _ := strconv.ParseInt(line[4], 10, 64)
+		count, _ := strconv.Atoi(line[5])
+		gross, _ := strconv.ParseInt(line[6], 10, 64)
+		fee, _ := strconv.ParseInt(line[7], 10, 64)
+		net, _ := strconv.ParseInt(line[8], 10, 64)
+		paymentIDs := []string{}
+		if line[9] != "" {
+			paymentIDs = strings.Split(line[9], ",")
+		}
+		s.settlements = append(s.settlements, &SettlementBatch{
+			ID:         line[0],
+			Day:        line[1],
+			MerchantID: line[2],
+			Category:   types.PaymentCategory(line[3]),
+			AccountID:  accountID,
+			Count:      count,
+			Gross:      types.Money(gross),
+			Fee:        types.Money(fee),
+			Net:        types.Money(net),
+			PaymentIDs: paymentIDs,
+			File:       line[10],
+		})
+	}
+}
@@ -0,0 +1,91 @@
+package wallet
+
+import (
+	"io/ioutil"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/SsSJKK/wallet/pkg/types"
+)
+
+func Test_Settle(t *testing.T) {
+	now := time.Date(2020, time.November, 3, 12, 0, 0, 0, time.UTC)
+	svc := &Service{}
+	svc.SetClock(func() time.Time { return now })
+	acc, _ := svc.RegisterAccount("992000000001")
+	shop, _ := svc.RegisterAccount("992000000002")
+	pharmacy, _ := svc.RegisterAccount("992000000003")
+	svc.Deposit(acc.ID, 10000)
+	merchant, _ := svc.RegisterMerchant("Shop", "food", "5411", shop.ID)
+	svc.RegisterMerchant("Pharmacy", "pharmacy", "5912", pharmacy.ID)
+	svc.SetFeeRule(FeeRule{Operation: OperationSettlement, Percent: 200})
+	svc.SetFeeRule(FeeRule{Operation: OperationPay, Fixed: 1})
+
+	first, _ := svc.PayMerchant(acc.ID, merchant.ID, 1000)
+	svc.PayMerchant(acc.ID, merchant.ID, 500)
+	byCategory, _ := svc.Pay(acc.ID, 300, "pharmacy")
+	svc.Pay(acc.ID, 200, "auto")
+	failed, _ := svc.PayMerchant(acc.ID, merchant.ID, 700)
+	svc.Reject(failed.ID)
+	now = now.Add(24 * time.Hour)
+	late, _ := svc.PayMerchant(acc.ID, merchant.ID, 100)
+
+	dir := t.TempDir()
+	batches, err := svc.Settle(now.Add(-24*time.Hour), dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(batches) != 1 {
+		t.Fatalf("ERROR: batches %+v", batches)
+	}
+	if b := batches[0]; b.MerchantID != merchant.ID || b.AccountID != shop.ID || b.Count != 2 || b.Gross != 1500 || b.Fee != 30 || b.Net != 1470 {
+		t.Errorf("ERROR: merchant batch %+v", b)
+	}
+	if shop.Balance != 1470 || pharmacy.Balance != 0 {
+		t.Errorf("ERROR: settlement balances %v %v", shop.Balance, pharmacy.Balance)
+	}
+	history, _ := svc.ExportAccountHistory(shop.ID)
+	if len(history) != 1 || history[0].Category != FeeCategory || history[0].Amount != 30 {
+		t.Errorf("ERROR: settlement fee %+v", history)
+	}
+	for _, payment := range svc.payments {
+		if payment.MerchantID == merchant.ID && payment.ID != late.ID && payment.Status == types.PaymentStatusInProgress {
+			t.Errorf("ERROR: payment not settled %+v", payment)
+		}
+	}
+	if late.Status != types.PaymentStatusInProgress || byCategory.Status != types.PaymentStatusInProgress {
+		t.Errorf("ERROR: settled %+v %+v", late, byCategory)
+	}
+
+	data, _ := ioutil.ReadFile(batches[0].File)
+	if !strings.HasSuffix(string(data), "total;2;1500;30;1470;\n") {
+		t.Errorf("ERROR: settlement file %s", data)
+	}
+
+	again, err := svc.Settle(now.Add(-24*time.Hour), dir)
+	if err != nil || len(again) != 0 || shop.Balance != 1470 {
+		t.Errorf("ERROR: second run %v %v balance %v", again, err, shop.Balance)
+	}
+	if len(svc.Settlements()) != 1 {
+		t.Errorf("ERROR: settlements %v", svc.Settlements())
+	}
+
+	// расчёт уже прошёл, отменить платёж можно только возвратом
+	if err := svc.Reject(first.ID); err != ErrPaymentNotRejectable {
+		t.Errorf("ERROR: %v need %v", err, ErrPaymentNotRejectable)
+	}
+	if err := svc.Refund(byCategory.ID); err != ErrPaymentNotRefundable {
+		t.Errorf("ERROR: %v need %v", err, ErrPaymentNotRefundable)
+	}
+	balance := acc.Balance
+	if err := svc.Refund(first.ID); err != nil {
+		t.Fatal(err)
+	}
+	if first.Status != types.PaymentStatusFail || acc.Balance != balance+1001 || shop.Balance != 470 {
+		t.Errorf("ERROR: refund %v balances %v %v", first.Status, acc.Balance, shop.Balance)
+	}
+	if err := svc.Refund(first.ID); err != ErrPaymentNotRefundable {
+		t.Errorf("ERROR: second refund %v", err)
+	}
+}
@@ -0,0 +1,177 @@
+package webhook
+
+import (
+	"bufio"
+	"encoding/base64"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/SsSJKK/wallet/pkg/wallet"
+)
+
+// Файлы в каталоге данных, формат строк как у остальных *.dump
+const (
+	endpointsFile  = "/webhooks.dump"
+	outboxFile     = "/outbox.dump"
+	deadLetterFile = "/deadletter.dump"
+)
+
+func (d *Dispatcher) load() error {
+	lines, err := readLines(d.Dir + endpointsFile)
+	if err != nil {
+		return err
+	}
+	for _, line := range lines {
+		if len(line) < 3 {
+			continue
+		}
+		secret, err := base64.StdEncoding.DecodeString(line[2])
+		if err != nil {
+			return err
+		}
+		d.endpoints = append(d.endpoints, &Endpoint{ID: line[0], URL: line[1], Secret: string(secret)})
+	}
+
+	// outbox дописывается в конец, поэтому у сообщения может быть несколько строк — верна последняя
+	known := map[string]*Message{}
+	for _, file := range []string{outboxFile, deadLetterFile} {
+		lines, err := readLines(d.Dir + file)
+		if err != nil {
+			return err
+		}
+		for _, line := range lines {
+			if len(line) < 8 {
+				continue
+			}
+			body, err := base64.StdEncoding.DecodeString(line[3])
+			if err != nil {
+				return err
+			}
+			attempts, _ := strconv.Atoi(line[4])
+			next, _ := strconv.ParseInt(line[5], 10, 64)
+			lastError, _ := base64.StdEncoding.DecodeString(line[6])
+			message := &Message{
+				ID:          line[0],
+				EndpointID:  line[1],
+				Event:       wallet.EventType(line[2]),
+				Body:        body,
+				Attempts:    attempts,
+				NextAttempt: next,
+				LastError:   string(lastError),
+				Status:      MessageStatus(line[7]),
+			}
+			if old, ok := known[message.ID]; ok {
+				*old = *message
+				continue
+			}
+			known[message.ID] = message
+			d.messages = append(d.messages, message)
+		}
+	}
+	return nil
+}
+
+func (d *Dispatcher) saveEndpoints() error {
+	text := ""
+	for _, endpoint := range d.endpoints {
+		text += endpoint.ID + ";" + endpoint.URL + ";" + base64.StdEncoding.EncodeToString([]byte(endpoint.Secret)) + ";\n"
+	}
+	return writeFile(d.Dir+endpointsFile, text)
+}
+
+// saveMessages переписывает outbox и dead-letter целиком, вызывается при доставке, а не при публикации
+func (d *Dispatcher) saveMessages() error {
+	outbox := ""
+	dead := ""
+	for _, message := range d.messages {
+		line := messageLine(message)
+		if message.Status == MessageDead {
+			dead += line
+		} else {
+			outbox += line
+		}
+	}
+	err := writeFile(d.Dir+outboxFile, outbox)
+	if err != nil {
+		return err
+	}
+	return writeFile(d.Dir+deadLetterFile, dead)
+}
+
+// appendMessages дописывает новые сообщения в конец outbox
+func (d *Dispatcher) appendMessages(messages []*Message) error {
+	text := ""
+	for _, message := range messages {
+		text += messageLine(message)
+	}
+	file, err := os.OpenFile(d.Dir+outboxFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	_, err = file.Write([]byte(text))
+	if err != nil {
+		file.Close()
+		return err
+	}
+	err = file.Sync()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+func messageLine(message *Message) string {
+	return message.ID + ";" +
+		message.EndpointID + ";" +
+		string(message.Event) + ";" +
+		base64.StdEncoding.EncodeToString(message.Body) + ";" +
+		strconv.Itoa(message.Attempts) + ";" +
+		strconv.FormatInt(message.NextAttempt, 10) + ";" +
+		base64.StdEncoding.EncodeToString([]byte(message.LastError)) + ";" +
+		string(message.Status) + ";\n"
+}
+
+func readLines(path string) ([][]string, error) {
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	lines := [][]string{}
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		lines = append(lines, strings.Split(scanner.Text(), ";"))
+	}
+	return lines, scanner.Err()
+}
+
+// writeFile пишет во временный файл и переименовывает, чтобы outbox не
+// остался обрезанным при падении процесса
+func writeFile(path string, text string) error {
+	tmp := path + ".tmp"
+	file, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	_, err = file.Write([]byte(text))
+	if err != nil {
+		file.Close()
+		return err
+	}
+	err = file.Sync()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	err = file.Close()
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
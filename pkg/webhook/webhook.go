@@ -0,0 +1,403 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/SsSJKK/wallet/pkg/types"
+	"github.com/SsSJKK/wallet/pkg/wallet"
+)
+
+//ErrEndpointNotFound err
+var ErrEndpointNotFound = errors.New("endpoint not found")
+
+//ErrMessageNotFound err
+var ErrMessageNotFound = errors.New("message not found")
+
+//ErrInvalidURL err
+var ErrInvalidURL = errors.New("invalid endpoint url")
+
+//Headers sent with every delivery
+const (
+	HeaderSignature = "X-Wallet-Signature"
+	HeaderEvent     = "X-Wallet-Event"
+	HeaderDelivery  = "X-Wallet-Delivery"
+)
+
+//Endpoint registered receiver of webhooks
+type Endpoint struct {
+	ID     string
+	URL    string
+	Secret string
+}
+
+//MessageStatus state of an outbox message
+type MessageStatus string
+
+//Message statuses
+const (
+	MessagePending MessageStatus = "PENDING"
+	MessageDead    MessageStatus = "DEAD"
+)
+
+//Message webhook waiting in the outbox or the dead-letter queue
+type Message struct {
+	ID          string
+	EndpointID  string
+	Event       wallet.EventType
+	Body        []byte
+	Attempts    int
+	NextAttempt int64
+	LastError   string
+	Status      MessageStatus
+}
+
+//Payload JSON body of a webhook
+type Payload struct {
+	ID        string              `json:"id"`
+	Type      wallet.EventType    `json:"type"`
+	AccountID int64               `json:"accountId"`
+	Time      int64               `json:"time"`
+	Payment   *PaymentPayload     `json:"payment,omitempty"`
+	Deposit   *DepositPayload     `json:"deposit,omitempty"`
+	OldStatus types.PaymentStatus `json:"oldStatus,omitempty"`
+}
+
+//PaymentPayload payment in a webhook body
+type PaymentPayload struct {
+	ID       string                `json:"id"`
+	Amount   types.Money           `json:"amount"`
+	Category types.PaymentCategory `json:"category"`
+	Status   types.PaymentStatus   `json:"status"`
+	Time     int64                 `json:"time"`
+}
+
+//DepositPayload deposit in a webhook body
+type DepositPayload struct {
+	ID     string      `json:"id"`
+	Amount types.Money `json:"amount"`
+	Time   int64       `json:"time"`
+}
+
+//Dispatcher keeps endpoints and the outbox in the data directory and
+//delivers messages with exponential backoff
+type Dispatcher struct {
+	Dir         string
+	Client      *http.Client
+	Clock       func() time.Time
+	BaseBackoff time.Duration
+	MaxBackoff  time.Duration
+	MaxAttempts int
+
+	mu        sync.Mutex
+	endpoints []*Endpoint
+	messages  []*Message
+}
+
+//NewDispatcher loads endpoints and the outbox from the directory
+func NewDispatcher(dir string) (*Dispatcher, error) {
+	d := &Dispatcher{
+		Dir:         dir,
+		Client:      &http.Client{Timeout: 10 * time.Second},
+		BaseBackoff: time.Second,
+		MaxBackoff:  time.Hour,
+		MaxAttempts: 10,
+	}
+	err := d.load()
+	if err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+func (d *Dispatcher) now() time.Time {
+	if d.Clock == nil {
+		return time.Now()
+	}
+	return d.Clock()
+}
+
+//Sign HMAC-SHA256 signature of the body sent in the signature header
+func Sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+//Verify checks the signature header of a received webhook
+func Verify(secret string, body []byte, signature string) bool {
+	return hmac.Equal([]byte(Sign(secret, body)), []byte(signature))
+}
+
+//AddEndpoint registers a receiver
+func (d *Dispatcher) AddEndpoint(rawURL string, secret string) (*Endpoint, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || strings.ContainsAny(rawURL, ";\n") {
+		return nil, ErrInvalidURL
+	}
+	endpoint := &Endpoint{ID: uuid.New().String(), URL: rawURL, Secret: secret}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.endpoints = append(d.endpoints, endpoint)
+	return endpoint, d.saveEndpoints()
+}
+
+//RemoveEndpoint removes the receiver, its pending messages are dropped
+func (d *Dispatcher) RemoveEndpoint(endpointID string) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for i, endpoint := range d.endpoints {
+		if endpoint.ID != endpointID {
+			continue
+		}
+		d.endpoints = append(d.endpoints[:i], d.endpoints[i+1:]...)
+		messages := d.messages[:0]
+		for _, message := range d.messages {
+			if message.EndpointID != endpointID {
+				messages = append(messages, message)
+			}
+		}
+		d.messages = messages
+		err := d.saveEndpoints()
+		if err != nil {
+			return err
+		}
+		return d.saveMessages()
+	}
+	return ErrEndpointNotFound
+}
+
+//Endpoints returns registered receivers
+func (d *Dispatcher) Endpoints() []Endpoint {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	endpoints := []Endpoint{}
+	for _, endpoint := range d.endpoints {
+		endpoints = append(endpoints, *endpoint)
+	}
+	return endpoints
+}
+
+//Attach subscribes the dispatcher to deposit and payment events of the bus.
+//Events are appended to the outbox synchronously and delivered later by
+//DeliverDue or Run, outside the publishing call.
+func (d *Dispatcher) Attach(bus *wallet.EventBus) *wallet.Subscription {
+	return bus.Subscribe(func(event wallet.Event) {
+		err := d.Enqueue(event)
+		if err != nil {
+			// событие уже применено к кошельку, поэтому только сообщаем об ошибке
+			log.Print("webhook: enqueue: ", err)
+		}
+	}, wallet.SubscribeOptions{
+		Mode:  wallet.DeliverSync,
+		Types: []wallet.EventType{wallet.EventDepositMade, wallet.EventPaymentCreated, wallet.EventPaymentStatusChanged},
+	})
+}
+
+//Enqueue puts a message for every endpoint to the outbox
+func (d *Dispatcher) Enqueue(event wallet.Event) error {
+	payload := Payload{
+		Type:      event.Type,
+		AccountID: event.AccountID,
+		Time:      event.Time,
+		OldStatus: event.OldStatus,
+	}
+	if event.Payment != nil {
+		payload.Payment = &PaymentPayload{
+			ID:       event.Payment.ID,
+			Amount:   event.Payment.Amount,
+			Category: event.Payment.Category,
+			Status:   event.Payment.Status,
+			Time:     event.Payment.Time,
+		}
+	}
+	if event.Deposit != nil {
+		payload.Deposit = &DepositPayload{
+			ID:     event.Deposit.ID,
+			Amount: event.Deposit.Amount,
+			Time:   event.Deposit.Time,
+		}
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if len(d.endpoints) == 0 {
+		return nil
+	}
+	now := d.now().Unix()
+	added := []*Message{}
+	for _, endpoint := range d.endpoints {
+		payload.ID = uuid.New().String()
+		body, err := json.Marshal(payload)
+		if err != nil {
+			return err
+		}
+		added = append(added, &Message{
+			ID:          payload.ID,
+			EndpointID:  endpoint.ID,
+			Event:       event.Type,
+			Body:        body,
+			NextAttempt: now,
+			Status:      MessagePending,
+		})
+	}
+	d.messages = append(d.messages, added...)
+	return d.appendMessages(added)
+}
+
+//Pending returns messages waiting for delivery
+func (d *Dispatcher) Pending() []Message {
+	return d.list(MessagePending)
+}
+
+//DeadLetters returns messages that failed MaxAttempts times
+func (d *Dispatcher) DeadLetters() []Message {
+	return d.list(MessageDead)
+}
+
+func (d *Dispatcher) list(status MessageStatus) []Message {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	messages := []Message{}
+	for _, message := range d.messages {
+		if message.Status == status {
+			messages = append(messages, *message)
+		}
+	}
+	return messages
+}
+
+//Redeliver moves the message back to the outbox for immediate delivery
+func (d *Dispatcher) Redeliver(messageID string) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for _, message := range d.messages {
+		if message.ID == messageID {
+			message.Status = MessagePending
+			message.Attempts = 0
+			message.NextAttempt = d.now().Unix()
+			return d.saveMessages()
+		}
+	}
+	return ErrMessageNotFound
+}
+
+//DeliverDue sends every pending message whose time has come and returns
+//how many were delivered
+func (d *Dispatcher) DeliverDue(ctx context.Context) (int, error) {
+	type delivery struct {
+		message  *Message
+		endpoint Endpoint
+	}
+
+	d.mu.Lock()
+	now := d.now().Unix()
+	due := []delivery{}
+	for _, message := range d.messages {
+		if message.Status != MessagePending || message.NextAttempt > now {
+			continue
+		}
+		for _, endpoint := range d.endpoints {
+			if endpoint.ID == message.EndpointID {
+				due = append(due, delivery{message: message, endpoint: *endpoint})
+			}
+		}
+	}
+	d.mu.Unlock()
+
+	delivered := map[*Message]bool{}
+	failed := map[*Message]error{}
+	for _, item := range due {
+		err := d.send(ctx, item.endpoint, item.message)
+		if err != nil {
+			failed[item.message] = err
+			continue
+		}
+		delivered[item.message] = true
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	messages := d.messages[:0]
+	for _, message := range d.messages {
+		if delivered[message] {
+			continue
+		}
+		if err, ok := failed[message]; ok {
+			message.Attempts++
+			message.LastError = err.Error()
+			if message.Attempts >= d.MaxAttempts {
+				message.Status = MessageDead
+			} else {
+				message.NextAttempt = d.now().Add(d.backoff(message.Attempts)).Unix()
+			}
+		}
+		messages = append(messages, message)
+	}
+	d.messages = messages
+	return len(delivered), d.saveMessages()
+}
+
+func (d *Dispatcher) backoff(attempts int) time.Duration {
+	delay := d.BaseBackoff
+	for i := 1; i < attempts; i++ {
+		delay *= 2
+		if delay >= d.MaxBackoff {
+			return d.MaxBackoff
+		}
+	}
+	return delay
+}
+
+func (d *Dispatcher) send(ctx context.Context, endpoint Endpoint, message *Message) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint.URL, bytes.NewReader(message.Body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(HeaderSignature, Sign(endpoint.Secret, message.Body))
+	req.Header.Set(HeaderEvent, string(message.Event))
+	req.Header.Set(HeaderDelivery, message.ID)
+
+	resp, err := d.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("endpoint responded %s", resp.Status)
+	}
+	return nil
+}
+
+//Run delivers due messages every interval until the context is done
+func (d *Dispatcher) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		_, err := d.DeliverDue(ctx)
+		if err != nil {
+			log.Print("webhook: deliver: ", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,165 @@
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/SsSJKK/wallet/pkg/types"
+	"github.com/SsSJKK/wallet/pkg/wallet"
+)
+
+type receiver struct {
+	mu       sync.Mutex
+	fail     bool
+	payloads []Payload
+	verified bool
+}
+
+func (r *receiver) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	body, _ := ioutil.ReadAll(req.Body)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.fail {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	r.verified = Verify("secret", body, req.Header.Get(HeaderSignature))
+	payload := Payload{}
+	json.Unmarshal(body, &payload)
+	r.payloads = append(r.payloads, payload)
+}
+
+func newWebhookService(t *testing.T, dir string) (*wallet.Service, *Dispatcher, *receiver, *httptest.Server) {
+	recv := &receiver{}
+	server := httptest.NewServer(recv)
+	t.Cleanup(server.Close)
+
+	d, err := NewDispatcher(dir)
+	if err != nil {
+		t.Fatalf("ERROR: %v", err)
+	}
+	if _, err := d.AddEndpoint(server.URL, "secret"); err != nil {
+		t.Fatalf("ERROR: %v", err)
+	}
+	bus := &wallet.EventBus{}
+	d.Attach(bus)
+	svc := &wallet.Service{}
+	svc.SetEventBus(bus)
+	return svc, d, recv, server
+}
+
+func Test_Webhook_Deliver(t *testing.T) {
+	svc, d, recv, _ := newWebhookService(t, t.TempDir())
+	acc, _ := svc.RegisterAccount("992000000001")
+	svc.Deposit(acc.ID, 100)
+	pay, _ := svc.Pay(acc.ID, 10, "auto")
+	svc.Reject(pay.ID)
+
+	if len(d.Pending()) != 3 {
+		t.Fatalf("ERROR: pending %v need 3", len(d.Pending()))
+	}
+	delivered, err := d.DeliverDue(context.Background())
+	if err != nil || delivered != 3 {
+		t.Fatalf("ERROR: delivered %v %v", delivered, err)
+	}
+	if !recv.verified {
+		t.Errorf("ERROR: signature not verified")
+	}
+	last := recv.payloads[2]
+	if last.Type != wallet.EventPaymentStatusChanged || last.Payment.ID != pay.ID || last.Payment.Status != types.PaymentStatusFail {
+		t.Errorf("ERROR: payload %v", last)
+	}
+	if len(d.Pending()) != 0 {
+		t.Errorf("ERROR: outbox is not empty")
+	}
+}
+
+func Test_Webhook_RetryDeadLetterRedeliver(t *testing.T) {
+	dir := t.TempDir()
+	svc, d, recv, _ := newWebhookService(t, dir)
+	now := time.Date(2020, time.November, 3, 12, 0, 0, 0, time.UTC)
+	d.Clock = func() time.Time { return now }
+	d.MaxAttempts = 3
+	recv.fail = true
+
+	acc, _ := svc.RegisterAccount("992000000001")
+	svc.Deposit(acc.ID, 100)
+
+	d.DeliverDue(context.Background())
+	pending := d.Pending()
+	if len(pending) != 1 || pending[0].Attempts != 1 || pending[0].NextAttempt != now.Add(time.Second).Unix() {
+		t.Fatalf("ERROR: pending %v", pending)
+	}
+	d.DeliverDue(context.Background())
+	if d.Pending()[0].Attempts != 1 {
+		t.Errorf("ERROR: delivered before backoff")
+	}
+	now = now.Add(time.Second)
+	d.DeliverDue(context.Background())
+	if next := d.Pending()[0].NextAttempt; next != now.Add(2*time.Second).Unix() {
+		t.Errorf("ERROR: backoff %v", next-now.Unix())
+	}
+	now = now.Add(2 * time.Second)
+	d.DeliverDue(context.Background())
+
+	dead := d.DeadLetters()
+	if len(dead) != 1 || len(d.Pending()) != 0 {
+		t.Fatalf("ERROR: dead %v pending %v", dead, d.Pending())
+	}
+
+	reloaded, err := NewDispatcher(dir)
+	if err != nil || len(reloaded.DeadLetters()) != 1 || len(reloaded.Endpoints()) != 1 {
+		t.Fatalf("ERROR: reload %v %v", err, reloaded)
+	}
+	if reloaded.Endpoints()[0].Secret != "secret" {
+		t.Errorf("ERROR: secret %q", reloaded.Endpoints()[0].Secret)
+	}
+
+	recv.fail = false
+	if err := reloaded.Redeliver(dead[0].ID); err != nil {
+		t.Fatalf("ERROR: %v", err)
+	}
+	delivered, _ := reloaded.DeliverDue(context.Background())
+	if delivered != 1 || len(recv.payloads) != 1 || recv.payloads[0].Deposit.Amount != 100 {
+		t.Errorf("ERROR: delivered %v %v", delivered, recv.payloads)
+	}
+	if reloaded.Redeliver("unknown") != ErrMessageNotFound {
+		t.Errorf("ERROR: redeliver unknown message")
+	}
+}
+
+func Test_Webhook_OutboxAppend(t *testing.T) {
+	dir := t.TempDir()
+	svc, d, recv, _ := newWebhookService(t, dir)
+	recv.fail = true
+	acc, _ := svc.RegisterAccount("992000000001")
+	svc.Deposit(acc.ID, 100)
+	first, _ := ioutil.ReadFile(dir + outboxFile)
+	svc.Pay(acc.ID, 10, "auto")
+	data, _ := ioutil.ReadFile(dir + outboxFile)
+	if !strings.HasPrefix(string(data), string(first)) || strings.Count(string(data), "\n") != 2 {
+		t.Errorf("ERROR: outbox must be appended %q", data)
+	}
+
+	d.DeliverDue(context.Background())
+	svc.Deposit(acc.ID, 5)
+	reloaded, err := NewDispatcher(dir)
+	if err != nil || len(reloaded.Pending()) != 3 || reloaded.Pending()[0].Attempts != 1 || reloaded.Pending()[2].Attempts != 0 {
+		t.Errorf("ERROR: reload %v %v", err, reloaded.Pending())
+	}
+}
+
+func Test_Webhook_AddEndpoint_Invalid(t *testing.T) {
+	d, _ := NewDispatcher(t.TempDir())
+	_, err := d.AddEndpoint("ftp://example.com", "")
+	if err != ErrInvalidURL {
+		t.Errorf("ERROR: %v need %v", err, ErrInvalidURL)
+	}
+}